@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
@@ -44,8 +45,16 @@ func main() {
 		getNetworkNames(client)
 	case "pools":
 		getPoolStats(client)
+	case "reaper":
+		getReaperStats(client)
+	case "reload":
+		reloadServer(client)
+	case "partner-down":
+		partnerDownCmd(client)
 	case "devices":
 		devicesCmd(client, args)
+	case "conflicts":
+		conflictsCmd(client, args)
 	default:
 		fmt.Printf("\"%s\" is not a command\n", command)
 		os.Exit(1)
@@ -57,22 +66,32 @@ var multLleaseTemplate = template.Must(template.New("").Parse(`Server Time: {{.N
 Leases in {{.Network}}:
 {{range .Leases}}
 	IP:         {{.IP.String}}
-	MAC:        {{.MAC.String}}
+	MAC:        {{.MAC.String}}{{if .ClientID}}
+	Client ID:  {{.ClientIDString}}{{end}}
 	Start:      {{.Start.Format "2006-01-02 15:04:05 -07:00"}}
-	End:        {{.End.Format "2006-01-02 15:04:05 -07:00"}}
+	End:        {{if .IsInfinite}}never{{else}}{{.End.Format "2006-01-02 15:04:05 -07:00"}}{{end}}
 	Hostname:   {{.Hostname}}
-	Registered: {{.Registered}}
+	Registered: {{.Registered}}{{if .Type}}
+	Type:       {{.Type}}{{end}}{{if .Relay.CircuitID}}
+	Circuit ID: {{.Relay.CircuitIDString}}{{end}}{{if .Relay.RemoteID}}
+	Remote ID:  {{.Relay.RemoteIDString}}{{end}}{{if .Classes}}
+	Classes:    {{range $i, $c := .Classes}}{{if $i}}, {{end}}{{$c}}{{end}}{{end}}
 {{end}}
 `))
 
 var singleLeaseTemplate = template.Must(template.New("").Parse(`Server Time: {{.Now.Format "2006-01-02 15:04:05 -07:00"}}
 {{with .Lease}}
 	IP:         {{.IP.String}}
-	MAC:        {{.MAC.String}}
+	MAC:        {{.MAC.String}}{{if .ClientID}}
+	Client ID:  {{.ClientIDString}}{{end}}
 	Start:      {{.Start.Format "2006-01-02 15:04:05 -07:00"}}
-	End:        {{.End.Format "2006-01-02 15:04:05 -07:00"}}
+	End:        {{if .IsInfinite}}never{{else}}{{.End.Format "2006-01-02 15:04:05 -07:00"}}{{end}}
 	Hostname:   {{.Hostname}}
-	Registered: {{.Registered}}
+	Registered: {{.Registered}}{{if .Type}}
+	Type:       {{.Type}}{{end}}{{if .Relay.CircuitID}}
+	Circuit ID: {{.Relay.CircuitIDString}}{{end}}{{if .Relay.RemoteID}}
+	Remote ID:  {{.Relay.RemoteIDString}}{{end}}{{if .Classes}}
+	Classes:    {{range $i, $c := .Classes}}{{if $i}}, {{end}}{{$c}}{{end}}{{end}}
 {{end}}
 `))
 
@@ -155,6 +174,45 @@ func getPoolStats(client rpcclient.Client) {
 	})
 }
 
+var reaperStatsTemplate = template.Must(template.New("").Parse(`Server Time: {{.Now.Format "2006-01-02 15:04:05 -07:00"}}
+
+Expired Lease Cleanup:
+{{if .Stats.DeleteAfter}}
+	Delete After:  {{.Stats.DeleteAfter}}{{if not .Stats.LastRun.IsZero}}
+	Last Run:      {{.Stats.LastRun.Format "2006-01-02 15:04:05 -07:00"}}
+	Last Deleted:  {{.Stats.LastDeleted}}{{end}}
+	Total Deleted: {{.Stats.TotalDeleted}}
+{{else}}
+	Disabled
+{{end}}
+`))
+
+func getReaperStats(client rpcclient.Client) {
+	stats, err := client.Server().GetReaperStats()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reaperStatsTemplate.Execute(os.Stdout, map[string]interface{}{
+		"Now":   time.Now(),
+		"Stats": stats,
+	})
+}
+
+func reloadServer(client rpcclient.Client) {
+	if err := client.Server().Reload(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Configuration reloaded successfully")
+}
+
+func partnerDownCmd(client rpcclient.Client) {
+	if err := client.Server().PartnerDown(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Failover partner declared down")
+}
+
 func devicesCmd(client rpcclient.Client, args []string) {
 	if len(args) != 2 {
 		fmt.Println("Usage: devices [show|register|unregister|blacklist|unblacklist|delete] MAC")
@@ -237,3 +295,52 @@ func devicesCmdDelete(client rpcclient.Client, mac net.HardwareAddr) {
 	}
 	fmt.Printf("%s deleted successfully\n", mac.String())
 }
+
+var conflictsTemplate = template.Must(template.New("").Parse(`Server Time: {{.Now.Format "2006-01-02 15:04:05 -07:00"}}
+
+Address Conflicts:
+{{range .Leases}}
+	IP:         {{.IP.String}}
+	MAC:        {{.MAC.String}}
+	Network:    {{.Network}}
+	Reason:     {{.AbandonReason}}{{if not .AbandonedAt.IsZero}}
+	Time:       {{.AbandonedAt.Format "2006-01-02 15:04:05 -07:00"}}{{end}}
+{{end}}
+`))
+
+func conflictsCmd(client rpcclient.Client, args []string) {
+	if len(args) == 0 {
+		conflictsCmdShow(client)
+		return
+	}
+
+	if len(args) != 2 || args[0] != "clear" {
+		fmt.Println("Usage: conflicts [clear IP]")
+		os.Exit(1)
+	}
+
+	ip := net.ParseIP(args[1])
+	if ip == nil {
+		fmt.Println("Invalid IP address")
+		os.Exit(1)
+	}
+	if err := client.Lease().ClearConflict(ip); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s cleared successfully\n", ip.String())
+}
+
+func conflictsCmdShow(client rpcclient.Client) {
+	leases, err := client.Lease().GetConflicts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return bytes.Compare(leases[i].IP.To16(), leases[j].IP.To16()) < 0
+	})
+
+	conflictsTemplate.Execute(os.Stdout, map[string]interface{}{
+		"Now":    time.Now(),
+		"Leases": leases,
+	})
+}
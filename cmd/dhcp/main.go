@@ -1,15 +1,21 @@
 package main
 
 import (
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"runtime/pprof"
+	"strconv"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
 
+	"github.com/packet-guardian/pg-dhcp/ddns"
 	"github.com/packet-guardian/pg-dhcp/internal/config"
 	"github.com/packet-guardian/pg-dhcp/internal/server"
 	"github.com/packet-guardian/pg-dhcp/internal/utils"
@@ -57,6 +63,11 @@ func main() {
 		return
 	}
 
+	if flag.Arg(0) == "fmt" {
+		formatDHCPConfig(flag.Args()[1:])
+		return
+	}
+
 	if cpuprofile != "" {
 		var err error
 		f, err := os.Create(cpuprofile)
@@ -102,6 +113,17 @@ func main() {
 	if err != nil {
 		e.Log.WithField("error", err).Fatal("Error loading DHCP configuration")
 	}
+	problems := networks.Validate()
+	for _, p := range problems {
+		if p.Severity == server.SeverityError {
+			e.Log.Error(p.String())
+		} else {
+			e.Log.Warning(p.String())
+		}
+	}
+	if problems.HasErrors() {
+		e.Log.Fatal("Invalid DHCP configuration")
+	}
 
 	e.Log.Info("Opening database")
 	store, err := openDatabase(e.Config)
@@ -117,12 +139,38 @@ func main() {
 		}
 	}()
 
+	pingTimeout, _ := time.ParseDuration(e.Config.Server.PingTimeout)
+	deleteAfter, _ := time.ParseDuration(e.Config.Leases.DeleteAfter)
 	serverConfig := &server.ServerConfig{
-		Log:            e.Log,
-		Store:          store,
-		Env:            server.EnvProd,
-		BlockBlacklist: e.Config.Server.BlockBlacklisted,
-		Workers:        e.Config.Server.Workers,
+		Log:              e.Log,
+		Store:            store,
+		Env:              server.EnvProd,
+		BlockBlacklist:   e.Config.Server.BlockBlacklisted,
+		Workers:          e.Config.Server.Workers,
+		PingTimeout:      pingTimeout,
+		LeaseDeleteAfter: deleteAfter,
+	}
+
+	if e.Config.Failover.Role != "" {
+		serverConfig.Failover, err = newFailover(e.Config.Failover)
+		if err != nil {
+			e.Log.WithField("error", err).Fatal("Error in failover configuration")
+		}
+	}
+
+	if e.Config.DDNS.Server != "" {
+		serverConfig.DNS, err = newDNSClient(e.Config.DDNS)
+		if err != nil {
+			e.Log.WithField("error", err).Fatal("Error in ddns configuration")
+		}
+		serverConfig.DNSTTL, _ = time.ParseDuration(e.Config.DDNS.TTL)
+	}
+
+	if len(e.Config.LeaseQuery.AllowedIPs) > 0 {
+		serverConfig.LeaseQuery, err = newLeaseQuery(e.Config.LeaseQuery)
+		if err != nil {
+			e.Log.WithField("error", err).Fatal("Error in leasequery configuration")
+		}
 	}
 
 	handler := server.NewDHCPServer(networks, serverConfig)
@@ -130,6 +178,22 @@ func main() {
 		e.Log.WithField("error", err).Fatal("Couldn't load leases")
 	}
 
+	reload := func() error {
+		return handler.ReloadFile(e.Config.Server.NetworksFile)
+	}
+	management.SetReloadFunc(reload)
+	management.SetPartnerDownFunc(serverConfig.Failover.PartnerDown)
+	management.SetClearConflictFunc(handler.ClearConflict)
+
+	go func(e *config.Environment) {
+		for range e.SubscribeReload() {
+			e.Log.Notice("Reloading DHCP configuration...")
+			if err := reload(); err != nil {
+				e.Log.WithField("error", err).Error("Error reloading DHCP configuration")
+			}
+		}
+	}(e)
+
 	go func(e *config.Environment) {
 		<-e.SubscribeShutdown()
 		e.Log.Notice("Shutting down...")
@@ -141,6 +205,93 @@ func main() {
 	}
 }
 
+func newFailover(cfg *config.FailoverConfig) (*server.Failover, error) {
+	role, err := server.ParseFailoverRole(cfg.Role)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Peer == "" {
+		return nil, errors.New("Failover peer is required")
+	}
+	secret, err := base64.StdEncoding.DecodeString(cfg.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid failover secret: %s", err)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("Failover secret is required")
+	}
+
+	address := cfg.Address
+	if address == "" {
+		address, err = peerFacingAddress(cfg.Peer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mclt, _ := time.ParseDuration(cfg.MCLT)
+	heartbeat, _ := time.ParseDuration(cfg.HeartbeatInterval)
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+	return server.NewFailover(&server.FailoverConfig{
+		Role:              role,
+		Address:           net.JoinHostPort(address, strconv.Itoa(cfg.Port)),
+		Peer:              cfg.Peer,
+		Secret:            secret,
+		MCLT:              mclt,
+		HeartbeatInterval: heartbeat,
+		Timeout:           timeout,
+	}), nil
+}
+
+// peerFacingAddress returns the local address used to reach peer.
+func peerFacingAddress(peer string) (string, error) {
+	// Connecting a UDP socket picks the route without sending anything
+	conn, err := net.Dial("udp", peer)
+	if err != nil {
+		return "", fmt.Errorf("No route to failover peer: %s", err)
+	}
+	defer conn.Close()
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	return host, err
+}
+
+func newLeaseQuery(cfg *config.LeaseQueryConfig) (*server.LeaseQueryConfig, error) {
+	lq := &server.LeaseQueryConfig{}
+	for _, s := range cfg.AllowedIPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid leasequery requestor address %q", s)
+		}
+		lq.AllowedIPs = append(lq.AllowedIPs, ip)
+	}
+	if cfg.BulkAddress != "" {
+		lq.BulkAddress = net.JoinHostPort(cfg.BulkAddress, strconv.Itoa(cfg.BulkPort))
+	}
+	return lq, nil
+}
+
+func newDNSClient(cfg *config.DDNSConfig) (*ddns.Client, error) {
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+	client := &ddns.Client{
+		Server:  net.JoinHostPort(cfg.Server, strconv.Itoa(cfg.Port)),
+		Timeout: timeout,
+	}
+	if cfg.KeyName == "" {
+		return client, nil
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(cfg.KeySecret)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TSIG key secret: %s", err)
+	}
+	client.Key = &ddns.TSIGKey{
+		Name:      cfg.KeyName,
+		Algorithm: cfg.KeyAlgorithm,
+		Secret:    secret,
+	}
+	return client, nil
+}
+
 func displayVersionInfo() {
 	fmt.Printf(`PG Dhcp - (C) 2016 The Packet Guardian Authors
 
@@ -161,15 +312,71 @@ func testMainConfig() {
 }
 
 func testDHCPConfig() {
-	_, err := server.ParseFile(configFile)
+	c, err := server.ParseFile(configFile)
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
 
+	problems := c.Validate()
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if problems.HasErrors() {
+		os.Exit(1)
+	}
+
 	fmt.Println("Configuration looks good")
 }
 
+// formatDHCPConfig rewrites a DHCP configuration file in the canonical format.
+// Usage: dhcp fmt [-l] [-w] [file], the file defaults to -c.
+func formatDHCPConfig(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := fs.Bool("l", false, "List files whose formatting differs")
+	write := fs.Bool("w", false, "Write the result to the files instead of printing it")
+	fs.Parse(args)
+
+	path := fs.Arg(0)
+	if path == "" {
+		path = configFile
+	}
+	if path == "" {
+		fmt.Println("Usage: dhcp fmt [-l] [-w] file")
+		os.Exit(1)
+	}
+
+	files, err := server.FormatFile(path)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	for _, f := range files {
+		if !f.Changed() {
+			continue
+		}
+		if *list {
+			fmt.Println(f.Path)
+		}
+		if *write {
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+			if err := ioutil.WriteFile(f.Path, f.Formatted, info.Mode().Perm()); err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if !*list && !*write {
+		os.Stdout.Write(files[0].Formatted)
+	}
+}
+
 func openDatabase(cfg *config.Config) (store.Store, error) {
 	switch cfg.Database.Type {
 	case "boltdb":
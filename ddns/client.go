@@ -0,0 +1,110 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ddns
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultTimeout is how long a Client waits for a response.
+const DefaultTimeout = 5 * time.Second
+
+var errTruncated = errors.New("ddns: response truncated")
+
+// An RcodeError is returned when the server rejects an update.
+type RcodeError struct {
+	Rcode Rcode
+}
+
+func (e *RcodeError) Error() string {
+	return "ddns: update failed with " + e.Rcode.String()
+}
+
+// A Client sends updates to a single DNS server over UDP.
+type Client struct {
+	Server  string // host:port, port 53 is used if omitted
+	Timeout time.Duration
+	Key     *TSIGKey // Sign updates and verify responses if set
+}
+
+// Send sends an update and waits for the server to acknowledge it.
+func (c *Client) Send(u *Update) error {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	msg, err := u.Marshal(id)
+	if err != nil {
+		return err
+	}
+	var requestMAC []byte
+	if c.Key != nil {
+		if msg, requestMAC, err = c.Key.Sign(msg, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	server := c.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		resp := buf[:n]
+		if len(resp) < headerLen || binary.BigEndian.Uint16(resp[0:2]) != id {
+			continue // Stray or late response to an earlier update
+		}
+		return c.checkResponse(resp, requestMAC)
+	}
+}
+
+func (c *Client) checkResponse(resp, requestMAC []byte) error {
+	flags := binary.BigEndian.Uint16(resp[2:4])
+	if flags&0x8000 == 0 || (flags>>11)&0xf != opcodeUpdate {
+		return fmt.Errorf("ddns: unexpected response flags %#04x", flags)
+	}
+	if flags&0x0200 != 0 {
+		return errTruncated
+	}
+
+	rcode := Rcode(flags & 0xf)
+	// Servers don't sign responses to requests they couldn't authenticate
+	if c.Key != nil && rcode != RcodeNotAuth && rcode != RcodeFormErr {
+		if err := c.Key.Verify(resp, requestMAC); err != nil {
+			return err
+		}
+	}
+	if rcode != RcodeSuccess {
+		return &RcodeError{Rcode: rcode}
+	}
+	return nil
+}
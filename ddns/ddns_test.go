@@ -0,0 +1,202 @@
+package ddns
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUpdateMarshal(t *testing.T) {
+	u := NewUpdate("example.com")
+	u.DeleteRRset("host.example.com.", TypeA)
+	u.AddAddress("host.example.com.", net.ParseIP("10.0.0.5"), 300)
+
+	msg, err := u.Marshal(0x1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		0x12, 0x34, 0x28, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00,
+		// Zone
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0x00, 0x06, 0x00, 0x01,
+		// Delete RRset
+		4, 'h', 'o', 's', 't', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0x00, 0x01, 0x00, 0xff, 0, 0, 0, 0, 0x00, 0x00,
+		// Add A
+		4, 'h', 'o', 's', 't', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0x00, 0x01, 0x00, 0x01, 0, 0, 0x01, 0x2c, 0x00, 0x04, 10, 0, 0, 5,
+	}
+	if !bytes.Equal(msg, expected) {
+		t.Errorf("Incorrect update.\nExpected %v\n     got %v", expected, msg)
+	}
+}
+
+func TestBadNames(t *testing.T) {
+	names := []string{
+		"host..example.com",
+		"a123456789012345678901234567890123456789012345678901234567890123.com",
+	}
+	for _, name := range names {
+		u := NewUpdate(name)
+		if _, err := u.Marshal(1); err == nil {
+			t.Errorf("Expected error for %q", name)
+		}
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		name string
+	}{
+		{"10.1.2.3", "3.2.1.10.in-addr.arpa."},
+		{"2001:db8::567:89ab", "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+	for _, test := range tests {
+		if name := ReverseName(net.ParseIP(test.ip)); name != test.name {
+			t.Errorf("Expected %s, got %s", test.name, name)
+		}
+	}
+}
+
+// fakeServer answers one update with rcode. The request is checked with key and
+// the response signed with respKey if they're set.
+func fakeServer(t *testing.T, key, respKey *TSIGKey, rcode Rcode) (string, <-chan []byte) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []byte, 1)
+
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 4096)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte{}, buf[:n]...)
+		received <- req
+
+		var requestMAC []byte
+		if key != nil {
+			start, rr, err := findTSIG(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			off, _ := skipName(rr, 0)
+			macLen := int(binary.BigEndian.Uint16(rr[off+8:]))
+			requestMAC = rr[off+10 : off+10+macLen]
+
+			stripped := append([]byte{}, req[:start]...)
+			binary.BigEndian.PutUint16(stripped[10:12], binary.BigEndian.Uint16(stripped[10:12])-1)
+			signed := time.Unix(int64(binary.BigEndian.Uint32(rr[off+2:])), 0)
+			vars, _ := key.tsigVariables(signed, binary.BigEndian.Uint16(rr[off+6:]))
+			mac := hmac.New(sha256.New, key.Secret)
+			mac.Write(stripped)
+			mac.Write(vars)
+			if !hmac.Equal(mac.Sum(nil), requestMAC) {
+				t.Error("Request signature does not verify")
+			}
+		}
+
+		// Echo the header and zone section
+		zoneEnd, _ := skipName(req, headerLen)
+		resp := append([]byte{}, req[:zoneEnd+4]...)
+		binary.BigEndian.PutUint16(resp[2:4], 0x8000|opcodeUpdate<<11|uint16(rcode))
+		binary.BigEndian.PutUint16(resp[8:10], 0)
+		binary.BigEndian.PutUint16(resp[10:12], 0)
+
+		if respKey != nil {
+			now := time.Now()
+			vars, _ := respKey.tsigVariables(now, 300)
+			mac := hmac.New(sha256.New, respKey.Secret)
+			mac.Write([]byte{0, byte(len(requestMAC))})
+			mac.Write(requestMAC)
+			mac.Write(resp)
+			mac.Write(vars)
+			resp, _ = respKey.appendTSIG(resp, mac.Sum(nil), now, 300)
+		}
+		conn.WriteTo(resp, addr)
+	}()
+
+	return conn.LocalAddr().String(), received
+}
+
+func TestClientSend(t *testing.T) {
+	addr, received := fakeServer(t, nil, nil, RcodeSuccess)
+	c := &Client{Server: addr, Timeout: time.Second}
+
+	u := NewUpdate("2.0.10.in-addr.arpa.")
+	if err := u.AddPTR("5.2.0.10.in-addr.arpa.", "host.example.com.", 300); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(u); err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+	if req := <-received; binary.BigEndian.Uint16(req[8:10]) != 1 {
+		t.Errorf("Expected 1 update record, got %d", binary.BigEndian.Uint16(req[8:10]))
+	}
+}
+
+func TestClientSendRcode(t *testing.T) {
+	addr, _ := fakeServer(t, nil, nil, RcodeRefused)
+	c := &Client{Server: addr, Timeout: time.Second}
+
+	err := c.Send(NewUpdate("example.com."))
+	if rerr, ok := err.(*RcodeError); !ok || rerr.Rcode != RcodeRefused {
+		t.Errorf("Expected REFUSED, got %v", err)
+	}
+}
+
+func TestClientSendTSIG(t *testing.T) {
+	key := &TSIGKey{Name: "dhcp-key.", Algorithm: HmacSHA256, Secret: []byte("secret")}
+	addr, received := fakeServer(t, key, key, RcodeSuccess)
+	c := &Client{Server: addr, Timeout: time.Second, Key: key}
+
+	u := NewUpdate("example.com.")
+	u.AddAddress("host.example.com.", net.ParseIP("10.0.0.5"), 300)
+	if err := c.Send(u); err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+	if req := <-received; binary.BigEndian.Uint16(req[10:12]) != 1 {
+		t.Error("Expected a TSIG record in the additional section")
+	}
+
+	// A response signed with a different key must be rejected
+	addr, _ = fakeServer(t, key, &TSIGKey{Name: "dhcp-key.", Secret: []byte("other")}, RcodeSuccess)
+	c.Server = addr
+	if err := c.Send(u); err == nil {
+		t.Error("Expected signature verification to fail")
+	}
+}
+
+func TestDHCID(t *testing.T) {
+	// Examples from RFC 4701 section 3.6
+	tests := []struct {
+		idType uint16
+		id     []byte
+		name   string
+		dhcid  string
+	}{
+		{DHCIDClientID, []byte{0x01, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c}, "chi.example.com", "AAEBOSD+XR3Os/0LozeXVqcNc7FwCfQdWL3b/NaiUDlW2No="},
+		{DHCIDHardware, []byte{0x01, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, "client.example.com", "AAABxLmlskllE0MVjd57zHcWmEH3pCQ6VytcKD//7es/deY="},
+	}
+	for _, test := range tests {
+		data, err := DHCID(test.idType, test.id, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := base64.StdEncoding.EncodeToString(data); s != test.dhcid {
+			t.Errorf("Expected DHCID %s for %s, got %s", test.dhcid, test.name, s)
+		}
+	}
+}
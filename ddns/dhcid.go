@@ -0,0 +1,38 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ddns
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// DHCID identifier types, RFC 4701
+const (
+	DHCIDHardware uint16 = 0 // The htype and chaddr of a DHCPv4 client
+	DHCIDClientID uint16 = 1 // A DHCPv4 client identifier, option 61
+	DHCIDDUID     uint16 = 2 // A DHCPv6 DUID
+)
+
+// dhcidSHA256 is the digest type of DHCID records.
+const dhcidSHA256 = 1
+
+// DHCID returns the RDATA of the DHCID record identifying the client with id
+// as the owner of name. The record lets servers tell if a name belongs to the
+// client they're updating, RFC 4703.
+func DHCID(idType uint16, id []byte, name string) ([]byte, error) {
+	wire, err := canonicalName(name)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(id)
+	h.Write(wire)
+
+	data := make([]byte, 3, 3+sha256.Size)
+	binary.BigEndian.PutUint16(data, idType)
+	data[2] = dhcidSHA256
+	return h.Sum(data), nil
+}
@@ -0,0 +1,277 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ddns implements DNS UPDATE messages from RFC 2136, optionally
+// signed with TSIG from RFC 8945, and DHCID records from RFC 4701.
+package ddns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Resource record types
+const (
+	TypeA     uint16 = 1
+	TypeSOA   uint16 = 6
+	TypePTR   uint16 = 12
+	TypeAAAA  uint16 = 28
+	TypeDHCID uint16 = 49
+	TypeTSIG  uint16 = 250
+	TypeANY   uint16 = 255
+)
+
+// Resource record classes
+const (
+	ClassIN   uint16 = 1
+	ClassNONE uint16 = 254
+	ClassANY  uint16 = 255
+)
+
+// opcodeUpdate is the header opcode of an UPDATE message.
+const opcodeUpdate = 5
+
+const headerLen = 12
+
+var (
+	errLabelTooLong = errors.New("ddns: label too long")
+	errNameTooLong  = errors.New("ddns: name too long")
+	errEmptyLabel   = errors.New("ddns: empty label")
+	errShortMessage = errors.New("ddns: message too short")
+	errBadPointer   = errors.New("ddns: bad compression pointer")
+)
+
+// Rcode is the response code of a DNS message.
+type Rcode uint16
+
+// Response codes, RFC 1035 and RFC 2136
+const (
+	RcodeSuccess  Rcode = 0
+	RcodeFormErr  Rcode = 1
+	RcodeServFail Rcode = 2
+	RcodeNXDomain Rcode = 3
+	RcodeNotImp   Rcode = 4
+	RcodeRefused  Rcode = 5
+	RcodeYXDomain Rcode = 6
+	RcodeYXRRSet  Rcode = 7
+	RcodeNXRRSet  Rcode = 8
+	RcodeNotAuth  Rcode = 9
+	RcodeNotZone  Rcode = 10
+)
+
+var rcodeNames = map[Rcode]string{
+	RcodeSuccess:  "NOERROR",
+	RcodeFormErr:  "FORMERR",
+	RcodeServFail: "SERVFAIL",
+	RcodeNXDomain: "NXDOMAIN",
+	RcodeNotImp:   "NOTIMP",
+	RcodeRefused:  "REFUSED",
+	RcodeYXDomain: "YXDOMAIN",
+	RcodeYXRRSet:  "YXRRSET",
+	RcodeNXRRSet:  "NXRRSET",
+	RcodeNotAuth:  "NOTAUTH",
+	RcodeNotZone:  "NOTZONE",
+}
+
+func (r Rcode) String() string {
+	if s, ok := rcodeNames[r]; ok {
+		return s
+	}
+	return "RCODE" + strconv.Itoa(int(r))
+}
+
+// An RR is a resource record in the prerequisite or update section.
+type RR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte // Wire format RDATA
+}
+
+// An Update is a DNS UPDATE message for a single zone. The updates are only
+// made if all prerequisites are met.
+type Update struct {
+	Zone    string
+	Prereqs []RR
+	Updates []RR
+}
+
+// NewUpdate creates an empty update for zone.
+func NewUpdate(zone string) *Update {
+	return &Update{Zone: zone}
+}
+
+// NameNotInUse adds a prerequisite that name has no records.
+func (u *Update) NameNotInUse(name string) {
+	u.Prereqs = append(u.Prereqs, RR{Name: name, Type: TypeANY, Class: ClassNONE})
+}
+
+// RRsetExists adds a prerequisite that name has an RRset of type rtype with
+// exactly the record data.
+func (u *Update) RRsetExists(name string, rtype uint16, data []byte) {
+	u.Prereqs = append(u.Prereqs, RR{Name: name, Type: rtype, Class: ClassIN, Data: data})
+}
+
+// Add adds a record to an RRset.
+func (u *Update) Add(name string, rtype uint16, ttl uint32, data []byte) {
+	u.Updates = append(u.Updates, RR{Name: name, Type: rtype, Class: ClassIN, TTL: ttl, Data: data})
+}
+
+// AddAddress adds an A or AAAA record depending on the address family of ip.
+func (u *Update) AddAddress(name string, ip net.IP, ttl uint32) {
+	rtype, data := addressRR(ip)
+	u.Add(name, rtype, ttl, data)
+}
+
+// AddPTR adds a PTR record pointing name at target.
+func (u *Update) AddPTR(name, target string, ttl uint32) error {
+	data, err := encodeName(target)
+	if err != nil {
+		return err
+	}
+	u.Add(name, TypePTR, ttl, data)
+	return nil
+}
+
+// DeleteRRset deletes all records of type rtype from name.
+func (u *Update) DeleteRRset(name string, rtype uint16) {
+	u.Updates = append(u.Updates, RR{Name: name, Type: rtype, Class: ClassANY})
+}
+
+// Delete deletes a single record from an RRset.
+func (u *Update) Delete(name string, rtype uint16, data []byte) {
+	u.Updates = append(u.Updates, RR{Name: name, Type: rtype, Class: ClassNONE, Data: data})
+}
+
+// DeleteAddress deletes the A or AAAA record of ip from name.
+func (u *Update) DeleteAddress(name string, ip net.IP) {
+	rtype, data := addressRR(ip)
+	u.Delete(name, rtype, data)
+}
+
+func addressRR(ip net.IP) (uint16, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return TypeA, []byte(ip4)
+	}
+	return TypeAAAA, []byte(ip.To16())
+}
+
+// Marshal returns the wire format of the update with the given message ID.
+func (u *Update) Marshal(id uint16) ([]byte, error) {
+	buf := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(buf[0:2], id)
+	binary.BigEndian.PutUint16(buf[2:4], opcodeUpdate<<11)
+	binary.BigEndian.PutUint16(buf[4:6], 1) // ZOCOUNT
+	binary.BigEndian.PutUint16(buf[6:8], uint16(len(u.Prereqs)))
+	binary.BigEndian.PutUint16(buf[8:10], uint16(len(u.Updates)))
+
+	zone, err := encodeName(u.Zone)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, zone...)
+	buf = append(buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(buf[len(buf)-4:], TypeSOA)
+	binary.BigEndian.PutUint16(buf[len(buf)-2:], ClassIN)
+
+	for _, rrs := range [][]RR{u.Prereqs, u.Updates} {
+		for _, rr := range rrs {
+			if buf, err = appendRR(buf, rr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf, nil
+}
+
+func appendRR(buf []byte, rr RR) ([]byte, error) {
+	name, err := encodeName(rr.Name)
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, name...)
+	var fixed [10]byte
+	binary.BigEndian.PutUint16(fixed[0:2], rr.Type)
+	binary.BigEndian.PutUint16(fixed[2:4], rr.Class)
+	binary.BigEndian.PutUint32(fixed[4:8], rr.TTL)
+	binary.BigEndian.PutUint16(fixed[8:10], uint16(len(rr.Data)))
+	buf = append(buf, fixed[:]...)
+	return append(buf, rr.Data...), nil
+}
+
+// encodeName returns the uncompressed wire format of a domain name. Names
+// are always treated as fully qualified.
+func encodeName(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return []byte{0}, nil
+	}
+
+	buf := make([]byte, 0, len(name)+2)
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return nil, errEmptyLabel
+		}
+		if len(label) > 63 {
+			return nil, errLabelTooLong
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	buf = append(buf, 0)
+	if len(buf) > 255 {
+		return nil, errNameTooLong
+	}
+	return buf, nil
+}
+
+// canonicalName returns the lowercase wire format of a name as used in TSIG
+// digests.
+func canonicalName(name string) ([]byte, error) {
+	return encodeName(strings.ToLower(name))
+}
+
+// skipName returns the offset after the possibly compressed name at off.
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errShortMessage
+		}
+		c := int(msg[off])
+		switch c & 0xc0 {
+		case 0x00:
+			if c == 0 {
+				return off + 1, nil
+			}
+			off += c + 1
+		case 0xc0:
+			if off+1 >= len(msg) {
+				return 0, errShortMessage
+			}
+			return off + 2, nil
+		default:
+			return 0, errBadPointer
+		}
+	}
+}
+
+// ReverseName returns the in-addr.arpa or ip6.arpa name of ip.
+func ReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.Itoa(int(ip4[3])) + "." + strconv.Itoa(int(ip4[2])) + "." +
+			strconv.Itoa(int(ip4[1])) + "." + strconv.Itoa(int(ip4[0])) + ".in-addr.arpa."
+	}
+
+	const hex = "0123456789abcdef"
+	ip16 := ip.To16()
+	buf := make([]byte, 0, 64+len("ip6.arpa."))
+	for i := len(ip16) - 1; i >= 0; i-- {
+		buf = append(buf, hex[ip16[i]&0xf], '.', hex[ip16[i]>>4], '.')
+	}
+	return string(buf) + "ip6.arpa."
+}
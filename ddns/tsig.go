@@ -0,0 +1,266 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ddns
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// TSIG algorithm names, RFC 8945 section 6
+const (
+	HmacMD5    = "hmac-md5.sig-alg.reg.int."
+	HmacSHA1   = "hmac-sha1."
+	HmacSHA256 = "hmac-sha256."
+	HmacSHA512 = "hmac-sha512."
+)
+
+// DefaultFudge is the allowed clock skew of a signature.
+const DefaultFudge = 300 * time.Second
+
+var (
+	errNoTSIG  = errors.New("ddns: response is not signed")
+	errBadTSIG = errors.New("ddns: response signature does not verify")
+)
+
+// A TSIGKey is a shared secret used to sign updates.
+type TSIGKey struct {
+	Name      string
+	Algorithm string // One of the Hmac* names, defaults to HmacSHA256
+	Secret    []byte
+}
+
+func (k *TSIGKey) algorithm() string {
+	if k.Algorithm == "" {
+		return HmacSHA256
+	}
+	return strings.ToLower(k.Algorithm)
+}
+
+func (k *TSIGKey) hash() (func() hash.Hash, error) {
+	switch strings.TrimSuffix(k.algorithm(), ".") {
+	case "hmac-md5.sig-alg.reg.int", "hmac-md5":
+		return md5.New, nil
+	case "hmac-sha1":
+		return sha1.New, nil
+	case "hmac-sha256":
+		return sha256.New, nil
+	case "hmac-sha512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("ddns: unsupported TSIG algorithm %s", k.Algorithm)
+}
+
+// algorithmName returns the canonical algorithm name as sent on the wire.
+func (k *TSIGKey) algorithmName() string {
+	alg := k.algorithm()
+	if alg == "hmac-md5" || alg == "hmac-md5." {
+		return HmacMD5
+	}
+	if !strings.HasSuffix(alg, ".") {
+		alg += "."
+	}
+	return alg
+}
+
+// tsigVariables returns the TSIG fields covered by the MAC.
+func (k *TSIGKey) tsigVariables(signed time.Time, fudge uint16) ([]byte, error) {
+	name, err := canonicalName(k.Name)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := canonicalName(k.algorithmName())
+	if err != nil {
+		return nil, err
+	}
+
+	buf := append(name, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(buf[len(buf)-6:], ClassANY) // TTL is zero
+	buf = append(buf, alg...)
+	return append(buf, timersBytes(signed, fudge)...), nil
+}
+
+// timersBytes returns the 48 bit time signed and the fudge followed by a
+// zero error and zero other length.
+func timersBytes(signed time.Time, fudge uint16) []byte {
+	buf := make([]byte, 12)
+	t := uint64(signed.Unix())
+	binary.BigEndian.PutUint16(buf[0:2], uint16(t>>32))
+	binary.BigEndian.PutUint32(buf[2:6], uint32(t))
+	binary.BigEndian.PutUint16(buf[6:8], fudge)
+	return buf
+}
+
+// Sign appends a TSIG record to msg and returns the signed message and its MAC.
+func (k *TSIGKey) Sign(msg []byte, signed time.Time) ([]byte, []byte, error) {
+	if len(msg) < headerLen {
+		return nil, nil, errShortMessage
+	}
+	h, err := k.hash()
+	if err != nil {
+		return nil, nil, err
+	}
+	fudge := uint16(DefaultFudge / time.Second)
+	vars, err := k.tsigVariables(signed, fudge)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mac := hmac.New(h, k.Secret)
+	mac.Write(msg)
+	mac.Write(vars)
+	sum := mac.Sum(nil)
+
+	out, err := k.appendTSIG(msg, sum, signed, fudge)
+	return out, sum, err
+}
+
+func (k *TSIGKey) appendTSIG(msg, sum []byte, signed time.Time, fudge uint16) ([]byte, error) {
+	alg, err := encodeName(k.algorithmName())
+	if err != nil {
+		return nil, err
+	}
+	data := append([]byte{}, alg...)
+	data = append(data, timersBytes(signed, fudge)[:8]...)
+	data = append(data, 0, 0)
+	binary.BigEndian.PutUint16(data[len(data)-2:], uint16(len(sum)))
+	data = append(data, sum...)
+	data = append(data, msg[0], msg[1]) // Original ID
+	data = append(data, 0, 0, 0, 0)     // Error and other length
+
+	out := make([]byte, len(msg), len(msg)+len(data)+64)
+	copy(out, msg)
+	binary.BigEndian.PutUint16(out[10:12], binary.BigEndian.Uint16(out[10:12])+1)
+	return appendRR(out, RR{Name: k.Name, Type: TypeTSIG, Class: ClassANY, Data: data})
+}
+
+// Verify checks the TSIG record on a response to a request signed with
+// requestMAC.
+func (k *TSIGKey) Verify(resp, requestMAC []byte) error {
+	start, rr, err := findTSIG(resp)
+	if err != nil {
+		return err
+	}
+	h, err := k.hash()
+	if err != nil {
+		return err
+	}
+
+	// RDATA: algorithm name, time signed (48), fudge, MAC size, MAC, original ID, error, other
+	off, err := skipName(rr, 0)
+	if err != nil {
+		return err
+	}
+	if len(rr) < off+10 {
+		return errShortMessage
+	}
+	timers := rr[off : off+8]
+	macLen := int(binary.BigEndian.Uint16(rr[off+8:]))
+	off += 10
+	if len(rr) < off+macLen+6 {
+		return errShortMessage
+	}
+	respMAC := rr[off : off+macLen]
+	origID := rr[off+macLen : off+macLen+2]
+	tsigErr := binary.BigEndian.Uint16(rr[off+macLen+2:])
+	other := rr[off+macLen+4:]
+	if tsigErr != 0 {
+		return fmt.Errorf("ddns: TSIG error %d", tsigErr)
+	}
+
+	// The response is digested without the TSIG record and with the original ID
+	stripped := make([]byte, start)
+	copy(stripped, resp[:start])
+	copy(stripped[0:2], origID)
+	binary.BigEndian.PutUint16(stripped[10:12], binary.BigEndian.Uint16(stripped[10:12])-1)
+
+	name, err := canonicalName(k.Name)
+	if err != nil {
+		return err
+	}
+	alg, err := canonicalName(k.algorithmName())
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(h, k.Secret)
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:], uint16(len(requestMAC)))
+	mac.Write(l[:])
+	mac.Write(requestMAC)
+	mac.Write(stripped)
+	mac.Write(name)
+	mac.Write([]byte{byte(ClassANY >> 8), byte(ClassANY), 0, 0, 0, 0})
+	mac.Write(alg)
+	mac.Write(timers)
+	mac.Write(rr[off+macLen+2 : off+macLen+4])
+	mac.Write(other)
+	if !hmac.Equal(mac.Sum(nil), respMAC) {
+		return errBadTSIG
+	}
+
+	signed := int64(binary.BigEndian.Uint16(timers[0:2]))<<32 | int64(binary.BigEndian.Uint32(timers[2:6]))
+	fudge := int64(binary.BigEndian.Uint16(timers[6:8]))
+	if d := time.Now().Unix() - signed; d > fudge || -d > fudge {
+		return errors.New("ddns: response signature time is outside the fudge")
+	}
+	return nil
+}
+
+// findTSIG returns the offset of the TSIG record, which must be the last
+// additional record, and its RDATA.
+func findTSIG(msg []byte) (int, []byte, error) {
+	if len(msg) < headerLen {
+		return 0, nil, errShortMessage
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:6]))
+	an := int(binary.BigEndian.Uint16(msg[6:8]))
+	ns := int(binary.BigEndian.Uint16(msg[8:10]))
+	ar := int(binary.BigEndian.Uint16(msg[10:12]))
+	if ar == 0 {
+		return 0, nil, errNoTSIG
+	}
+
+	off := headerLen
+	var err error
+	for i := 0; i < qd; i++ {
+		if off, err = skipName(msg, off); err != nil {
+			return 0, nil, err
+		}
+		off += 4
+	}
+	for i := 0; i < an+ns+ar; i++ {
+		start := off
+		if off, err = skipName(msg, off); err != nil {
+			return 0, nil, err
+		}
+		if len(msg) < off+10 {
+			return 0, nil, errShortMessage
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if len(msg) < off+rdlen {
+			return 0, nil, errShortMessage
+		}
+		if i == an+ns+ar-1 {
+			if rtype != TypeTSIG {
+				return 0, nil, errNoTSIG
+			}
+			return start, msg[off : off+rdlen], nil
+		}
+		off += rdlen
+	}
+	return 0, nil, errNoTSIG
+}
@@ -0,0 +1,157 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var errMalformedRoutes = errors.New("malformed classless static routes")
+
+// ClasslessRoute is a single route of options 121 and 249, RFC 3442.
+type ClasslessRoute struct {
+	Destination *net.IPNet
+	Router      net.IP
+}
+
+// EncodeClasslessRoutes returns the option 121 encoding of routes. Each route
+// is the prefix length, the significant octets of the destination and the router.
+func EncodeClasslessRoutes(routes []ClasslessRoute) ([]byte, error) {
+	var buf []byte
+	for _, r := range routes {
+		dest := r.Destination.IP.To4()
+		router := r.Router.To4()
+		ones, bits := r.Destination.Mask.Size()
+		if dest == nil || router == nil || bits != 32 {
+			return nil, fmt.Errorf("route %s via %s is not IPv4", r.Destination, r.Router)
+		}
+		buf = append(buf, byte(ones))
+		buf = append(buf, dest.Mask(r.Destination.Mask)[:(ones+7)/8]...)
+		buf = append(buf, router...)
+	}
+	return buf, nil
+}
+
+// ParseClasslessRoutes parses the contents of option 121 or 249.
+func ParseClasslessRoutes(data []byte) ([]ClasslessRoute, error) {
+	var routes []ClasslessRoute
+	for len(data) > 0 {
+		ones := int(data[0])
+		size := (ones + 7) / 8
+		if ones > 32 || len(data) < 1+size+4 {
+			return nil, errMalformedRoutes
+		}
+		dest := make(net.IP, 4)
+		copy(dest, data[1:1+size])
+		routes = append(routes, ClasslessRoute{
+			Destination: &net.IPNet{IP: dest, Mask: net.CIDRMask(ones, 32)},
+			Router:      net.IP(append([]byte(nil), data[1+size:5+size]...)),
+		})
+		data = data[5+size:]
+	}
+	return routes, nil
+}
+
+// EncodeDomainSearch returns the option 119 encoding of names, RFC 3397.
+// Names are in DNS wire format with suffixes compressed using pointers to
+// earlier names in the list, offsets are from the start of the option data.
+func EncodeDomainSearch(names []string) ([]byte, error) {
+	var buf []byte
+	offsets := make(map[string]int) // Lower case suffix to its offset in buf
+
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		if name == "" || len(name) > 253 {
+			return nil, fmt.Errorf("invalid domain name %q", name)
+		}
+		labels := strings.Split(name, ".")
+		for _, label := range labels {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid domain name %q", name)
+			}
+		}
+
+		for i := range labels {
+			suffix := strings.ToLower(strings.Join(labels[i:], "."))
+			if offset, ok := offsets[suffix]; ok {
+				buf = append(buf, 0xC0|byte(offset>>8), byte(offset))
+				break
+			}
+			if len(buf) < 0x3FFF {
+				offsets[suffix] = len(buf)
+			}
+			buf = append(buf, byte(len(labels[i])))
+			buf = append(buf, labels[i]...)
+			if i == len(labels)-1 {
+				buf = append(buf, 0)
+			}
+		}
+	}
+	return buf, nil
+}
+
+// ParseDomainSearch parses the contents of option 119.
+func ParseDomainSearch(data []byte) ([]string, error) {
+	var names []string
+	for i := 0; i < len(data); {
+		name, next, err := readCompressedName(data, i)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		i = next
+	}
+	return names, nil
+}
+
+// readCompressedName reads the name at offset i and returns it with the offset
+// following it. Pointers may only point backwards so loops are impossible.
+func readCompressedName(data []byte, i int) (string, int, error) {
+	var labels []string
+	next := -1
+	for {
+		if i >= len(data) {
+			return "", 0, errors.New("truncated domain name")
+		}
+		size := int(data[i])
+		switch {
+		case size == 0:
+			if next == -1 {
+				next = i + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case size&0xC0 == 0xC0:
+			if i+1 >= len(data) {
+				return "", 0, errors.New("truncated domain name pointer")
+			}
+			ptr := (size&0x3F)<<8 | int(data[i+1])
+			if ptr >= i {
+				return "", 0, errors.New("domain name pointer must point backwards")
+			}
+			if next == -1 {
+				next = i + 2
+			}
+			i = ptr
+		case size > 63 || i+1+size > len(data):
+			return "", 0, errors.New("malformed domain name label")
+		default:
+			labels = append(labels, string(data[i+1:i+1+size]))
+			i += 1 + size
+		}
+	}
+}
+
+// EncodeSubOption appends an encapsulated sub-option, such as those of option
+// 43, to buf.
+func EncodeSubOption(buf []byte, code byte, value []byte) ([]byte, error) {
+	if len(value) > 255 {
+		return nil, fmt.Errorf("sub-option %d is longer than 255 bytes", code)
+	}
+	buf = append(buf, code, byte(len(value)))
+	return append(buf, value...), nil
+}
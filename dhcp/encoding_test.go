@@ -0,0 +1,99 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestClasslessRoutes(t *testing.T) {
+	_, n1, _ := net.ParseCIDR("10.0.0.0/8")
+	_, n2, _ := net.ParseCIDR("192.168.128.0/17")
+	_, n3, _ := net.ParseCIDR("0.0.0.0/0")
+	routes := []ClasslessRoute{
+		{Destination: n1, Router: net.ParseIP("10.0.1.1")},
+		{Destination: n2, Router: net.ParseIP("10.0.1.2")},
+		{Destination: n3, Router: net.ParseIP("10.0.1.3")},
+	}
+
+	data, err := EncodeClasslessRoutes(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		8, 10, 10, 0, 1, 1,
+		17, 192, 168, 128, 10, 0, 1, 2,
+		0, 10, 0, 1, 3,
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("Expected %v, got %v", expected, data)
+	}
+
+	parsed, err := ParseClasslessRoutes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 3 || parsed[1].Destination.String() != "192.168.128.0/17" || !parsed[1].Router.Equal(net.ParseIP("10.0.1.2")) {
+		t.Errorf("Incorrect parsed routes %v", parsed)
+	}
+
+	if _, err := ParseClasslessRoutes([]byte{24, 10, 0}); err == nil {
+		t.Error("Expected an error for truncated routes")
+	}
+	if _, err := ParseClasslessRoutes([]byte{33, 10, 0, 0, 0, 0, 10, 0, 0, 1}); err == nil {
+		t.Error("Expected an error for an invalid prefix length")
+	}
+}
+
+func TestDomainSearch(t *testing.T) {
+	// Example from RFC 3397
+	names := []string{"eng.apple.com", "marketing.apple.com"}
+	data, err := EncodeDomainSearch(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte("\x03eng\x05apple\x03com\x00\x09marketing\xc0\x04")
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("Expected %q, got %q", expected, data)
+	}
+
+	parsed, err := ParseDomainSearch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, names) {
+		t.Errorf("Expected %v, got %v", names, parsed)
+	}
+
+	// A repeated name is a single pointer
+	data, _ = EncodeDomainSearch([]string{"example.com", "Example.com."})
+	if !bytes.Equal(data, []byte("\x07example\x03com\x00\xc0\x00")) {
+		t.Errorf("Incorrect encoding of a repeated name %q", data)
+	}
+
+	for _, bad := range []string{"", "a..b", string(make([]byte, 64)) + ".com"} {
+		if _, err := EncodeDomainSearch([]string{bad}); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+	if _, err := ParseDomainSearch([]byte("\x03com\x00\xc0\x06")); err == nil {
+		t.Error("Expected an error for a forward pointer")
+	}
+}
+
+func TestEncodeSubOption(t *testing.T) {
+	buf, _ := EncodeSubOption(nil, 1, []byte{10, 0, 0, 5})
+	buf, _ = EncodeSubOption(buf, 2, []byte("abc"))
+	expected := []byte{1, 4, 10, 0, 0, 5, 2, 3, 'a', 'b', 'c'}
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("Expected %v, got %v", expected, buf)
+	}
+	if _, err := EncodeSubOption(nil, 1, make([]byte, 256)); err == nil {
+		t.Error("Expected an error for a long sub-option")
+	}
+}
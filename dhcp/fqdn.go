@@ -0,0 +1,84 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"errors"
+	"strings"
+)
+
+// Client FQDN flags, RFC 4702
+const (
+	FQDNServerUpdate   byte = 0x01 // S: the server should perform the A record update
+	FQDNOverride       byte = 0x02 // O: the server overrode the client's S flag
+	FQDNEncoded        byte = 0x04 // E: the name is in DNS wire format
+	FQDNNoServerUpdate byte = 0x08 // N: the server should perform no updates
+)
+
+var errMalformedFQDN = errors.New("malformed client FQDN")
+
+// ClientFQDN is the parsed form of option 81.
+type ClientFQDN struct {
+	Flags byte
+	Name  string // Without a trailing dot, may be a partial name
+}
+
+// ParseClientFQDN parses the contents of option 81.
+func ParseClientFQDN(data []byte) (*ClientFQDN, error) {
+	if len(data) < 3 {
+		return nil, errMalformedFQDN
+	}
+
+	f := &ClientFQDN{Flags: data[0]}
+	if f.Flags&FQDNEncoded == 0 {
+		f.Name = strings.TrimSuffix(string(data[3:]), ".")
+		return f, nil
+	}
+
+	// Canonical wire format, a partial name omits the terminating root label
+	var labels []string
+	data = data[3:]
+	for len(data) > 0 && data[0] != 0 {
+		size := int(data[0])
+		if size > 63 || len(data) < 1+size {
+			return nil, errMalformedFQDN
+		}
+		labels = append(labels, string(data[1:1+size]))
+		data = data[1+size:]
+	}
+	f.Name = strings.Join(labels, ".")
+	return f, nil
+}
+
+// Marshal returns the option 81 encoding of f. The RCODE fields are set to
+// 255 as required of servers.
+func (f *ClientFQDN) Marshal() []byte {
+	buf := []byte{f.Flags, 255, 255}
+	if f.Flags&FQDNEncoded == 0 {
+		return append(buf, f.Name...)
+	}
+	if f.Name == "" {
+		return buf
+	}
+	for _, label := range strings.Split(f.Name, ".") {
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0)
+}
+
+// ClientFQDN returns the parsed option 81 if it exists and is valid.
+// Otherwise it returns nil.
+func (o Options) ClientFQDN() *ClientFQDN {
+	data, ok := o[OptionClientFQDN]
+	if !ok {
+		return nil
+	}
+	f, err := ParseClientFQDN(data)
+	if err != nil {
+		return nil
+	}
+	return f
+}
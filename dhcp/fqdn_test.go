@@ -0,0 +1,80 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseClientFQDN(t *testing.T) {
+	var tests = []struct {
+		description string
+		data        []byte
+		flags       byte
+		name        string
+		err         bool
+	}{
+		{
+			description: "ascii name",
+			data:        []byte{0x01, 0, 0, 'h', 'o', 's', 't', '.', 'e', 'x', '.'},
+			flags:       FQDNServerUpdate,
+			name:        "host.ex",
+		},
+		{
+			description: "encoded full name",
+			data:        []byte{0x05, 0, 0, 4, 'h', 'o', 's', 't', 2, 'e', 'x', 0},
+			flags:       FQDNServerUpdate | FQDNEncoded,
+			name:        "host.ex",
+		},
+		{
+			description: "encoded partial name",
+			data:        []byte{0x04, 0, 0, 4, 'h', 'o', 's', 't'},
+			flags:       FQDNEncoded,
+			name:        "host",
+		},
+		{
+			description: "truncated label",
+			data:        []byte{0x04, 0, 0, 6, 'h', 'o'},
+			err:         true,
+		},
+		{
+			description: "too short",
+			data:        []byte{0x01, 0},
+			err:         true,
+		},
+	}
+
+	for _, test := range tests {
+		f, err := ParseClientFQDN(test.data)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.description)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.description, err)
+			continue
+		}
+		if f.Flags != test.flags || f.Name != test.name {
+			t.Errorf("%s: expected %#x %q, got %#x %q", test.description, test.flags, test.name, f.Flags, f.Name)
+		}
+	}
+}
+
+func TestClientFQDNMarshal(t *testing.T) {
+	f := &ClientFQDN{Flags: FQDNServerUpdate | FQDNEncoded, Name: "host.ex"}
+	expected := []byte{0x05, 255, 255, 4, 'h', 'o', 's', 't', 2, 'e', 'x', 0}
+	if data := f.Marshal(); !bytes.Equal(data, expected) {
+		t.Errorf("Expected %v, got %v", expected, data)
+	}
+
+	f = &ClientFQDN{Flags: FQDNServerUpdate, Name: "host.ex"}
+	expected = []byte{0x01, 255, 255, 'h', 'o', 's', 't', '.', 'e', 'x'}
+	if data := f.Marshal(); !bytes.Equal(data, expected) {
+		t.Errorf("Expected %v, got %v", expected, data)
+	}
+}
@@ -0,0 +1,89 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// Bulk leasequery status codes sent in option 151, RFC 6926
+const (
+	StatusSuccess         byte = 0
+	StatusUnspecFail      byte = 1
+	StatusQueryTerminated byte = 2
+	StatusMalformedQuery  byte = 3
+	StatusNotAllowed      byte = 4
+)
+
+// Lease states sent in option 156, RFC 6926
+const (
+	StateAvailable     byte = 1
+	StateActive        byte = 2
+	StateExpired       byte = 3
+	StateReleased      byte = 4
+	StateAbandoned     byte = 5
+	StateReset         byte = 6
+	StateRemote        byte = 7
+	StateTransitioning byte = 8
+)
+
+// maxStreamMessage is the largest message accepted over a bulk leasequery
+// connection. Requests are small, this keeps a bad length from using much memory.
+const maxStreamMessage = 16384
+
+var errStreamMessageSize = errors.New("bulk leasequery message has an invalid length")
+
+// EncodeStatusCode returns the contents of option 151.
+func EncodeStatusCode(code byte, message string) []byte {
+	return append([]byte{code}, message...)
+}
+
+// OptionsTime returns t as seconds since the epoch, used by the base time and
+// query time options of bulk leasequery.
+func OptionsTime(t time.Time) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(t.Unix()))
+	return buf
+}
+
+// ParseOptionsTime parses a time encoded by OptionsTime.
+func ParseOptionsTime(data []byte) (time.Time, bool) {
+	if len(data) != 4 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(binary.BigEndian.Uint32(data)), 0), true
+}
+
+// ReadStreamPacket reads a packet from a bulk leasequery connection. Messages
+// over TCP are prefixed with their length as 2 bytes, RFC 6926 section 6.1.
+func ReadStreamPacket(r io.Reader) (Packet, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(size[:]))
+	if n < 240 || n > maxStreamMessage {
+		return nil, errStreamMessageSize
+	}
+	p := make(Packet, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WriteStreamPacket writes p to a bulk leasequery connection prefixed with its length.
+func WriteStreamPacket(w io.Writer, p Packet) error {
+	if len(p) > 0xFFFF {
+		return errStreamMessageSize
+	}
+	buf := make([]byte, 2, 2+len(p))
+	binary.BigEndian.PutUint16(buf, uint16(len(p)))
+	_, err := w.Write(append(buf, p...))
+	return err
+}
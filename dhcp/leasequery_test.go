@@ -0,0 +1,62 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStreamPacket(t *testing.T) {
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	p := RequestPacket(BulkLeaseQuery, mac, nil, []byte{1, 2, 3, 4}, false, nil)
+
+	var buf bytes.Buffer
+	if err := WriteStreamPacket(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteStreamPacket(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 2*(len(p)+2) {
+		t.Fatalf("Expected %d bytes, got %d", 2*(len(p)+2), buf.Len())
+	}
+
+	for i := 0; i < 2; i++ {
+		read, err := ReadStreamPacket(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, p) {
+			t.Errorf("Packet %d changed", i)
+		}
+	}
+	if _, err := ReadStreamPacket(&buf); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+
+	if _, err := ReadStreamPacket(bytes.NewReader([]byte{0, 10, 1, 2})); err != errStreamMessageSize {
+		t.Errorf("Expected errStreamMessageSize for short message, got %v", err)
+	}
+	if _, err := ReadStreamPacket(bytes.NewReader(append([]byte{1, 0}, make([]byte, 100)...))); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected ErrUnexpectedEOF for truncated message, got %v", err)
+	}
+}
+
+func TestOptionsTime(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	if got, ok := ParseOptionsTime(OptionsTime(now)); !ok || !got.Equal(now) {
+		t.Errorf("Expected %s, got %s", now, got)
+	}
+	if _, ok := ParseOptionsTime([]byte{1, 2}); ok {
+		t.Error("Expected short time to be invalid")
+	}
+	if s := EncodeStatusCode(StatusNotAllowed, "no"); !bytes.Equal(s, []byte{4, 'n', 'o'}) {
+		t.Errorf("Incorrect status code %v", s)
+	}
+}
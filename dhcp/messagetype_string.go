@@ -4,9 +4,9 @@ package dhcp4
 
 import "strconv"
 
-const _MessageType_name = "DiscoverOfferRequestDeclineACKNAKReleaseInform"
+const _MessageType_name = "DiscoverOfferRequestDeclineACKNAKReleaseInformForceRenewLeaseQueryLeaseUnassignedLeaseUnknownLeaseActiveBulkLeaseQueryLeaseQueryDone"
 
-var _MessageType_index = [...]uint8{0, 8, 13, 20, 27, 30, 33, 40, 46}
+var _MessageType_index = [...]uint8{0, 8, 13, 20, 27, 30, 33, 40, 46, 56, 66, 81, 93, 104, 118, 132}
 
 func (i MessageType) String() string {
 	i -= 1
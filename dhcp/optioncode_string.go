@@ -7,21 +7,27 @@ import "strconv"
 const (
 	_OptionCode_name_0 = "PadOptionSubnetMaskOptionTimeOffsetOptionRouterOptionTimeServerOptionNameServerOptionDomainNameServerOptionLogServerOptionCookieServerOptionLPRServerOptionImpressServerOptionResourceLocationServerOptionHostNameOptionBootFileSizeOptionMeritDumpFileOptionDomainNameOptionSwapServerOptionRootPathOptionExtensionsPathOptionIPForwardingEnableDisableOptionNonLocalSourceRoutingEnableDisableOptionPolicyFilterOptionMaximumDatagramReassemblySizeOptionDefaultIPTimeToLiveOptionPathMTUAgingTimeoutOptionPathMTUPlateauTableOptionInterfaceMTUOptionAllSubnetsAreLocalOptionBroadcastAddressOptionPerformMaskDiscoveryOptionMaskSupplierOptionPerformRouterDiscoveryOptionRouterSolicitationAddressOptionStaticRouteOptionTrailerEncapsulationOptionARPCacheTimeoutOptionEthernetEncapsulationOptionTCPDefaultTTLOptionTCPKeepaliveIntervalOptionTCPKeepaliveGarbageOptionNetworkInformationServiceDomainOptionNetworkInformationServersOptionNetworkTimeProtocolServersOptionVendorSpecificInformationOptionNetBIOSOverTCPIPNameServerOptionNetBIOSOverTCPIPDatagramDistributionServerOptionNetBIOSOverTCPIPNodeTypeOptionNetBIOSOverTCPIPScopeOptionXWindowSystemFontServerOptionXWindowSystemDisplayManagerOptionRequestedIPAddressOptionIPAddressLeaseTimeOptionOverloadOptionDHCPMessageTypeOptionServerIdentifierOptionParameterRequestListOptionMessageOptionMaximumDHCPMessageSizeOptionRenewalTimeValueOptionRebindingTimeValueOptionVendorClassIdentifierOptionClientIdentifier"
 	_OptionCode_name_1 = "OptionNetworkInformationServicePlusDomainOptionNetworkInformationServicePlusServersOptionTFTPServerNameOptionBootFileNameOptionMobileIPHomeAgentOptionSimpleMailTransportProtocolOptionPostOfficeProtocolServerOptionNetworkNewsTransportProtocolOptionDefaultWorldWideWebServerOptionDefaultFingerServerOptionDefaultInternetRelayChatServerOptionStreetTalkServerOptionStreetTalkDirectoryAssistanceOptionUserClass"
-	_OptionCode_name_2 = "OptionRelayAgentInformation"
-	_OptionCode_name_3 = "OptionClientArchitecture"
+	_OptionCode_name_2 = "OptionRapidCommitOptionClientFQDNOptionRelayAgentInformation"
+	_OptionCode_name_3 = "OptionClientLastTransactionTimeOptionAssociatedIPOptionClientArchitecture"
 	_OptionCode_name_4 = "OptionTZPOSIXStringOptionTZDatabaseString"
-	_OptionCode_name_5 = "OptionClasslessRouteFormat"
-	_OptionCode_name_6 = "End"
+	_OptionCode_name_5 = "OptionSubnetSelectionOptionDomainSearch"
+	_OptionCode_name_6 = "OptionClasslessRouteFormat"
+	_OptionCode_name_7 = "OptionStatusCodeOptionBaseTimeOptionStartTimeOfStateOptionQueryStartTimeOptionQueryEndTimeOptionDHCPStateOptionDataSource"
+	_OptionCode_name_8 = "OptionMSClasslessRouteFormat"
+	_OptionCode_name_9 = "End"
 )
 
 var (
 	_OptionCode_index_0 = [...]uint16{0, 3, 19, 35, 47, 63, 79, 101, 116, 134, 149, 168, 196, 210, 228, 247, 263, 279, 293, 313, 344, 384, 402, 437, 462, 487, 512, 530, 554, 576, 602, 620, 648, 679, 696, 722, 743, 770, 789, 815, 840, 877, 908, 940, 971, 1003, 1051, 1081, 1108, 1137, 1170, 1194, 1218, 1232, 1253, 1275, 1301, 1314, 1342, 1364, 1388, 1415, 1437}
 	_OptionCode_index_1 = [...]uint16{0, 41, 83, 103, 121, 144, 177, 207, 241, 272, 297, 333, 355, 390, 405}
-	_OptionCode_index_2 = [...]uint8{0, 27}
-	_OptionCode_index_3 = [...]uint8{0, 24}
+	_OptionCode_index_2 = [...]uint8{0, 17, 33, 60}
+	_OptionCode_index_3 = [...]uint8{0, 31, 49, 73}
 	_OptionCode_index_4 = [...]uint8{0, 19, 41}
-	_OptionCode_index_5 = [...]uint8{0, 26}
-	_OptionCode_index_6 = [...]uint8{0, 3}
+	_OptionCode_index_5 = [...]uint8{0, 21, 39}
+	_OptionCode_index_6 = [...]uint8{0, 26}
+	_OptionCode_index_7 = [...]uint8{0, 16, 30, 52, 72, 90, 105, 121}
+	_OptionCode_index_8 = [...]uint8{0, 28}
+	_OptionCode_index_9 = [...]uint8{0, 3}
 )
 
 func (i OptionCode) String() string {
@@ -31,17 +37,27 @@ func (i OptionCode) String() string {
 	case 64 <= i && i <= 77:
 		i -= 64
 		return _OptionCode_name_1[_OptionCode_index_1[i]:_OptionCode_index_1[i+1]]
-	case i == 82:
-		return _OptionCode_name_2
-	case i == 93:
-		return _OptionCode_name_3
+	case 80 <= i && i <= 82:
+		i -= 80
+		return _OptionCode_name_2[_OptionCode_index_2[i]:_OptionCode_index_2[i+1]]
+	case 91 <= i && i <= 93:
+		i -= 91
+		return _OptionCode_name_3[_OptionCode_index_3[i]:_OptionCode_index_3[i+1]]
 	case 100 <= i && i <= 101:
 		i -= 100
 		return _OptionCode_name_4[_OptionCode_index_4[i]:_OptionCode_index_4[i+1]]
+	case 118 <= i && i <= 119:
+		i -= 118
+		return _OptionCode_name_5[_OptionCode_index_5[i]:_OptionCode_index_5[i+1]]
 	case i == 121:
-		return _OptionCode_name_5
-	case i == 255:
 		return _OptionCode_name_6
+	case 151 <= i && i <= 157:
+		i -= 151
+		return _OptionCode_name_7[_OptionCode_index_7[i]:_OptionCode_index_7[i+1]]
+	case i == 249:
+		return _OptionCode_name_8
+	case i == 255:
+		return _OptionCode_name_9
 	default:
 		return "OptionCode(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
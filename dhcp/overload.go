@@ -0,0 +1,190 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Option overload values, option 52, RFC 2132. The file and sname fields hold
+// options instead of their usual values.
+const (
+	OverloadFile  byte = 1
+	OverloadSName byte = 2
+	OverloadBoth  byte = 3
+)
+
+const (
+	// MinMaxMessageSize is the smallest maximum message size a client may send,
+	// and the size every client must accept, RFC 2132 section 9.10.
+	MinMaxMessageSize = 576
+	// MaxMaxMessageSize is the largest message size used, an Ethernet MTU, so
+	// replies aren't fragmented.
+	MaxMaxMessageSize = 1500
+
+	ipUDPHeaderLen = 28 // IPv4 header without options and UDP header
+)
+
+// MaxMessageSize returns the largest DHCP message the client accepts. The maximum
+// message size option (57) counts the IP and UDP headers, they aren't included in
+// the returned size. Clients without a valid option get the minimum every client
+// accepts.
+func (o Options) MaxMessageSize() int {
+	size := MinMaxMessageSize
+	if v := o[OptionMaximumDHCPMessageSize]; len(v) == 2 {
+		if s := int(binary.BigEndian.Uint16(v)); s > size {
+			size = s
+		}
+	}
+	if size > MaxMaxMessageSize {
+		size = MaxMaxMessageSize
+	}
+	return size - ipUDPHeaderLen
+}
+
+// requiredOptions are never left out of a reply, a DHCP client can't use it without them.
+var requiredOptions = map[OptionCode]bool{
+	OptionDHCPMessageType:    true,
+	OptionServerIdentifier:   true,
+	OptionIPAddressLeaseTime: true,
+}
+
+// Fit returns p with its options laid out so it's at most maxSize bytes. When the
+// options field is full, options continue in the file field and then the sname
+// field if p is a DHCP message and the fields are empty (option overload). Options
+// that don't fit are left out whole, including every part of a split option.
+// The message type, server identifier, lease time and relay agent information
+// options are always kept. p is returned as is if it's already small enough.
+func (p Packet) Fit(maxSize int) Packet {
+	if len(p) <= maxSize || len(p) < 240 {
+		return p
+	}
+
+	opts := groupOptions(rawOptions(p.Options()))
+	var rai []byte
+	if n := len(opts); n > 0 && OptionCode(opts[n-1][0][0]) == OptionRelayAgentInformation {
+		rai = bytes.Join(opts[n-1], nil) // Must stay the last option, RFC 3046
+		opts = opts[:n-1]
+	}
+
+	// Space for each field, each ends with an End option
+	fields := []int{maxSize - 240 - len(rai) - 1}
+	var overload []byte
+	if _, ok := p.ParseOptions()[OptionDHCPMessageType]; ok {
+		if len(p.File()) == 0 {
+			fields = append(fields, 128-1)
+			overload = append(overload, OverloadFile)
+		}
+		if len(p.SName()) == 0 {
+			fields = append(fields, 64-1)
+			overload = append(overload, OverloadSName)
+		}
+		if len(overload) > 0 {
+			fields[0] -= 3 // Option 52
+		}
+	}
+
+	// Required options go first in the options field, the rest are placed in
+	// order in the first field with space. The parts of a split option are
+	// concatenated in field order, so a part is never placed in an earlier
+	// field than the part before it.
+	data := make([][]byte, len(fields))
+	for _, parts := range opts {
+		if requiredOptions[OptionCode(parts[0][0])] {
+			data[0] = append(data[0], bytes.Join(parts, nil)...)
+		}
+	}
+	for _, parts := range opts {
+		if requiredOptions[OptionCode(parts[0][0])] {
+			continue
+		}
+		placed := make([]int, len(parts))
+		used := make([]int, len(fields))
+		for i := range data {
+			used[i] = len(data[i])
+		}
+		field := 0
+		for i, o := range parts {
+			for field < len(fields) && used[field]+len(o) > fields[field] {
+				field++
+			}
+			if field == len(fields) {
+				break
+			}
+			placed[i] = field
+			used[field] += len(o)
+		}
+		if field == len(fields) {
+			continue // Leave out the whole option but keep trying smaller ones
+		}
+		for i, o := range parts {
+			data[placed[i]] = append(data[placed[i]], o...)
+		}
+	}
+
+	fit := make(Packet, 240, maxSize)
+	copy(fit, p[:240])
+	fit = append(fit, data[0]...)
+
+	var used byte
+	for i := 1; i < len(data); i++ {
+		if len(data[i]) == 0 {
+			continue
+		}
+		area := fit[108:236] // file
+		if overload[i-1] == OverloadSName {
+			area = fit[44:108]
+		}
+		copy(area, data[i])
+		area[len(data[i])] = byte(End)
+		for j := len(data[i]) + 1; j < len(area); j++ {
+			area[j] = byte(Pad)
+		}
+		used |= overload[i-1]
+	}
+	if used != 0 {
+		fit = append(fit, byte(OptionOverload), 1, used)
+	}
+	fit = append(fit, rai...)
+	fit = append(fit, byte(End))
+	fit.PadToMinSize()
+	return fit
+}
+
+// groupOptions groups the parts of options split into several options with
+// the same code, RFC 3396. Groups are in the order of their first part.
+func groupOptions(opts [][]byte) [][][]byte {
+	var groups [][][]byte
+	index := make(map[byte]int)
+	for _, o := range opts {
+		if i, ok := index[o[0]]; ok {
+			groups[i] = append(groups[i], o)
+			continue
+		}
+		index[o[0]] = len(groups)
+		groups = append(groups, [][]byte{o})
+	}
+	return groups
+}
+
+// rawOptions splits encoded options into individual code, length, value slices.
+// Pad options are dropped.
+func rawOptions(data []byte) [][]byte {
+	var opts [][]byte
+	for len(data) >= 2 && OptionCode(data[0]) != End {
+		if OptionCode(data[0]) == Pad {
+			data = data[1:]
+			continue
+		}
+		size := int(data[1])
+		if len(data) < 2+size {
+			break
+		}
+		opts = append(opts, data[:2+size])
+		data = data[2+size:]
+	}
+	return opts
+}
@@ -0,0 +1,189 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMaxMessageSize(t *testing.T) {
+	tests := []struct {
+		value []byte
+		size  int
+	}{
+		{nil, 548},
+		{[]byte{0x03, 0xe8}, 972},
+		{[]byte{0x00, 0x64}, 548},
+		{[]byte{0x23, 0x28}, 1472},
+		{[]byte{0x03}, 548},
+	}
+	for i, tt := range tests {
+		o := Options{}
+		if tt.value != nil {
+			o[OptionMaximumDHCPMessageSize] = tt.value
+		}
+		if size := o.MaxMessageSize(); size != tt.size {
+			t.Errorf("%02d: expected %d, got %d", i, tt.size, size)
+		}
+	}
+}
+
+func TestLongOptions(t *testing.T) {
+	long := bytes.Repeat([]byte{1, 2, 3}, 100)
+	p := NewPacket(BootReply)
+	p.AddOption(OptionDomainSearch, long)
+	p.AddOption(OptionRapidCommit, nil)
+
+	// Split in 255 and 45 byte parts
+	opts := p.Options()
+	if opts[0] != byte(OptionDomainSearch) || opts[1] != 255 || opts[257] != byte(OptionDomainSearch) || opts[258] != 45 {
+		t.Fatalf("Option not split: %v", opts)
+	}
+	parsed := p.ParseOptions()
+	if !bytes.Equal(parsed[OptionDomainSearch], long) {
+		t.Errorf("Split option not concatenated, got %v", parsed[OptionDomainSearch])
+	}
+	if v, ok := parsed[OptionRapidCommit]; !ok || len(v) != 0 {
+		t.Errorf("Expected empty rapid commit option, got %v", v)
+	}
+}
+
+func TestParseOverloadedOptions(t *testing.T) {
+	p := NewPacket(BootRequest)
+	p.AddOption(OptionDHCPMessageType, []byte{byte(Discover)})
+	p.AddOption(OptionOverload, []byte{OverloadBoth})
+	copy(p[108:], []byte{byte(OptionHostName), 4, 'h', 'o', 's', 't', byte(End)})
+	copy(p[44:], []byte{byte(OptionUserClass), 3, 'a', 'b', 'c', byte(End)})
+
+	opts := p.ParseOptions()
+	if string(opts[OptionHostName]) != "host" || string(opts[OptionUserClass]) != "abc" {
+		t.Errorf("Overloaded options not parsed: %v", opts)
+	}
+
+	// Without option 52 the fields are ignored
+	p = NewPacket(BootRequest)
+	copy(p[108:], []byte{byte(OptionHostName), 4, 'h', 'o', 's', 't', byte(End)})
+	if _, ok := p.ParseOptions()[OptionHostName]; ok {
+		t.Error("Expected file field to not be parsed")
+	}
+}
+
+func TestFit(t *testing.T) {
+	req := NewPacket(BootRequest)
+	req.AddOption(OptionRelayAgentInformation, []byte{1, 2, 'a', 'b'})
+
+	bigOptions := func() []Option {
+		var opts []Option
+		for i := 0; i < 8; i++ {
+			opts = append(opts, Option{Code: OptionCode(224 + i), Value: bytes.Repeat([]byte{byte(i)}, 40)})
+		}
+		return opts
+	}
+	p := ReplyPacket(req, ACK, net.IP{10, 0, 0, 1}, net.IP{10, 0, 1, 10}, 0, bigOptions())
+	if len(p) <= 548 {
+		t.Fatalf("Test reply is too small, %d bytes", len(p))
+	}
+
+	fit := p.Fit(548)
+	if len(fit) > 548 {
+		t.Errorf("Expected at most 548 bytes, got %d", len(fit))
+	}
+	opts := fit.ParseOptions()
+	if v := opts[OptionOverload]; !bytes.Equal(v, []byte{OverloadFile}) {
+		t.Errorf("Expected file overload, got %v", v)
+	}
+	for _, o := range bigOptions() {
+		if !bytes.Equal(opts[o.Code], o.Value) {
+			t.Errorf("Option %d lost in overloaded reply", o.Code)
+		}
+	}
+	raw := rawOptions(fit.Options())
+	if last := raw[len(raw)-1]; OptionCode(last[0]) != OptionRelayAgentInformation {
+		t.Errorf("Expected relay agent information last, got option %d", last[0])
+	}
+
+	// A set file field isn't overloaded and options that don't fit are left out
+	p.SetFile([]byte("pxelinux.0"))
+	fit = p.Fit(548)
+	if len(fit) > 548 || string(fit.File()) != "pxelinux.0" {
+		t.Errorf("Expected file field kept, got %q", fit.File())
+	}
+	opts = fit.ParseOptions()
+	if _, ok := opts[OptionOverload]; !ok {
+		t.Error("Expected sname overload")
+	}
+	if _, ok := opts[OptionCode(231)]; ok {
+		t.Error("Expected last option to be left out")
+	}
+	if _, ok := opts[OptionRelayAgentInformation]; !ok {
+		t.Error("Expected relay agent information kept")
+	}
+	if mt := opts[OptionDHCPMessageType]; !bytes.Equal(mt, []byte{byte(ACK)}) {
+		t.Errorf("Expected message type kept, got %v", mt)
+	}
+
+	// Small replies are unchanged
+	small := ReplyPacket(req, ACK, net.IP{10, 0, 0, 1}, net.IP{10, 0, 1, 10}, 0, nil)
+	if fit := small.Fit(548); !bytes.Equal(fit, small) {
+		t.Error("Expected small reply to be unchanged")
+	}
+}
+
+func TestFitSplitOption(t *testing.T) {
+	req := NewPacket(BootRequest)
+	long := bytes.Repeat([]byte{'a'}, 300) // Split in 255 and 45 byte parts
+	opts := []Option{
+		{Code: OptionDomainSearch, Value: long},
+		{Code: OptionHostName, Value: []byte("host")},
+	}
+	p := ReplyPacket(req, ACK, net.IP{10, 0, 0, 1}, net.IP{10, 0, 1, 10}, time.Hour, opts)
+	maxSize := Options{OptionMaximumDHCPMessageSize: []byte{0x02, 0x40}}.MaxMessageSize() // 576
+	if len(p) <= maxSize {
+		t.Fatalf("Test reply is too small, %d bytes", len(p))
+	}
+
+	// The second part continues in the file field
+	fit := p.Fit(maxSize)
+	if len(fit) > maxSize {
+		t.Errorf("Expected at most %d bytes, got %d", maxSize, len(fit))
+	}
+	if v := fit.ParseOptions()[OptionDomainSearch]; !bytes.Equal(v, long) {
+		t.Errorf("Expected the whole split option, got %d bytes", len(v))
+	}
+
+	// Without overloading only the first part fits, the option is left out whole
+	p.SetFile([]byte("pxelinux.0"))
+	p.SetSName([]byte("boot"))
+	fit = p.Fit(maxSize)
+	if len(fit) > maxSize {
+		t.Errorf("Expected at most %d bytes, got %d", maxSize, len(fit))
+	}
+	parsed := fit.ParseOptions()
+	if v, ok := parsed[OptionDomainSearch]; ok {
+		t.Errorf("Expected split option left out, got %d bytes", len(v))
+	}
+	if string(parsed[OptionHostName]) != "host" {
+		t.Error("Expected option after the left out option kept")
+	}
+
+	// The required options are kept even when they come last
+	p = NewPacket(BootReply)
+	p.AddOption(OptionDomainSearch, bytes.Repeat([]byte{'a'}, 600))
+	p.AddOption(OptionDHCPMessageType, []byte{byte(ACK)})
+	p.AddOption(OptionServerIdentifier, []byte{10, 0, 0, 1})
+	p.AddOption(OptionIPAddressLeaseTime, OptionsLeaseTime(time.Hour))
+	parsed = p.Fit(maxSize).ParseOptions()
+	for _, code := range []OptionCode{OptionDHCPMessageType, OptionServerIdentifier, OptionIPAddressLeaseTime} {
+		if _, ok := parsed[code]; !ok {
+			t.Errorf("Expected option %d kept", code)
+		}
+	}
+	if _, ok := parsed[OptionDomainSearch]; ok {
+		t.Error("Expected option too large for the reply left out")
+	}
+}
@@ -12,6 +12,7 @@
 package dhcp4
 
 import (
+	"bytes"
 	"net"
 	"time"
 )
@@ -64,6 +65,15 @@ func trimNull(d []byte) []byte {
 }
 
 func (p Packet) Cookie() []byte { return p[236:240] }
+
+// magicCookie starts the options of a DHCP packet and the vendor extensions
+// of a BOOTP packet that uses them, RFC 1497.
+var magicCookie = []byte{99, 130, 83, 99}
+
+// HasMagicCookie returns if the packet's options start with the magic cookie.
+// BOOTP clients that don't set it may use the vendor area for anything.
+func (p Packet) HasMagicCookie() bool { return bytes.Equal(p.Cookie(), magicCookie) }
+
 func (p Packet) Options() []byte {
 	if len(p) > 240 {
 		return p[240:]
@@ -114,10 +124,24 @@ func (p Packet) SetFile(file []byte) {
 // Map of DHCP options
 type Options map[OptionCode][]byte
 
-// Parses the packet's options into an Options map
+// Parses the packet's options into an Options map. If the options overload the
+// file or sname fields (option 52), the options in them are included. Options
+// that appear more than once are concatenated, RFC 3396.
 func (p Packet) ParseOptions() Options {
-	opts := p.Options()
 	options := make(Options, 10)
+	parseOptionsInto(options, p.Options())
+	if o := options[OptionOverload]; len(o) == 1 && len(p) >= 240 {
+		if o[0]&OverloadFile != 0 {
+			parseOptionsInto(options, p[108:236])
+		}
+		if o[0]&OverloadSName != 0 {
+			parseOptionsInto(options, p[44:108])
+		}
+	}
+	return options
+}
+
+func parseOptionsInto(options Options, opts []byte) {
 	for len(opts) >= 2 && OptionCode(opts[0]) != End {
 		if OptionCode(opts[0]) == Pad {
 			opts = opts[1:]
@@ -127,26 +151,43 @@ func (p Packet) ParseOptions() Options {
 		if len(opts) < 2+size {
 			break
 		}
-		options[OptionCode(opts[0])] = opts[2 : 2+size]
+		code := OptionCode(opts[0])
+		if prev, ok := options[code]; ok {
+			// Copy so the packet isn't overwritten by the concatenation
+			options[code] = append(append(make([]byte, 0, len(prev)+size), prev...), opts[2:2+size]...)
+		} else {
+			options[code] = opts[2 : 2+size]
+		}
 		opts = opts[2+size:]
 	}
-	return options
 }
 
 func NewPacket(opCode OpCode) Packet {
 	p := make(Packet, 241)
 	p.SetOpCode(opCode)
 	p.SetHType(1) // Ethernet
-	p.SetCookie([]byte{99, 130, 83, 99})
+	p.SetCookie(magicCookie)
 	p[240] = byte(End)
 	return p
 }
 
-// Appends a DHCP option to the end of a packet
+// Appends a DHCP option to the end of a packet. Values longer than 255 bytes
+// are split into multiple options with the same code, RFC 3396.
 func (p *Packet) AddOption(o OptionCode, value []byte) {
-	*p = append((*p)[:len(*p)-1], []byte{byte(o), byte(len(value))}...) // Strip off End, Add OptionCode and Length
-	*p = append(*p, value...)                                           // Add Option Value
-	*p = append(*p, byte(End))                                          // Add on new End
+	*p = (*p)[:len(*p)-1] // Strip off End
+	for {
+		n := len(value)
+		if n > 255 {
+			n = 255
+		}
+		*p = append(*p, byte(o), byte(n)) // Add OptionCode and Length
+		*p = append(*p, value[:n]...)     // Add Option Value
+		value = value[n:]
+		if len(value) == 0 {
+			break
+		}
+	}
+	*p = append(*p, byte(End)) // Add on new End
 }
 
 // Removes all options from packet.
@@ -190,6 +231,38 @@ func ReplyPacket(req Packet, mt MessageType, serverId, yIAddr net.IP, leaseDurat
 	for _, o := range options {
 		p.AddOption(o.Code, o.Value)
 	}
+	// Relay agent information must be echoed back as the last option, RFC 3046
+	if rai, ok := req.ParseOptions()[OptionRelayAgentInformation]; ok {
+		p.AddOption(OptionRelayAgentInformation, rai)
+	}
+	p.PadToMinSize()
+	return p
+}
+
+// BOOTPReplyPacket creates a reply packet that a Server would send to a BOOTP
+// client, RFC 951. Unlike ReplyPacket it has no message type, server identifier,
+// or lease time. The options are only added if the request had the magic cookie,
+// otherwise the vendor area is left empty.
+func BOOTPReplyPacket(req Packet, yIAddr net.IP, options []Option) Packet {
+	p := NewPacket(BootReply)
+	p.SetHType(req.HType())
+	p.SetXId(req.XId())
+	p.SetFlags(req.Flags())
+	p.SetCIAddr(req.CIAddr())
+	p.SetYIAddr(yIAddr)
+	p.SetGIAddr(req.GIAddr())
+	p.SetCHAddr(req.CHAddr())
+	if !req.HasMagicCookie() {
+		p = p[:236]
+		p.PadToMinSize()
+		return p
+	}
+	for _, o := range options {
+		p.AddOption(o.Code, o.Value)
+	}
+	if rai, ok := req.ParseOptions()[OptionRelayAgentInformation]; ok {
+		p.AddOption(OptionRelayAgentInformation, rai)
+	}
 	p.PadToMinSize()
 	return p
 }
@@ -222,6 +295,16 @@ const (
 	NAK      MessageType = 6 // From Server, No you cannot have that IP
 	Release  MessageType = 7 // From Client, I don't need that IP anymore
 	Inform   MessageType = 8 // From Client, I have this IP and there's nothing you can do about it
+
+	ForceRenew MessageType = 9 // From Server, Renew your lease now, RFC 3203
+
+	// Leasequery, RFC 4388 and bulk leasequery, RFC 6926
+	LeaseQuery      MessageType = 10 // From Relay, Who has this IP, MAC or client ID
+	LeaseUnassigned MessageType = 11 // From Server, This IP is mine but not leased
+	LeaseUnknown    MessageType = 12 // From Server, I don't know about that
+	LeaseActive     MessageType = 13 // From Server, Here's the active lease
+	BulkLeaseQuery  MessageType = 14 // From Relay over TCP, Tell me about many leases
+	LeaseQueryDone  MessageType = 15 // From Server over TCP, That's all of them
 )
 
 //go:generate stringer -type=OptionCode
@@ -301,6 +384,8 @@ const (
 	OptionStreetTalkServer                           OptionCode = 75
 	OptionStreetTalkDirectoryAssistance              OptionCode = 76
 
+	OptionRapidCommit           OptionCode = 80 // RFC 4039
+	OptionClientFQDN            OptionCode = 81
 	OptionRelayAgentInformation OptionCode = 82
 
 	// DHCP Extensions
@@ -322,12 +407,28 @@ const (
 
 	OptionUserClass OptionCode = 77
 
-	OptionClientArchitecture OptionCode = 93
+	OptionClientLastTransactionTime OptionCode = 91 // RFC 4388
+	OptionAssociatedIP              OptionCode = 92 // RFC 4388
+	OptionClientArchitecture        OptionCode = 93
 
 	OptionTZPOSIXString    OptionCode = 100
 	OptionTZDatabaseString OptionCode = 101
 
+	OptionSubnetSelection OptionCode = 118 // RFC 3011
+	OptionDomainSearch    OptionCode = 119
+
 	OptionClasslessRouteFormat OptionCode = 121
+
+	// Bulk leasequery, RFC 6926
+	OptionStatusCode       OptionCode = 151
+	OptionBaseTime         OptionCode = 152
+	OptionStartTimeOfState OptionCode = 153
+	OptionQueryStartTime   OptionCode = 154
+	OptionQueryEndTime     OptionCode = 155
+	OptionDHCPState        OptionCode = 156
+	OptionDataSource       OptionCode = 157
+
+	OptionMSClasslessRouteFormat OptionCode = 249 // Microsoft's pre-standard option 121
 )
 
 /* Notes
@@ -261,6 +261,43 @@ func TestReplyPacket(t *testing.T) {
 	}
 }
 
+func TestBOOTPReplyPacket(t *testing.T) {
+	mac := net.HardwareAddr{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc}
+	req := NewPacket(BootRequest)
+	req.SetCHAddr(mac)
+	req.SetXId([]byte{1, 2, 3, 4})
+	req.SetGIAddr(net.IP{10, 0, 1, 1})
+	req.AddOption(OptionRelayAgentInformation, []byte{1, 1, 5})
+	req.PadToMinSize()
+
+	yiaddr := net.IP{10, 0, 1, 10}
+	p := BOOTPReplyPacket(req, yiaddr, []Option{{Code: OptionRouter, Value: []byte{10, 0, 1, 1}}})
+	if p.OpCode() != BootReply || !p.YIAddr().Equal(yiaddr) || !bytes.Equal(p.XId(), req.XId()) ||
+		!bytes.Equal(p.CHAddr(), mac) || !p.GIAddr().Equal(req.GIAddr()) {
+		t.Fatalf("BOOTP reply header not set: %v", p)
+	}
+	opts := p.ParseOptions()
+	if _, ok := opts[OptionDHCPMessageType]; ok {
+		t.Error("BOOTP reply has a message type")
+	}
+	if !bytes.Equal(opts[OptionRouter], []byte{10, 0, 1, 1}) {
+		t.Errorf("Expected router option, got %v", opts[OptionRouter])
+	}
+	if !bytes.Equal(opts[OptionRelayAgentInformation], []byte{1, 1, 5}) {
+		t.Errorf("Expected relay agent information echoed, got %v", opts[OptionRelayAgentInformation])
+	}
+	if len(p) < 272 {
+		t.Errorf("BOOTP reply not padded, length %d", len(p))
+	}
+
+	// Without the magic cookie the vendor area is left empty
+	req.SetCookie([]byte{0, 0, 0, 0})
+	p = BOOTPReplyPacket(req, yiaddr, []Option{{Code: OptionRouter, Value: []byte{10, 0, 1, 1}}})
+	if p.HasMagicCookie() || !bytes.Equal(p[236:], make([]byte, len(p)-236)) {
+		t.Errorf("Expected empty vendor area, got %v", p[236:])
+	}
+}
+
 // newPacket mimics the raw logic of NewPacket, and verifies that its
 // behavior does not change.
 func newPacket(opCode OpCode) Packet {
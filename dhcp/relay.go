@@ -0,0 +1,82 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"errors"
+	"net"
+)
+
+// Relay Agent Information sub-options, RFC 3046, RFC 3527 and RFC 6925
+const (
+	RelayCircuitID     byte = 1
+	RelayRemoteID      byte = 2
+	RelayLinkSelection byte = 5
+	RelayRelayID       byte = 12
+)
+
+var errMalformedSubOption = errors.New("malformed sub-option")
+
+// RelayAgentInformation is the parsed form of option 82.
+type RelayAgentInformation struct {
+	CircuitID     []byte
+	RemoteID      []byte
+	LinkSelection net.IP
+	SubOptions    map[byte][]byte // Every sub-option including the ones above
+}
+
+// ParseRelayAgentInformation parses the contents of option 82. An error is
+// returned if a sub-option runs past the end of data.
+func ParseRelayAgentInformation(data []byte) (*RelayAgentInformation, error) {
+	subOpts, err := parseSubOptions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RelayAgentInformation{
+		CircuitID:  subOpts[RelayCircuitID],
+		RemoteID:   subOpts[RelayRemoteID],
+		SubOptions: subOpts,
+	}
+	if ls, ok := subOpts[RelayLinkSelection]; ok {
+		if len(ls) != 4 {
+			return nil, errMalformedSubOption
+		}
+		r.LinkSelection = net.IP(ls)
+	}
+	return r, nil
+}
+
+// RelayAgentInformation returns the parsed option 82 if it exists and is valid.
+// Otherwise it returns nil. Clients can send the option themselves, it should
+// only be trusted in requests from a known relay.
+func (o Options) RelayAgentInformation() *RelayAgentInformation {
+	data, ok := o[OptionRelayAgentInformation]
+	if !ok {
+		return nil
+	}
+	r, err := ParseRelayAgentInformation(data)
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
+// parseSubOptions parses a byte slice of code, length, value triplets.
+func parseSubOptions(data []byte) (map[byte][]byte, error) {
+	subOpts := make(map[byte][]byte)
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errMalformedSubOption
+		}
+		size := int(data[1])
+		if len(data) < 2+size {
+			return nil, errMalformedSubOption
+		}
+		subOpts[data[0]] = data[2 : 2+size]
+		data = data[2+size:]
+	}
+	return subOpts, nil
+}
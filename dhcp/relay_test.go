@@ -0,0 +1,89 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp4
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestParseRelayAgentInformation(t *testing.T) {
+	var tests = []struct {
+		description string
+		data        []byte
+		circuitID   []byte
+		remoteID    []byte
+		link        net.IP
+		err         bool
+	}{
+		{
+			description: "circuit and remote id",
+			data:        []byte{1, 3, 'g', 'i', '1', 2, 2, 0xab, 0xcd},
+			circuitID:   []byte("gi1"),
+			remoteID:    []byte{0xab, 0xcd},
+		},
+		{
+			description: "link selection",
+			data:        []byte{1, 1, 'a', 5, 4, 10, 0, 1, 0},
+			circuitID:   []byte("a"),
+			link:        net.IP{10, 0, 1, 0},
+		},
+		{
+			description: "truncated sub-option",
+			data:        []byte{1, 5, 'a', 'b'},
+			err:         true,
+		},
+		{
+			description: "bad link selection",
+			data:        []byte{5, 2, 10, 0},
+			err:         true,
+		},
+	}
+
+	for i, tt := range tests {
+		r, err := ParseRelayAgentInformation(tt.data)
+		if tt.err {
+			if err == nil {
+				t.Fatalf("%02d: test %q, expected an error", i, tt.description)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%02d: test %q, unexpected error: %v", i, tt.description, err)
+		}
+
+		if !bytes.Equal(r.CircuitID, tt.circuitID) {
+			t.Fatalf("%02d: test %q, unexpected circuit id: %v != %v", i, tt.description, tt.circuitID, r.CircuitID)
+		}
+		if !bytes.Equal(r.RemoteID, tt.remoteID) {
+			t.Fatalf("%02d: test %q, unexpected remote id: %v != %v", i, tt.description, tt.remoteID, r.RemoteID)
+		}
+		if !r.LinkSelection.Equal(tt.link) {
+			t.Fatalf("%02d: test %q, unexpected link selection: %v != %v", i, tt.description, tt.link, r.LinkSelection)
+		}
+	}
+}
+
+func TestReplyPacketEchoesRelayInformation(t *testing.T) {
+	rai := []byte{1, 3, 'g', 'i', '1'}
+	req := RequestPacket(Discover, net.HardwareAddr{1, 2, 3, 4, 5, 6}, nil, []byte{1, 2, 3, 4}, false, []Option{
+		{Code: OptionRelayAgentInformation, Value: rai},
+	})
+
+	p := ReplyPacket(req, Offer, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 0, oneOptionSlice)
+	if got := p.ParseOptions()[OptionRelayAgentInformation]; !bytes.Equal(got, rai) {
+		t.Fatalf("Relay agent information not echoed: %v != %v", rai, got)
+	}
+
+	// Option 82 must be the last option
+	var last OptionCode
+	for opts := p.Options(); len(opts) > 1 && OptionCode(opts[0]) != End; opts = opts[2+int(opts[1]):] {
+		last = OptionCode(opts[0])
+	}
+	if last != OptionRelayAgentInformation {
+		t.Fatalf("Relay agent information is not the last option, got %s", last)
+	}
+}
@@ -23,6 +23,14 @@ type Handler interface {
 	ServeDHCP(req Packet, msgType MessageType, options Options) Packet
 }
 
+// A BOOTPHandler takes a BOOTP request packet, one without a DHCP message
+// type, and generates a response to the client. A Handler passed to Serve may
+// implement BOOTPHandler to answer legacy BOOTP clients, otherwise those
+// packets are ignored.
+type BOOTPHandler interface {
+	ServeBOOTP(req Packet, options Options) Packet
+}
+
 // ServeConn is the bare minimum connection functions required by Serve()
 // It allows you to create custom connections for greater control,
 // such as ServeIfConn (see serverif.go), which locks to a given interface.
@@ -81,38 +89,53 @@ func Serve(conn ServeConn, handler Handler, workers int) (err error) {
 func process(conn ServeConn, p Packet, handler Handler, from net.Addr) {
 	options := p.ParseOptions()
 
-	t := options[OptionDHCPMessageType]
+	t, ok := options[OptionDHCPMessageType]
+	if !ok {
+		if bh, ok := handler.(BOOTPHandler); ok && p.OpCode() == BootRequest {
+			sendReply(conn, p, bh.ServeBOOTP(p, options).Fit(options.MaxMessageSize()), from)
+		}
+		return
+	}
 	if len(t) != 1 {
 		return
 	}
 
 	reqType := MessageType(t[0])
-	if reqType < Discover || reqType > Inform {
+	if (reqType < Discover || reqType > Inform) && reqType != LeaseQuery {
 		return
 	}
 
-	if res := handler.ServeDHCP(p, reqType, options); res != nil {
-		// If coming from a relay, unicast back
-		if !p.GIAddr().Equal(net.IPv4zero) {
-			if _, e := conn.WriteTo(res, from); e != nil {
-				panic(e)
-			}
-			return
-		}
+	// Replies are fit to the size the client accepts, RFC 2131 section 4.1
+	sendReply(conn, p, handler.ServeDHCP(p, reqType, options).Fit(options.MaxMessageSize()), from)
+}
 
-		ipStr, portStr, err := net.SplitHostPort(from.String())
-		if err != nil {
-			return
-		}
+// sendReply writes the response to request p back to the client or relay.
+// Nothing is sent if res is nil.
+func sendReply(conn ServeConn, p, res Packet, from net.Addr) {
+	if res == nil {
+		return
+	}
 
-		// If IP not available or broadcast bit is set, broadcast
-		if net.ParseIP(ipStr).Equal(net.IPv4zero) || p.Broadcast() {
-			port, _ := strconv.Atoi(portStr)
-			from = &net.UDPAddr{IP: net.IPv4bcast, Port: port}
-		}
+	// If coming from a relay, unicast back
+	if !p.GIAddr().Equal(net.IPv4zero) {
 		if _, e := conn.WriteTo(res, from); e != nil {
 			panic(e)
 		}
+		return
+	}
+
+	ipStr, portStr, err := net.SplitHostPort(from.String())
+	if err != nil {
+		return
+	}
+
+	// If IP not available or broadcast bit is set, broadcast
+	if net.ParseIP(ipStr).Equal(net.IPv4zero) || p.Broadcast() {
+		port, _ := strconv.Atoi(portStr)
+		from = &net.UDPAddr{IP: net.IPv4bcast, Port: port}
+	}
+	if _, e := conn.WriteTo(res, from); e != nil {
+		panic(e)
 	}
 }
 
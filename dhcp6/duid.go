@@ -0,0 +1,70 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp6
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// DUIDType is the type of a DHCP Unique Identifier.
+type DUIDType uint16
+
+// DUID types, RFC 8415 section 11
+const (
+	DUIDLLT  DUIDType = 1
+	DUIDEN   DUIDType = 2
+	DUIDLL   DUIDType = 3
+	DUIDUUID DUIDType = 4
+)
+
+// ethernetHType is the IANA hardware type for Ethernet.
+const ethernetHType = 1
+
+// A DUID identifies a DHCPv6 client or server.
+type DUID []byte
+
+// NewDUIDLL creates a DUID-LL from an Ethernet hardware address.
+func NewDUIDLL(mac net.HardwareAddr) DUID {
+	d := make(DUID, 4, 4+len(mac))
+	binary.BigEndian.PutUint16(d[0:2], uint16(DUIDLL))
+	binary.BigEndian.PutUint16(d[2:4], ethernetHType)
+	return append(d, mac...)
+}
+
+// Type returns the DUID type, or 0 if the DUID is too short.
+func (d DUID) Type() DUIDType {
+	if len(d) < 2 {
+		return 0
+	}
+	return DUIDType(binary.BigEndian.Uint16(d[0:2]))
+}
+
+// HardwareAddr returns the Ethernet address in a DUID-LLT or DUID-LL.
+// It returns nil for other DUID types.
+func (d DUID) HardwareAddr() net.HardwareAddr {
+	var addr []byte
+	switch d.Type() {
+	case DUIDLLT:
+		if len(d) < 8 || binary.BigEndian.Uint16(d[2:4]) != ethernetHType {
+			return nil
+		}
+		addr = d[8:]
+	case DUIDLL:
+		if len(d) < 4 || binary.BigEndian.Uint16(d[2:4]) != ethernetHType {
+			return nil
+		}
+		addr = d[4:]
+	}
+	if len(addr) != 6 {
+		return nil
+	}
+	return net.HardwareAddr(append([]byte(nil), addr...))
+}
+
+// String returns the DUID as colon separated hex.
+func (d DUID) String() string {
+	return net.HardwareAddr(d).String()
+}
@@ -0,0 +1,99 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp6
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// StatusCode is the status of a message or IA.
+type StatusCode uint16
+
+// Status codes, RFC 8415 section 21.13
+const (
+	Success StatusCode = iota
+	UnspecFail
+	NoAddrsAvail
+	NoBinding
+	NotOnLink
+	UseMulticast
+)
+
+// StatusCodeOption returns the value of a Status Code option.
+func StatusCodeOption(code StatusCode, msg string) []byte {
+	buf := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(code))
+	return append(buf, msg...)
+}
+
+// IANA is an Identity Association for Non-temporary Addresses.
+type IANA struct {
+	IAID    uint32
+	T1      time.Duration
+	T2      time.Duration
+	Options Options
+}
+
+// ParseIANA parses the value of an IA_NA option.
+func ParseIANA(data []byte) (*IANA, error) {
+	if len(data) < 12 {
+		return nil, errMalformedOption
+	}
+	opts, err := ParseOptions(data[12:])
+	if err != nil {
+		return nil, err
+	}
+	return &IANA{
+		IAID:    binary.BigEndian.Uint32(data[0:4]),
+		T1:      time.Duration(binary.BigEndian.Uint32(data[4:8])) * time.Second,
+		T2:      time.Duration(binary.BigEndian.Uint32(data[8:12])) * time.Second,
+		Options: opts,
+	}, nil
+}
+
+// Marshal returns the value of an IA_NA option.
+func (ia *IANA) Marshal() []byte {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint32(buf[0:4], ia.IAID)
+	binary.BigEndian.PutUint32(buf[4:8], uint32(ia.T1/time.Second))
+	binary.BigEndian.PutUint32(buf[8:12], uint32(ia.T2/time.Second))
+	return append(buf, ia.Options.Marshal()...)
+}
+
+// IAAddr is an address within an IA.
+type IAAddr struct {
+	IP                net.IP
+	PreferredLifetime time.Duration
+	ValidLifetime     time.Duration
+	Options           Options
+}
+
+// ParseIAAddr parses the value of an IA Address option.
+func ParseIAAddr(data []byte) (*IAAddr, error) {
+	if len(data) < 24 {
+		return nil, errMalformedOption
+	}
+	opts, err := ParseOptions(data[24:])
+	if err != nil {
+		return nil, err
+	}
+	return &IAAddr{
+		IP:                net.IP(append([]byte(nil), data[0:16]...)),
+		PreferredLifetime: time.Duration(binary.BigEndian.Uint32(data[16:20])) * time.Second,
+		ValidLifetime:     time.Duration(binary.BigEndian.Uint32(data[20:24])) * time.Second,
+		Options:           opts,
+	}, nil
+}
+
+// Marshal returns the value of an IA Address option.
+func (a *IAAddr) Marshal() []byte {
+	buf := make([]byte, 24)
+	copy(buf[0:16], a.IP.To16())
+	binary.BigEndian.PutUint32(buf[16:20], uint32(a.PreferredLifetime/time.Second))
+	binary.BigEndian.PutUint32(buf[20:24], uint32(a.ValidLifetime/time.Second))
+	return append(buf, a.Options.Marshal()...)
+}
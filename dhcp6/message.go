@@ -0,0 +1,203 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dhcp6 implements the DHCPv6 message format from RFC 8415.
+package dhcp6
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+// UDP ports used by DHCPv6
+const (
+	ClientPort = 546
+	ServerPort = 547
+)
+
+// AllDHCPRelayAgentsAndServers is the link-scoped multicast address clients send to.
+var AllDHCPRelayAgentsAndServers = net.ParseIP("ff02::1:2")
+
+// MessageType is the DHCPv6 msg-type field.
+type MessageType uint8
+
+// DHCPv6 message types
+const (
+	Solicit MessageType = iota + 1
+	Advertise
+	Request
+	Confirm
+	Renew
+	Rebind
+	Reply
+	Release
+	Decline
+	Reconfigure
+	InformationRequest
+	RelayForw
+	RelayRepl
+)
+
+var messageTypeNames = [...]string{
+	Solicit:            "Solicit",
+	Advertise:          "Advertise",
+	Request:            "Request",
+	Confirm:            "Confirm",
+	Renew:              "Renew",
+	Rebind:             "Rebind",
+	Reply:              "Reply",
+	Release:            "Release",
+	Decline:            "Decline",
+	Reconfigure:        "Reconfigure",
+	InformationRequest: "InformationRequest",
+	RelayForw:          "RelayForw",
+	RelayRepl:          "RelayRepl",
+}
+
+func (t MessageType) String() string {
+	if int(t) < len(messageTypeNames) && messageTypeNames[t] != "" {
+		return messageTypeNames[t]
+	}
+	return "MessageType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// maxHopCount is the maximum number of relays a message may pass through.
+const maxHopCount = 32
+
+var (
+	errMessageTooShort = errors.New("message too short")
+	errNotRelayMessage = errors.New("not a relay message")
+	errNoRelayMessage  = errors.New("relay message option missing")
+	errTooManyHops     = errors.New("too many relay hops")
+)
+
+// A Message is a client/server message.
+type Message struct {
+	Type          MessageType
+	TransactionID [3]byte
+	Options       Options
+}
+
+// ParseMessage parses a client/server message.
+func ParseMessage(data []byte) (*Message, error) {
+	if len(data) < 4 {
+		return nil, errMessageTooShort
+	}
+	opts, err := ParseOptions(data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{
+		Type:    MessageType(data[0]),
+		Options: opts,
+	}
+	copy(m.TransactionID[:], data[1:4])
+	return m, nil
+}
+
+// NewReply creates a message of type t with the transaction ID of req.
+func NewReply(req *Message, t MessageType) *Message {
+	return &Message{
+		Type:          t,
+		TransactionID: req.TransactionID,
+	}
+}
+
+// Marshal returns the wire format of the message.
+func (m *Message) Marshal() []byte {
+	buf := []byte{byte(m.Type), m.TransactionID[0], m.TransactionID[1], m.TransactionID[2]}
+	return append(buf, m.Options.Marshal()...)
+}
+
+// A RelayMessage is a Relay-forward or Relay-reply message.
+type RelayMessage struct {
+	Type        MessageType
+	HopCount    uint8
+	LinkAddress net.IP
+	PeerAddress net.IP
+	Options     Options
+}
+
+// ParseRelayMessage parses a Relay-forward or Relay-reply message.
+func ParseRelayMessage(data []byte) (*RelayMessage, error) {
+	if len(data) < 34 {
+		return nil, errMessageTooShort
+	}
+	t := MessageType(data[0])
+	if t != RelayForw && t != RelayRepl {
+		return nil, errNotRelayMessage
+	}
+	opts, err := ParseOptions(data[34:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &RelayMessage{
+		Type:        t,
+		HopCount:    data[1],
+		LinkAddress: net.IP(append([]byte(nil), data[2:18]...)),
+		PeerAddress: net.IP(append([]byte(nil), data[18:34]...)),
+		Options:     opts,
+	}, nil
+}
+
+// Marshal returns the wire format of the relay message.
+func (r *RelayMessage) Marshal() []byte {
+	buf := make([]byte, 34)
+	buf[0] = byte(r.Type)
+	buf[1] = r.HopCount
+	copy(buf[2:18], r.LinkAddress.To16())
+	copy(buf[18:34], r.PeerAddress.To16())
+	return append(buf, r.Options.Marshal()...)
+}
+
+// UnwrapRelays returns the client message in data and the Relay-forward
+// messages it was encapsulated in, outermost first.
+func UnwrapRelays(data []byte) (*Message, []*RelayMessage, error) {
+	var relays []*RelayMessage
+	for len(data) > 0 && MessageType(data[0]) == RelayForw {
+		if len(relays) > maxHopCount {
+			return nil, nil, errTooManyHops
+		}
+		r, err := ParseRelayMessage(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		relays = append(relays, r)
+
+		data = r.Options.Get(OptionRelayMsg)
+		if data == nil {
+			return nil, nil, errNoRelayMessage
+		}
+	}
+
+	m, err := ParseMessage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, relays, nil
+}
+
+// WrapReply encapsulates reply in Relay-reply messages mirroring relays so it
+// follows the same path back to the client.
+func WrapReply(reply *Message, relays []*RelayMessage) []byte {
+	data := reply.Marshal()
+	for i := len(relays) - 1; i >= 0; i-- {
+		r := relays[i]
+		rr := &RelayMessage{
+			Type:        RelayRepl,
+			HopCount:    r.HopCount,
+			LinkAddress: r.LinkAddress,
+			PeerAddress: r.PeerAddress,
+		}
+		if id := r.Options.Get(OptionInterfaceID); id != nil {
+			rr.Options.Add(OptionInterfaceID, id)
+		}
+		rr.Options.Add(OptionRelayMsg, data)
+		data = rr.Marshal()
+	}
+	return data
+}
@@ -0,0 +1,142 @@
+package dhcp6
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &Message{
+		Type:          Solicit,
+		TransactionID: [3]byte{1, 2, 3},
+	}
+	m.Options.Add(OptionClientID, []byte{0, 3, 0, 1, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56})
+	m.Options.Add(OptionElapsedTime, []byte{0, 0})
+
+	m2, err := ParseMessage(m.Marshal())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(m, m2) {
+		t.Errorf("Parsed message failed. Expected %#v, got %#v", m, m2)
+	}
+
+	if _, err := ParseMessage([]byte{1, 2}); err == nil {
+		t.Error("Expected error on short message")
+	}
+	if _, err := ParseMessage([]byte{1, 2, 3, 4, 0, 1, 0, 10, 0}); err == nil {
+		t.Error("Expected error on truncated option")
+	}
+}
+
+func TestRelayUnwrapWrap(t *testing.T) {
+	m := &Message{Type: Request, TransactionID: [3]byte{4, 5, 6}}
+	m.Options.Add(OptionClientID, []byte{0, 3, 0, 1, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56})
+
+	inner := &RelayMessage{
+		Type:        RelayForw,
+		HopCount:    0,
+		LinkAddress: net.ParseIP("2001:db8:1::1"),
+		PeerAddress: net.ParseIP("fe80::1"),
+	}
+	inner.Options.Add(OptionInterfaceID, []byte("eth0"))
+	inner.Options.Add(OptionRelayMsg, m.Marshal())
+	outer := &RelayMessage{
+		Type:        RelayForw,
+		HopCount:    1,
+		LinkAddress: net.IPv6unspecified,
+		PeerAddress: net.ParseIP("2001:db8:1::1"),
+	}
+	outer.Options.Add(OptionRelayMsg, inner.Marshal())
+
+	req, relays, err := UnwrapRelays(outer.Marshal())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(req, m) {
+		t.Errorf("Unwrapped message failed. Expected %#v, got %#v", m, req)
+	}
+	if len(relays) != 2 || relays[0].HopCount != 1 || !relays[1].LinkAddress.Equal(inner.LinkAddress) {
+		t.Fatalf("Incorrect relays %#v", relays)
+	}
+
+	reply := NewReply(req, Reply)
+	data := WrapReply(reply, relays)
+	r, err := ParseRelayMessage(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if r.Type != RelayRepl || r.HopCount != 1 {
+		t.Errorf("Incorrect outer relay reply %#v", r)
+	}
+	r2, err := ParseRelayMessage(r.Options.Get(OptionRelayMsg))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !bytes.Equal(r2.Options.Get(OptionInterfaceID), []byte("eth0")) {
+		t.Error("Interface ID not echoed")
+	}
+	if !r2.PeerAddress.Equal(inner.PeerAddress) {
+		t.Errorf("Expected peer address %s, got %s", inner.PeerAddress, r2.PeerAddress)
+	}
+	m2, err := ParseMessage(r2.Options.Get(OptionRelayMsg))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if m2.Type != Reply || m2.TransactionID != m.TransactionID {
+		t.Errorf("Incorrect reply %#v", m2)
+	}
+}
+
+func TestIANARoundTrip(t *testing.T) {
+	addr := &IAAddr{
+		IP:                net.ParseIP("2001:db8::10"),
+		PreferredLifetime: time.Hour,
+		ValidLifetime:     2 * time.Hour,
+	}
+	ia := &IANA{IAID: 0x01020304, T1: 30 * time.Minute, T2: 48 * time.Minute}
+	ia.Options.Add(OptionIAAddr, addr.Marshal())
+
+	ia2, err := ParseIANA(ia.Marshal())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if ia2.IAID != ia.IAID || ia2.T1 != ia.T1 || ia2.T2 != ia.T2 {
+		t.Errorf("Parsed IA_NA failed. Expected %#v, got %#v", ia, ia2)
+	}
+	addr2, err := ParseIAAddr(ia2.Options.Get(OptionIAAddr))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(addr, addr2) {
+		t.Errorf("Parsed IA address failed. Expected %#v, got %#v", addr, addr2)
+	}
+}
+
+func TestDUIDHardwareAddr(t *testing.T) {
+	mac, _ := net.ParseMAC("ab:cd:ef:12:34:56")
+	tests := []struct {
+		duid DUID
+		mac  net.HardwareAddr
+	}{
+		{NewDUIDLL(mac), mac},
+		{DUID{0, 1, 0, 1, 0x20, 0x1, 0x2, 0x3, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56}, mac}, // LLT
+		{DUID{0, 2, 0, 0, 0x1, 0x2, 0xab, 0xcd}, nil},                                    // EN
+		{DUID{0, 3, 0, 6, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56}, nil},                      // Not Ethernet
+	}
+	for _, test := range tests {
+		if got := test.duid.HardwareAddr(); !bytes.Equal(got, test.mac) {
+			t.Errorf("DUID %s: expected %s, got %s", test.duid, test.mac, got)
+		}
+	}
+}
+
+func TestEncodeDomainList(t *testing.T) {
+	expected := []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 1, 'a', 0}
+	if got := EncodeDomainList([]string{"example.com.", "a"}); !bytes.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,131 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp6
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// OptionCode is a DHCPv6 option code.
+type OptionCode uint16
+
+// DHCPv6 options, RFC 8415, RFC 3646 and RFC 6939
+const (
+	OptionClientID            OptionCode = 1
+	OptionServerID            OptionCode = 2
+	OptionIANA                OptionCode = 3
+	OptionIATA                OptionCode = 4
+	OptionIAAddr              OptionCode = 5
+	OptionORO                 OptionCode = 6
+	OptionPreference          OptionCode = 7
+	OptionElapsedTime         OptionCode = 8
+	OptionRelayMsg            OptionCode = 9
+	OptionStatusCode          OptionCode = 13
+	OptionRapidCommit         OptionCode = 14
+	OptionInterfaceID         OptionCode = 18
+	OptionDNSServers          OptionCode = 23
+	OptionDomainList          OptionCode = 24
+	OptionClientLinkLayerAddr OptionCode = 79
+)
+
+var errMalformedOption = errors.New("malformed option")
+
+// An Option is a single DHCPv6 option.
+type Option struct {
+	Code  OptionCode
+	Value []byte
+}
+
+// Options is an ordered list of options. Unlike DHCPv4, an option code may
+// appear more than once.
+type Options []Option
+
+// ParseOptions parses a sequence of options.
+func ParseOptions(data []byte) (Options, error) {
+	var opts Options
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errMalformedOption
+		}
+		code := OptionCode(binary.BigEndian.Uint16(data[0:2]))
+		size := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+size {
+			return nil, errMalformedOption
+		}
+		opts = append(opts, Option{Code: code, Value: data[4 : 4+size]})
+		data = data[4+size:]
+	}
+	return opts, nil
+}
+
+// Marshal returns the wire format of the options.
+func (o Options) Marshal() []byte {
+	var buf []byte
+	for _, opt := range o {
+		buf = append(buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-4:], uint16(opt.Code))
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(opt.Value)))
+		buf = append(buf, opt.Value...)
+	}
+	return buf
+}
+
+// Get returns the value of the first option with code, or nil if it doesn't exist.
+func (o Options) Get(code OptionCode) []byte {
+	for _, opt := range o {
+		if opt.Code == code {
+			if opt.Value == nil {
+				return []byte{}
+			}
+			return opt.Value
+		}
+	}
+	return nil
+}
+
+// GetAll returns the values of every option with code.
+func (o Options) GetAll(code OptionCode) [][]byte {
+	var vals [][]byte
+	for _, opt := range o {
+		if opt.Code == code {
+			vals = append(vals, opt.Value)
+		}
+	}
+	return vals
+}
+
+// Add appends an option.
+func (o *Options) Add(code OptionCode, value []byte) {
+	*o = append(*o, Option{Code: code, Value: value})
+}
+
+// Requested returns the option codes in the Option Request option.
+func (o Options) Requested() []OptionCode {
+	oro := o.Get(OptionORO)
+	codes := make([]OptionCode, 0, len(oro)/2)
+	for i := 0; i+1 < len(oro); i += 2 {
+		codes = append(codes, OptionCode(binary.BigEndian.Uint16(oro[i:])))
+	}
+	return codes
+}
+
+// EncodeDomainList encodes domain names in the DNS wire format used by the
+// Domain Search List option.
+func EncodeDomainList(names []string) []byte {
+	var buf []byte
+	for _, name := range names {
+		for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+			if label == "" {
+				continue
+			}
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+		buf = append(buf, 0)
+	}
+	return buf
+}
@@ -0,0 +1,116 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dhcp6
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+var (
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			return make([]byte, 1500)
+		},
+	}
+)
+
+// A Handler takes a client message and generates a reply. relays are the
+// Relay-forward messages the request was encapsulated in, outermost first.
+type Handler interface {
+	ServeDHCPv6(req *Message, relays []*RelayMessage) *Message
+}
+
+// ServeConn is the bare minimum connection functions required by Serve().
+type ServeConn interface {
+	ReadFrom(b []byte) (n int, addr net.Addr, err error)
+	WriteTo(b []byte, addr net.Addr) (n int, err error)
+}
+
+// ListenPacket listens on the DHCPv6 server port and joins the
+// All_DHCP_Relay_Agents_and_Servers group on ifi. If ifi is nil,
+// the system default interface is used.
+func ListenPacket(ifi *net.Interface) (net.PacketConn, error) {
+	return net.ListenMulticastUDP("udp6", ifi, &net.UDPAddr{
+		IP:   AllDHCPRelayAgentsAndServers,
+		Port: ServerPort,
+	})
+}
+
+// Serve reads messages from conn and passes them to handler using workers
+// goroutines. Replies are encapsulated for any relays and sent back to the
+// address the request came from.
+func Serve(conn ServeConn, handler Handler, workers int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+			return
+		}
+	}()
+
+	taskQueue := startWorkers(workers, conn, handler)
+
+	for {
+		buffer := bufferPool.Get().([]byte)
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			close(taskQueue)
+			return err
+		}
+		if n < 4 { // Message too small to be DHCPv6
+			bufferPool.Put(buffer)
+			continue
+		}
+
+		select {
+		case taskQueue <- job{data: buffer[:n], from: addr}:
+		default:
+			fmt.Println("Task queue full")
+			bufferPool.Put(buffer)
+		}
+	}
+}
+
+func process(conn ServeConn, data []byte, handler Handler, from net.Addr) {
+	req, relays, err := UnwrapRelays(data)
+	if err != nil {
+		return
+	}
+
+	switch req.Type {
+	case Solicit, Request, Confirm, Renew, Rebind, Release, Decline, InformationRequest:
+	default: // Not a client message
+		return
+	}
+
+	if res := handler.ServeDHCPv6(req, relays); res != nil {
+		if _, e := conn.WriteTo(WrapReply(res, relays), from); e != nil {
+			panic(e)
+		}
+	}
+}
+
+type job struct {
+	data []byte
+	from net.Addr
+}
+
+func startWorkers(num int, conn ServeConn, handler Handler) chan job {
+	tasks := make(chan job, num*2)
+
+	for i := 1; i <= num; i++ {
+		go worker(conn, handler, tasks)
+	}
+
+	return tasks
+}
+
+func worker(conn ServeConn, handler Handler, tasks <-chan job) {
+	for j := range tasks {
+		process(conn, j.data, handler, j.from)
+		bufferPool.Put(j.data[:cap(j.data)])
+	}
+}
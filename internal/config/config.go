@@ -18,6 +18,9 @@ type Config struct {
 	Leases     *LeasesConfig
 	Server     *ServerConfig
 	Management *ManagementConfig
+	Failover   *FailoverConfig
+	DDNS       *DDNSConfig
+	LeaseQuery *LeaseQueryConfig
 }
 
 type LoggingConfig struct {
@@ -42,13 +45,14 @@ type DatabaseConfig struct {
 }
 
 type LeasesConfig struct {
-	DeleteAfter string // TODO: Run a job to clean up old leases
+	DeleteAfter string
 }
 
 type ServerConfig struct {
 	BlockBlacklisted bool
 	NetworksFile     string
 	Workers          int
+	PingTimeout      string
 }
 
 type ManagementConfig struct {
@@ -57,6 +61,33 @@ type ManagementConfig struct {
 	AllowedIPs []string
 }
 
+type FailoverConfig struct {
+	Role              string // primary or secondary, empty disables failover
+	Address           string
+	Port              int
+	Peer              string
+	Secret            string // Base64 encoded secret shared with the partner
+	MCLT              string
+	HeartbeatInterval string
+	Timeout           string
+}
+
+type DDNSConfig struct {
+	Server       string // DNS server to send updates to, empty disables dynamic DNS
+	Port         int
+	Timeout      string
+	TTL          string // Empty uses a third of the lease time
+	KeyName      string // TSIG key, updates are unsigned if empty
+	KeyAlgorithm string
+	KeySecret    string // Base64 encoded
+}
+
+type LeaseQueryConfig struct {
+	AllowedIPs  []string // Requestors allowed to send leasequeries, empty disables leasequery
+	BulkAddress string   // Address to listen on for bulk leasequery, empty disables bulk leasequery
+	BulkPort    int
+}
+
 func FindConfigFile() string {
 	if os.Getenv("PG_DHCP_CONFIG") != "" && utils.FileExists(os.Getenv("PG_DHCP_CONFIG")) {
 		return os.Getenv("PG_DHCP_CONFIG")
@@ -129,6 +160,15 @@ func setSensibleDefaults(c *Config) (*Config, error) {
 	if c.Management == nil {
 		c.Management = &ManagementConfig{}
 	}
+	if c.Failover == nil {
+		c.Failover = &FailoverConfig{}
+	}
+	if c.DDNS == nil {
+		c.DDNS = &DDNSConfig{}
+	}
+	if c.LeaseQuery == nil {
+		c.LeaseQuery = &LeaseQueryConfig{}
+	}
 
 	// Logging
 	c.Logging.Level = setStringOrDefault(c.Logging.Level, "notice")
@@ -157,6 +197,10 @@ func setSensibleDefaults(c *Config) (*Config, error) {
 	// DHCP
 	c.Server.NetworksFile = setStringOrDefault(c.Server.NetworksFile, "/etc/pg-dhcp/dhcp.conf")
 	c.Server.Workers = setIntOrDefault(c.Server.Workers, runtime.GOMAXPROCS(0))
+	c.Server.PingTimeout = setStringOrDefault(c.Server.PingTimeout, "1s")
+	if _, err := time.ParseDuration(c.Server.PingTimeout); err != nil {
+		c.Server.PingTimeout = "1s"
+	}
 
 	// Management
 	c.Management.Address = setStringOrDefault(c.Management.Address, "0.0.0.0")
@@ -168,9 +212,35 @@ func setSensibleDefaults(c *Config) (*Config, error) {
 		}
 	}
 
+	// Failover
+	// An empty address listens on the interface facing the peer
+	c.Failover.Port = setIntOrDefault(c.Failover.Port, 647)
+	c.Failover.MCLT = setDurationOrDefault(c.Failover.MCLT, "1h")
+	c.Failover.HeartbeatInterval = setDurationOrDefault(c.Failover.HeartbeatInterval, "5s")
+	c.Failover.Timeout = setDurationOrDefault(c.Failover.Timeout, "10s")
+
+	// Dynamic DNS
+	c.DDNS.Port = setIntOrDefault(c.DDNS.Port, 53)
+	c.DDNS.Timeout = setDurationOrDefault(c.DDNS.Timeout, "5s")
+	if c.DDNS.TTL != "" {
+		c.DDNS.TTL = setDurationOrDefault(c.DDNS.TTL, "")
+	}
+	c.DDNS.KeyAlgorithm = setStringOrDefault(c.DDNS.KeyAlgorithm, "hmac-sha256")
+
+	// Leasequery
+	c.LeaseQuery.BulkPort = setIntOrDefault(c.LeaseQuery.BulkPort, 67)
+
 	return c, nil
 }
 
+// Given duration string s, if it is empty or invalid, return v else return s.
+func setDurationOrDefault(s, v string) string {
+	if _, err := time.ParseDuration(s); err != nil {
+		return v
+	}
+	return s
+}
+
 // Given string s, if it is empty, return v else return s.
 func setStringOrDefault(s, v string) string {
 	if s == "" {
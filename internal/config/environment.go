@@ -28,6 +28,8 @@ type Environment struct {
 	Log          *verbose.Logger
 	shutdownSubs []*subscriber
 	shutdownChan chan os.Signal
+	reloadSubs   []*subscriber
+	reloadChan   chan os.Signal
 }
 
 func NewEnvironment(t EnvironmentEnv) *Environment {
@@ -89,3 +91,34 @@ func (e *Environment) shutdownWatcher() {
 		}
 	}(e)
 }
+
+// SubscribeReload returns a channel that receives every time the process gets a SIGHUP.
+func (e *Environment) SubscribeReload() <-chan bool {
+	e.reloadWatcher() // Start the watcher
+
+	sub := &subscriber{
+		c: make(chan bool, 1),
+	}
+
+	e.reloadSubs = append(e.reloadSubs, sub)
+	return sub.c
+}
+
+func (e *Environment) reloadWatcher() {
+	if e.reloadChan != nil {
+		return
+	}
+
+	e.reloadChan = make(chan os.Signal, 1)
+	signal.Notify(e.reloadChan, syscall.SIGHUP)
+	go func(env *Environment) {
+		for range e.reloadChan {
+			for _, sub := range e.reloadSubs {
+				select {
+				case sub.c <- true:
+				default: // A reload is already pending
+				}
+			}
+		}
+	}(e)
+}
@@ -0,0 +1,77 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/lfkeitel/verbose"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+func TestAuthoritative(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/authoritativeConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+	mac, _ := net.ParseMAC("12:34:56:00:00:01")
+	nak := d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.NAK)}}
+
+	initReboot := func(relay, ip net.IP) d4.Packet {
+		opts := []d4.Option{{Code: d4.OptionRequestedIPAddress, Value: []byte(ip.To4())}}
+		p := d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		return server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	}
+	inform := func(ip net.IP) d4.Packet {
+		p := d4.RequestPacket(d4.Inform, mac, ip, nil, false, nil)
+		return server.ServeDHCP(p, d4.Inform, p.ParseOptions())
+	}
+
+	// Unknown addresses are ignored in the non-authoritative network
+	if rp := initReboot(net.IP{10, 0, 1, 5}, net.IP{10, 0, 1, 50}); rp != nil {
+		t.Error("Expected no response from non-authoritative network")
+	}
+	// and refused in the authoritative one
+	checkOptions(initReboot(net.IP{10, 0, 2, 5}, net.IP{10, 0, 2, 50}), nak, t)
+
+	// Addresses from a different network are the same
+	if rp := initReboot(net.IP{10, 0, 1, 5}, net.IP{10, 0, 2, 50}); rp != nil {
+		t.Error("Expected no response for wrong network address from non-authoritative network")
+	}
+	checkOptions(initReboot(net.IP{10, 0, 2, 5}, net.IP{10, 0, 1, 50}), nak, t)
+
+	// Renewing an address outside every network uses the global setting
+	p := d4.RequestPacket(d4.Request, mac, net.IP{192, 168, 1, 10}, nil, false, nil)
+	checkOptions(server.ServeDHCP(p, d4.Request, p.ParseOptions()), nak, t)
+
+	// Static addresses outside the pools are only informed by authoritative networks
+	if rp := inform(net.IP{10, 0, 1, 200}); rp != nil {
+		t.Error("Expected no INFORM response from non-authoritative network")
+	}
+	checkOptions(inform(net.IP{10, 0, 2, 200}), d4.Options{
+		d4.OptionDHCPMessageType: []byte{byte(d4.ACK)},
+		d4.OptionRouter:          []byte{10, 0, 2, 1},
+	}, t)
+
+	// Addresses in a pool are informed either way
+	checkOptions(inform(net.IP{10, 0, 1, 20}), d4.Options{
+		d4.OptionDHCPMessageType: []byte{byte(d4.ACK)},
+		d4.OptionRouter:          []byte{10, 0, 1, 1},
+	}, t)
+}
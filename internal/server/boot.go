@@ -0,0 +1,95 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+// ipxeUserClass is the user class iPXE sends, used to break chainloading loops.
+var ipxeUserClass = []byte("iPXE")
+
+// bootParams are the network boot fields of a reply.
+type bootParams struct {
+	nextServer net.IP
+	serverName string
+	filename   string
+}
+
+// getBootParams returns the boot fields for a client. blocks are ordered from
+// the most specific scope, each value is taken from the first block that sets it.
+func getBootParams(options dhcp4.Options, blocks ...*settings) *bootParams {
+	b := &bootParams{}
+	for _, s := range blocks {
+		if b.nextServer == nil {
+			b.nextServer = s.nextServer
+		}
+		if b.serverName == "" {
+			b.serverName = s.serverName
+		}
+	}
+
+	if userClassMatches(options[dhcp4.OptionUserClass], ipxeUserClass) {
+		for _, s := range blocks {
+			if s.ipxeFilename != "" {
+				b.filename = s.ipxeFilename
+				return b
+			}
+		}
+	}
+
+	arch, hasArch := clientArch(options)
+	for _, s := range blocks {
+		if file, ok := s.archFilenames[arch]; ok && hasArch {
+			b.filename = file
+			break
+		}
+		if s.filename != "" {
+			b.filename = s.filename
+			break
+		}
+	}
+	return b
+}
+
+// clientArch returns the first architecture type in option 93, RFC 4578.
+func clientArch(options dhcp4.Options) (uint16, bool) {
+	data := options[dhcp4.OptionClientArchitecture]
+	if len(data) < 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(data), true
+}
+
+// options returns the TFTP server name and boot file name options if the client
+// asked for them in prl and they aren't already configured in leaseOptions.
+// Some PXE clients only look at the options, not the header fields.
+func (b *bootParams) options(leaseOptions dhcp4.Options, prl []byte) []dhcp4.Option {
+	var opts []dhcp4.Option
+	if _, ok := leaseOptions[dhcp4.OptionTFTPServerName]; !ok && b.serverName != "" && bytes.IndexByte(prl, byte(dhcp4.OptionTFTPServerName)) > -1 {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionTFTPServerName, Value: []byte(b.serverName)})
+	}
+	if _, ok := leaseOptions[dhcp4.OptionBootFileName]; !ok && b.filename != "" && bytes.IndexByte(prl, byte(dhcp4.OptionBootFileName)) > -1 {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionBootFileName, Value: []byte(b.filename)})
+	}
+	return opts
+}
+
+// setHeader fills the siaddr, sname and file fields of reply.
+func (b *bootParams) setHeader(reply dhcp4.Packet) {
+	if b.nextServer != nil {
+		reply.SetSIAddr(b.nextServer)
+	}
+	if b.serverName != "" {
+		reply.SetSName([]byte(b.serverName))
+	}
+	if b.filename != "" {
+		reply.SetFile([]byte(b.filename))
+	}
+}
@@ -0,0 +1,93 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/lfkeitel/verbose"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+func TestNetworkBoot(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/bootConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+	relay := net.ParseIP("10.0.1.5")
+
+	discover := func(mac string, opts []d4.Option) d4.Packet {
+		hw, _ := net.ParseMAC(mac)
+		p := d4.RequestPacket(d4.Discover, hw, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+		return dp
+	}
+	checkBoot := func(p d4.Packet, siaddr net.IP, sname, file string) {
+		if !p.SIAddr().Equal(siaddr) {
+			t.Errorf("Expected next server %s, got %s", siaddr, p.SIAddr())
+		}
+		if string(p.SName()) != sname {
+			t.Errorf("Expected server name %q, got %q", sname, p.SName())
+		}
+		if string(p.File()) != file {
+			t.Errorf("Expected file %q, got %q", file, p.File())
+		}
+	}
+
+	// Legacy BIOS client gets the default file
+	dp := discover("12:34:56:00:00:01", []d4.Option{{Code: d4.OptionClientArchitecture, Value: []byte{0, 0}}})
+	checkBoot(dp, net.ParseIP("10.0.0.5"), "tftp.example.com", "pxelinux.0")
+
+	// Architecture specific file, also sent as an option when requested
+	dp = discover("12:34:56:00:00:02", []d4.Option{
+		{Code: d4.OptionParameterRequestList, Value: []byte{1, 3, 66, 67}},
+		{Code: d4.OptionClientArchitecture, Value: []byte{0, 9}},
+	})
+	checkBoot(dp, net.ParseIP("10.0.0.5"), "tftp.example.com", "ipxe.efi")
+	checkOptions(dp, d4.Options{
+		d4.OptionTFTPServerName: []byte("tftp.example.com"),
+		d4.OptionBootFileName:   []byte("ipxe.efi"),
+	}, t)
+
+	// iPXE is sent to its script instead of loading itself again
+	dp = discover("12:34:56:00:00:03", []d4.Option{
+		{Code: d4.OptionClientArchitecture, Value: []byte{0, 9}},
+		{Code: d4.OptionUserClass, Value: []byte("iPXE")},
+	})
+	checkBoot(dp, net.ParseIP("10.0.0.5"), "tftp.example.com", "http://10.0.0.5/boot.ipxe")
+
+	// Pool settings take precedence
+	mac, _ := net.ParseMAC("12:34:56:00:00:04")
+	db.PutDevice(&models.Device{MAC: mac, Registered: true})
+	dp = discover(mac.String(), []d4.Option{{Code: d4.OptionClientArchitecture, Value: []byte{0, 9}}})
+	checkBoot(dp, net.ParseIP("10.0.2.5"), "", "lab.0")
+
+	// Acknowledgements carry the same fields
+	hw, _ := net.ParseMAC("12:34:56:00:00:01")
+	opts := []d4.Option{{Code: d4.OptionRequestedIPAddress, Value: []byte{10, 0, 1, 10}}}
+	p := d4.RequestPacket(d4.Request, hw, nil, nil, false, opts)
+	p.SetGIAddr(relay)
+	rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+	checkBoot(rp, net.ParseIP("10.0.0.5"), "tftp.example.com", "pxelinux.0")
+}
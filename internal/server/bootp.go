@@ -0,0 +1,154 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+// ServeBOOTP processes an incoming BOOTP request, one without a DHCP message type,
+// and returns a response. Clients with a host reservation always get their fixed
+// address, other clients only get addresses from pools with allow-bootp set.
+func (h *Handler) ServeBOOTP(p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
+	defer h.recoverPanic()
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	if !h.c.Failover.shouldServe() {
+		return nil // The failover partner is serving clients
+	}
+
+	if !p.HasMagicCookie() {
+		// The vendor area isn't options, don't trust anything parsed from it
+		options = dhcp4.Options{}
+	}
+
+	h.c.Log.WithFields(verbose.Fields{
+		"type":     "BOOTP",
+		"ip":       p.CIAddr().String(),
+		"mac":      p.CHAddr().String(),
+		"relay_ip": p.GIAddr().String(),
+	}).Debug("Incoming request")
+
+	device, err := h.c.Store.GetDevice(p.CHAddr())
+	if err != nil {
+		h.c.Log.WithField("error", err.Error()).Error("Failed getting device")
+		return nil
+	}
+	if device.Blacklisted && h.c.BlockBlacklist {
+		return nil
+	}
+
+	return h.handleBOOTP(p, options, device, h.conf.classify(p, options))
+}
+
+// Handle BOOTP requests, RFC 951. The lease is committed immediately as the client
+// never confirms, renews, or releases it.
+func (h *Handler) handleBOOTP(p dhcp4.Packet, options dhcp4.Options, device *models.Device, classes []string) dhcp4.Packet {
+	start := time.Now()
+
+	registered := isDeviceRegistered(device)
+	relay := h.relayInformation(p, options)
+
+	network := h.clientNetwork(p, options, relay)
+	if network == nil {
+		h.c.Log.WithFields(verbose.Fields{
+			"relay_ip": p.GIAddr().String(),
+			"link":     h.linkAddress(p, options, relay).String(),
+		}).Notice("Network not found")
+		return nil
+	}
+	network.Lock()
+	defer network.Unlock()
+
+	var (
+		lease        *models.Lease
+		leaseOptions dhcp4.Options
+		hostName     string
+		scopes       []*settings
+	)
+
+	if host := network.getHostByMAC(p.CHAddr()); host != nil {
+		// Reservations always take precedence over pools
+		lease = host.getLease()
+		leaseOptions = host.getOptions()
+		hostName = host.name
+		scopes = host.scopes()
+	} else {
+		var pool *pool
+		lease, pool = network.getLeaseByMAC(p.CHAddr(), nil, registered)
+		if lease != nil && (!pool.matchesRelay(relay) || !pool.allowsClasses(classes) || !pool.bootpAllowed(registered)) {
+			lease = nil
+		}
+		if lease == nil {
+			lease, pool = h.getCheckedFreeLease(network, p.CHAddr(), registered, true, relay, classes)
+			if lease == nil {
+				h.c.Log.WithFields(verbose.Fields{
+					"network":    network.name,
+					"registered": registered,
+				}).Notice("No free BOOTP leases available in network")
+				return nil
+			}
+		}
+		leaseOptions = pool.getOptions(registered)
+		scopes = pool.scopes(registered)
+	}
+
+	leaseDur := h.c.Failover.capLeaseTime(bootpLeaseTime(scopes...))
+	lease.Start = time.Now()
+	if leaseDur == infiniteLeaseTime {
+		lease.End = models.InfiniteEnd
+	} else {
+		lease.End = lease.Start.Add(leaseDur)
+	}
+	lease.Offered = false
+	lease.MAC = make([]byte, len(p.CHAddr()))
+	copy(lease.MAC, p.CHAddr())
+	lease.ClientID = nil
+	if ci, ok := options[dhcp4.OptionHostName]; ok {
+		lease.Hostname = string(ci)
+	}
+	if relay != nil {
+		lease.Relay = relayInfo(relay)
+	}
+	lease.Classes = classes
+	lease.Type = models.LeaseBOOTP
+	if err := h.saveLease(lease); err != nil {
+		h.c.Log.WithFields(verbose.Fields{
+			"mac":   p.CHAddr().String(),
+			"error": err,
+		}).Error("Error saving lease")
+		return nil
+	}
+
+	duration := "infinite"
+	if !lease.IsInfinite() {
+		duration = leaseDur.String()
+	}
+	h.c.Log.WithFields(verbose.Fields{
+		"ip":          lease.IP.String(),
+		"mac":         lease.MAC.String(),
+		"duration":    duration,
+		"network":     network.name,
+		"relay_ip":    p.GIAddr().String(),
+		"registered":  device.Registered,
+		"host":        hostName,
+		"hostname":    lease.Hostname,
+		"classes":     strings.Join(lease.Classes, ","),
+		"action":      "bootp_reply",
+		"blacklisted": device.Blacklisted,
+		"took":        time.Since(start).String(),
+	}).Info("Assigning BOOTP lease")
+
+	// BOOTP clients only know the boot header fields, there's no parameter request list
+	reply := dhcp4.BOOTPReplyPacket(p, lease.IP, leaseOptions.SelectOrderOrAll(nil))
+	getBootParams(options, scopes...).setHeader(reply)
+	return reply
+}
@@ -0,0 +1,105 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+func TestBOOTP(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/bootpConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+
+	bootp := func(mac string, relay net.IP) d4.Packet {
+		hw, _ := net.ParseMAC(mac)
+		p := d4.NewPacket(d4.BootRequest)
+		p.SetCHAddr(hw)
+		p.SetXId([]byte{1, 2, 3, 4})
+		p.SetGIAddr(relay)
+		p.PadToMinSize()
+		return server.ServeBOOTP(p, p.ParseOptions())
+	}
+	relay := net.IP{10, 0, 1, 1}
+
+	// Only the pool that allows BOOTP gives addresses
+	rp := bootp("12:34:56:00:00:01", relay)
+	if rp == nil {
+		t.Fatal("Expected BOOTP reply")
+	}
+	if !rp.YIAddr().Equal(net.IP{10, 0, 1, 20}) {
+		t.Errorf("Expected address from BOOTP pool, got %s", rp.YIAddr())
+	}
+	opts := checkOptions(rp, d4.Options{d4.OptionRouter: []byte{10, 0, 1, 1}}, t)
+	for _, code := range []d4.OptionCode{d4.OptionDHCPMessageType, d4.OptionServerIdentifier, d4.OptionIPAddressLeaseTime} {
+		if _, ok := opts[code]; ok {
+			t.Errorf("BOOTP reply has DHCP option %s", code)
+		}
+	}
+	if !rp.SIAddr().Equal(net.IP{10, 0, 1, 5}) || !bytes.Equal(rp.File(), []byte("bacnet.bin")) {
+		t.Errorf("Expected boot header fields, got %s %q", rp.SIAddr(), rp.File())
+	}
+
+	lease, _ := db.GetLease(net.IP{10, 0, 1, 20})
+	if lease == nil || lease.Type != models.LeaseBOOTP || !lease.IsInfinite() {
+		t.Fatalf("Expected infinite BOOTP lease saved, got %#v", lease)
+	}
+
+	// The client keeps its address
+	if rp := bootp("12:34:56:00:00:01", relay); rp == nil || !rp.YIAddr().Equal(net.IP{10, 0, 1, 20}) {
+		t.Error("Expected client to get the same address again")
+	}
+
+	// The BOOTP pool runs out even though the DHCP pool has addresses
+	bootp("12:34:56:00:00:02", relay)
+	if rp := bootp("12:34:56:00:00:03", relay); rp != nil {
+		t.Errorf("Expected no address, got %s", rp.YIAddr())
+	}
+
+	// Reservations are always served
+	if rp := bootp("12:34:56:00:00:09", relay); rp == nil || !rp.YIAddr().Equal(net.IP{10, 0, 1, 200}) {
+		t.Error("Expected reserved address")
+	}
+
+	// A fixed BOOTP lease time
+	rp = bootp("12:34:56:00:00:04", net.IP{10, 0, 2, 1})
+	if rp == nil {
+		t.Fatal("Expected BOOTP reply")
+	}
+	lease, _ = db.GetLease(rp.YIAddr())
+	if lease == nil || lease.IsInfinite() || lease.End.Sub(lease.Start) != time.Hour {
+		t.Errorf("Expected one hour BOOTP lease, got %#v", lease)
+	}
+
+	// The client switching to DHCP makes the lease dynamic
+	hw, _ := net.ParseMAC("12:34:56:00:00:04")
+	opts2 := []d4.Option{{Code: d4.OptionRequestedIPAddress, Value: []byte(rp.YIAddr().To4())}}
+	p := d4.RequestPacket(d4.Request, hw, nil, nil, false, opts2)
+	p.SetGIAddr(net.IP{10, 0, 2, 1})
+	checkOptions(server.ServeDHCP(p, d4.Request, p.ParseOptions()), d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+	if lease, _ = db.GetLease(rp.YIAddr()); lease.Type != models.LeaseDynamic {
+		t.Errorf("Expected dynamic lease, got %s", lease.Type)
+	}
+}
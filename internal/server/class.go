@@ -0,0 +1,115 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"path"
+	"strings"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/internal/utils"
+)
+
+// A class is a named group of clients selected by properties of their requests.
+type class struct {
+	name    string
+	matches []classMatch
+	pos     position
+}
+
+// A classMatch selects clients by a single property of a request.
+type classMatch struct {
+	kind    token  // VENDOR_CLASS, USER_CLASS, PARAMETER_LIST, HARDWARE_PREFIX or HOSTNAME
+	value   []byte // Compared to the request's value
+	pattern string // Lower case glob for HOSTNAME
+}
+
+// includes returns true if any of the class's match statements match the request.
+func (c *class) includes(p dhcp4.Packet, options dhcp4.Options) bool {
+	for _, m := range c.matches {
+		if m.matches(p, options) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m classMatch) matches(p dhcp4.Packet, options dhcp4.Options) bool {
+	switch m.kind {
+	case VENDOR_CLASS:
+		return bytes.HasPrefix(options[dhcp4.OptionVendorClassIdentifier], m.value)
+	case USER_CLASS:
+		return userClassMatches(options[dhcp4.OptionUserClass], m.value)
+	case PARAMETER_LIST:
+		prl, ok := options[dhcp4.OptionParameterRequestList]
+		return ok && bytes.Equal(prl, m.value)
+	case HARDWARE_PREFIX:
+		return bytes.HasPrefix(p.CHAddr(), m.value)
+	case HOSTNAME:
+		hostname, ok := options[dhcp4.OptionHostName]
+		if !ok {
+			return false
+		}
+		matched, _ := path.Match(m.pattern, strings.ToLower(string(hostname)))
+		return matched
+	}
+	return false
+}
+
+// userClassMatches returns if value is one of the user classes in option 77.
+// The option is a list of length prefixed classes per RFC 3004, but some clients
+// send a single unprefixed string so the whole option is also compared.
+func userClassMatches(data, value []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	if bytes.Equal(data, value) {
+		return true
+	}
+	for len(data) > 0 {
+		size := int(data[0])
+		if size == 0 || len(data) < 1+size {
+			return false
+		}
+		if bytes.Equal(data[1:1+size], value) {
+			return true
+		}
+		data = data[1+size:]
+	}
+	return false
+}
+
+// classify returns the names of the classes a request is a member of in the
+// order they're defined.
+func (c *Config) classify(p dhcp4.Packet, options dhcp4.Options) []string {
+	var names []string
+	for _, cl := range c.classes {
+		if cl.includes(p, options) {
+			names = append(names, cl.name)
+		}
+	}
+	return names
+}
+
+// allowsClasses returns if a client in classes may get a lease from the pool.
+// A member of any denied class is refused. If the pool has allow statements,
+// the client must be a member of at least one allowed class.
+func (p *pool) allowsClasses(classes []string) bool {
+	for _, c := range classes {
+		if utils.StringSliceContains(p.denyClasses, c) {
+			return false
+		}
+	}
+	if len(p.allowClasses) == 0 {
+		return true
+	}
+	for _, c := range classes {
+		if utils.StringSliceContains(p.allowClasses, c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/lfkeitel/verbose"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+func TestClientClasses(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/classConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+	relay := net.ParseIP("10.0.1.5")
+
+	// Acquire a lease for mac, returns the offered IP
+	acquire := func(mac string, opts []d4.Option) net.IP {
+		hw, _ := net.ParseMAC(mac)
+		p := d4.RequestPacket(d4.Discover, hw, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+
+		opts = append(opts, d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte(dp.YIAddr().To4())})
+		p = d4.RequestPacket(d4.Request, hw, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+		checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+		return dp.YIAddr()
+	}
+	phonePool := c.networks["network1"].subnets[0].pools[0]
+	openPool := c.networks["network1"].subnets[0].pools[1]
+
+	// Vendor class prefix puts a phone in the allowed pool
+	ip := acquire("12:34:56:00:00:01", []d4.Option{{Code: d4.OptionVendorClassIdentifier, Value: []byte("Polycom-SPIP")}})
+	if !phonePool.includes(ip) {
+		t.Errorf("Expected phone to get a lease from the phone pool, got %s", ip)
+	}
+
+	// Hardware prefix
+	ip = acquire("00:04:f2:00:00:01", nil)
+	if !phonePool.includes(ip) {
+		t.Errorf("Expected phone OUI to get a lease from the phone pool, got %s", ip)
+	}
+
+	// Other clients are kept out of the allow pool, and phones and printers out of the deny pool
+	ip = acquire("12:34:56:00:00:02", []d4.Option{{Code: d4.OptionUserClass, Value: []byte("\x04iPXE")}})
+	if !openPool.includes(ip) {
+		t.Errorf("Expected client to get a lease from the open pool, got %s", ip)
+	}
+	lease, _ := db.GetLease(ip)
+	if lease == nil || !reflect.DeepEqual(lease.Classes, []string{"pxe"}) {
+		t.Errorf("Expected classes to be saved with lease, got %#v", lease)
+	}
+
+	// Printers are in neither pool
+	hw, _ := net.ParseMAC("12:34:56:00:00:03")
+	opts := []d4.Option{{Code: d4.OptionHostName, Value: []byte("Printer-2F")}}
+	p := d4.RequestPacket(d4.Discover, hw, nil, nil, false, opts)
+	p.SetGIAddr(relay)
+	if dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions()); dp != nil {
+		t.Errorf("Expected no offer for printer, got %s", dp.YIAddr())
+	}
+
+	// Requesting a lease from a pool the client's classes aren't allowed is refused
+	opts = []d4.Option{{Code: d4.OptionRequestedIPAddress, Value: []byte(net.IP{10, 0, 1, 15})}}
+	p = d4.RequestPacket(d4.Request, hw, nil, nil, false, opts)
+	p.SetGIAddr(relay)
+	rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.NAK)}}, t)
+}
+
+func TestUserClassMatches(t *testing.T) {
+	tests := []struct {
+		data  string
+		match bool
+	}{
+		{"iPXE", true},
+		{"\x04iPXE", true},
+		{"\x03foo\x04iPXE", true},
+		{"\x03foo", false},
+		{"\x09iPXE", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if m := userClassMatches([]byte(test.data), []byte("iPXE")); m != test.match {
+			t.Errorf("Expected %t for %q, got %t", test.match, test.data, m)
+		}
+	}
+}
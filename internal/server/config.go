@@ -4,12 +4,51 @@
 
 package server
 
-import "net"
+import (
+	"fmt"
+	"net"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+)
 
 // A Config is the parsed object generated from a PG-DHCP configuration file.
 type Config struct {
+	file     string // Main configuration file
 	global   *global
 	networks map[string]*network
+	classes  []*class // In definition order
+	// Networks with relay agent information match statements, in definition order
+	relayNetworks []*network
+}
+
+// position is where a block is declared in the configuration files.
+type position struct {
+	file      string
+	line, col int
+	include   *position // Include statement that read file, nil for the main file
+}
+
+func (p position) String() string {
+	if p.line == 0 {
+		return p.file
+	}
+	s := fmt.Sprintf("line %d", p.line)
+	if p.file != "" {
+		s = fmt.Sprintf("%s:%d", p.file, p.line)
+	}
+	if p.col > 0 {
+		s = fmt.Sprintf("%s:%d", s, p.col)
+	}
+	return s
+}
+
+// includes returns the include statements that lead to p, innermost first.
+func (p position) includes() []position {
+	var chain []position
+	for i := p.include; i != nil; i = i.include {
+		chain = append(chain, *i)
+	}
+	return chain
 }
 
 func newConfig() *Config {
@@ -27,3 +66,42 @@ func (c *Config) searchNetworksFor(ip net.IP) *network {
 	}
 	return nil
 }
+
+// searchNetworksByRelay returns the network with a match statement for the
+// relay agent information r, or nil if there isn't one. If several networks
+// match, the first one declared is returned.
+func (c *Config) searchNetworksByRelay(r *dhcp4.RelayAgentInformation) *network {
+	if r == nil {
+		return nil
+	}
+	for _, network := range c.relayNetworks {
+		if network.relayMatches.matches(r) {
+			return network
+		}
+	}
+	return nil
+}
+
+// searchNetworksFor6 returns the network with a DHCPv6 subnet that includes
+// link. If link is unspecified, the local network is returned.
+func (c *Config) searchNetworksFor6(link net.IP) *network {
+	for _, network := range c.networks {
+		if len(network.subnets6) == 0 {
+			continue
+		}
+		if ((link == nil || link.IsUnspecified()) && network.local) || network.includes(link) {
+			return network
+		}
+	}
+	return nil
+}
+
+// hasIPv6 returns if any network has a DHCPv6 subnet.
+func (c *Config) hasIPv6() bool {
+	for _, network := range c.networks {
+		if len(network.subnets6) > 0 {
+			return true
+		}
+	}
+	return false
+}
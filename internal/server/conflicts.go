@@ -0,0 +1,56 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"net"
+
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+// ErrNoConflict is returned when clearing an address that isn't marked as a conflict.
+var ErrNoConflict = errors.New("address is not marked as a conflict")
+
+// GetConflicts returns copies of all abandoned leases across all networks.
+func GetConflicts() []*models.Lease {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	conflicts := make([]*models.Lease, 0)
+	for _, n := range c.networks {
+		n.Lock()
+		for _, l := range n.getAllLeases() {
+			if l.IsAbandoned {
+				cp := *l
+				conflicts = append(conflicts, &cp)
+			}
+		}
+		n.Unlock()
+	}
+	return conflicts
+}
+
+// ClearConflict removes the conflict mark from the lease for ip so the address
+// can be given out again. The cleared lease is saved and sent to the failover
+// partner.
+func (h *Handler) ClearConflict(ip net.IP) error {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	n := h.conf.searchNetworksFor(ip)
+	if n == nil {
+		return ErrNoConflict
+	}
+	n.Lock()
+	defer n.Unlock()
+
+	l := n.findLease(ip)
+	if l == nil || !l.IsAbandoned {
+		return ErrNoConflict
+	}
+	l.ClearAbandoned()
+	return h.saveLease(l)
+}
@@ -0,0 +1,409 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	"github.com/packet-guardian/pg-dhcp/ddns"
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+// Dynamic DNS queue limits
+const (
+	ddnsQueueSize    = 4096
+	ddnsMaxAttempts  = 10
+	ddnsRetryDelay   = 2 * time.Second // Doubled after each failed attempt
+	ddnsMaxBackoff   = 5 * time.Minute
+	ddnsExpiryPeriod = time.Minute
+)
+
+// A DNSClient sends dynamic DNS updates. *ddns.Client is the standard implementation.
+type DNSClient interface {
+	Send(u *ddns.Update) error
+}
+
+// dnsChange is an update with the updates that depend on its outcome.
+type dnsChange struct {
+	update *ddns.Update
+	// fallback is sent if update's prerequisites aren't met, without one the change is skipped
+	fallback *ddns.Update
+	// then is sent once update or fallback succeeds
+	then *ddns.Update
+}
+
+// dnsQueue sends updates in order from a single goroutine. An update that
+// fails is retried with backoff before any later update is sent so a
+// removal can't overtake the addition it undoes. DHCP processing never waits
+// on the queue.
+type dnsQueue struct {
+	client     DNSClient
+	log        *verbose.Logger
+	retryDelay time.Duration
+	updates    chan *dnsChange
+	done       chan struct{}
+}
+
+func newDNSQueue(client DNSClient, log *verbose.Logger, retryDelay time.Duration) *dnsQueue {
+	q := &dnsQueue{
+		client:     client,
+		log:        log,
+		retryDelay: retryDelay,
+		updates:    make(chan *dnsChange, ddnsQueueSize),
+		done:       make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// enqueue adds an update to the queue. The update is dropped if the queue is full.
+// It is safe to call on a nil queue.
+func (q *dnsQueue) enqueue(u *ddns.Update) {
+	q.enqueueChange(&dnsChange{update: u})
+}
+
+// enqueueChange adds a change to the queue. The change is dropped if the queue is full.
+func (q *dnsQueue) enqueueChange(c *dnsChange) {
+	if q == nil {
+		return
+	}
+	select {
+	case q.updates <- c:
+	default:
+		q.log.WithField("zone", c.update.Zone).Error("DNS update queue full, dropping update")
+	}
+}
+
+func (q *dnsQueue) close() {
+	if q != nil {
+		close(q.done)
+	}
+}
+
+func (q *dnsQueue) run() {
+	var (
+		pending  []*dnsChange
+		attempts int
+		retry    <-chan time.Time
+	)
+
+	for {
+		for len(pending) > 0 && retry == nil {
+			c := pending[0]
+			err := q.client.Send(c.update)
+			if err == nil {
+				attempts = 0
+				if c.then != nil {
+					pending[0] = &dnsChange{update: c.then}
+				} else {
+					pending = pending[1:]
+				}
+				continue
+			}
+
+			if len(c.update.Prereqs) > 0 && prerequisiteFailed(err) {
+				attempts = 0
+				if c.fallback != nil {
+					pending[0] = &dnsChange{update: c.fallback, then: c.then}
+					continue
+				}
+				q.log.WithFields(verbose.Fields{
+					"zone": c.update.Zone,
+					"name": c.update.Prereqs[0].Name,
+				}).Warning("DNS name belongs to another client, not updating")
+				pending = pending[1:]
+				continue
+			}
+
+			attempts++
+			if attempts >= ddnsMaxAttempts {
+				q.log.WithFields(verbose.Fields{
+					"zone":  c.update.Zone,
+					"error": err,
+				}).Error("Giving up on DNS update")
+				pending, attempts = pending[1:], 0
+				continue
+			}
+
+			backoff := q.retryDelay << uint(attempts-1)
+			if backoff > ddnsMaxBackoff || backoff <= 0 {
+				backoff = ddnsMaxBackoff
+			}
+			q.log.WithFields(verbose.Fields{
+				"zone":    c.update.Zone,
+				"error":   err,
+				"retry":   backoff.String(),
+				"pending": len(pending),
+			}).Warning("DNS update failed")
+			retry = time.After(backoff)
+		}
+
+		select {
+		case c := <-q.updates:
+			pending = append(pending, c)
+		case <-retry:
+			retry = nil
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// prerequisiteFailed returns if err is a server's answer to an update whose
+// prerequisites weren't met. Sending it again won't help.
+func prerequisiteFailed(err error) bool {
+	rerr, ok := err.(*ddns.RcodeError)
+	if !ok {
+		return false
+	}
+	switch rerr.Rcode {
+	case ddns.RcodeYXDomain, ddns.RcodeYXRRSet, ddns.RcodeNXDomain, ddns.RcodeNXRRSet:
+		return true
+	}
+	return false
+}
+
+// ddnsZones returns the forward and reverse zones for the address ip. Either may
+// be empty if it isn't configured. Only IPv4 addresses are updated.
+func (n *network) ddnsZones(ip net.IP, registered bool) (string, string) {
+	if ip.To4() == nil {
+		return "", ""
+	}
+
+	var blocks []*settings
+	s := n.getSubnetOfIP(ip)
+	if h := n.getHostByIP(ip); h != nil {
+		blocks = append(blocks, h.settings)
+		s = h.subnet
+		registered = h.registered()
+	} else if p := n.getPoolOfIP(ip); p != nil {
+		blocks = append(blocks, p.settings)
+	}
+	if s != nil {
+		blocks = append(blocks, s.getSettings(registered))
+	} else {
+		blocks = append(blocks, n.getSettings(registered))
+	}
+
+	var domain, reverse string
+	for _, b := range blocks {
+		if domain == "" {
+			domain = b.ddnsDomain
+		}
+		if reverse == "" {
+			reverse = b.ddnsReverseZone
+		}
+	}
+	return domain, reverse
+}
+
+// sanitizeHostLabel turns the first label of a client supplied name into a
+// valid DNS label. It returns an empty string if nothing usable remains.
+func sanitizeHostLabel(name string) string {
+	if i := strings.IndexByte(name, '.'); i > -1 {
+		name = name[:i]
+	}
+	name = strings.ToLower(name)
+
+	label := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-':
+			label = append(label, c)
+		case c == ' ' || c == '_':
+			label = append(label, '-')
+		}
+	}
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return strings.Trim(string(label), "-")
+}
+
+// updateLeaseDNS registers the lease's name in DNS after a lease is acknowledged.
+// The name comes from the client FQDN option, or the hostname option, in the
+// configured ddns-domain. It returns the client FQDN option for the reply, or
+// nil if the client didn't send one. The lease's DNSName is updated but not saved.
+func (h *Handler) updateLeaseDNS(n *network, lease *models.Lease, options dhcp4.Options, leaseDur time.Duration) []byte {
+	fqdn := options.ClientFQDN()
+	if h.dns == nil {
+		if fqdn == nil {
+			return nil
+		}
+		// Tell the client the server won't perform updates so it may do its own
+		return (&dhcp4.ClientFQDN{Flags: fqdn.Flags&dhcp4.FQDNEncoded | dhcp4.FQDNNoServerUpdate, Name: fqdn.Name}).Marshal()
+	}
+
+	domain, reverse := n.ddnsZones(lease.IP, lease.Registered)
+
+	hostname := lease.Hostname
+	if fqdn != nil {
+		hostname = fqdn.Name
+	}
+	label := sanitizeHostLabel(hostname)
+
+	name := ""
+	if domain != "" && label != "" && (fqdn == nil || fqdn.Flags&dhcp4.FQDNNoServerUpdate == 0) {
+		name = label + "." + domain
+	}
+
+	if name != lease.DNSName {
+		if lease.DNSName != "" {
+			h.removeLeaseDNS(n, lease)
+		}
+		if name != "" {
+			h.addLeaseDNS(lease, name, domain, reverse, h.dnsTTL(leaseDur))
+		}
+		lease.DNSName = name
+	}
+
+	if fqdn == nil {
+		return nil
+	}
+	reply := &dhcp4.ClientFQDN{Flags: fqdn.Flags & dhcp4.FQDNEncoded, Name: name}
+	if name == "" {
+		reply.Flags |= dhcp4.FQDNNoServerUpdate
+		reply.Name = fqdn.Name
+	} else {
+		// The server always updates the A record, override a client that wanted to
+		reply.Flags |= dhcp4.FQDNServerUpdate
+		if fqdn.Flags&dhcp4.FQDNServerUpdate == 0 {
+			reply.Flags |= dhcp4.FQDNOverride
+		}
+	}
+	return reply.Marshal()
+}
+
+// dnsTTL returns the TTL of records for a lease of duration d. Without a
+// configured TTL, records live a third of the lease time as suggested by RFC 4702.
+func (h *Handler) dnsTTL(d time.Duration) uint32 {
+	if h.c.DNSTTL > 0 {
+		return uint32(h.c.DNSTTL / time.Second)
+	}
+	return uint32(d / 3 / time.Second)
+}
+
+// leaseDHCID returns the DHCID record data of the lease's client for name.
+func leaseDHCID(lease *models.Lease, name string) ([]byte, error) {
+	if len(lease.ClientID) > 0 {
+		return ddns.DHCID(ddns.DHCIDClientID, lease.ClientID, name)
+	}
+	id := append([]byte{1}, lease.MAC...) // Ethernet htype and chaddr
+	return ddns.DHCID(ddns.DHCIDHardware, id, name)
+}
+
+// addLeaseDNS registers name for the lease following RFC 4703. The name is only
+// taken if it's not in use, or replaced if its DHCID shows it belongs to the
+// same client. A name belonging to someone else isn't touched. The PTR record
+// is updated once the name is registered.
+func (h *Handler) addLeaseDNS(lease *models.Lease, name, domain, reverse string, ttl uint32) {
+	dhcid, err := leaseDHCID(lease, name)
+	if err != nil {
+		return
+	}
+
+	add := ddns.NewUpdate(domain)
+	add.NameNotInUse(name)
+	add.AddAddress(name, lease.IP, ttl)
+	add.Add(name, ddns.TypeDHCID, ttl, dhcid)
+
+	replace := ddns.NewUpdate(domain)
+	replace.RRsetExists(name, ddns.TypeDHCID, dhcid)
+	replace.DeleteRRset(name, ddns.TypeA)
+	replace.AddAddress(name, lease.IP, ttl)
+
+	change := &dnsChange{update: add, fallback: replace}
+	ptr := ddns.ReverseName(lease.IP)
+	if inZone(ptr, reverse) {
+		u := ddns.NewUpdate(reverse)
+		u.DeleteRRset(ptr, ddns.TypePTR)
+		if err := u.AddPTR(ptr, name, ttl); err == nil {
+			change.then = u
+		}
+	}
+	h.dns.enqueueChange(change)
+}
+
+// removeLeaseDNS removes the records of a lease's DNSName. The name's records
+// are only removed if its DHCID shows it belongs to the lease's client. The
+// lease is not modified.
+func (h *Handler) removeLeaseDNS(n *network, lease *models.Lease) {
+	if h.dns == nil || lease.DNSName == "" {
+		return
+	}
+	domain, reverse := n.ddnsZones(lease.IP, lease.Registered)
+
+	if inZone(lease.DNSName, domain) {
+		if dhcid, err := leaseDHCID(lease, lease.DNSName); err == nil {
+			u := ddns.NewUpdate(domain)
+			u.RRsetExists(lease.DNSName, ddns.TypeDHCID, dhcid)
+			u.DeleteAddress(lease.DNSName, lease.IP)
+			u.DeleteRRset(lease.DNSName, ddns.TypeDHCID)
+			h.dns.enqueue(u)
+		}
+	}
+
+	ptr := ddns.ReverseName(lease.IP)
+	if inZone(ptr, reverse) {
+		u := ddns.NewUpdate(reverse)
+		u.DeleteRRset(ptr, ddns.TypePTR)
+		h.dns.enqueue(u)
+	}
+}
+
+// inZone returns if name is at or below zone.
+func inZone(name, zone string) bool {
+	if zone == "" {
+		return false
+	}
+	name = strings.TrimSuffix(name, ".")
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+func (h *Handler) expireDNSLoop() {
+	t := time.NewTicker(ddnsExpiryPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			h.expireDNS()
+		case <-h.dns.done:
+			return
+		}
+	}
+}
+
+// expireDNS removes the DNS records of expired leases.
+func (h *Handler) expireDNS() {
+	if h.dns == nil || !h.c.Failover.shouldServe() {
+		return
+	}
+
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	for _, n := range h.conf.networks {
+		n.Lock()
+		for _, lease := range n.getAllLeases() {
+			if lease.DNSName == "" || !lease.IsExpired() {
+				continue
+			}
+			h.removeLeaseDNS(n, lease)
+			lease.DNSName = ""
+			if err := h.saveLease(lease); err != nil {
+				h.c.Log.WithFields(verbose.Fields{
+					"ip":    lease.IP.String(),
+					"error": err,
+				}).Error("Error saving lease")
+			}
+		}
+		n.Unlock()
+	}
+}
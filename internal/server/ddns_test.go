@@ -0,0 +1,396 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	"github.com/packet-guardian/pg-dhcp/ddns"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+// fakeDNS records updates and fails the first failures sends. Updates with
+// prerequisites get the rcodes in prereqRcodes in order, then succeed.
+type fakeDNS struct {
+	sync.Mutex
+	failures     int
+	prereqRcodes []ddns.Rcode
+	sent         chan *ddns.Update
+}
+
+func newFakeDNS(failures int) *fakeDNS {
+	return &fakeDNS{failures: failures, sent: make(chan *ddns.Update, 20)}
+}
+
+func (d *fakeDNS) Send(u *ddns.Update) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.failures > 0 {
+		d.failures--
+		return errors.New("server unreachable")
+	}
+	if len(u.Prereqs) > 0 && len(d.prereqRcodes) > 0 {
+		rcode := d.prereqRcodes[0]
+		d.prereqRcodes = d.prereqRcodes[1:]
+		if rcode != ddns.RcodeSuccess {
+			return &ddns.RcodeError{Rcode: rcode}
+		}
+	}
+	d.sent <- u
+	return nil
+}
+
+// next returns the next delivered update.
+func (d *fakeDNS) next(t *testing.T) *ddns.Update {
+	select {
+	case u := <-d.sent:
+		return u
+	case <-time.After(time.Second):
+		t.Fatal("Expected a DNS update")
+	}
+	return nil
+}
+
+func (d *fakeDNS) none(t *testing.T) {
+	select {
+	case u := <-d.sent:
+		t.Errorf("Unexpected DNS update for zone %s", u.Zone)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// checkPrereqs checks the prerequisites of u.
+func checkPrereqs(t *testing.T, u *ddns.Update, expected ...ddns.RR) {
+	if len(u.Prereqs) != len(expected) {
+		t.Fatalf("Expected %d prerequisites, got %#v", len(expected), u.Prereqs)
+	}
+	for i, rr := range expected {
+		got := u.Prereqs[i]
+		if got.Name != rr.Name || got.Type != rr.Type || got.Class != rr.Class || string(got.Data) != string(rr.Data) {
+			t.Errorf("Expected prerequisite %#v, got %#v", rr, got)
+		}
+	}
+}
+
+func checkUpdate(t *testing.T, u *ddns.Update, zone string, expected ...ddns.RR) {
+	if u.Zone != zone {
+		t.Errorf("Expected zone %s, got %s", zone, u.Zone)
+	}
+	if len(u.Updates) != len(expected) {
+		t.Fatalf("Expected %d records, got %#v", len(expected), u.Updates)
+	}
+	for i, rr := range expected {
+		got := u.Updates[i]
+		if got.Name != rr.Name || got.Type != rr.Type || got.Class != rr.Class || string(got.Data) != string(rr.Data) {
+			t.Errorf("Expected record %#v, got %#v", rr, got)
+		}
+	}
+}
+
+func TestDynamicDNS(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/ddnsConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	dns := newFakeDNS(0)
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+		DNS:   dns,
+	})
+	defer server.dns.close()
+	mac, _ := net.ParseMAC("12:34:56:00:00:01")
+
+	acquire := func(relay net.IP, opts []d4.Option) d4.Packet {
+		p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+
+		opts = append(opts, d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte(dp.YIAddr().To4())})
+		p = d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+		checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+		return rp
+	}
+
+	relay := net.ParseIP("10.0.1.5")
+	ip := net.IP{10, 0, 1, 10}
+	ptr := "10.1.0.10.in-addr.arpa."
+	ptrData := []byte("\x09my-laptop\x07example\x03com\x00")
+	dhcid := func(name string) []byte {
+		data, _ := ddns.DHCID(ddns.DHCIDHardware, append([]byte{1}, mac...), name)
+		return data
+	}
+
+	// Hostname option, the name is only added if it's not in use
+	acquire(relay, []d4.Option{{Code: d4.OptionHostName, Value: []byte("My_Laptop")}})
+	u := dns.next(t)
+	checkPrereqs(t, u, ddns.RR{Name: "my-laptop.example.com", Type: ddns.TypeANY, Class: ddns.ClassNONE})
+	checkUpdate(t, u, "example.com",
+		ddns.RR{Name: "my-laptop.example.com", Type: ddns.TypeA, Class: ddns.ClassIN, Data: ip},
+		ddns.RR{Name: "my-laptop.example.com", Type: ddns.TypeDHCID, Class: ddns.ClassIN, Data: dhcid("my-laptop.example.com")},
+	)
+	checkUpdate(t, dns.next(t), "1.0.10.in-addr.arpa",
+		ddns.RR{Name: ptr, Type: ddns.TypePTR, Class: ddns.ClassANY},
+		ddns.RR{Name: ptr, Type: ddns.TypePTR, Class: ddns.ClassIN, Data: ptrData},
+	)
+	if l, _ := db.GetLease(ip); l == nil || l.DNSName != "my-laptop.example.com" {
+		t.Errorf("Expected DNS name to be saved with lease, got %#v", l)
+	}
+
+	// Renewing with the same name doesn't send updates
+	acquire(relay, []d4.Option{{Code: d4.OptionHostName, Value: []byte("My_Laptop")}})
+	dns.none(t)
+
+	// Client FQDN asking to do its own updates is overridden
+	fqdn := &d4.ClientFQDN{Flags: d4.FQDNEncoded, Name: "desk.corp.example"}
+	rp := acquire(relay, []d4.Option{{Code: d4.OptionClientFQDN, Value: fqdn.Marshal()}})
+	expected := &d4.ClientFQDN{Flags: d4.FQDNEncoded | d4.FQDNServerUpdate | d4.FQDNOverride, Name: "desk.example.com"}
+	checkOptions(rp, d4.Options{d4.OptionClientFQDN: expected.Marshal()}, t)
+	u = dns.next(t)
+	checkPrereqs(t, u, ddns.RR{Name: "my-laptop.example.com", Type: ddns.TypeDHCID, Class: ddns.ClassIN, Data: dhcid("my-laptop.example.com")})
+	checkUpdate(t, u, "example.com",
+		ddns.RR{Name: "my-laptop.example.com", Type: ddns.TypeA, Class: ddns.ClassNONE, Data: ip},
+		ddns.RR{Name: "my-laptop.example.com", Type: ddns.TypeDHCID, Class: ddns.ClassANY},
+	)
+	checkUpdate(t, dns.next(t), "1.0.10.in-addr.arpa",
+		ddns.RR{Name: ptr, Type: ddns.TypePTR, Class: ddns.ClassANY},
+	)
+	checkUpdate(t, dns.next(t), "example.com",
+		ddns.RR{Name: "desk.example.com", Type: ddns.TypeA, Class: ddns.ClassIN, Data: ip},
+		ddns.RR{Name: "desk.example.com", Type: ddns.TypeDHCID, Class: ddns.ClassIN, Data: dhcid("desk.example.com")},
+	)
+	dns.next(t) // PTR
+
+	// Release removes the records
+	p := d4.RequestPacket(d4.Release, mac, ip, nil, false, nil)
+	server.ServeDHCP(p, d4.Release, p.ParseOptions())
+	checkUpdate(t, dns.next(t), "example.com",
+		ddns.RR{Name: "desk.example.com", Type: ddns.TypeA, Class: ddns.ClassNONE, Data: ip},
+		ddns.RR{Name: "desk.example.com", Type: ddns.TypeDHCID, Class: ddns.ClassANY},
+	)
+	dns.next(t) // PTR
+	if l, _ := db.GetLease(ip); l == nil || l.DNSName != "" {
+		t.Errorf("Expected DNS name to be cleared from lease, got %#v", l)
+	}
+
+	// No server updates requested
+	fqdn = &d4.ClientFQDN{Flags: d4.FQDNNoServerUpdate, Name: "desk"}
+	rp = acquire(relay, []d4.Option{{Code: d4.OptionClientFQDN, Value: fqdn.Marshal()}})
+	checkOptions(rp, d4.Options{d4.OptionClientFQDN: fqdn.Marshal()}, t)
+	dns.none(t)
+
+	// Network without ddns-domain
+	acquire(net.ParseIP("10.0.2.5"), []d4.Option{{Code: d4.OptionHostName, Value: []byte("other")}})
+	dns.none(t)
+
+	// Expired leases are removed from DNS
+	acquire(relay, []d4.Option{{Code: d4.OptionHostName, Value: []byte("expiring")}})
+	dns.next(t)
+	dns.next(t)
+	lease := c.networks["network1"].findLease(ip)
+	lease.End = time.Now().Add(-time.Minute)
+	server.expireDNS()
+	checkUpdate(t, dns.next(t), "example.com",
+		ddns.RR{Name: "expiring.example.com", Type: ddns.TypeA, Class: ddns.ClassNONE, Data: ip},
+		ddns.RR{Name: "expiring.example.com", Type: ddns.TypeDHCID, Class: ddns.ClassANY},
+	)
+	dns.next(t)
+	if lease.DNSName != "" {
+		t.Errorf("Expected DNS name to be cleared from expired lease, got %s", lease.DNSName)
+	}
+}
+
+func TestDynamicDNSConflict(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/ddnsConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	dns := newFakeDNS(0)
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+		DNS:   dns,
+	})
+	defer server.dns.close()
+
+	relay := net.ParseIP("10.0.1.5")
+	acquire := func(mac net.HardwareAddr, hostname string) net.IP {
+		opts := []d4.Option{{Code: d4.OptionHostName, Value: []byte(hostname)}}
+		p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+		opts = append(opts, d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte(dp.YIAddr().To4())})
+		p = d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+		checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+		return rp.YIAddr()
+	}
+
+	// The name is in use by another client, nothing is changed
+	mac, _ := net.ParseMAC("12:34:56:00:00:01")
+	dns.Lock()
+	dns.prereqRcodes = []ddns.Rcode{ddns.RcodeYXDomain, ddns.RcodeNXRRSet}
+	dns.Unlock()
+	acquire(mac, "www")
+	dns.none(t)
+
+	// The name belongs to the same client, its address is replaced
+	mac2, _ := net.ParseMAC("12:34:56:00:00:02")
+	dns.Lock()
+	dns.prereqRcodes = []ddns.Rcode{ddns.RcodeYXDomain}
+	dns.Unlock()
+	ip := acquire(mac2, "desk")
+	dhcid, _ := ddns.DHCID(ddns.DHCIDHardware, append([]byte{1}, mac2...), "desk.example.com")
+	u := dns.next(t)
+	checkPrereqs(t, u, ddns.RR{Name: "desk.example.com", Type: ddns.TypeDHCID, Class: ddns.ClassIN, Data: dhcid})
+	checkUpdate(t, u, "example.com",
+		ddns.RR{Name: "desk.example.com", Type: ddns.TypeA, Class: ddns.ClassANY},
+		ddns.RR{Name: "desk.example.com", Type: ddns.TypeA, Class: ddns.ClassIN, Data: ip},
+	)
+	checkUpdate(t, dns.next(t), "1.0.10.in-addr.arpa",
+		ddns.RR{Name: ddns.ReverseName(ip), Type: ddns.TypePTR, Class: ddns.ClassANY},
+		ddns.RR{Name: ddns.ReverseName(ip), Type: ddns.TypePTR, Class: ddns.ClassIN, Data: []byte("\x04desk\x07example\x03com\x00")},
+	)
+}
+
+func TestDynamicDNSReusedAddress(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/ddnsConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	dns := newFakeDNS(0)
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+		DNS:   dns,
+	})
+	defer server.dns.close()
+
+	relay := net.ParseIP("10.0.1.5")
+	discover := func(mac net.HardwareAddr) d4.Packet {
+		p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, nil)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+		return dp
+	}
+
+	mac, _ := net.ParseMAC("12:34:56:00:00:01")
+	opts := []d4.Option{
+		{Code: d4.OptionHostName, Value: []byte("laptop")},
+		{Code: d4.OptionRequestedIPAddress, Value: []byte(discover(mac).YIAddr().To4())},
+	}
+	p := d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(relay)
+	ip := server.ServeDHCP(p, d4.Request, p.ParseOptions()).YIAddr()
+	dns.next(t)
+	dns.next(t)
+
+	// The lease expired long ago and its records weren't removed yet
+	lease := c.networks["network1"].findLease(ip)
+	lease.End = time.Now().Add(-365 * 24 * time.Hour)
+
+	// Another client gets the address, the records are removed using the previous client's DHCID
+	mac2, _ := net.ParseMAC("12:34:56:00:00:02")
+	if dp := discover(mac2); !dp.YIAddr().Equal(ip) {
+		t.Fatalf("Expected address %s to be reused, got %s", ip, dp.YIAddr())
+	}
+	dhcid, _ := ddns.DHCID(ddns.DHCIDHardware, append([]byte{1}, mac...), "laptop.example.com")
+	u := dns.next(t)
+	checkPrereqs(t, u, ddns.RR{Name: "laptop.example.com", Type: ddns.TypeDHCID, Class: ddns.ClassIN, Data: dhcid})
+	checkUpdate(t, u, "example.com",
+		ddns.RR{Name: "laptop.example.com", Type: ddns.TypeA, Class: ddns.ClassNONE, Data: ip},
+		ddns.RR{Name: "laptop.example.com", Type: ddns.TypeDHCID, Class: ddns.ClassANY},
+	)
+	checkUpdate(t, dns.next(t), "1.0.10.in-addr.arpa",
+		ddns.RR{Name: ddns.ReverseName(ip), Type: ddns.TypePTR, Class: ddns.ClassANY},
+	)
+	if lease.DNSName != "" {
+		t.Errorf("Expected DNS name to be cleared from reused lease, got %s", lease.DNSName)
+	}
+}
+
+func TestDNSQueueRetry(t *testing.T) {
+	dns := newFakeDNS(3)
+	q := newDNSQueue(dns, verbose.New(""), time.Millisecond)
+	defer q.close()
+
+	first := ddns.NewUpdate("first")
+	second := ddns.NewUpdate("second")
+	q.enqueue(first)
+	q.enqueue(second)
+
+	// Updates are delivered in order once the server comes back
+	if u := dns.next(t); u != first {
+		t.Errorf("Expected first update, got %s", u.Zone)
+	}
+	if u := dns.next(t); u != second {
+		t.Errorf("Expected second update, got %s", u.Zone)
+	}
+
+	// An update that keeps failing is eventually dropped
+	dns.Lock()
+	dns.failures = ddnsMaxAttempts
+	dns.Unlock()
+	q.enqueue(first)
+	q.enqueue(second)
+	if u := dns.next(t); u != second {
+		t.Errorf("Expected second update, got %s", u.Zone)
+	}
+}
+
+func TestSanitizeHostLabel(t *testing.T) {
+	tests := map[string]string{
+		"My_Laptop":        "my-laptop",
+		"host.example.com": "host",
+		"-weird!name-":     "weirdname",
+		"!!!":              "",
+	}
+	for in, out := range tests {
+		if label := sanitizeHostLabel(in); label != out {
+			t.Errorf("Expected %q for %q, got %q", out, in, label)
+		}
+	}
+}
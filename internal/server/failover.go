@@ -0,0 +1,675 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+// FailoverRole is the part a server plays in a failover pair.
+type FailoverRole int
+
+// Failover roles
+const (
+	// FailoverPrimary always serves clients.
+	FailoverPrimary FailoverRole = iota + 1
+	// FailoverSecondary is a hot standby that only serves clients once its partner is declared down.
+	FailoverSecondary
+)
+
+func (r FailoverRole) String() string {
+	switch r {
+	case FailoverPrimary:
+		return "primary"
+	case FailoverSecondary:
+		return "secondary"
+	}
+	return "unknown"
+}
+
+// ParseFailoverRole returns the role named s.
+func ParseFailoverRole(s string) (FailoverRole, error) {
+	switch strings.ToLower(s) {
+	case "primary":
+		return FailoverPrimary, nil
+	case "secondary":
+		return FailoverSecondary, nil
+	}
+	return 0, fmt.Errorf("Unknown failover role %s", s)
+}
+
+// Failover defaults
+const (
+	DefaultMCLT              = time.Hour
+	DefaultHeartbeatInterval = 5 * time.Second
+	DefaultFailoverTimeout   = 10 * time.Second
+)
+
+// FailoverConfig configures lease synchronization with a partner server.
+type FailoverConfig struct {
+	Role FailoverRole
+	// Address is the host:port to listen on for the partner.
+	Address string
+	// Peer is the host:port of the partner. Only connections from its address are accepted.
+	Peer string
+	// Secret is shared with the partner, both prove they know it when connecting.
+	Secret []byte
+	// MCLT is the maximum client lead time. While out of contact with the partner,
+	// leases are granted for at most MCLT so the partner can safely take over.
+	MCLT              time.Duration
+	HeartbeatInterval time.Duration
+	// Timeout is how long to wait for the partner to answer before contact is lost.
+	Timeout time.Duration
+}
+
+type failoverState int
+
+const (
+	// Starting or lost contact, the partner may not know about all leases
+	failoverInterrupted failoverState = iota
+	// In contact and leases are synchronized
+	failoverNormal
+	// The operator declared the partner down
+	failoverPartnerDown
+)
+
+func (s failoverState) String() string {
+	switch s {
+	case failoverNormal:
+		return "normal"
+	case failoverPartnerDown:
+		return "partner-down"
+	}
+	return "communications-interrupted"
+}
+
+var (
+	errSameFailoverRole = errors.New("partner has the same failover role")
+	errFailoverDisabled = errors.New("Failover is not enabled")
+	errPartnerReachable = errors.New("Failover partner is reachable")
+	errFailoverAuth     = errors.New("failover partner failed authentication")
+)
+
+// Failover keeps the leases of two servers synchronized. Every committed binding
+// is sent to the partner, and all leases are exchanged whenever contact is
+// (re)established. A nil *Failover means failover is disabled.
+type Failover struct {
+	conf *FailoverConfig
+	h    *Handler
+
+	m           sync.Mutex
+	state       failoverState
+	lastContact time.Time
+	started     time.Time
+	synced      bool // Leases were exchanged with the partner since starting
+	listener    net.Listener
+	conns       map[net.Conn]struct{} // Partner connections to this server
+	client      *rpc.Client
+	closing     bool
+
+	updates chan []byte
+	done    chan struct{}
+}
+
+// NewFailover creates a failover subsystem. It's enabled by setting ServerConfig.Failover.
+func NewFailover(conf *FailoverConfig) *Failover {
+	if conf.MCLT <= 0 {
+		conf.MCLT = DefaultMCLT
+	}
+	if conf.HeartbeatInterval <= 0 {
+		conf.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultFailoverTimeout
+	}
+	return &Failover{
+		conf:    conf,
+		conns:   make(map[net.Conn]struct{}),
+		updates: make(chan []byte, 1024),
+		done:    make(chan struct{}),
+	}
+}
+
+// Listen opens the listener for partner connections. Start calls Listen if it
+// hasn't been called.
+func (f *Failover) Listen() error {
+	l, err := net.Listen("tcp", f.conf.Address)
+	if err != nil {
+		return err
+	}
+	f.listener = l
+	return nil
+}
+
+// Addr returns the address the failover listener is bound to.
+func (f *Failover) Addr() net.Addr {
+	return f.listener.Addr()
+}
+
+// Start begins serving the partner and sending heartbeats and binding updates.
+func (f *Failover) Start() error {
+	if f.h == nil {
+		return errors.New("Failover is not attached to a server")
+	}
+	if f.conf.Role != FailoverPrimary && f.conf.Role != FailoverSecondary {
+		return errors.New("Failover role must be primary or secondary")
+	}
+	if len(f.conf.Secret) == 0 {
+		return errors.New("Failover secret is required")
+	}
+	if f.listener == nil {
+		if err := f.Listen(); err != nil {
+			return err
+		}
+	}
+
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("Failover", &failoverService{f: f}); err != nil {
+		return err
+	}
+
+	f.m.Lock()
+	// The partner may have been down for a while, assume it was seen just now
+	f.lastContact = time.Now()
+	f.started = f.lastContact
+	f.m.Unlock()
+
+	f.h.c.Log.WithFields(verbose.Fields{
+		"role":    f.conf.Role.String(),
+		"address": f.listener.Addr().String(),
+		"peer":    f.conf.Peer,
+	}).Info("Starting failover")
+
+	go f.serve(rpcServer)
+	go f.heartbeatLoop()
+	go f.updateLoop()
+	return nil
+}
+
+// Close stops failover and closes all partner connections.
+func (f *Failover) Close() {
+	if f == nil {
+		return
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.closing {
+		return
+	}
+	f.closing = true
+	close(f.done)
+	if f.listener != nil {
+		f.listener.Close()
+	}
+	for conn := range f.conns {
+		conn.Close()
+	}
+	if f.client != nil {
+		f.client.Close()
+		f.client = nil
+	}
+}
+
+func (f *Failover) serve(rpcServer *rpc.Server) {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		f.m.Lock()
+		if f.closing {
+			f.m.Unlock()
+			conn.Close()
+			return
+		}
+		f.conns[conn] = struct{}{}
+		f.m.Unlock()
+
+		go func() {
+			f.serveConn(rpcServer, conn)
+			f.m.Lock()
+			delete(f.conns, conn)
+			f.m.Unlock()
+		}()
+	}
+}
+
+// serveConn serves a connection if it's from the partner and it knows the secret.
+func (f *Failover) serveConn(rpcServer *rpc.Server, conn net.Conn) {
+	if !f.isPeer(conn.RemoteAddr()) {
+		conn.Close()
+		f.h.c.Log.WithField("address", conn.RemoteAddr().String()).Notice("Blocked failover connection")
+		return
+	}
+	if err := f.authenticate(conn, false); err != nil {
+		conn.Close()
+		f.h.c.Log.WithFields(verbose.Fields{
+			"address": conn.RemoteAddr().String(),
+			"error":   err,
+		}).Notice("Failover partner failed authentication")
+		return
+	}
+	rpcServer.ServeConn(conn)
+}
+
+func (f *Failover) heartbeatLoop() {
+	ticker := time.NewTicker(f.conf.HeartbeatInterval)
+	defer ticker.Stop()
+
+	f.heartbeat()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			f.heartbeat()
+		}
+	}
+}
+
+// isPeer returns if a is an address of the partner.
+func (f *Failover) isPeer(a net.Addr) bool {
+	host, _, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	peer, _, err := net.SplitHostPort(f.conf.Peer)
+	if err != nil || ip == nil {
+		return false
+	}
+	if peerIP := net.ParseIP(peer); peerIP != nil {
+		return peerIP.Equal(ip)
+	}
+	ips, err := net.LookupIP(peer)
+	if err != nil {
+		return false
+	}
+	for _, peerIP := range ips {
+		if peerIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate proves to the partner on conn that this server knows the shared
+// secret and checks the partner knows it too. Each side sends a random challenge
+// and answers the other's with an HMAC over both challenges, the dialing side
+// answers first.
+func (f *Failover) authenticate(conn net.Conn, dialed bool) error {
+	conn.SetDeadline(time.Now().Add(f.conf.Timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	ours := make([]byte, sha256.Size)
+	if _, err := rand.Read(ours); err != nil {
+		return err
+	}
+	if _, err := conn.Write(ours); err != nil {
+		return err
+	}
+	theirs := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, theirs); err != nil {
+		return err
+	}
+
+	dialer, listener := ours, theirs
+	if !dialed {
+		dialer, listener = theirs, ours
+	}
+	dialerMAC := failoverMAC(f.conf.Secret, "dialer", dialer, listener)
+	listenerMAC := failoverMAC(f.conf.Secret, "listener", dialer, listener)
+	mac := make([]byte, sha256.Size)
+
+	if dialed {
+		if _, err := conn.Write(dialerMAC); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, mac); err != nil {
+			return err
+		}
+		if !hmac.Equal(mac, listenerMAC) {
+			return errFailoverAuth
+		}
+		return nil
+	}
+
+	if _, err := io.ReadFull(conn, mac); err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, dialerMAC) {
+		return errFailoverAuth
+	}
+	_, err := conn.Write(listenerMAC)
+	return err
+}
+
+func failoverMAC(secret []byte, side string, challenges ...[]byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(side))
+	for _, c := range challenges {
+		mac.Write(c)
+	}
+	return mac.Sum(nil)
+}
+
+// heartbeat checks the partner is reachable and synchronizes all leases if
+// contact was lost.
+func (f *Failover) heartbeat() {
+	var role int
+	if err := f.call("Failover.Heartbeat", int(f.conf.Role), &role); err != nil {
+		f.lostContact(err)
+		return
+	}
+
+	f.m.Lock()
+	synced := f.state == failoverNormal
+	f.m.Unlock()
+	if synced {
+		f.contact(false)
+		return
+	}
+
+	var theirs [][]byte
+	if err := f.call("Failover.Sync", f.allLeases(), &theirs); err != nil {
+		f.lostContact(err)
+		return
+	}
+	f.applyLeases(theirs, false)
+	f.contact(true)
+}
+
+// updateLoop sends binding updates to the partner. Updates made while out of
+// contact are dropped, they're sent with the full synchronization instead.
+func (f *Failover) updateLoop() {
+	for {
+		var batch [][]byte
+		select {
+		case <-f.done:
+			return
+		case data := <-f.updates:
+			batch = append(batch, data)
+		}
+
+	drain:
+		for len(batch) < cap(f.updates) {
+			select {
+			case data := <-f.updates:
+				batch = append(batch, data)
+			default:
+				break drain
+			}
+		}
+
+		f.m.Lock()
+		synced := f.state == failoverNormal
+		f.m.Unlock()
+		if !synced {
+			continue
+		}
+
+		var applied int
+		if err := f.call("Failover.BindingUpdate", batch, &applied); err != nil {
+			f.lostContact(err)
+		}
+	}
+}
+
+// call makes an RPC call to the partner, connecting first if needed.
+func (f *Failover) call(method string, args, reply interface{}) error {
+	f.m.Lock()
+	if f.closing {
+		f.m.Unlock()
+		return errors.New("Failover closed")
+	}
+	client := f.client
+	f.m.Unlock()
+
+	timeout := f.conf.Timeout
+	if client == nil {
+		conn, err := net.DialTimeout("tcp", f.conf.Peer, timeout)
+		if err != nil {
+			return err
+		}
+		if err := f.authenticate(conn, true); err != nil {
+			conn.Close()
+			return err
+		}
+		client = rpc.NewClient(conn)
+
+		f.m.Lock()
+		if f.closing {
+			f.m.Unlock()
+			client.Close()
+			return errors.New("Failover closed")
+		}
+		f.client = client
+		f.m.Unlock()
+	}
+
+	c := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-c.Done:
+		if c.Error == rpc.ErrShutdown {
+			f.dropClient(client)
+		}
+		return c.Error
+	case <-time.After(timeout):
+		f.dropClient(client)
+		return fmt.Errorf("%s timed out", method)
+	}
+}
+
+func (f *Failover) dropClient(client *rpc.Client) {
+	client.Close()
+	f.m.Lock()
+	if f.client == client {
+		f.client = nil
+	}
+	f.m.Unlock()
+}
+
+// contact records the partner was heard from. If synced is true, all leases
+// have been exchanged.
+func (f *Failover) contact(synced bool) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.lastContact = time.Now()
+	if synced {
+		f.synced = true
+	}
+	if synced && f.state != failoverNormal {
+		f.h.c.Log.WithFields(verbose.Fields{
+			"role":  f.conf.Role.String(),
+			"peer":  f.conf.Peer,
+			"state": f.state.String(),
+		}).Notice("Failover partner synchronized")
+		f.state = failoverNormal
+	}
+}
+
+// lostContact moves to communications-interrupted. The partner may still be
+// serving clients, only the operator can declare it down with PartnerDown.
+func (f *Failover) lostContact(err error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.closing {
+		return
+	}
+
+	if f.state == failoverNormal {
+		f.h.c.Log.WithFields(verbose.Fields{
+			"peer":  f.conf.Peer,
+			"error": err,
+		}).Warning("Lost contact with failover partner")
+		f.state = failoverInterrupted
+	}
+}
+
+// PartnerDown declares the partner down. The server can't tell a stopped
+// partner from one it can't reach, so this is left to the operator. The
+// secondary then serves clients until contact is reestablished.
+func (f *Failover) PartnerDown() error {
+	if f == nil {
+		return errFailoverDisabled
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.state == failoverNormal {
+		return errPartnerReachable
+	}
+	if f.state != failoverPartnerDown {
+		f.h.c.Log.WithFields(verbose.Fields{
+			"role": f.conf.Role.String(),
+			"peer": f.conf.Peer,
+		}).Alert("Failover partner declared down")
+		f.state = failoverPartnerDown
+	}
+	return nil
+}
+
+// shouldServe returns if the server should answer clients. A secondary only
+// answers when its partner was declared down.
+func (f *Failover) shouldServe() bool {
+	if f == nil || f.conf.Role == FailoverPrimary {
+		return true
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.state == failoverPartnerDown
+}
+
+// canAllocate returns if addresses may be given to new clients. A secondary
+// that took over waits MCLT from the last contact, by then any lease the
+// partner granted that it didn't hear about has expired. A primary that
+// restarted doesn't know what the secondary granted while it was down, it waits
+// for the first synchronization or MCLT from starting.
+func (f *Failover) canAllocate() bool {
+	if f == nil {
+		return true
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.conf.Role == FailoverPrimary {
+		return f.synced || time.Since(f.started) >= f.conf.MCLT
+	}
+	return f.state != failoverPartnerDown || time.Since(f.lastContact) >= f.conf.MCLT
+}
+
+// capLeaseTime limits d to MCLT while leases aren't synchronized with the partner.
+func (f *Failover) capLeaseTime(d time.Duration) time.Duration {
+	if f == nil {
+		return d
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.state != failoverNormal && d > f.conf.MCLT {
+		return f.conf.MCLT
+	}
+	return d
+}
+
+// sendUpdate queues a binding update for the partner.
+func (f *Failover) sendUpdate(l *models.Lease) {
+	if f == nil {
+		return
+	}
+	select {
+	case f.updates <- l.Serialize():
+	default:
+		// Queue is full, fall back to a full synchronization
+		f.m.Lock()
+		if f.state == failoverNormal {
+			f.state = failoverInterrupted
+		}
+		f.m.Unlock()
+	}
+}
+
+// allLeases returns every bound lease serialized.
+func (f *Failover) allLeases() [][]byte {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	var leases [][]byte
+	for _, n := range f.h.conf.networks {
+		n.Lock()
+		for _, l := range n.getAllLeases() {
+			if l.Offered || l.End.IsZero() {
+				continue
+			}
+			leases = append(leases, l.Serialize())
+		}
+		n.Unlock()
+	}
+	return leases
+}
+
+// applyLeases merges leases from the partner. Binding updates always replace
+// the existing lease, during synchronization the newer lease wins.
+func (f *Failover) applyLeases(leases [][]byte, update bool) int {
+	applied := 0
+	for _, data := range leases {
+		l := models.NewLease()
+		if err := l.Unserialize(data); err != nil {
+			f.h.c.Log.WithField("error", err).Error("Invalid lease from failover partner")
+			continue
+		}
+		if f.h.applyPartnerLease(l, update) {
+			applied++
+		}
+	}
+	return applied
+}
+
+// partnerLeaseIsNewer returns if theirs should replace ours during synchronization.
+// The lease changed last wins, whether it was renewed, released or abandoned.
+func partnerLeaseIsNewer(ours, theirs *models.Lease) bool {
+	if ours.Offered {
+		return true
+	}
+	return theirs.UpdatedAt.After(ours.UpdatedAt)
+}
+
+// failoverService is the RPC interface exposed to the partner.
+type failoverService struct {
+	f *Failover
+}
+
+func (s *failoverService) Heartbeat(role int, reply *int) error {
+	if FailoverRole(role) == s.f.conf.Role {
+		return errSameFailoverRole
+	}
+	s.f.contact(false)
+	*reply = int(s.f.conf.Role)
+	return nil
+}
+
+func (s *failoverService) BindingUpdate(leases [][]byte, reply *int) error {
+	*reply = s.f.applyLeases(leases, true)
+	s.f.contact(false)
+	return nil
+}
+
+func (s *failoverService) Sync(leases [][]byte, reply *[][]byte) error {
+	*reply = s.f.allLeases()
+	s.f.applyLeases(leases, false)
+	s.f.contact(true)
+	return nil
+}
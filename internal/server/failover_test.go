@@ -0,0 +1,347 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/models"
+	"github.com/packet-guardian/pg-dhcp/store"
+)
+
+func newFailoverPeer(t *testing.T, db store.Store, role FailoverRole, address string) (*Handler, *Failover) {
+	c, err := ParseFile("./testdata/testConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	f := NewFailover(&FailoverConfig{
+		Role:              role,
+		Address:           address,
+		Secret:            []byte("secret"),
+		MCLT:              400 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+		Timeout:           200 * time.Millisecond,
+	})
+	if err := f.Listen(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewDHCPServer(c, &ServerConfig{
+		Env:      EnvTesting,
+		Log:      verbose.New(""),
+		Store:    db,
+		Failover: f,
+	})
+	if err := h.LoadLeases(); err != nil {
+		t.Fatal(err)
+	}
+	return h, f
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func failoverStateIs(f *Failover, s failoverState) func() bool {
+	return func() bool {
+		f.m.Lock()
+		defer f.m.Unlock()
+		return f.state == s
+	}
+}
+
+func acquireLease(h *Handler, mac net.HardwareAddr, t *testing.T) d4.Packet {
+	p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, nil)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	dp := h.ServeDHCP(p, d4.Discover, p.ParseOptions())
+	if dp == nil {
+		t.Fatal("No offer")
+	}
+	return requestLease(h, mac, dp.YIAddr())
+}
+
+func requestLease(h *Handler, mac net.HardwareAddr, ip net.IP) d4.Packet {
+	opts := []d4.Option{
+		d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte(ip.To4())},
+	}
+	p := d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	h.gatewayCache["10.0.1.5"] = h.conf.searchNetworksFor(net.ParseIP("10.0.1.5"))
+	return h.ServeDHCP(p, d4.Request, p.ParseOptions())
+}
+
+func TestFailover(t *testing.T) {
+	dbA, _ := store.NewMemoryStore()
+	dbB, _ := store.NewMemoryStore()
+	primary, fa := newFailoverPeer(t, dbA, FailoverPrimary, "127.0.0.1:0")
+	secondary, fb := newFailoverPeer(t, dbB, FailoverSecondary, "127.0.0.1:0")
+	fa.conf.Peer = fb.Addr().String()
+	fb.conf.Peer = fa.Addr().String()
+	defer fb.Close()
+
+	if err := fa.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "primary to synchronize", failoverStateIs(fa, failoverNormal))
+	waitFor(t, "secondary to synchronize", failoverStateIs(fb, failoverNormal))
+
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	setDevice(dbA, mac, true, false)
+	setDevice(dbB, mac, true, false)
+
+	// The standby doesn't answer while the primary is up
+	p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, nil)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	if secondary.ServeDHCP(p, d4.Discover, p.ParseOptions()) != nil {
+		t.Error("Secondary answered while the primary is up")
+	}
+
+	ack := acquireLease(primary, mac, t)
+	checkOptions(ack, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+	ip := ack.YIAddr()
+
+	// Binding update reaches the secondary's store and pools
+	waitFor(t, "binding update", func() bool {
+		l, _ := dbB.GetLease(ip)
+		return l != nil && l.MAC.String() == mac.String()
+	})
+	n := secondary.conf.networks["network1"]
+	n.Lock()
+	l := n.findLease(ip)
+	n.Unlock()
+	if l == nil || l.MAC.String() != mac.String() {
+		t.Fatal("Binding update not in secondary's pool")
+	}
+
+	if err := fb.PartnerDown(); err != errPartnerReachable {
+		t.Errorf("Expected partner-down to be refused while in contact, got %v", err)
+	}
+
+	// Primary goes down, the secondary waits for the operator to take over
+	fa.Close()
+	waitFor(t, "lost contact", failoverStateIs(fb, failoverInterrupted))
+	time.Sleep(100 * time.Millisecond)
+	if !failoverStateIs(fb, failoverInterrupted)() || fb.shouldServe() {
+		t.Fatal("Secondary took over without the partner declared down")
+	}
+	if err := fb.PartnerDown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Known bindings are renewed with lease times capped at MCLT
+	ack = requestLease(secondary, mac, ip)
+	checkOptions(ack, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+	leaseTime := ack.ParseOptions()[d4.OptionIPAddressLeaseTime]
+	if d := time.Duration(binary.BigEndian.Uint32(leaseTime)) * time.Second; d > time.Second {
+		t.Errorf("Expected lease time capped at MCLT, got %s", d)
+	}
+
+	// New clients wait for MCLT before getting an address
+	mac2, _ := net.ParseMAC("12:34:56:12:34:57")
+	setDevice(dbB, mac2, true, false)
+	setDevice(dbA, mac2, true, false)
+	p = d4.RequestPacket(d4.Discover, mac2, nil, nil, false, nil)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	fb.m.Lock()
+	waited := time.Since(fb.lastContact) >= fb.conf.MCLT
+	fb.m.Unlock()
+	if dp := secondary.ServeDHCP(p, d4.Discover, p.ParseOptions()); dp != nil && !waited {
+		t.Error("Secondary allocated an address before MCLT passed")
+	}
+	waitFor(t, "MCLT to pass", func() bool { return fb.canAllocate() })
+	ack2 := acquireLease(secondary, mac2, t)
+	ip2 := ack2.YIAddr()
+
+	// Primary restarts with its own store and learns the secondary's leases
+	primary2, fa2 := newFailoverPeer(t, dbA, FailoverPrimary, fa.Addr().String())
+	fa2.conf.Peer = fb.Addr().String()
+	if err := fa2.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fa2.Close()
+	waitFor(t, "restarted primary to synchronize", failoverStateIs(fa2, failoverNormal))
+	waitFor(t, "secondary to synchronize", failoverStateIs(fb, failoverNormal))
+
+	n = primary2.conf.networks["network1"]
+	n.Lock()
+	l = n.findLease(ip2)
+	n.Unlock()
+	if l == nil || l.MAC.String() != mac2.String() {
+		t.Errorf("Restarted primary doesn't know lease %s", ip2)
+	}
+	if secondary.ServeDHCP(p, d4.Discover, p.ParseOptions()) != nil {
+		t.Error("Secondary answered after the primary returned")
+	}
+}
+
+func TestFailoverPrimaryStartup(t *testing.T) {
+	db, _ := store.NewMemoryStore()
+	primary, fa := newFailoverPeer(t, db, FailoverPrimary, "127.0.0.1:0")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fa.conf.Peer = l.Addr().String()
+	l.Close() // The secondary is unreachable
+	if err := fa.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fa.Close()
+
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	setDevice(db, mac, true, false)
+	p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, nil)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+
+	// The secondary may have given out addresses the primary doesn't know about
+	fa.m.Lock()
+	waited := time.Since(fa.started) >= fa.conf.MCLT
+	fa.m.Unlock()
+	if dp := primary.ServeDHCP(p, d4.Discover, p.ParseOptions()); dp != nil && !waited {
+		t.Error("Primary allocated an address before synchronizing or MCLT passing")
+	}
+
+	waitFor(t, "MCLT to pass", func() bool { return fa.canAllocate() })
+	ack := acquireLease(primary, mac, t)
+	checkOptions(ack, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+	leaseTime := ack.ParseOptions()[d4.OptionIPAddressLeaseTime]
+	if d := time.Duration(binary.BigEndian.Uint32(leaseTime)) * time.Second; d > time.Second {
+		t.Errorf("Expected lease time capped at MCLT, got %s", d)
+	}
+}
+
+func TestFailoverLeaseMerge(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		ours, theirs *models.Lease
+		newer        bool
+	}{
+		{&models.Lease{UpdatedAt: now}, &models.Lease{UpdatedAt: now.Add(time.Millisecond)}, true},
+		{&models.Lease{UpdatedAt: now}, &models.Lease{UpdatedAt: now.Add(-time.Millisecond)}, false},
+		{&models.Lease{UpdatedAt: now}, &models.Lease{UpdatedAt: now}, false},
+		{&models.Lease{}, &models.Lease{UpdatedAt: now}, true},
+		{&models.Lease{UpdatedAt: now, Offered: true}, &models.Lease{}, true},
+		// A release is newer even though it moves the binding's times back
+		{
+			&models.Lease{Start: now, End: now.Add(time.Hour), UpdatedAt: now},
+			&models.Lease{Start: time.Unix(1, 0), End: time.Unix(1, 0), UpdatedAt: now.Add(time.Second)},
+			true,
+		},
+	}
+	for i, test := range tests {
+		if partnerLeaseIsNewer(test.ours, test.theirs) != test.newer {
+			t.Errorf("Test %d: expected newer to be %t", i, test.newer)
+		}
+	}
+}
+
+func TestFailoverPartitionRelease(t *testing.T) {
+	dbA, _ := store.NewMemoryStore()
+	dbB, _ := store.NewMemoryStore()
+	_, fa := newFailoverPeer(t, dbA, FailoverPrimary, "127.0.0.1:0")
+	secondary, fb := newFailoverPeer(t, dbB, FailoverSecondary, "127.0.0.1:0")
+	fa.conf.Peer = fb.Addr().String()
+	fb.conf.Peer = fa.Addr().String()
+	defer fb.Close()
+
+	if err := fa.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "primary to synchronize", failoverStateIs(fa, failoverNormal))
+	waitFor(t, "secondary to synchronize", failoverStateIs(fb, failoverNormal))
+
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	setDevice(dbA, mac, true, false)
+	setDevice(dbB, mac, true, false)
+	p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, nil)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	primary := fa.h
+	ip := acquireLease(primary, mac, t).YIAddr()
+	waitFor(t, "binding update", func() bool {
+		l, _ := dbB.GetLease(ip)
+		return l != nil && l.MAC.String() == mac.String()
+	})
+
+	// The client releases its lease to the secondary while the primary is unreachable
+	fa.Close()
+	waitFor(t, "lost contact", failoverStateIs(fb, failoverInterrupted))
+	if err := fb.PartnerDown(); err != nil {
+		t.Fatal(err)
+	}
+	p = d4.RequestPacket(d4.Release, mac, ip, nil, false, nil)
+	secondary.ServeDHCP(p, d4.Release, p.ParseOptions())
+
+	// The primary still has the binding and learns of the release when synchronizing
+	primary2, fa2 := newFailoverPeer(t, dbA, FailoverPrimary, fa.Addr().String())
+	fa2.conf.Peer = fb.Addr().String()
+	if err := fa2.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer fa2.Close()
+	waitFor(t, "restarted primary to synchronize", failoverStateIs(fa2, failoverNormal))
+
+	n := primary2.conf.networks["network1"]
+	n.Lock()
+	l := n.findLease(ip)
+	released := l != nil && l.IsExpired()
+	n.Unlock()
+	if !released {
+		t.Error("Release made during the partition was lost")
+	}
+	if l, _ := dbA.GetLease(ip); l == nil || !l.IsExpired() {
+		t.Error("Release made during the partition wasn't saved")
+	}
+}
+
+func TestFailoverAuthentication(t *testing.T) {
+	dbA, _ := store.NewMemoryStore()
+	dbB, _ := store.NewMemoryStore()
+	_, fa := newFailoverPeer(t, dbA, FailoverPrimary, "127.0.0.1:0")
+	_, fb := newFailoverPeer(t, dbB, FailoverSecondary, "127.0.0.1:0")
+	_, fc := newFailoverPeer(t, dbB, FailoverSecondary, "127.0.0.1:0")
+	fa.conf.Peer = fb.Addr().String()
+	fb.conf.Peer = fa.Addr().String()
+	fc.conf.Peer = fa.Addr().String()
+	fc.conf.Secret = []byte("wrong")
+	defer fa.Close()
+	defer fb.Close()
+	defer fc.Close()
+
+	if err := fa.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var role int
+	if err := fc.call("Failover.Heartbeat", int(FailoverSecondary), &role); err == nil {
+		t.Error("Partner with the wrong secret was served")
+	}
+	if err := fb.call("Failover.Heartbeat", int(FailoverSecondary), &role); err != nil {
+		t.Errorf("Partner wasn't served: %s", err)
+	}
+
+	if !fa.isPeer(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}) {
+		t.Error("Peer address not accepted")
+	}
+	if fa.isPeer(&net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 1234}) {
+		t.Error("Address other than the peer accepted")
+	}
+}
@@ -0,0 +1,573 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A FormattedFile is a configuration file rewritten in the canonical format.
+type FormattedFile struct {
+	Path      string
+	Original  []byte
+	Formatted []byte
+}
+
+// Changed returns if formatting changed the file.
+func (f *FormattedFile) Changed() bool {
+	return !bytes.Equal(f.Original, f.Formatted)
+}
+
+// FormatFile parses the configuration file at path and returns it, followed by
+// every file it includes, in the canonical format. Networks are written in the
+// full form and pools declared with only a range statement get pool blocks.
+// Statements in a block are grouped by kind in a fixed order, statements of the
+// same kind keep their order. Comments move with the statement they are on or
+// above. The formatted files parse to the same configuration.
+func FormatFile(path string) ([]*FormattedFile, error) {
+	if _, err := ParseFile(path); err != nil {
+		return nil, err
+	}
+
+	var files []*FormattedFile
+	seen := make(map[string]bool)
+	queue := []string{path}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if abs, err := filepath.Abs(file); err == nil {
+			if seen[abs] {
+				continue
+			}
+			seen[abs] = true
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		formatted, includes, err := formatConfig(data, file)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &FormattedFile{Path: file, Original: data, Formatted: formatted})
+		queue = append(queue, includes...)
+	}
+	return files, nil
+}
+
+// formatConfig formats the configuration data read from file. It returns the
+// files named by the file's include statements.
+func formatConfig(data []byte, file string) ([]byte, []string, error) {
+	f := &formatter{l: newLexer(bufio.NewReader(bytes.NewReader(data)), file)}
+	root := &fmtNode{}
+	if err := f.block(root); err != nil {
+		return nil, nil, err
+	}
+
+	var includes []string
+	for _, n := range root.children {
+		if n.tok.token != INCLUDE {
+			continue
+		}
+		files, err := includeFiles(n.args[0], file)
+		if err != nil {
+			return nil, nil, err
+		}
+		includes = append(includes, files...)
+	}
+
+	canonicalize(root)
+	w := &fmtWriter{blank: true}
+	if err := w.file(root); err != nil {
+		return nil, nil, err
+	}
+	return w.buf.Bytes(), includes, nil
+}
+
+// fmtNode is a statement of a configuration file being formatted. Comments
+// include the leading #.
+type fmtNode struct {
+	tok        *lexToken // Keyword starting the statement, nil for the file
+	args       []*lexToken
+	comments   []string // Lines before the statement, "" is a blank line
+	trailing   string   // Comment at the end of the statement's line
+	block      bool     // The statement is a block closed by end
+	children   []*fmtNode
+	footer     []string // Lines before end
+	endComment string   // Comment at the end of the end line
+}
+
+// formatter reads the statements of a file. Unlike the parser it doesn't read
+// included files and keeps the comments.
+type formatter struct {
+	l        *lexer
+	peeked   *lexToken
+	comments []string // Lines since the last statement, "" is a blank line
+	eols     int      // Line ends since the last token
+	trailing *string  // Where a comment on the current line goes
+}
+
+func (f *formatter) read() *lexToken {
+	if tok := f.peeked; tok != nil {
+		f.peeked = nil
+		return tok
+	}
+	return f.l.next()
+}
+
+// next returns the next token that isn't a comment or line end. Comments are
+// attached to the statement on their line or kept for the next statement.
+func (f *formatter) next() *lexToken {
+	for {
+		tok := f.read()
+		switch tok.token {
+		case EOL:
+			f.eols++
+			f.trailing = nil
+			if f.eols > 1 && (len(f.comments) == 0 || f.comments[len(f.comments)-1] != "") {
+				f.comments = append(f.comments, "")
+			}
+		case COMMENT:
+			f.eols = 0
+			if f.trailing != nil {
+				*f.trailing = "#" + tok.value.(string)
+				f.trailing = nil
+			} else {
+				f.comments = append(f.comments, "#"+tok.value.(string))
+			}
+		default:
+			f.eols = 0
+			return tok
+		}
+	}
+}
+
+// block reads the statements of n until its end.
+func (f *formatter) block(n *fmtNode) error {
+	n.block = true
+	var pool *fmtNode // Pool of a range statement without a pool block
+	for {
+		tok := f.next()
+		if tok.token == END && n.tok == nil {
+			return tokenErrorf(tok, "Unexpected end")
+		}
+		if tok.token == EOF || tok.token == END {
+			n.footer, f.comments = f.comments, nil
+			if tok.token == END {
+				f.trailing = &n.endComment
+			}
+			return nil
+		}
+
+		child, err := f.statement(tok)
+		if err != nil {
+			return err
+		}
+
+		if n.tok != nil && n.tok.token == SUBNET {
+			// A range statement starts a pool that ends at the next range, host or end
+			switch child.tok.token {
+			case RANGE:
+				pool = &fmtNode{tok: &lexToken{token: POOL}, block: true, comments: child.comments}
+				child.comments = nil
+				n.children = append(n.children, pool)
+			case POOL, HOST:
+				pool = nil
+			}
+			if pool != nil {
+				pool.children = append(pool.children, child)
+				continue
+			}
+		}
+		n.children = append(n.children, child)
+	}
+}
+
+// statement reads the statement started by tok.
+func (f *formatter) statement(tok *lexToken) (*fmtNode, error) {
+	n := &fmtNode{tok: tok, comments: f.comments}
+	f.comments = nil
+
+	var err error
+	switch tok.token {
+	case OPTION, OPTION6, OPTION_DEFINITION, TRUSTED_RELAY, FILENAME:
+		f.line(n)
+		return n, nil
+	case MATCH:
+		if err = f.args(n, 1); err == nil {
+			if n.args[0].token == PARAMETER_LIST {
+				f.line(n)
+				return n, nil
+			}
+			err = f.args(n, 1)
+		}
+	case NETWORK:
+		if err = f.args(n, 1); err == nil && n.args[0].token == LOCAL {
+			err = f.args(n, 1)
+		}
+	case SUBNET, SUBNET6, RANGE, RANGE6:
+		err = f.args(n, 2)
+	case AUTHORITATIVE:
+		if b := f.read(); b.token == BOOLEAN {
+			n.args = append(n.args, b)
+		} else {
+			f.peeked = b
+		}
+	case GLOBAL, POOL, REGISTERED, UNREGISTERED:
+	case INCLUDE, HOST, CLASS, SERVER_IDENTIFIER, LEASE_BINDING, ALLOW, DENY, HARDWARE_ADDRESS, FIXED_ADDRESS:
+		err = f.args(n, 1)
+	default:
+		if !tok.token.isSetting() {
+			return nil, tokenErrorf(tok, "Unexpected token %s", tok.string())
+		}
+		err = f.args(n, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.trailing = &n.trailing
+
+	switch tok.token {
+	case GLOBAL, NETWORK, SUBNET, SUBNET6, POOL, HOST, CLASS, REGISTERED, UNREGISTERED:
+		err = f.block(n)
+	}
+	return n, err
+}
+
+// args reads count arguments of n.
+func (f *formatter) args(n *fmtNode, count int) error {
+	for i := 0; i < count; i++ {
+		tok := f.read()
+		if tok.token == EOL || tok.token == COMMENT || tok.token == EOF {
+			return tokenErrorf(tok, "Unexpected end of %s statement", n.tok.token.string())
+		}
+		n.args = append(n.args, tok)
+	}
+	return nil
+}
+
+// line reads the arguments of n to the end of the line.
+func (f *formatter) line(n *fmtNode) {
+	for {
+		tok := f.read()
+		switch tok.token {
+		case EOF:
+			f.peeked = tok
+			return
+		case EOL:
+			f.eols = 1
+			f.trailing = nil
+			return
+		case COMMENT:
+			n.trailing = "#" + tok.value.(string)
+		default:
+			n.args = append(n.args, tok)
+		}
+	}
+}
+
+// statementOrder is the order of statements in each kind of block. Settings
+// are placed with OPTION. Statements not listed, and the statements of other
+// blocks, keep their order.
+var statementOrder = map[token][]token{
+	GLOBAL:     {SERVER_IDENTIFIER, AUTHORITATIVE, TRUSTED_RELAY, OPTION_DEFINITION, OPTION, REGISTERED, HOST},
+	NETWORK:    {AUTHORITATIVE, LEASE_BINDING, MATCH, OPTION, HOST, REGISTERED},
+	REGISTERED: {OPTION, SUBNET},
+	SUBNET:     {OPTION, HOST, POOL},
+	SUBNET6:    {OPTION, RANGE6},
+	POOL:       {RANGE, MATCH, ALLOW, OPTION},
+	HOST:       {HARDWARE_ADDRESS, FIXED_ADDRESS, OPTION},
+}
+
+func statementRank(block, tok token) int {
+	switch {
+	case tok == UNREGISTERED:
+		tok = REGISTERED
+	case tok == SUBNET6:
+		tok = SUBNET
+	case tok == DENY:
+		tok = ALLOW
+	case tok == OPTION6 || tok.isSetting():
+		tok = OPTION
+	}
+	if block == UNREGISTERED {
+		block = REGISTERED
+	}
+
+	order := statementOrder[block]
+	for i, t := range order {
+		if t == tok {
+			return i
+		}
+	}
+	return len(order)
+}
+
+// canonicalize puts the statements under n in the canonical order. The order
+// of the file's statements is kept.
+func canonicalize(n *fmtNode) {
+	if n.tok != nil && n.tok.token == NETWORK {
+		fullNetworkForm(n)
+	}
+	for _, c := range n.children {
+		canonicalize(c)
+	}
+	if n.tok != nil {
+		sort.SliceStable(n.children, func(i, j int) bool {
+			return statementRank(n.tok.token, n.children[i].tok.token) < statementRank(n.tok.token, n.children[j].tok.token)
+		})
+	}
+}
+
+// fullNetworkForm moves the subnets of network n into registered and
+// unregistered blocks and the hosts of those blocks to the network. Consecutive
+// subnets of the same kind share a block so subnets keep their order. The
+// settings of a kind go in its first block.
+func fullNetworkForm(n *fmtNode) {
+	var root, subnets []*fmtNode
+	kinds := make(map[*fmtNode]token)
+	settings := make(map[token][]*fmtNode)
+	sources := make(map[token][]*fmtNode) // Registered and unregistered blocks in n
+
+	for _, c := range n.children {
+		switch c.tok.token {
+		case SUBNET, SUBNET6:
+			// Subnets outside a registered block are unregistered
+			subnets = append(subnets, c)
+			kinds[c] = UNREGISTERED
+		case REGISTERED, UNREGISTERED:
+			kind := c.tok.token
+			sources[kind] = append(sources[kind], c)
+			for _, cc := range c.children {
+				switch cc.tok.token {
+				case SUBNET, SUBNET6:
+					subnets = append(subnets, cc)
+					kinds[cc] = kind
+				case HOST:
+					root = append(root, cc)
+				default:
+					settings[kind] = append(settings[kind], cc)
+				}
+			}
+		default:
+			root = append(root, c)
+		}
+	}
+
+	var blocks []*fmtNode
+	first := make(map[token]*fmtNode)
+	for _, s := range subnets {
+		kind := kinds[s]
+		if len(blocks) == 0 || blocks[len(blocks)-1].tok.token != kind {
+			blocks = append(blocks, &fmtNode{tok: &lexToken{token: kind}, block: true})
+			if first[kind] == nil {
+				first[kind] = blocks[len(blocks)-1]
+			}
+		}
+		b := blocks[len(blocks)-1]
+		b.children = append(b.children, s)
+	}
+
+	for _, kind := range []token{UNREGISTERED, REGISTERED} {
+		b := first[kind]
+		if b == nil {
+			if len(settings[kind]) == 0 && len(sources[kind]) == 0 {
+				continue
+			}
+			b = &fmtNode{tok: &lexToken{token: kind}, block: true}
+			blocks = append(blocks, b)
+		}
+		b.children = append(settings[kind], b.children...)
+
+		for i, src := range sources[kind] {
+			if i == 0 {
+				b.comments, b.trailing, b.footer, b.endComment = src.comments, src.trailing, src.footer, src.endComment
+				continue
+			}
+			// Comments of merged blocks are kept at the end of the block
+			b.footer = append(b.footer, src.comments...)
+			for _, c := range append([]string{src.trailing}, append(src.footer, src.endComment)...) {
+				if c != "" {
+					b.footer = append(b.footer, c)
+				}
+			}
+		}
+	}
+	n.children = append(root, blocks...)
+}
+
+// fmtWriter writes formatted statements indented with tabs.
+type fmtWriter struct {
+	buf   bytes.Buffer
+	blank bool // The last line is blank or starts a block
+}
+
+func (w *fmtWriter) line(depth int, s string) {
+	if s == "" {
+		if !w.blank {
+			w.buf.WriteByte('\n')
+			w.blank = true
+		}
+		return
+	}
+	w.buf.WriteString(strings.Repeat("\t", depth))
+	w.buf.WriteString(s)
+	w.buf.WriteByte('\n')
+	w.blank = false
+}
+
+// lines writes comment lines without trailing blank lines.
+func (w *fmtWriter) lines(depth int, lines []string) {
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for _, l := range lines {
+		w.line(depth, l)
+	}
+}
+
+// file writes the statements of the file root. Blocks are separated from
+// other statements by a blank line.
+func (w *fmtWriter) file(root *fmtNode) error {
+	for i, n := range root.children {
+		if i > 0 && (n.block || root.children[i-1].block) {
+			w.line(0, "")
+		}
+		if err := w.node(0, n); err != nil {
+			return err
+		}
+	}
+	w.lines(0, root.footer)
+	return nil
+}
+
+func (w *fmtWriter) node(depth int, n *fmtNode) error {
+	for _, c := range n.comments {
+		w.line(depth, c)
+	}
+	s, err := n.statement()
+	if err != nil {
+		return err
+	}
+	if n.trailing != "" {
+		s += " " + n.trailing
+	}
+	w.line(depth, s)
+	if !n.block {
+		return nil
+	}
+
+	w.blank = true
+	for _, c := range n.children {
+		if err := w.node(depth+1, c); err != nil {
+			return err
+		}
+	}
+	w.lines(depth+1, n.footer)
+	if n.endComment != "" {
+		w.line(depth, "end "+n.endComment)
+	} else {
+		w.line(depth, "end")
+	}
+	return nil
+}
+
+// statement returns the text of n's statement line. An IPv4 address followed
+// by a mask is written in CIDR notation, the lexer only makes 4 byte addresses
+// for masks in CIDR notation.
+func (n *fmtNode) statement() (string, error) {
+	parts := []string{n.tok.token.string()}
+	cidr := false // The last part is in CIDR notation
+	for i, arg := range n.args {
+		if i > 0 && arg.token == IP_ADDRESS && n.args[i-1].token == IP_ADDRESS && !cidr {
+			mask := net.IPMask(arg.value.(net.IP))
+			ones, bits := mask.Size()
+			if len(mask) == net.IPv4len || (n.tok.token == SUBNET6 && i == 1 && bits > 0) {
+				parts[len(parts)-1] += "/" + strconv.Itoa(ones)
+				cidr = true
+				continue
+			}
+		}
+		cidr = false
+
+		s, err := formatToken(arg, n.bareArg(i))
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// bareArg returns if a string argument i of n is written without quotes when
+// possible. Names and words of the syntax are bare, other strings are quoted.
+func (n *fmtNode) bareArg(i int) bool {
+	switch n.tok.token {
+	case NETWORK, LEASE_BINDING, OPTION_DEFINITION, BOOTP_LEASE_TIME:
+		return true
+	case HOST, OPTION, OPTION6:
+		return i == 0
+	}
+	return false
+}
+
+// formatToken returns the text of tok that the lexer reads back as tok.
+func formatToken(tok *lexToken, bare bool) (string, error) {
+	switch tok.token {
+	case STRING:
+		s := tok.value.(string)
+		if bare && isBareString(s) {
+			return s, nil
+		}
+		if !strings.Contains(s, `"`) {
+			return `"` + s + `"`, nil
+		}
+		if isBareString(s) {
+			return s, nil
+		}
+	case NUMBER:
+		return fmt.Sprint(tok.value), nil
+	case FLOAT:
+		s := strconv.FormatFloat(tok.value.(float64), 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s, nil
+	case BOOLEAN:
+		return strconv.FormatBool(tok.value.(bool)), nil
+	case IP_ADDRESS:
+		return tok.value.(net.IP).String(), nil
+	case MAC_ADDRESS:
+		return tok.value.(net.HardwareAddr).String(), nil
+	case ILLEGAL:
+		// Custom options take anything, the lexer keeps the text of bad hex numbers
+		if s, ok := tok.value.(string); ok {
+			return s, nil
+		}
+	default:
+		if keyword_beg < tok.token && tok.token < keyword_end {
+			return tok.token.string(), nil
+		}
+	}
+	return "", tokenErrorf(tok, "Can't format %s", tok.string())
+}
+
+// isBareString returns if the lexer reads s without quotes as the string s.
+func isBareString(s string) bool {
+	if s == "" {
+		return false
+	}
+	tok := newLexer(bufio.NewReader(strings.NewReader(s+"\n")), "").next()
+	return tok.token == STRING && tok.value.(string) == s
+}
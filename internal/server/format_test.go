@@ -0,0 +1,215 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	conf := `# Main configuration
+global
+	option domain-name example.com
+  server-identifier 10.0.0.1
+	registered
+		default-lease-time 86400
+	end
+end
+network Building1 # Short form
+	subnet 10.0.5.0/24
+		range 10.0.5.10 10.0.5.50
+		option router 10.0.5.1
+
+
+		# Second pool
+		range 10.0.5.100 10.0.5.200
+		allow "phones"
+		host printer
+			fixed-address 10.0.5.5
+			hardware-address 12-34-56-AB-CD-EF
+		end
+	end
+	match circuit-id "ge-0/0/1.0"
+end
+network Building2
+	subnet 10.0.6.0/24
+		range 10.0.6.10 10.0.6.200
+	end
+	registered
+		subnet 10.0.7.0/24
+			range 10.0.7.10 10.0.7.200
+		end
+		option domain-name-server 10.0.0.2
+	end
+	authoritative
+end
+class "phones"
+	match vendor-class "Polycom"
+end
+# The end`
+
+	expected := `# Main configuration
+global
+	server-identifier 10.0.0.1
+	option domain-name "example.com"
+	registered
+		default-lease-time 86400
+	end
+end
+
+network Building1 # Short form
+	match circuit-id "ge-0/0/1.0"
+	unregistered
+		subnet 10.0.5.0/24
+			host printer
+				hardware-address 12:34:56:ab:cd:ef
+				fixed-address 10.0.5.5
+			end
+			pool
+				range 10.0.5.10 10.0.5.50
+				option router 10.0.5.1
+			end
+
+			# Second pool
+			pool
+				range 10.0.5.100 10.0.5.200
+				allow "phones"
+			end
+		end
+	end
+end
+
+network Building2
+	authoritative
+	unregistered
+		subnet 10.0.6.0/24
+			pool
+				range 10.0.6.10 10.0.6.200
+			end
+		end
+	end
+	registered
+		option domain-name-server 10.0.0.2
+		subnet 10.0.7.0/24
+			pool
+				range 10.0.7.10 10.0.7.200
+			end
+		end
+	end
+end
+
+class "phones"
+	match vendor-class "Polycom"
+end
+# The end
+`
+
+	formatted, _, err := formatConfig([]byte(conf), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(formatted) != expected {
+		t.Fatalf("Incorrect format, expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("./testdata/*.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		c, err := ParseFile(file)
+		if err != nil {
+			continue // Configurations for parse error tests
+		}
+
+		formatted, err := FormatFile(file)
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+		main := formatted[0].Formatted
+
+		c2, err := newParser(bufio.NewReader(bytes.NewReader(main)), file).parse()
+		if err != nil {
+			t.Errorf("%s: formatted config failed parsing: %s", file, err)
+			continue
+		}
+		clearPositions(c)
+		clearPositions(c2)
+		if !reflect.DeepEqual(c, c2) {
+			t.Errorf("%s: formatted config parsed differently:\n%s", file, main)
+		}
+
+		again, _, err := formatConfig(main, file)
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+		} else if !bytes.Equal(again, main) {
+			t.Errorf("%s: formatting isn't stable, first:\n%s\nsecond:\n%s", file, main, again)
+		}
+	}
+}
+
+func TestFormatIncludes(t *testing.T) {
+	files, err := FormatFile("./testdata/includeGlobConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expected the config and 2 included files, got %d", len(files))
+	}
+	if files[1].Path != "testdata/buildings/east.conf" || files[2].Path != "testdata/buildings/west.conf" {
+		t.Errorf("Incorrect included files %s and %s", files[1].Path, files[2].Path)
+	}
+}
+
+// clearPositions removes the parts of c that depend on where it was declared.
+func clearPositions(c *Config) {
+	c.file = ""
+	c.global.pos = position{}
+	clearSettingsPositions(c.global.settings, c.global.registeredSettings, c.global.unregisteredSettings)
+	for _, h := range c.global.hosts {
+		h.pos = position{}
+		clearSettingsPositions(h.settings)
+	}
+	for _, cl := range c.classes {
+		cl.pos = position{}
+	}
+	for _, n := range c.networks {
+		n.pos = position{}
+		clearSettingsPositions(n.settings, n.registeredSettings, n.unregisteredSettings)
+		for _, h := range n.hosts {
+			h.pos = position{}
+			clearSettingsPositions(h.settings)
+		}
+		for _, s := range n.subnets {
+			s.pos = position{}
+			clearSettingsPositions(s.settings)
+			for _, p := range s.pools {
+				p.pos = position{}
+				clearSettingsPositions(p.settings)
+			}
+		}
+		for _, s := range n.subnets6 {
+			s.pos = position{}
+			clearSettingsPositions(s.settings)
+			for _, p := range s.pools {
+				p.pos = position{}
+			}
+		}
+	}
+}
+
+func clearSettingsPositions(blocks ...*settings) {
+	for _, s := range blocks {
+		s.freeLeaseAfterPos = position{}
+	}
+}
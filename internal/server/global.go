@@ -10,12 +10,16 @@ import (
 )
 
 type global struct {
+	pos                  position
 	serverIdentifier     net.IP
+	authoritative        bool
+	trustedRelays        []net.IP // Relays allowed to select the client's subnet
 	settings             *settings
 	registeredSettings   *settings
 	regOptionsCached     bool
 	unregisteredSettings *settings
 	unregOptionsCached   bool
+	hosts                []*host
 }
 
 func newGlobal() *global {
@@ -73,6 +77,17 @@ func (g *global) getLeaseTime(req time.Duration, registered bool) time.Duration
 	return g.settings.maxLeaseTime
 }
 
+// trustsRelay returns if the relay at ip may select the client's subnet with the
+// subnet selection option or link selection sub-option.
+func (g *global) trustsRelay(ip net.IP) bool {
+	for _, r := range g.trustedRelays {
+		if r.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *global) getSettings(registered bool) *settings {
 	if registered && g.regOptionsCached {
 		return g.registeredSettings
@@ -0,0 +1,91 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+// A host is a static reservation of an IP address for a single hardware address.
+type host struct {
+	name          string
+	mac           net.HardwareAddr
+	fixedAddress  net.IP
+	settings      *settings
+	optionsCached bool
+	subnet        *subnet
+	lease         *models.Lease
+	pos           position
+}
+
+func newHost(name string) *host {
+	return &host{
+		name:     name,
+		settings: newSettingsBlock(),
+	}
+}
+
+func (h *host) registered() bool {
+	return !h.subnet.allowUnknown
+}
+
+// getLeaseTime returns the lease time given the requested time req.
+// If req is 0 then the default lease time is returned. Otherwise it will return the lower of
+// req and the maximum lease time. If the host does not have an explicitly set duration for either,
+// it will get the duration from its subnet.
+func (h *host) getLeaseTime(req time.Duration) time.Duration {
+	if req == 0 {
+		if h.settings.defaultLeaseTime > 0 {
+			return h.settings.defaultLeaseTime
+		}
+		return h.subnet.getLeaseTime(req, h.registered())
+	}
+
+	if h.settings.maxLeaseTime > 0 {
+		if req <= h.settings.maxLeaseTime {
+			return req
+		}
+		return h.settings.maxLeaseTime
+	}
+	return h.subnet.getLeaseTime(req, h.registered())
+}
+
+func (h *host) getOptions() dhcp4.Options {
+	if h.optionsCached {
+		return h.settings.options
+	}
+
+	higher := h.subnet.getOptions(h.registered())
+	for c, v := range higher {
+		if _, ok := h.settings.options[c]; !ok {
+			h.settings.options[c] = v
+		}
+	}
+	h.optionsCached = true
+	return h.settings.options
+}
+
+// scopes returns the host's settings followed by the merged settings of its subnet.
+func (h *host) scopes() []*settings {
+	return []*settings{h.settings, h.subnet.getSettings(h.registered())}
+}
+
+// getLease returns the lease object for the reserved address. The lease is
+// created if it doesn't exist yet.
+func (h *host) getLease() *models.Lease {
+	if h.lease == nil {
+		l := models.NewLease()
+		l.IP = h.fixedAddress
+		l.MAC = h.mac
+		l.Network = h.subnet.network.name
+		l.Registered = h.registered()
+		h.lease = l
+	}
+	return h.lease
+}
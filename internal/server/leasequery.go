@@ -0,0 +1,447 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+// bulkIdleTimeout is how long a bulk leasequery connection may wait between queries.
+const bulkIdleTimeout = 2 * time.Minute
+
+// maxAssociatedIPs is the number of addresses that fit in option 92.
+const maxAssociatedIPs = 255 / 4
+
+var errMalformedLeaseQuery = errors.New("query has no address, client identifier or hardware address")
+
+// LeaseQueryConfig controls leasequery, RFC 4388, and bulk leasequery, RFC 6926.
+type LeaseQueryConfig struct {
+	AllowedIPs  []net.IP // Requestors allowed to query, queries from others are dropped
+	BulkAddress string   // TCP address to listen on for bulk leasequery, empty disables it
+}
+
+// allowed returns if ip may send leasequeries.
+func (c *LeaseQueryConfig) allowed(ip net.IP) bool {
+	if c == nil {
+		return false
+	}
+	for _, a := range c.AllowedIPs {
+		if a.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// A leaseQuery is what a leasequery message asks about. Only one field
+// is set, a bulk query without any asks for every lease.
+type leaseQuery struct {
+	ip       net.IP
+	clientID []byte
+	mac      net.HardwareAddr
+	remoteID []byte // Bulk only
+	relayID  []byte // Bulk only
+
+	// Bulk only, leases whose state changed outside the range are skipped
+	startTime time.Time
+	endTime   time.Time
+}
+
+// newLeaseQuery reads the query of a leasequery message. An address in ciaddr
+// takes precedence, then the client identifier and then the hardware address.
+// bulk allows the queries that are only defined for bulk leasequery.
+func newLeaseQuery(p dhcp4.Packet, options dhcp4.Options, bulk bool) (*leaseQuery, error) {
+	q := &leaseQuery{}
+	if bulk {
+		q.startTime, _ = dhcp4.ParseOptionsTime(options[dhcp4.OptionQueryStartTime])
+		q.endTime, _ = dhcp4.ParseOptionsTime(options[dhcp4.OptionQueryEndTime])
+	}
+
+	if ip := p.CIAddr(); !ip.Equal(net.IPv4zero) {
+		q.ip = append(net.IP(nil), ip.To4()...)
+		return q, nil
+	}
+	if id, ok := options[dhcp4.OptionClientIdentifier]; ok && len(id) > 0 {
+		q.clientID = append([]byte(nil), id...)
+		return q, nil
+	}
+	if mac := p.CHAddr(); p.HLen() > 0 && !bytes.Equal(mac, make([]byte, len(mac))) {
+		q.mac = append(net.HardwareAddr(nil), mac...)
+		return q, nil
+	}
+	if !bulk {
+		return nil, errMalformedLeaseQuery
+	}
+
+	if data, ok := options[dhcp4.OptionRelayAgentInformation]; ok {
+		relay, err := dhcp4.ParseRelayAgentInformation(data)
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := relay.SubOptions[dhcp4.RelayRelayID]; ok {
+			q.relayID = id
+			return q, nil
+		}
+		if len(relay.RemoteID) > 0 {
+			q.remoteID = relay.RemoteID
+			return q, nil
+		}
+	}
+	return q, nil // Every lease
+}
+
+// matches returns if lease l is one the query asks about.
+func (q *leaseQuery) matches(l *models.Lease) bool {
+	switch {
+	case q.ip != nil:
+		return q.ip.Equal(l.IP)
+	case q.clientID != nil:
+		return bytes.Equal(q.clientID, l.ClientID)
+	case q.mac != nil:
+		return bytes.Equal(q.mac, l.MAC)
+	case q.remoteID != nil:
+		return bytes.Equal(q.remoteID, l.Relay.RemoteID)
+	}
+	return true
+}
+
+// inTimeRange returns if a state change at t is within the query's time range.
+func (q *leaseQuery) inTimeRange(t time.Time) bool {
+	if !q.startTime.IsZero() && t.Before(q.startTime) {
+		return false
+	}
+	return q.endTime.IsZero() || !t.After(q.endTime)
+}
+
+// isBinding returns if l is an IPv4 lease that was acknowledged to a client.
+func isBinding(l *models.Lease) bool {
+	return !l.IsIPv6() && l.MAC != nil && !l.Offered && !l.End.IsZero()
+}
+
+// isActiveBinding returns if l is a binding the client may still be using.
+func isActiveBinding(l *models.Lease, now time.Time) bool {
+	return isBinding(l) && !l.IsAbandoned && l.End.After(now)
+}
+
+// leaseState returns the bulk leasequery state of binding l and when it began.
+func leaseState(l *models.Lease, now time.Time) (byte, time.Time) {
+	switch {
+	case l.IsAbandoned:
+		return dhcp4.StateAbandoned, l.AbandonedAt
+	case l.End.After(now):
+		return dhcp4.StateActive, l.Start
+	}
+	return dhcp4.StateExpired, l.End
+}
+
+// queryLeaseByIP returns a copy of the binding of ip, if any, and if the address
+// is in one of the server's pools or reserved by a host.
+func (h *Handler) queryLeaseByIP(ip net.IP) (*models.Lease, bool) {
+	network := h.conf.searchNetworksFor(ip)
+	if network == nil {
+		return nil, false
+	}
+	network.Lock()
+	defer network.Unlock()
+
+	configured := network.getPoolOfIP(ip) != nil || network.isReserved(ip)
+	if l := network.findLease(ip); l != nil && isBinding(l) {
+		lease := *l
+		return &lease, configured
+	}
+	return nil, configured
+}
+
+// queryLeases returns copies of the bindings matching q, most recent first.
+func (h *Handler) queryLeases(q *leaseQuery) []*models.Lease {
+	var leases []*models.Lease
+	for _, n := range h.conf.networks {
+		n.Lock()
+		for _, l := range n.getAllLeases() {
+			if isBinding(l) && q.matches(l) {
+				lease := *l
+				leases = append(leases, &lease)
+			}
+		}
+		n.Unlock()
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].Start.After(leases[j].Start)
+	})
+	return leases
+}
+
+// leaseQueryOptions returns the options describing binding l. The client
+// identifier, hostname and relay information are sent if they were requested
+// in prl or prl is empty.
+func leaseQueryOptions(l *models.Lease, prl []byte, now time.Time) []dhcp4.Option {
+	requested := func(code dhcp4.OptionCode) bool {
+		return len(prl) == 0 || bytes.IndexByte(prl, byte(code)) > -1
+	}
+
+	var opts []dhcp4.Option
+	if l.IsInfinite() {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionIPAddressLeaseTime, Value: []byte{0xff, 0xff, 0xff, 0xff}})
+	} else if l.End.After(now) {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionIPAddressLeaseTime, Value: dhcp4.OptionsLeaseTime(l.End.Sub(now))})
+	}
+	if since := now.Sub(l.Start); since >= 0 {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionClientLastTransactionTime, Value: dhcp4.OptionsLeaseTime(since)})
+	}
+	if len(l.ClientID) > 0 && requested(dhcp4.OptionClientIdentifier) {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionClientIdentifier, Value: l.ClientID})
+	}
+	if l.Hostname != "" && requested(dhcp4.OptionHostName) {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionHostName, Value: []byte(l.Hostname)})
+	}
+	if relay := encodeRelayInfo(l.Relay); relay != nil && requested(dhcp4.OptionRelayAgentInformation) {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionRelayAgentInformation, Value: relay})
+	}
+	return opts
+}
+
+// leaseQueryReply creates a reply to the leasequery req. The client address and
+// hardware address are those of l if it isn't nil, otherwise ip and the request's.
+func (h *Handler) leaseQueryReply(req dhcp4.Packet, mt dhcp4.MessageType, ip net.IP, l *models.Lease, options []dhcp4.Option) dhcp4.Packet {
+	p := dhcp4.NewPacket(dhcp4.BootReply)
+	p.SetXId(req.XId())
+	p.SetGIAddr(req.GIAddr())
+	if l != nil {
+		ip = l.IP
+		p.SetCHAddr(l.MAC) // NewPacket sets the Ethernet hardware type
+	} else {
+		p.SetHType(req.HType())
+		p.SetCHAddr(req.CHAddr())
+	}
+	if ip != nil {
+		p.SetCIAddr(ip)
+	}
+	p.AddOption(dhcp4.OptionDHCPMessageType, []byte{byte(mt)})
+	p.AddOption(dhcp4.OptionServerIdentifier, []byte(h.conf.global.serverIdentifier.To4()))
+	for _, o := range options {
+		p.AddOption(o.Code, o.Value)
+	}
+	p.PadToMinSize()
+	return p
+}
+
+// handleLeaseQuery answers a DHCPLEASEQUERY, RFC 4388.
+func (h *Handler) handleLeaseQuery(p dhcp4.Packet, options dhcp4.Options) dhcp4.Packet {
+	requestor := p.GIAddr()
+	if requestor.Equal(net.IPv4zero) {
+		return nil // Replies are sent to giaddr, the requestor's address
+	}
+	if !h.c.LeaseQuery.allowed(requestor) {
+		h.c.Log.WithField("requestor", requestor.String()).Notice("Leasequery from unauthorized requestor")
+		return nil
+	}
+
+	q, err := newLeaseQuery(p, options, false)
+	if err != nil {
+		h.c.Log.WithFields(verbose.Fields{
+			"requestor": requestor.String(),
+			"error":     err,
+		}).Info("Malformed leasequery")
+		return nil
+	}
+
+	now := time.Now()
+	prl := options[dhcp4.OptionParameterRequestList]
+	var (
+		result dhcp4.MessageType
+		lease  *models.Lease
+		opts   []dhcp4.Option
+	)
+	if q.ip != nil {
+		l, configured := h.queryLeaseByIP(q.ip)
+		switch {
+		case l != nil && isActiveBinding(l, now):
+			result, lease, opts = dhcp4.LeaseActive, l, leaseQueryOptions(l, prl, now)
+		case configured:
+			result = dhcp4.LeaseUnassigned
+		default:
+			result = dhcp4.LeaseUnknown
+		}
+	} else {
+		var active []*models.Lease
+		leases := h.queryLeases(q)
+		for _, l := range leases {
+			if isActiveBinding(l, now) {
+				active = append(active, l)
+			}
+		}
+
+		switch {
+		case len(active) > 0:
+			// The most recent binding is returned, with every bound address if there are more
+			result, lease, opts = dhcp4.LeaseActive, active[0], leaseQueryOptions(active[0], prl, now)
+			if len(active) > 1 {
+				var ips []byte
+				for i := 0; i < len(active) && i < maxAssociatedIPs; i++ {
+					ips = append(ips, active[i].IP.To4()...)
+				}
+				opts = append(opts, dhcp4.Option{Code: dhcp4.OptionAssociatedIP, Value: ips})
+			}
+		case len(leases) > 0:
+			result = dhcp4.LeaseUnassigned
+		default:
+			result = dhcp4.LeaseUnknown
+		}
+	}
+
+	reply := h.leaseQueryReply(p, result, q.ip, lease, opts)
+	h.c.Log.WithFields(verbose.Fields{
+		"requestor": requestor.String(),
+		"ip":        reply.CIAddr().String(),
+		"mac":       reply.CHAddr().String(),
+		"result":    result.String(),
+	}).Debug("Answered leasequery")
+	return reply
+}
+
+// listenBulkLeaseQuery starts accepting bulk leasequery connections if it's enabled.
+func (h *Handler) listenBulkLeaseQuery() error {
+	if h.c.LeaseQuery == nil || h.c.LeaseQuery.BulkAddress == "" {
+		return nil
+	}
+	l, err := net.Listen("tcp", h.c.LeaseQuery.BulkAddress)
+	if err != nil {
+		return err
+	}
+	h.bulkListener = l
+	h.c.Log.Infof("Bulk leasequery listening on %s", l.Addr())
+	go h.serveBulkLeaseQuery(l)
+	return nil
+}
+
+// serveBulkLeaseQuery accepts bulk leasequery connections until l is closed.
+func (h *Handler) serveBulkLeaseQuery(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go h.handleBulkConn(conn)
+	}
+}
+
+// handleBulkConn answers the queries sent over a bulk leasequery connection
+// until the requestor closes it or stays idle too long.
+func (h *Handler) handleBulkConn(conn net.Conn) {
+	defer conn.Close()
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if !h.c.LeaseQuery.allowed(net.ParseIP(host)) {
+		h.c.Log.WithField("requestor", host).Notice("Bulk leasequery from unauthorized requestor")
+		return
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(bulkIdleTimeout))
+		p, err := dhcp4.ReadStreamPacket(conn)
+		if err != nil {
+			if err != io.EOF {
+				h.c.Log.WithFields(verbose.Fields{
+					"requestor": host,
+					"error":     err,
+				}).Debug("Closing bulk leasequery connection")
+			}
+			return
+		}
+		if err := h.answerBulkLeaseQuery(conn, p); err != nil {
+			h.c.Log.WithFields(verbose.Fields{
+				"requestor": host,
+				"error":     err,
+			}).Error("Error sending bulk leasequery reply")
+			return
+		}
+	}
+}
+
+// answerBulkLeaseQuery writes the replies to a DHCPBULKLEASEQUERY to w. Every
+// binding matching the query is sent followed by a DHCPLEASEQUERYDONE.
+func (h *Handler) answerBulkLeaseQuery(w io.Writer, p dhcp4.Packet) error {
+	for _, reply := range h.bulkLeaseQueryReplies(p) {
+		if err := dhcp4.WriteStreamPacket(w, reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkLeaseQueryReplies returns the replies to bulk leasequery p. They're built
+// before any are sent so a slow requestor doesn't hold up a reload.
+func (h *Handler) bulkLeaseQueryReplies(p dhcp4.Packet) []dhcp4.Packet {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	var replies []dhcp4.Packet
+	options := p.ParseOptions()
+	done := func(status byte, message string) []dhcp4.Packet {
+		return append(replies, h.leaseQueryReply(p, dhcp4.LeaseQueryDone, nil, nil, []dhcp4.Option{
+			{Code: dhcp4.OptionStatusCode, Value: dhcp4.EncodeStatusCode(status, message)},
+		}))
+	}
+
+	if mt := options[dhcp4.OptionDHCPMessageType]; len(mt) != 1 || dhcp4.MessageType(mt[0]) != dhcp4.BulkLeaseQuery {
+		return done(dhcp4.StatusMalformedQuery, "Expected DHCPBULKLEASEQUERY")
+	}
+	q, err := newLeaseQuery(p, options, true)
+	if err != nil {
+		return done(dhcp4.StatusMalformedQuery, err.Error())
+	}
+	if q.relayID != nil {
+		return done(dhcp4.StatusNotAllowed, "Query by relay identifier is not supported")
+	}
+
+	now := time.Now()
+	prl := options[dhcp4.OptionParameterRequestList]
+	send := func(l *models.Lease) {
+		state, since := leaseState(l, now)
+		if !q.inTimeRange(since) {
+			return
+		}
+		mt := dhcp4.LeaseUnassigned
+		if state == dhcp4.StateActive {
+			mt = dhcp4.LeaseActive
+		}
+		opts := append(leaseQueryOptions(l, prl, now),
+			dhcp4.Option{Code: dhcp4.OptionBaseTime, Value: dhcp4.OptionsTime(now)},
+			dhcp4.Option{Code: dhcp4.OptionDHCPState, Value: []byte{state}},
+			dhcp4.Option{Code: dhcp4.OptionStartTimeOfState, Value: dhcp4.OptionsLeaseTime(now.Sub(since))},
+		)
+		replies = append(replies, h.leaseQueryReply(p, mt, nil, l, opts))
+	}
+
+	if q.ip != nil {
+		l, configured := h.queryLeaseByIP(q.ip)
+		switch {
+		case l != nil:
+			send(l)
+		case configured:
+			replies = append(replies, h.leaseQueryReply(p, dhcp4.LeaseUnassigned, q.ip, nil, []dhcp4.Option{
+				{Code: dhcp4.OptionBaseTime, Value: dhcp4.OptionsTime(now)},
+				{Code: dhcp4.OptionDHCPState, Value: []byte{dhcp4.StateAvailable}},
+			}))
+		default:
+			replies = append(replies, h.leaseQueryReply(p, dhcp4.LeaseUnknown, q.ip, nil, nil))
+		}
+		return done(dhcp4.StatusSuccess, "")
+	}
+
+	for _, l := range h.queryLeases(q) {
+		send(l)
+	}
+	return done(dhcp4.StatusSuccess, "")
+}
@@ -0,0 +1,266 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+// setUpLeaseQuery returns a server with a lease for mac acquired through a relay
+// with relay agent information and client identifier clientID.
+func setUpLeaseQuery(t *testing.T, mac net.HardwareAddr, clientID string) (*Handler, net.IP) {
+	server := setUpTest1(t)
+	server.c.LeaseQuery = &LeaseQueryConfig{AllowedIPs: []net.IP{net.ParseIP("10.0.1.1")}}
+
+	relay := []byte{1, 3, 'g', 'i', '1', 2, 2, 0xab, 0xcd}
+	opts := []d4.Option{
+		{Code: d4.OptionClientIdentifier, Value: []byte(clientID)},
+		{Code: d4.OptionRelayAgentInformation, Value: relay},
+	}
+	p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+	if dp == nil {
+		t.Fatal("Processed packet is nil")
+	}
+
+	opts = append(opts, d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte(dp.YIAddr().To4())})
+	p = d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+	return server, dp.YIAddr().To4()
+}
+
+func TestLeaseQuery(t *testing.T) {
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	server, leased := setUpLeaseQuery(t, mac, "\x01client-a")
+	defer tearDownTest1(server)
+
+	query := func(requestor, ciaddr net.IP, chaddr net.HardwareAddr, opts []d4.Option) d4.Packet {
+		p := d4.RequestPacket(d4.LeaseQuery, chaddr, ciaddr, []byte{1, 2, 3, 4}, false, opts)
+		if requestor != nil {
+			p.SetGIAddr(requestor)
+		}
+		return server.ServeDHCP(p, d4.LeaseQuery, p.ParseOptions())
+	}
+	router := net.ParseIP("10.0.1.1")
+	messageType := func(mt d4.MessageType) d4.Options {
+		return d4.Options{d4.OptionDHCPMessageType: []byte{byte(mt)}}
+	}
+
+	// By IP address
+	rp := query(router, leased, nil, nil)
+	opts := checkOptions(rp, d4.Options{
+		d4.OptionDHCPMessageType:       []byte{byte(d4.LeaseActive)},
+		d4.OptionClientIdentifier:      []byte("\x01client-a"),
+		d4.OptionRelayAgentInformation: []byte{1, 3, 'g', 'i', '1', 2, 2, 0xab, 0xcd},
+	}, t)
+	if !rp.CIAddr().Equal(leased) || !bytes.Equal(rp.CHAddr(), mac) {
+		t.Errorf("Expected lease %s for %s, got %s for %s", leased, mac, rp.CIAddr(), rp.CHAddr())
+	}
+	if !bytes.Equal(rp.XId(), []byte{1, 2, 3, 4}) {
+		t.Error("Transaction ID not copied")
+	}
+	if !rp.GIAddr().Equal(router) {
+		t.Error("Reply not addressed to the requestor")
+	}
+	if d := requestedLeaseTime(opts); d <= 0 || d > 86400*time.Second {
+		t.Errorf("Incorrect remaining lease time %s", d)
+	}
+	if _, ok := opts[d4.OptionClientLastTransactionTime]; !ok {
+		t.Error("Client last transaction time not received")
+	}
+
+	// Only requested options are sent
+	rp = query(router, leased, nil, []d4.Option{{Code: d4.OptionParameterRequestList, Value: []byte{82}}})
+	opts = checkOptions(rp, messageType(d4.LeaseActive), t)
+	if _, ok := opts[d4.OptionClientIdentifier]; ok {
+		t.Error("Client identifier sent without being requested")
+	}
+	if _, ok := opts[d4.OptionRelayAgentInformation]; !ok {
+		t.Error("Requested relay agent information not sent")
+	}
+
+	// Address in a pool without a lease, and an address that isn't ours
+	rp = query(router, net.IP{10, 0, 1, 150}, nil, nil)
+	checkOptions(rp, messageType(d4.LeaseUnassigned), t)
+	if !rp.CIAddr().Equal(net.IP{10, 0, 1, 150}) {
+		t.Errorf("Expected queried address in reply, got %s", rp.CIAddr())
+	}
+	checkOptions(query(router, net.IP{192, 168, 1, 10}, nil, nil), messageType(d4.LeaseUnknown), t)
+
+	// By hardware address and client identifier
+	rp = query(router, nil, mac, nil)
+	checkOptions(rp, messageType(d4.LeaseActive), t)
+	if !rp.CIAddr().Equal(leased) {
+		t.Errorf("Expected lease %s, got %s", leased, rp.CIAddr())
+	}
+	other, _ := net.ParseMAC("12:34:56:00:00:99")
+	checkOptions(query(router, nil, other, nil), messageType(d4.LeaseUnknown), t)
+
+	rp = query(router, nil, other, []d4.Option{{Code: d4.OptionClientIdentifier, Value: []byte("\x01client-a")}})
+	checkOptions(rp, messageType(d4.LeaseActive), t)
+	if !bytes.Equal(rp.CHAddr(), mac) {
+		t.Errorf("Expected lease of %s, got %s", mac, rp.CHAddr())
+	}
+
+	// Queries without a requestor, from unknown requestors and without a query are dropped
+	if query(nil, leased, nil, nil) != nil {
+		t.Error("Expected no reply to leasequery without giaddr")
+	}
+	if query(net.ParseIP("10.0.1.2"), leased, nil, nil) != nil {
+		t.Error("Expected no reply to leasequery from unauthorized requestor")
+	}
+	if query(router, nil, nil, nil) != nil {
+		t.Error("Expected no reply to malformed leasequery")
+	}
+}
+
+// bulkLeaseQuery sends the query to the server and returns the replies.
+func bulkLeaseQuery(t *testing.T, server *Handler, p d4.Packet) []d4.Packet {
+	var buf bytes.Buffer
+	if err := server.answerBulkLeaseQuery(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	var replies []d4.Packet
+	for buf.Len() > 0 {
+		r, err := d4.ReadStreamPacket(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, r)
+	}
+	return replies
+}
+
+// checkBulkReplies checks the message types of replies and returns their options.
+func checkBulkReplies(replies []d4.Packet, expected []d4.MessageType, t *testing.T) []d4.Options {
+	if len(replies) != len(expected) {
+		t.Fatalf("Expected %d replies, got %d", len(expected), len(replies))
+	}
+	var options []d4.Options
+	for i, r := range replies {
+		options = append(options, checkOptions(r, d4.Options{d4.OptionDHCPMessageType: []byte{byte(expected[i])}}, t))
+	}
+	return options
+}
+
+func TestBulkLeaseQuery(t *testing.T) {
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	server, leased := setUpLeaseQuery(t, mac, "\x01client-a")
+	defer tearDownTest1(server)
+
+	done := []byte{d4.StatusSuccess}
+
+	// Every lease
+	p := d4.RequestPacket(d4.BulkLeaseQuery, nil, nil, []byte{9, 9, 9, 9}, false, nil)
+	replies := bulkLeaseQuery(t, server, p)
+	opts := checkBulkReplies(replies, []d4.MessageType{d4.LeaseActive, d4.LeaseQueryDone}, t)
+	if !replies[0].CIAddr().Equal(leased) || !bytes.Equal(replies[0].XId(), []byte{9, 9, 9, 9}) {
+		t.Errorf("Incorrect reply for lease %s", leased)
+	}
+	if !bytes.Equal(opts[0][d4.OptionDHCPState], []byte{d4.StateActive}) {
+		t.Errorf("Expected active state, got %v", opts[0][d4.OptionDHCPState])
+	}
+	for _, code := range []d4.OptionCode{d4.OptionBaseTime, d4.OptionStartTimeOfState} {
+		if _, ok := opts[0][code]; !ok {
+			t.Errorf("%s not received", code)
+		}
+	}
+	if !bytes.Equal(opts[1][d4.OptionStatusCode], done) {
+		t.Errorf("Expected success, got %v", opts[1][d4.OptionStatusCode])
+	}
+
+	// By remote ID
+	query := func(opts []d4.Option) []d4.Packet {
+		return bulkLeaseQuery(t, server, d4.RequestPacket(d4.BulkLeaseQuery, nil, nil, nil, false, opts))
+	}
+	remoteID := func(id byte) []d4.Option {
+		return []d4.Option{{Code: d4.OptionRelayAgentInformation, Value: []byte{2, 2, 0xab, id}}}
+	}
+	checkBulkReplies(query(remoteID(0xcd)), []d4.MessageType{d4.LeaseActive, d4.LeaseQueryDone}, t)
+	checkBulkReplies(query(remoteID(0xef)), []d4.MessageType{d4.LeaseQueryDone}, t)
+
+	// Leases that changed state after the query end time are skipped
+	end := []d4.Option{{Code: d4.OptionQueryEndTime, Value: d4.OptionsTime(time.Now().Add(-time.Hour))}}
+	checkBulkReplies(query(end), []d4.MessageType{d4.LeaseQueryDone}, t)
+
+	// By address
+	p = d4.RequestPacket(d4.BulkLeaseQuery, nil, net.IP{10, 0, 1, 150}, nil, false, nil)
+	opts = checkBulkReplies(bulkLeaseQuery(t, server, p), []d4.MessageType{d4.LeaseUnassigned, d4.LeaseQueryDone}, t)
+	if !bytes.Equal(opts[0][d4.OptionDHCPState], []byte{d4.StateAvailable}) {
+		t.Errorf("Expected available state, got %v", opts[0][d4.OptionDHCPState])
+	}
+
+	// Unsupported queries
+	opts = checkBulkReplies(query([]d4.Option{
+		{Code: d4.OptionRelayAgentInformation, Value: []byte{12, 2, 1, 2}},
+	}), []d4.MessageType{d4.LeaseQueryDone}, t)
+	if code := opts[0][d4.OptionStatusCode]; len(code) == 0 || code[0] != d4.StatusNotAllowed {
+		t.Errorf("Expected not allowed status, got %v", code)
+	}
+
+	p = d4.RequestPacket(d4.LeaseQuery, nil, nil, nil, false, nil)
+	opts = checkBulkReplies(bulkLeaseQuery(t, server, p), []d4.MessageType{d4.LeaseQueryDone}, t)
+	if code := opts[0][d4.OptionStatusCode]; len(code) == 0 || code[0] != d4.StatusMalformedQuery {
+		t.Errorf("Expected malformed query status, got %v", code)
+	}
+}
+
+func TestBulkLeaseQueryConnection(t *testing.T) {
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	server, leased := setUpLeaseQuery(t, mac, "\x01client-a")
+	defer tearDownTest1(server)
+
+	// Connections from requestors that aren't allowed are closed
+	client, conn := net.Pipe()
+	go server.handleBulkConn(conn)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := d4.ReadStreamPacket(client); err == nil {
+		t.Error("Expected unauthorized connection to be closed")
+	}
+	client.Close()
+
+	server.c.LeaseQuery.AllowedIPs = append(server.c.LeaseQuery.AllowedIPs, net.ParseIP("127.0.0.1"))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go server.serveBulkLeaseQuery(l)
+
+	conn, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Several queries can be sent over one connection
+	for i := 0; i < 2; i++ {
+		p := d4.RequestPacket(d4.BulkLeaseQuery, mac, nil, []byte{0, 0, 0, byte(i)}, false, nil)
+		if err := d4.WriteStreamPacket(conn, p); err != nil {
+			t.Fatal(err)
+		}
+		var replies []d4.Packet
+		for _, mt := range []d4.MessageType{d4.LeaseActive, d4.LeaseQueryDone} {
+			r, err := d4.ReadStreamPacket(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replies = append(replies, r)
+			checkOptions(r, d4.Options{d4.OptionDHCPMessageType: []byte{byte(mt)}}, t)
+		}
+		if !replies[0].CIAddr().Equal(leased) || replies[1].XId()[3] != byte(i) {
+			t.Errorf("Query %d: incorrect replies", i)
+		}
+	}
+}
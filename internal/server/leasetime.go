@@ -0,0 +1,56 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+// requestedLeaseTime returns the lease time the client asked for in option 51,
+// or 0 if it didn't ask for one.
+func requestedLeaseTime(options dhcp4.Options) time.Duration {
+	data := options[dhcp4.OptionIPAddressLeaseTime]
+	if len(data) != 4 {
+		return 0
+	}
+	return time.Duration(binary.BigEndian.Uint32(data)) * time.Second
+}
+
+// grantLeaseTime returns the lease time for a client that requested req using
+// getLeaseTime of the client's pool or host. Requests are honored up to the
+// maximum lease time. When no maximum is configured, requests can shorten the
+// default lease time but not extend it.
+func grantLeaseTime(getLeaseTime func(time.Duration) time.Duration, req time.Duration) time.Duration {
+	def := getLeaseTime(0)
+	if req == 0 {
+		return def
+	}
+	if d := getLeaseTime(req); d > 0 {
+		return d
+	}
+	if req < def {
+		return req
+	}
+	return def
+}
+
+// renewalOptions returns the renewal (58) and rebinding (59) time options for
+// a lease of duration d unless they're set explicitly in leaseOptions.
+func renewalOptions(leaseOptions dhcp4.Options, d, t1, t2 time.Duration) []dhcp4.Option {
+	if d <= 0 {
+		return nil
+	}
+	var opts []dhcp4.Option
+	if _, ok := leaseOptions[dhcp4.OptionRenewalTimeValue]; !ok {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionRenewalTimeValue, Value: dhcp4.OptionsLeaseTime(t1)})
+	}
+	if _, ok := leaseOptions[dhcp4.OptionRebindingTimeValue]; !ok {
+		opts = append(opts, dhcp4.Option{Code: dhcp4.OptionRebindingTimeValue, Value: dhcp4.OptionsLeaseTime(t2)})
+	}
+	return opts
+}
@@ -0,0 +1,98 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+func TestRequestedLeaseTime(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/leaseTimeConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+	mac, _ := net.ParseMAC("12:34:56:00:00:01")
+
+	// Acquire a lease requesting req, returns the ACK
+	acquire := func(relay net.IP, req time.Duration) d4.Packet {
+		var opts []d4.Option
+		if req > 0 {
+			opts = append(opts, d4.Option{Code: d4.OptionIPAddressLeaseTime, Value: d4.OptionsLeaseTime(req)})
+		}
+		p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+
+		opts = append(opts, d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte(dp.YIAddr().To4())})
+		p = d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		return server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	}
+	checkTimes := func(p d4.Packet, lease, t1, t2 time.Duration) {
+		checkOptions(p, d4.Options{
+			d4.OptionDHCPMessageType:    []byte{byte(d4.ACK)},
+			d4.OptionIPAddressLeaseTime: d4.OptionsLeaseTime(lease),
+			d4.OptionRenewalTimeValue:   d4.OptionsLeaseTime(t1),
+			d4.OptionRebindingTimeValue: d4.OptionsLeaseTime(t2),
+		}, t)
+	}
+
+	relay := net.ParseIP("10.0.1.5")
+	checkTimes(acquire(relay, 0), 1800*time.Second, 450*time.Second, 1575*time.Second)
+	checkTimes(acquire(relay, 600*time.Second), 600*time.Second, 150*time.Second, 525*time.Second)
+	checkTimes(acquire(relay, 24*time.Hour), 3600*time.Second, 900*time.Second, 3150*time.Second)
+
+	// Explicit renewal time option is kept
+	mac, _ = net.ParseMAC("12:34:56:00:00:02")
+	rp := acquire(net.ParseIP("10.0.2.5"), 0)
+	opts := rp.ParseOptions()
+	if !bytes.Equal(opts[d4.OptionRenewalTimeValue], []byte{0, 0, 0, 100}) {
+		t.Errorf("Expected configured renewal time, got %v", opts[d4.OptionRenewalTimeValue])
+	}
+	if !bytes.Equal(opts[d4.OptionRebindingTimeValue], d4.OptionsLeaseTime(1575*time.Second)) {
+		t.Errorf("Expected computed rebinding time, got %v", opts[d4.OptionRebindingTimeValue])
+	}
+}
+
+func TestGrantLeaseTime(t *testing.T) {
+	// No maximum configured
+	noMax := func(req time.Duration) time.Duration {
+		if req == 0 {
+			return 100
+		}
+		return 0
+	}
+	if d := grantLeaseTime(noMax, 50); d != 50 {
+		t.Errorf("Expected shorter request to be honored, got %d", d)
+	}
+	if d := grantLeaseTime(noMax, 500); d != 100 {
+		t.Errorf("Expected longer request to get the default, got %d", d)
+	}
+
+	if d := requestedLeaseTime(d4.Options{d4.OptionIPAddressLeaseTime: []byte{0, 0, 1}}); d != 0 {
+		t.Errorf("Expected invalid lease time to be ignored, got %s", d)
+	}
+}
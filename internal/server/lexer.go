@@ -9,40 +9,76 @@ import (
 	"bytes"
 	"net"
 	"strconv"
+	"strings"
 	"unicode"
 )
 
 type lexer struct {
+	src      *lexSource
 	line     int
+	col      int // Bytes read from the current line
 	r        *bufio.Reader
 	buffer   []*lexToken
 	prev     *lexToken
 	readPrev bool
-	readers  []*bufio.Reader
+	readers  []*lexReader
 }
 
-func newLexer(r *bufio.Reader) *lexer {
+// lexSource is a file read by the lexer.
+type lexSource struct {
+	file    string
+	include *position // Include statement that read the file, nil for the main file
+}
+
+// lexReader is a reader suspended by an include.
+type lexReader struct {
+	r         *bufio.Reader
+	src       *lexSource
+	line, col int
+}
+
+func newLexer(r *bufio.Reader, file string) *lexer {
 	return &lexer{
 		r:       r,
+		src:     &lexSource{file: file},
 		line:    1,
-		readers: make([]*bufio.Reader, 0),
+		readers: make([]*lexReader, 0),
 	}
 }
 
-func (l *lexer) pushReader(r *bufio.Reader) {
-	l.readers = append(l.readers, l.r)
+// pushReader reads r until its end before continuing with the current reader.
+// include is the position of the include statement that opened file.
+func (l *lexer) pushReader(r *bufio.Reader, file string, include position) {
+	l.readers = append(l.readers, &lexReader{r: l.r, src: l.src, line: l.line, col: l.col})
 	l.r = r
+	l.src = &lexSource{file: file, include: &include}
+	l.line = 1
+	l.col = 0
 }
 
 func (l *lexer) popReader() bool {
 	if len(l.readers) == 0 {
 		return false
 	}
-	l.r = l.readers[len(l.readers)-1]
+	prev := l.readers[len(l.readers)-1]
+	l.r, l.src, l.line, l.col = prev.r, prev.src, prev.line, prev.col
 	l.readers = l.readers[0 : len(l.readers)-1]
 	return true
 }
 
+func (l *lexer) readByte() (byte, error) {
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.col++
+	}
+	return b, err
+}
+
+func (l *lexer) unreadByte() {
+	l.r.UnreadByte()
+	l.col--
+}
+
 // This function will make the lexer reread the previous token. This can
 // only be used to reread one token.
 func (l *lexer) unread() {
@@ -87,26 +123,29 @@ func (l *lexer) next() *lexToken {
 	}
 
 	var tok []*lexToken // Some consumes produce multiple tokens
+	var col int
 
 	for {
-		c, err := l.r.ReadByte()
+		c, err := l.readByte()
 		if err != nil {
 			if l.popReader() {
 				continue
 			}
-			return &lexToken{token: EOF}
+			return &lexToken{token: EOF, src: l.src, line: l.line, col: l.col + 1}
 		}
+		col = l.col
 
 		if c == '"' {
 			tok = l.consumeString() // Start after double quote
 			break
-		} else if isNumber(c) || c == '-' {
-			l.r.UnreadByte()
+		} else if isNumber(c) || c == '-' || c == ':' {
+			l.unreadByte()
 			tok = l.consumeNumeric()
 			break
 		} else if c == '\n' {
+			tok = []*lexToken{&lexToken{token: EOL, src: l.src, line: l.line, col: col}}
 			l.line++
-			tok = []*lexToken{&lexToken{token: EOL}}
+			l.col = 0
 			break
 		} else if c == '#' {
 			line := l.consumeLine()
@@ -118,15 +157,19 @@ func (l *lexer) next() *lexToken {
 			}
 			break
 		} else if isLetter(c) {
-			l.r.UnreadByte()
+			l.unreadByte()
 			tok = l.consumeIdent()
 			break
 		}
 	}
 
-	// Ensure all produced tokens have a line number
+	// Ensure all produced tokens have a position, EOL already has the line it ends
 	for _, t := range tok {
-		t.line = l.line
+		if t.src == nil {
+			t.src = l.src
+			t.line = l.line
+			t.col = col
+		}
 	}
 
 	// This function only returns one token, if more were created,
@@ -142,7 +185,7 @@ func (l *lexer) next() *lexToken {
 func (l *lexer) consumeString() []*lexToken {
 	buf := bytes.Buffer{}
 	for {
-		b, err := l.r.ReadByte()
+		b, err := l.readByte()
 		if err != nil {
 			return nil
 		}
@@ -157,12 +200,12 @@ func (l *lexer) consumeString() []*lexToken {
 func (l *lexer) consumeLine() []byte {
 	buf := bytes.Buffer{}
 	for {
-		b, err := l.r.ReadByte()
+		b, err := l.readByte()
 		if err != nil {
-			return nil
+			break // A comment can end the file
 		}
 		if b == '\n' {
-			l.r.UnreadByte()
+			l.unreadByte()
 			break
 		}
 		buf.WriteByte(b)
@@ -172,37 +215,67 @@ func (l *lexer) consumeLine() []byte {
 
 func (l *lexer) consumeNumeric() []*lexToken {
 	buf := bytes.Buffer{}
+	raw := bytes.Buffer{} // Everything read, used for hardware addresses
 	dotCount := 0
 	hasSlash := false
+	hasColon := false
+	hasSep := false // A colon or hyphen after the first character
+	hasHex := false
 	negative := false
 
 	for {
-		b, err := l.r.ReadByte()
+		b, err := l.readByte()
 		if err != nil {
-			return nil
+			break // The last line of a file may not end
 		}
 		if isNumber(b) {
 			buf.WriteByte(b)
+			raw.WriteByte(b)
 			continue
 		} else if b == '.' {
 			buf.WriteByte(b)
+			raw.WriteByte(b)
 			dotCount++
 			continue
 		} else if b == '/' {
 			buf.WriteByte(b)
+			raw.WriteByte(b)
 			hasSlash = true
 			continue
 		} else if b == '-' {
+			hasSep = hasSep || raw.Len() > 0
+			raw.WriteByte(b)
 			negative = true
 			continue
+		} else if b == ':' {
+			raw.WriteByte(b)
+			hasColon = true
+			hasSep = true
+			continue
+		} else if isHexLetter(b) {
+			raw.WriteByte(b)
+			hasHex = true
+			continue
 		}
-		l.r.UnreadByte()
+		l.unreadByte()
 		break
 	}
 
 	toks := make([]*lexToken, 1)
 	toks[0] = &lexToken{}
-	if hasSlash && dotCount == 3 { // CIDR notation
+	if hasColon { // Hardware or IPv6 address
+		toks[0] = macToken(raw.String())
+		if toks[0].token == ILLEGAL {
+			if ipToks := ipv6Tokens(raw.String()); ipToks != nil {
+				toks = ipToks
+			}
+		}
+	} else if hasSep && raw.Len() == 17 { // Hardware address
+		toks[0] = macToken(raw.String())
+	} else if hasHex {
+		toks[0].token = ILLEGAL
+		toks[0].value = raw.String()
+	} else if hasSlash && dotCount == 3 { // CIDR notation
 		ip, network, err := net.ParseCIDR(buf.String())
 		if err != nil {
 			toks[0].token = ILLEGAL
@@ -223,6 +296,14 @@ func (l *lexer) consumeNumeric() []*lexToken {
 			toks[0].token = IP_ADDRESS
 			toks[0].value = ip
 		}
+	} else if dotCount == 1 && !hasSlash { // Decimal number
+		num, err := strconv.ParseFloat(buf.String(), 64)
+		if err != nil || negative {
+			toks[0].token = ILLEGAL
+		} else {
+			toks[0].token = FLOAT
+			toks[0].value = num
+		}
 	} else if dotCount == 0 { // Number
 		if negative {
 			num, err := strconv.ParseInt(buf.String(), 10, 64)
@@ -248,12 +329,12 @@ func (l *lexer) consumeNumeric() []*lexToken {
 func (l *lexer) consumeIdent() []*lexToken {
 	buf := bytes.Buffer{}
 	for {
-		b, err := l.r.ReadByte()
+		b, err := l.readByte()
 		if err != nil {
-			return nil
+			break // The last line of a file may not end
 		}
 		if isWhitespace(b) {
-			l.r.UnreadByte()
+			l.unreadByte()
 			break
 		}
 		buf.WriteByte(b)
@@ -264,12 +345,58 @@ func (l *lexer) consumeIdent() []*lexToken {
 		tok = &lexToken{token: BOOLEAN, value: true}
 	} else if s == "false" {
 		tok = &lexToken{token: BOOLEAN, value: false}
+	} else if isMAC(s) {
+		tok = macToken(s)
+	} else if ipToks := ipv6Tokens(s); ipToks != nil { // IPv6 address starting with a letter
+		return ipToks
 	} else {
 		tok = &lexToken{token: lookup(buf.String()), value: buf.String()}
 	}
 	return []*lexToken{tok}
 }
 
+func macToken(s string) *lexToken {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return &lexToken{token: ILLEGAL, value: s}
+	}
+	return &lexToken{token: MAC_ADDRESS, value: mac}
+}
+
+// ipv6Tokens returns the tokens for an IPv6 address or prefix. A prefix
+// produces an address and a mask token like IPv4 CIDR notation. It returns
+// nil if s is not an IPv6 address.
+func ipv6Tokens(s string) []*lexToken {
+	if !strings.Contains(s, ":") {
+		return nil
+	}
+	if strings.Contains(s, "/") {
+		ip, network, err := net.ParseCIDR(s)
+		if err != nil || ip.To4() != nil {
+			return nil
+		}
+		return []*lexToken{
+			&lexToken{token: IP_ADDRESS, value: ip},
+			&lexToken{token: IP_ADDRESS, value: net.IP(network.Mask)},
+		}
+	}
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() != nil {
+		return nil
+	}
+	return []*lexToken{&lexToken{token: IP_ADDRESS, value: ip}}
+}
+
+// isMAC reports if s looks like a colon or hyphen separated hardware address.
+func isMAC(s string) bool {
+	if len(s) != 17 || (s[2] != ':' && s[2] != '-') {
+		return false
+	}
+	_, err := net.ParseMAC(s)
+	return err == nil
+}
+
+func isHexLetter(b byte) bool  { return ('a' <= b && b <= 'f') || ('A' <= b && b <= 'F') }
 func isNumber(b byte) bool     { return unicode.IsDigit(rune(b)) }
 func isLetter(b byte) bool     { return unicode.IsLetter(rune(b)) }
 func isWhitespace(b byte) bool { return unicode.IsSpace(rune(b)) }
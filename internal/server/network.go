@@ -11,11 +11,24 @@ import (
 	"sync"
 	"time"
 
+	"github.com/packet-guardian/pg-dhcp/dhcp"
 	"github.com/packet-guardian/pg-dhcp/models"
 )
 
+// leaseBinding determines how a client is matched to an existing lease.
+type leaseBinding int
+
+const (
+	// bindClientIDFirst matches on the client identifier if the client or lease
+	// has one, and falls back to the hardware address otherwise.
+	bindClientIDFirst leaseBinding = iota
+	// bindMACOnly matches only on the hardware address.
+	bindMACOnly
+)
+
 type network struct {
 	sync.Mutex
+	pos                  position
 	global               *global
 	name                 string
 	settings             *settings
@@ -24,7 +37,14 @@ type network struct {
 	unregisteredSettings *settings
 	unregOptionsCached   bool
 	subnets              []*subnet
+	subnets6             []*subnet6
 	local                bool
+	hosts                []*host
+	hostsByMAC           map[string]*host
+	hostsByIP            map[string]*host
+	relayMatches         relayMatches
+	leaseBinding         leaseBinding
+	authoritative        boolSetting
 }
 
 func newNetwork(name string) *network {
@@ -33,6 +53,8 @@ func newNetwork(name string) *network {
 		settings:             newSettingsBlock(),
 		registeredSettings:   newSettingsBlock(),
 		unregisteredSettings: newSettingsBlock(),
+		hostsByMAC:           make(map[string]*host),
+		hostsByIP:            make(map[string]*host),
 	}
 }
 
@@ -112,13 +134,16 @@ func (n *network) getSettings(registered bool) *settings {
 		return n.unregisteredSettings
 	}
 
+	// Network "root" settings apply to both registered and unregistered
 	gSet := n.global.getSettings(registered)
 	if registered {
+		mergeSettings(n.registeredSettings, n.settings)
 		mergeSettings(n.registeredSettings, gSet)
 		n.regOptionsCached = true
 		return n.registeredSettings
 	}
 
+	mergeSettings(n.unregisteredSettings, n.settings)
 	mergeSettings(n.unregisteredSettings, gSet)
 	n.unregOptionsCached = true
 	return n.unregisteredSettings
@@ -130,9 +155,68 @@ func (n *network) includes(ip net.IP) bool {
 			return true
 		}
 	}
+	for _, s := range n.subnets6 {
+		if s.includes(ip) {
+			return true
+		}
+	}
 	return false
 }
 
+func (n *network) getSubnetOfIP(ip net.IP) *subnet {
+	for _, s := range n.subnets {
+		if s.includes(ip) {
+			return s
+		}
+	}
+	return nil
+}
+
+// addHost registers a host reservation with the network. The host's subnet
+// must already be set.
+func (n *network) addHost(h *host) error {
+	if o, exists := n.hostsByMAC[h.mac.String()]; exists {
+		return parseErrorf(h.pos, "Host %s has the same hardware-address as host %s", h.name, o.name)
+	}
+	if o, exists := n.hostsByIP[h.fixedAddress.String()]; exists {
+		return parseErrorf(h.pos, "Host %s has the same fixed-address as host %s", h.name, o.name)
+	}
+	n.hosts = append(n.hosts, h)
+	n.hostsByMAC[h.mac.String()] = h
+	n.hostsByIP[h.fixedAddress.String()] = h
+	return nil
+}
+
+func (n *network) getHostByMAC(mac net.HardwareAddr) *host {
+	return n.hostsByMAC[mac.String()]
+}
+
+func (n *network) getHostByIP(ip net.IP) *host {
+	return n.hostsByIP[ip.String()]
+}
+
+// isReserved returns if ip is the fixed address of a host.
+func (n *network) isReserved(ip net.IP) bool {
+	_, reserved := n.hostsByIP[ip.String()]
+	return reserved
+}
+
+// isAuthoritative returns if the network NAKs requests for addresses it has no
+// record of. Networks inherit the global setting.
+func (n *network) isAuthoritative() bool {
+	if n.authoritative != boolUnset {
+		return n.authoritative == boolTrue
+	}
+	return n.global.authoritative
+}
+
+// servesIP returns if ip is a pool address the network gives to clients of
+// the registration state and classes.
+func (n *network) servesIP(ip net.IP, registered bool, classes []string) bool {
+	p := n.getPoolOfIP(ip)
+	return p != nil && p.subnet.allowUnknown != registered && p.allowsClasses(classes) && !n.isReserved(ip)
+}
+
 func (n *network) getPoolOfIP(ip net.IP) *pool {
 	for _, s := range n.subnets {
 		for _, p := range s.pools {
@@ -144,12 +228,15 @@ func (n *network) getPoolOfIP(ip net.IP) *pool {
 	return nil
 }
 
-func (n *network) getFreeLease(e *ServerConfig, registered bool) (*models.Lease, *pool) {
+func (n *network) getFreeLease(e *ServerConfig, registered, bootp bool, relay *dhcp4.RelayAgentInformation, classes []string) (*models.Lease, *pool) {
 	for _, s := range n.subnets {
 		if s.allowUnknown == registered {
 			continue
 		}
 		for _, p := range s.pools {
+			if !p.matchesRelay(relay) || !p.allowsClasses(classes) || (bootp && !p.bootpAllowed(registered)) {
+				continue
+			}
 			if l := p.getFreeLease(e); l != nil {
 				return l, p
 			}
@@ -158,12 +245,15 @@ func (n *network) getFreeLease(e *ServerConfig, registered bool) (*models.Lease,
 	return nil, nil
 }
 
-func (n *network) getFreeLeaseDesperate(e *ServerConfig, registered bool) (*models.Lease, *pool) {
+func (n *network) getFreeLeaseDesperate(e *ServerConfig, registered, bootp bool, relay *dhcp4.RelayAgentInformation, classes []string) (*models.Lease, *pool) {
 	for _, s := range n.subnets {
 		if s.allowUnknown == registered {
 			continue
 		}
 		for _, p := range s.pools {
+			if !p.matchesRelay(relay) || !p.allowsClasses(classes) || (bootp && !p.bootpAllowed(registered)) {
+				continue
+			}
 			if l := p.getFreeLeaseDesperate(e); l != nil {
 				return l, p
 			}
@@ -172,14 +262,21 @@ func (n *network) getFreeLeaseDesperate(e *ServerConfig, registered bool) (*mode
 	return nil, nil
 }
 
-func (n *network) getLeaseByMAC(mac net.HardwareAddr, registered bool) (*models.Lease, *pool) {
+// getOfferByMAC returns an unexpired lease offered to mac, from the same pools
+// getFreeLease would use. This finds an address still reserved for the client
+// while it's probed.
+func (n *network) getOfferByMAC(mac net.HardwareAddr, registered, bootp bool, relay *dhcp4.RelayAgentInformation, classes []string) (*models.Lease, *pool) {
+	now := time.Now()
 	for _, s := range n.subnets {
 		if s.allowUnknown == registered {
 			continue
 		}
 		for _, p := range s.pools {
+			if !p.matchesRelay(relay) || !p.allowsClasses(classes) || (bootp && !p.bootpAllowed(registered)) {
+				continue
+			}
 			for _, l := range p.leases {
-				if bytes.Equal(l.MAC, mac) {
+				if l.Offered && !l.IsAbandoned && l.End.After(now) && bytes.Equal(l.MAC, mac) {
 					return l, p
 				}
 			}
@@ -188,6 +285,119 @@ func (n *network) getLeaseByMAC(mac net.HardwareAddr, registered bool) (*models.
 	return nil, nil
 }
 
+// getFreeLease6 returns an unused DHCPv6 lease from the network.
+func (n *network) getFreeLease6(registered bool) (*models.Lease, *pool6) {
+	for _, s := range n.subnets6 {
+		if s.allowUnknown == registered {
+			continue
+		}
+		for _, p := range s.pools {
+			if l := p.getFreeLease(); l != nil {
+				return l, p
+			}
+		}
+	}
+	return nil, nil
+}
+
+// getLeaseByDUID returns the DHCPv6 lease bound to the identity association
+// iaid of the client with DUID duid.
+func (n *network) getLeaseByDUID(duid []byte, iaid uint32, registered bool) (*models.Lease, *pool6) {
+	for _, s := range n.subnets6 {
+		if s.allowUnknown == registered {
+			continue
+		}
+		for _, p := range s.pools {
+			for _, l := range p.leases {
+				if !l.IsAbandoned && l.IAID == iaid && bytes.Equal(l.ClientID, duid) {
+					return l, p
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// claimLease6 returns a lease for the first of addrs that is free in a pool
+// serving the client's registration state. It's used to recreate a binding the
+// server lost for a client that is still using its address.
+func (n *network) claimLease6(addrs []net.IP, duid []byte, registered bool) (*models.Lease, *pool6) {
+	for _, ip := range addrs {
+		p := n.getPool6OfIP(ip)
+		if p == nil || p.subnet.allowUnknown == registered {
+			continue
+		}
+		if l := p.claimLease(ip, duid); l != nil {
+			return l, p
+		}
+	}
+	return nil, nil
+}
+
+// getPool6OfIP returns the DHCPv6 pool that includes ip.
+func (n *network) getPool6OfIP(ip net.IP) *pool6 {
+	for _, s := range n.subnets6 {
+		for _, p := range s.pools {
+			if p.includes(ip) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// getSubnet6 returns the first DHCPv6 subnet for clients of the registration state.
+func (n *network) getSubnet6(registered bool) *subnet6 {
+	for _, s := range n.subnets6 {
+		if s.allowUnknown != registered {
+			return s
+		}
+	}
+	return nil
+}
+
+// getLeaseByMAC returns the lease bound to the client with hardware address mac
+// and client identifier clientID. A lease bound by client identifier is preferred
+// over one matched by hardware address.
+func (n *network) getLeaseByMAC(mac net.HardwareAddr, clientID []byte, registered bool) (*models.Lease, *pool) {
+	var (
+		macLease *models.Lease
+		macPool  *pool
+	)
+	for _, s := range n.subnets {
+		if s.allowUnknown == registered {
+			continue
+		}
+		for _, p := range s.pools {
+			for _, l := range p.leases {
+				if l.IsAbandoned || !n.leaseBelongsTo(l, mac, clientID) {
+					continue
+				}
+				if n.leaseBinding == bindMACOnly || len(l.ClientID) > 0 {
+					return l, p
+				}
+				if macLease == nil {
+					macLease, macPool = l, p
+				}
+			}
+		}
+	}
+	return macLease, macPool
+}
+
+// leaseBelongsTo returns if lease l is bound to the client with hardware address mac
+// and client identifier clientID.
+func (n *network) leaseBelongsTo(l *models.Lease, mac net.HardwareAddr, clientID []byte) bool {
+	if n.leaseBinding == bindMACOnly {
+		return bytes.Equal(l.MAC, mac)
+	}
+	if len(l.ClientID) > 0 {
+		return bytes.Equal(l.ClientID, clientID)
+	}
+	// Leases without a client identifier fall back to the hardware address
+	return bytes.Equal(l.MAC, mac)
+}
+
 func (n *network) getLeaseByIP(ip net.IP, registered bool) (*models.Lease, *pool) {
 	for _, s := range n.subnets {
 		if s.allowUnknown == registered {
@@ -202,6 +412,32 @@ func (n *network) getLeaseByIP(ip net.IP, registered bool) (*models.Lease, *pool
 	return nil, nil
 }
 
+// getLeaseOrHostLease returns the lease for ip from either a pool or a host reservation.
+func (n *network) getLeaseOrHostLease(ip net.IP, registered bool) *models.Lease {
+	if h := n.getHostByIP(ip); h != nil {
+		return h.lease
+	}
+	l, _ := n.getLeaseByIP(ip, registered)
+	return l
+}
+
+// findLease returns the lease for ip regardless of the registration state of its pool.
+func (n *network) findLease(ip net.IP) *models.Lease {
+	if h := n.getHostByIP(ip); h != nil {
+		return h.lease
+	}
+	if ip.To4() == nil {
+		if p := n.getPool6OfIP(ip); p != nil {
+			return p.leases[ip.String()]
+		}
+		return nil
+	}
+	if p := n.getPoolOfIP(ip); p != nil {
+		return p.leases[ip.String()]
+	}
+	return nil
+}
+
 func (n *network) getAllLeases() []*models.Lease {
 	leases := make([]*models.Lease, 0, 20)
 	for _, s := range n.subnets {
@@ -211,5 +447,17 @@ func (n *network) getAllLeases() []*models.Lease {
 			}
 		}
 	}
+	for _, s := range n.subnets6 {
+		for _, p := range s.pools {
+			for _, l := range p.leases {
+				leases = append(leases, l)
+			}
+		}
+	}
+	for _, h := range n.hosts {
+		if h.lease != nil {
+			leases = append(leases, h.lease)
+		}
+	}
 	return leases
 }
@@ -5,6 +5,7 @@
 package server
 
 import (
+	"bytes"
 	"net"
 	"testing"
 	"time"
@@ -78,3 +79,39 @@ func TestGiveLeaseFromMultiplePools(t *testing.T) {
 
 	checkIP(dp, []byte{0xa, 0x0, 0x8, 0x78}, t)
 }
+
+func TestNetworkRootSettings(t *testing.T) {
+	c, err := ParseFile("./testdata/networkRootConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+	network := c.networks["building1"]
+
+	unreg := network.getPoolOfIP(net.IP{10, 0, 1, 10})
+	opts := unreg.getOptions(false)
+	if !bytes.Equal(opts[dhcp4.OptionRouter], []byte{10, 0, 1, 1}) {
+		t.Errorf("Expected router from network root, got %v", opts[dhcp4.OptionRouter])
+	}
+	if !bytes.Equal(opts[dhcp4.OptionDomainNameServer], []byte{10, 0, 1, 2}) {
+		t.Errorf("Expected DNS server from network root, got %v", opts[dhcp4.OptionDomainNameServer])
+	}
+	if string(opts[dhcp4.OptionDomainName]) != "example.com" {
+		t.Errorf("Expected domain name from global, got %q", opts[dhcp4.OptionDomainName])
+	}
+	if !unreg.pingCheckEnabled(false) {
+		t.Error("Expected ping-check from network root")
+	}
+
+	// The registered block comes before the root
+	reg := network.getPoolOfIP(net.IP{10, 0, 2, 10})
+	opts = reg.getOptions(true)
+	if !bytes.Equal(opts[dhcp4.OptionDomainNameServer], []byte{10, 0, 2, 2}) {
+		t.Errorf("Expected DNS server from registered block, got %v", opts[dhcp4.OptionDomainNameServer])
+	}
+	if !bytes.Equal(opts[dhcp4.OptionRouter], []byte{10, 0, 1, 1}) {
+		t.Errorf("Expected router from network root, got %v", opts[dhcp4.OptionRouter])
+	}
+	if !reg.pingCheckEnabled(true) {
+		t.Error("Expected ping-check from network root in registered subnet")
+	}
+}
@@ -0,0 +1,121 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+// encodeClasslessRoutes encodes routes given as a destination in CIDR notation
+// followed by the router, e.g. "10.0.0.0/8 10.0.1.1 0.0.0.0/0 10.0.1.1".
+func encodeClasslessRoutes(params []*lexToken) ([]byte, error) {
+	// The lexer splits CIDR notation into an address and a mask token
+	if len(params)%3 != 0 {
+		return nil, tokenErrorf(params[0], "Routes must be a destination in CIDR notation and a router")
+	}
+
+	routes := make([]dhcp4.ClasslessRoute, 0, len(params)/3)
+	for i := 0; i < len(params); i += 3 {
+		dest, mask, router := params[i], params[i+1], params[i+2]
+		if dest.token != IP_ADDRESS || mask.token != IP_ADDRESS || router.token != IP_ADDRESS {
+			return nil, tokenErrorf(dest, "Routes must be a destination in CIDR notation and a router")
+		}
+
+		ipMask := net.IPMask(mask.value.(net.IP).To4())
+		if _, bits := ipMask.Size(); bits != 32 || dest.value.(net.IP).To4() == nil {
+			return nil, tokenErrorf(dest, "Route destination must be an IPv4 network in CIDR notation")
+		}
+		network := &net.IPNet{IP: dest.value.(net.IP).To4(), Mask: ipMask}
+		if !network.IP.Equal(network.IP.Mask(ipMask)) {
+			return nil, tokenErrorf(dest, "Route destination %s has host bits set", network)
+		}
+		if router.value.(net.IP).To4() == nil {
+			return nil, tokenErrorf(router, "Route router must be an IPv4 address")
+		}
+		routes = append(routes, dhcp4.ClasslessRoute{Destination: network, Router: router.value.(net.IP)})
+	}
+	return dhcp4.EncodeClasslessRoutes(routes)
+}
+
+// encodeDomainSearch encodes a list of domain names with compression.
+func encodeDomainSearch(params []*lexToken) ([]byte, error) {
+	names := make([]string, len(params))
+	for i, p := range params {
+		if p.token != STRING {
+			return nil, tokenErrorf(p, "Expected domain name, got %s", p.string())
+		}
+		names[i] = p.value.(string)
+	}
+
+	data, err := dhcp4.EncodeDomainSearch(names)
+	if err != nil {
+		return nil, tokenErrorf(params[0], "Invalid domain search list: %s", err)
+	}
+	return data, nil
+}
+
+// encodeVendorOption encodes a single option 43 sub-option given as a code followed
+// by its value. Addresses are 4 bytes, numbers and booleans 1 byte and strings
+// their bytes. Other encodings can be given with hex.
+func encodeVendorOption(params []*lexToken) ([]byte, error) {
+	code := params[0]
+	if code.token != NUMBER || code.value.(uint64) < 1 || code.value.(uint64) > 254 {
+		return nil, tokenErrorf(code, "Expected sub-option code between 1 and 254, got %s", code.string())
+	}
+
+	var value []byte
+	for i := 1; i < len(params); i++ {
+		tok := params[i]
+		switch tok.token {
+		case IP_ADDRESS:
+			ip := tok.value.(net.IP).To4()
+			if ip == nil {
+				return nil, tokenErrorf(tok, "Expected IPv4 address")
+			}
+			value = append(value, ip...)
+		case NUMBER:
+			if tok.value.(uint64) > 255 {
+				return nil, tokenErrorf(tok, "Number is too big, use hex for wider values")
+			}
+			value = append(value, byte(tok.value.(uint64)))
+		case BOOLEAN:
+			if tok.value.(bool) {
+				value = append(value, 1)
+			} else {
+				value = append(value, 0)
+			}
+		case STRING:
+			value = append(value, tok.value.(string)...)
+		case HEX:
+			if i+1 == len(params) || params[i+1].token != STRING {
+				return nil, tokenErrorf(tok, "Expected hex string")
+			}
+			i++
+			b, err := parseHexString(params[i].value.(string))
+			if err != nil {
+				return nil, tokenErrorf(tok, "Invalid hex string: %s", err)
+			}
+			value = append(value, b...)
+		default:
+			return nil, tokenErrorf(tok, "Unexpected token %s in sub-option", tok.string())
+		}
+	}
+	return dhcp4.EncodeSubOption(nil, byte(code.value.(uint64)), value)
+}
+
+// parseHexString decodes hex bytes which may be separated by colons,
+// e.g. "0a:0b:ff" or "0a0bff".
+func parseHexString(s string) ([]byte, error) {
+	s = strings.Replace(s, ":", "", -1)
+	if s == "" {
+		return nil, errors.New("empty hex string")
+	}
+	return hex.DecodeString(s)
+}
@@ -25,6 +25,11 @@ var (
 	int16Schema    = &optionSchema{token: NUMBER, multi: 1, maxlen: 2, multipleOf: 2}
 	int32Schema    = &optionSchema{token: NUMBER, multi: 1, maxlen: 4, multipleOf: 4}
 	anySchema      = &optionSchema{token: ANY, multi: oneOrMore, maxlen: unlimited, multipleOf: 1}
+
+	// Structured options with their own encoding
+	classlessRouteSchema = &optionSchema{encode: encodeClasslessRoutes}
+	domainSearchSchema   = &optionSchema{encode: encodeDomainSearch}
+	vendorSchema         = &optionSchema{encode: encodeVendorOption}
 )
 
 type optionSchema struct {
@@ -32,11 +37,52 @@ type optionSchema struct {
 	multi      multiple // How many of the token are allowed
 	multipleOf int
 	maxlen     length // Maximum number of bytes the option can be
+
+	// encode converts the option's parameters for options that aren't a list
+	// of a single token type. The other fields are ignored if it's set.
+	encode func(params []*lexToken) ([]byte, error)
 }
 
 type dhcpOptionBlock struct {
 	code   dhcp4.OptionCode
 	schema *optionSchema
+	vendor bool // A sub-option sent encapsulated in option 43
+}
+
+// definitionTypes are the fixed width types available to option-definition
+// statements and the size of a single value in bytes.
+var definitionTypes = map[string]struct {
+	token token
+	width int
+}{
+	"boolean":    {BOOLEAN, 1},
+	"ip-address": {IP_ADDRESS, 4},
+	"uint8":      {NUMBER, 1},
+	"uint16":     {NUMBER, 2},
+	"uint32":     {NUMBER, 4},
+	"int8":       {NUMBER, 1},
+	"int16":      {NUMBER, 2},
+	"int32":      {NUMBER, 4},
+}
+
+// newDefinitionSchema returns the schema of a user defined option of type typ.
+// Array options take one or more values.
+func newDefinitionSchema(typ string, array bool) (*optionSchema, bool) {
+	switch typ {
+	case "domain-list":
+		return domainSearchSchema, !array
+	case "string":
+		return stringSchema, !array
+	}
+
+	t, ok := definitionTypes[typ]
+	if !ok {
+		return nil, false
+	}
+	if array {
+		return &optionSchema{token: t.token, multi: oneOrMore, maxlen: unlimited, multipleOf: t.width}, true
+	}
+	return &optionSchema{token: t.token, multi: 1, maxlen: length(t.width), multipleOf: t.width}, true
 }
 
 var options = map[string]*dhcpOptionBlock{
@@ -219,10 +265,10 @@ var options = map[string]*dhcpOptionBlock{
 		code:   dhcp4.OptionNetworkTimeProtocolServers,
 		schema: multiIPSchema,
 	},
-	// "vendor-specific-information": &dhcpOptionBlock{
-	// 	code:   dhcp4.OptionVendorSpecificInformation,
-	// 	schema: stringSchema,
-	// },
+	"vendor-specific-information": &dhcpOptionBlock{
+		code:   dhcp4.OptionVendorSpecificInformation,
+		schema: vendorSchema,
+	},
 	"netbios-over-tcpip-name-server": &dhcpOptionBlock{
 		code:   dhcp4.OptionNetBIOSOverTCPIPNameServer,
 		schema: multiIPSchema,
@@ -305,4 +351,18 @@ var options = map[string]*dhcpOptionBlock{
 		code:   dhcp4.OptionRebindingTimeValue,
 		schema: int32Schema,
 	},
+
+	// Later extensions
+	"domain-search": &dhcpOptionBlock{
+		code:   dhcp4.OptionDomainSearch,
+		schema: domainSearchSchema,
+	},
+	"classless-static-route": &dhcpOptionBlock{
+		code:   dhcp4.OptionClasslessRouteFormat,
+		schema: classlessRouteSchema,
+	},
+	"ms-classless-static-route": &dhcpOptionBlock{
+		code:   dhcp4.OptionMSClasslessRouteFormat,
+		schema: classlessRouteSchema,
+	},
 }
@@ -6,17 +6,21 @@ package server
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"strconv"
 
 	"github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/dhcp6"
 )
 
 // ParseFile takes the file name to a configuration file.
@@ -27,20 +31,62 @@ func ParseFile(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	return newParser(bufio.NewReader(file)).parse()
+	defer file.Close()
+	return newParser(bufio.NewReader(file), path).parse()
+}
+
+// ParseError is an error in a configuration file.
+type ParseError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+	// IncludedFrom lists the include statements that read File, innermost first.
+	IncludedFrom []string
+}
+
+func (e *ParseError) Error() string {
+	pos := position{file: e.File, line: e.Line, col: e.Column}
+	s := fmt.Sprintf("%s: %s", pos, e.Message)
+	for _, i := range e.IncludedFrom {
+		s += "\n\tincluded from " + i
+	}
+	return s
+}
+
+func parseErrorf(pos position, format string, args ...interface{}) error {
+	e := &ParseError{
+		File:    pos.file,
+		Line:    pos.line,
+		Column:  pos.col,
+		Message: fmt.Sprintf(format, args...),
+	}
+	for _, i := range pos.includes() {
+		e.IncludedFrom = append(e.IncludedFrom, i.String())
+	}
+	return e
+}
+
+func tokenErrorf(tok *lexToken, format string, args ...interface{}) error {
+	return parseErrorf(tok.position(), format, args...)
 }
 
 type parser struct {
-	l *lexer
-	c *Config
+	l           *lexer
+	c           *Config
+	definitions map[string]*dhcpOptionBlock // User defined options by name
 }
 
-func newParser(r *bufio.Reader) *parser {
-	return &parser{l: newLexer(r)}
+func newParser(r *bufio.Reader, file string) *parser {
+	return &parser{
+		l:           newLexer(r, file),
+		definitions: make(map[string]*dhcpOptionBlock),
+	}
 }
 
 func (p *parser) parse() (*Config, error) {
 	p.c = newConfig()
+	p.c.file = p.l.src.file
 
 mainLoop:
 	for {
@@ -50,24 +96,22 @@ mainLoop:
 		case COMMENT, EOL:
 			continue
 		case GLOBAL:
+			if p.c.global.pos.line == 0 {
+				p.c.global.pos = tok.position()
+			}
 			err = p.parseGlobal()
 		case NETWORK:
 			err = p.parseNetwork()
+		case CLASS:
+			err = p.parseClass()
+		case OPTION_DEFINITION:
+			err = p.parseOptionDefinition()
 		case INCLUDE:
-			n := p.l.next()
-			if n.token != STRING {
-				return nil, fmt.Errorf("Include must be a file path on line %d", n.line)
-			}
-			file, err := os.Open(n.value.(string))
-			if err != nil {
-				return nil, fmt.Errorf("Error including file %v: %v", n.value, err)
-			}
-			p.l.pushReader(bufio.NewReader(file))
-			continue
+			err = p.parseInclude(tok)
 		case EOF:
 			break mainLoop
 		default:
-			return nil, fmt.Errorf("Invalid token on line %d: %s", tok.line, tok.string())
+			return nil, tokenErrorf(tok, "Invalid token: %s", tok.string())
 		}
 		if err != nil {
 			return nil, err
@@ -77,9 +121,89 @@ mainLoop:
 	for _, n := range p.c.networks {
 		n.global = p.c.global
 	}
+
+	// Classes may be declared after the pools that use them
+	if err := p.checkPoolClasses(); err != nil {
+		return nil, err
+	}
+
+	// Global hosts can only be placed after all networks are known
+	for _, h := range p.c.global.hosts {
+		for _, n := range p.c.networks {
+			if h.subnet = n.getSubnetOfIP(h.fixedAddress); h.subnet != nil {
+				break
+			}
+		}
+		if h.subnet == nil {
+			return nil, parseErrorf(h.pos, "Host %s fixed-address %s is not in any subnet", h.name, h.fixedAddress)
+		}
+		if err := h.subnet.network.addHost(h); err != nil {
+			return nil, err
+		}
+	}
 	return p.c, nil
 }
 
+// parseInclude reads the files named by an include statement before the rest of
+// the current file. Relative paths are relative to the including file and may be
+// glob patterns, matching files are read in lexical order.
+func (p *parser) parseInclude(includeTok *lexToken) error {
+	n := p.l.next()
+	if n.token != STRING {
+		return tokenErrorf(n, "Include must be a file path")
+	}
+	files, err := includeFiles(n, includeTok.src.file)
+	if err != nil {
+		return err
+	}
+
+	// The lexer reads the last pushed reader first
+	for i := len(files) - 1; i >= 0; i-- {
+		if isIncluding(includeTok.position(), files[i]) {
+			return tokenErrorf(n, "Include cycle, %s is already being read", files[i])
+		}
+		data, err := ioutil.ReadFile(files[i])
+		if err != nil {
+			return tokenErrorf(n, "Error including file: %s", err)
+		}
+		p.l.pushReader(bufio.NewReader(bytes.NewReader(data)), files[i], includeTok.position())
+	}
+	return nil
+}
+
+// includeFiles returns the files named by the path tok of an include statement
+// in the file from.
+func includeFiles(tok *lexToken, from string) ([]string, error) {
+	pattern := tok.value.(string)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(from), pattern)
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, tokenErrorf(tok, "Invalid include pattern %s: %s", tok.value, err)
+	}
+	return files, nil
+}
+
+// isIncluding returns if file is being read at pos or by one of the include
+// statements leading to it.
+func isIncluding(pos position, file string) bool {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	for _, i := range append([]position{pos}, pos.includes()...) {
+		if f, err := filepath.Abs(i.file); err == nil && f == abs {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *parser) parseGlobal() error {
 mainLoop:
 	for {
@@ -92,7 +216,7 @@ mainLoop:
 		case SERVER_IDENTIFIER:
 			addr := p.l.next()
 			if addr.token != IP_ADDRESS {
-				return fmt.Errorf("Expected IP address on line %d", addr.line)
+				return tokenErrorf(addr, "Expected IP address")
 			}
 			p.c.global.serverIdentifier = addr.value.(net.IP)
 		case REGISTERED:
@@ -109,6 +233,36 @@ mainLoop:
 			}
 			p.c.global.unregisteredSettings = s
 			p.l.next() // Consume END from block
+		case HOST:
+			h, err := p.parseHost()
+			if err != nil {
+				return err
+			}
+			p.c.global.hosts = append(p.c.global.hosts, h)
+		case OPTION_DEFINITION:
+			if err := p.parseOptionDefinition(); err != nil {
+				return err
+			}
+		case AUTHORITATIVE:
+			authoritative, err := p.parseAuthoritative()
+			if err != nil {
+				return err
+			}
+			p.c.global.authoritative = authoritative
+		case TRUSTED_RELAY:
+			relays := p.l.untilNext(EOL)
+			if len(relays) == 0 || relays[0].token == COMMENT {
+				return tokenErrorf(tok, "Expected IP address")
+			}
+			for _, r := range relays {
+				if r.token == COMMENT {
+					continue
+				}
+				if r.token != IP_ADDRESS || r.value.(net.IP).To4() == nil {
+					return tokenErrorf(r, "Expected IPv4 address, got %s", r.string())
+				}
+				p.c.global.trustedRelays = append(p.c.global.trustedRelays, r.value.(net.IP).To4())
+			}
 		default:
 			if tok.token.isSetting() {
 				p.l.unread()
@@ -118,7 +272,7 @@ mainLoop:
 				}
 				continue
 			}
-			return fmt.Errorf("Unexpected token %s on line %d in global", tok.string(), tok.line)
+			return tokenErrorf(tok, "Unexpected token %s in global", tok.string())
 		}
 	}
 	return nil
@@ -132,18 +286,20 @@ func (p *parser) parseNetwork() error {
 		nameToken = p.l.next()
 	}
 	if nameToken.token != STRING {
-		return fmt.Errorf("Expected STRING on line %d", nameToken.line)
+		return tokenErrorf(nameToken, "Expected STRING")
 	}
 	name := strings.ToLower(nameToken.value.(string))
 	if len(name) > 255 {
-		return fmt.Errorf("Network name is too long on line %d", nameToken.line)
+		return tokenErrorf(nameToken, "Network name is too long")
 	}
 
 	if _, exists := p.c.networks[name]; exists {
-		return fmt.Errorf("Network %s already declared, line %d", name, nameToken.line)
+		return tokenErrorf(nameToken, "Network %s already declared", name)
 	}
 	netBlock := newNetwork(name)
+	netBlock.pos = nameToken.position()
 	netBlock.local = local
+	var hosts []*host
 	mode := 0 // 0 = root, 1 = registered, 2 = unregistered
 mainLoop:
 	for {
@@ -168,21 +324,75 @@ mainLoop:
 			}
 			subnet.network = netBlock
 			netBlock.subnets = append(netBlock.subnets, subnet)
+			for _, h := range subnet.hosts {
+				if err := netBlock.addHost(h); err != nil {
+					return err
+				}
+			}
 			if shortSyntax {
 				mode = 0
 			}
+		case SUBNET6:
+			sub, err := p.parseSubnet6()
+			if err != nil {
+				return err
+			}
+			// Like subnet, a subnet6 outside a registered block is unregistered
+			sub.allowUnknown = (mode != 1)
+			sub.network = netBlock
+			netBlock.subnets6 = append(netBlock.subnets6, sub)
+		case HOST:
+			h, err := p.parseHost()
+			if err != nil {
+				return err
+			}
+			hosts = append(hosts, h)
+		case MATCH:
+			if mode != 0 {
+				return tokenErrorf(tok, "Match not allowed in un/registered block")
+			}
+			m, err := p.parseMatch()
+			if err != nil {
+				return err
+			}
+			netBlock.relayMatches = append(netBlock.relayMatches, m)
+		case LEASE_BINDING:
+			if mode != 0 {
+				return tokenErrorf(tok, "Lease-binding not allowed in un/registered block")
+			}
+			binding := p.l.next()
+			if binding.token != STRING {
+				return tokenErrorf(binding, "Expected client-id-first or mac-only, got %s", binding.string())
+			}
+			switch binding.value.(string) {
+			case "client-id-first":
+				netBlock.leaseBinding = bindClientIDFirst
+			case "mac-only":
+				netBlock.leaseBinding = bindMACOnly
+			default:
+				return tokenErrorf(binding, "Expected client-id-first or mac-only, got %s", binding.value)
+			}
+		case AUTHORITATIVE:
+			if mode != 0 {
+				return tokenErrorf(tok, "Authoritative not allowed in un/registered block")
+			}
+			authoritative, err := p.parseAuthoritative()
+			if err != nil {
+				return err
+			}
+			netBlock.authoritative = newBoolSetting(authoritative)
 		case REGISTERED:
 			if mode == 0 {
 				mode = 1
 				continue
 			}
-			return fmt.Errorf("Registered block not allowed on line %d", tok.line)
+			return tokenErrorf(tok, "Registered block not allowed")
 		case UNREGISTERED:
 			if mode == 0 {
 				mode = 2
 				continue
 			}
-			return fmt.Errorf("Unregistered block not allowed on line %d", tok.line)
+			return tokenErrorf(tok, "Unregistered block not allowed")
 		case END:
 			if mode == 0 { // Exit from root network block
 				break mainLoop
@@ -204,24 +414,44 @@ mainLoop:
 				}
 				continue
 			}
-			return fmt.Errorf("Unexpected token %s on line %d in network", tok.string(), tok.line)
+			return tokenErrorf(tok, "Unexpected token %s in network", tok.string())
+		}
+	}
+
+	// Hosts may be declared before the subnet they belong to
+	for _, h := range hosts {
+		h.subnet = netBlock.getSubnetOfIP(h.fixedAddress)
+		if h.subnet == nil {
+			return parseErrorf(h.pos, "Host %s fixed-address %s is not in network %s", h.name, h.fixedAddress, name)
+		}
+		if err := netBlock.addHost(h); err != nil {
+			return err
 		}
 	}
+
 	p.c.networks[name] = netBlock
+	if len(netBlock.relayMatches) > 0 {
+		p.c.relayNetworks = append(p.c.relayNetworks, netBlock)
+	}
 	return nil
 }
 
 func (p *parser) parseSubnet() (*subnet, error) {
 	ipAddr := p.l.next()
 	if ipAddr.token != IP_ADDRESS {
-		return nil, fmt.Errorf("Expected IP address on line %d", ipAddr.line)
+		return nil, tokenErrorf(ipAddr, "Expected IP address")
+	}
+
+	if ipAddr.value.(net.IP).To4() == nil {
+		return nil, tokenErrorf(ipAddr, "Expected IPv4 address, use subnet6 for IPv6")
 	}
 
 	netmask := p.l.next()
 	if netmask.token != IP_ADDRESS {
-		return nil, fmt.Errorf("Expected IP address on line %d", netmask.line)
+		return nil, tokenErrorf(netmask, "Expected IP address")
 	}
 	sub := newSubnet()
+	sub.pos = ipAddr.position()
 	sub.net = &net.IPNet{
 		IP:   ipAddr.value.(net.IP),
 		Mask: net.IPMask(netmask.value.(net.IP)),
@@ -236,7 +466,7 @@ mainLoop:
 		case EOF, END:
 			break mainLoop
 		case POOL:
-			subPool, err := p.parsePool()
+			subPool, err := p.parsePool(false)
 			if err != nil {
 				return nil, err
 			}
@@ -244,13 +474,23 @@ mainLoop:
 			sub.pools = append(sub.pools, subPool)
 		case RANGE:
 			p.l.unread()
-			subPool, err := p.parsePool() // Start with range statement
+			subPool, err := p.parsePool(true) // Start with range statement
 			if err != nil {
 				return nil, err
 			}
 			subPool.subnet = sub
 			sub.pools = append(sub.pools, subPool)
 			p.l.unread() // Reread END token
+		case HOST:
+			h, err := p.parseHost()
+			if err != nil {
+				return nil, err
+			}
+			if !sub.includes(h.fixedAddress) {
+				return nil, parseErrorf(h.pos, "Host %s fixed-address %s is not in subnet %s", h.name, h.fixedAddress, sub.net)
+			}
+			h.subnet = sub
+			sub.hosts = append(sub.hosts, h)
 		default:
 			if tok.token.isSetting() {
 				p.l.unread()
@@ -260,7 +500,7 @@ mainLoop:
 				}
 				continue
 			}
-			return nil, fmt.Errorf("Unexpected token %s on line %d in subnet", tok.string(), tok.line)
+			return nil, tokenErrorf(tok, "Unexpected token %s in subnet", tok.string())
 		}
 	}
 	if _, ok := sub.settings.options[dhcp4.OptionSubnetMask]; !ok {
@@ -269,7 +509,9 @@ mainLoop:
 	return sub, nil
 }
 
-func (p *parser) parsePool() (*pool, error) {
+// parsePool parses a pool block. shortForm denotes a pool declared with only
+// a range statement and no pool/end keywords.
+func (p *parser) parsePool(shortForm bool) (*pool, error) {
 	nPool := newPool()
 
 mainLoop:
@@ -288,15 +530,39 @@ mainLoop:
 			}
 			startIP := p.l.next()
 			if startIP.token != IP_ADDRESS {
-				return nil, fmt.Errorf("Expected IP address on line %d, got %s", startIP.line, startIP.string())
+				return nil, tokenErrorf(startIP, "Expected IP address, got %s", startIP.string())
 			}
+			nPool.pos = startIP.position()
 			nPool.rangeStart = startIP.value.(net.IP)
 
 			endIP := p.l.next()
 			if endIP.token != IP_ADDRESS {
-				return nil, fmt.Errorf("Expected IP address on line %d, got %s", endIP.line, endIP.string())
+				return nil, tokenErrorf(endIP, "Expected IP address, got %s", endIP.string())
 			}
 			nPool.rangeEnd = endIP.value.(net.IP)
+		case MATCH:
+			m, err := p.parseMatch()
+			if err != nil {
+				return nil, err
+			}
+			nPool.relayMatches = append(nPool.relayMatches, m)
+		case ALLOW, DENY:
+			name := p.l.next()
+			if name.token != STRING {
+				return nil, tokenErrorf(name, "Expected class name, got %s", name.string())
+			}
+			if tok.token == ALLOW {
+				nPool.allowClasses = append(nPool.allowClasses, strings.ToLower(name.value.(string)))
+			} else {
+				nPool.denyClasses = append(nPool.denyClasses, strings.ToLower(name.value.(string)))
+			}
+		case HOST:
+			if !shortForm {
+				return nil, tokenErrorf(tok, "Host not allowed in pool")
+			}
+			// Hosts belong to the subnet
+			p.l.unread()
+			break mainLoop
 		default:
 			if tok.token.isSetting() {
 				p.l.unread()
@@ -306,12 +572,342 @@ mainLoop:
 				}
 				continue
 			}
-			return nil, fmt.Errorf("Unexpected token %s on line %d in pool", tok.string(), tok.line)
+			return nil, tokenErrorf(tok, "Unexpected token %s in pool", tok.string())
 		}
 	}
 	return nPool, nil
 }
 
+func (p *parser) parseSubnet6() (*subnet6, error) {
+	ipAddr := p.l.next()
+	if ipAddr.token != IP_ADDRESS || ipAddr.value.(net.IP).To4() != nil {
+		return nil, tokenErrorf(ipAddr, "Expected IPv6 prefix, got %s", ipAddr.string())
+	}
+	prefixMask := p.l.next()
+	if prefixMask.token != IP_ADDRESS {
+		return nil, tokenErrorf(prefixMask, "Expected IPv6 prefix")
+	}
+	sub := newSubnet6()
+	sub.pos = ipAddr.position()
+	sub.net = &net.IPNet{
+		IP:   ipAddr.value.(net.IP),
+		Mask: net.IPMask(prefixMask.value.(net.IP)),
+	}
+
+mainLoop:
+	for {
+		tok := p.l.next()
+		switch tok.token {
+		case COMMENT, EOL:
+			continue
+		case EOF, END:
+			break mainLoop
+		case RANGE6:
+			nPool := newPool6()
+			startIP := p.l.next()
+			nPool.pos = startIP.position()
+			if startIP.token != IP_ADDRESS || !sub.includes(startIP.value.(net.IP)) {
+				return nil, tokenErrorf(startIP, "Expected IPv6 address in subnet %s, got %s", sub.net, startIP.string())
+			}
+			nPool.rangeStart = startIP.value.(net.IP).To16()
+
+			endIP := p.l.next()
+			if endIP.token != IP_ADDRESS || !sub.includes(endIP.value.(net.IP)) {
+				return nil, tokenErrorf(endIP, "Expected IPv6 address in subnet %s, got %s", sub.net, endIP.string())
+			}
+			nPool.rangeEnd = endIP.value.(net.IP).To16()
+			if !nPool.includes(nPool.rangeStart) {
+				return nil, tokenErrorf(endIP, "Range start is after range end")
+			}
+			nPool.subnet = sub
+			sub.pools = append(sub.pools, nPool)
+		case OPTION6:
+			code, data, err := p.parseOption6()
+			if err != nil {
+				return nil, err
+			}
+			sub.options[code] = data
+		case OPTION:
+			return nil, tokenErrorf(tok, "DHCPv4 option not allowed in subnet6, use option6")
+		default:
+			if tok.token.isSetting() {
+				p.l.unread()
+				err := p.parseSetting(sub.settings)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, tokenErrorf(tok, "Unexpected token %s in subnet6", tok.string())
+		}
+	}
+	return sub, nil
+}
+
+// parseOption6 parses the rest of an option6 statement.
+func (p *parser) parseOption6() (dhcp6.OptionCode, []byte, error) {
+	tokens := p.l.untilNext(EOL)
+	if len(tokens) < 2 {
+		return 0, nil, errors.New("Options require a name and value")
+	}
+
+	n := tokens[0]
+	if n.token != STRING {
+		return 0, nil, tokenErrorf(n, "Invalid option name")
+	}
+	code, exists := options6[n.value.(string)]
+	if !exists {
+		return 0, nil, tokenErrorf(n, "Option %s is not supported", n.value)
+	}
+
+	var optionData []byte
+	switch code {
+	case dhcp6.OptionDNSServers:
+		for _, tok := range tokens[1:] {
+			if tok.token != IP_ADDRESS || tok.value.(net.IP).To4() != nil {
+				return 0, nil, tokenErrorf(tok, "Expected IPv6 address, got %s", tok.string())
+			}
+			optionData = append(optionData, tok.value.(net.IP).To16()...)
+		}
+	case dhcp6.OptionDomainList:
+		names := make([]string, 0, len(tokens)-1)
+		for _, tok := range tokens[1:] {
+			if tok.token != STRING {
+				return 0, nil, tokenErrorf(tok, "Expected STRING, got %s", tok.string())
+			}
+			names = append(names, tok.value.(string))
+		}
+		optionData = dhcp6.EncodeDomainList(names)
+	}
+	return code, optionData, nil
+}
+
+func (p *parser) parseHost() (*host, error) {
+	nameToken := p.l.next()
+	if nameToken.token != STRING {
+		return nil, tokenErrorf(nameToken, "Expected STRING")
+	}
+	h := newHost(nameToken.value.(string))
+	h.pos = nameToken.position()
+
+mainLoop:
+	for {
+		tok := p.l.next()
+		switch tok.token {
+		case COMMENT, EOL:
+			continue
+		case EOF, END:
+			break mainLoop
+		case HARDWARE_ADDRESS:
+			mac := p.l.next()
+			if mac.token != MAC_ADDRESS {
+				return nil, tokenErrorf(mac, "Expected hardware address, got %s", mac.string())
+			}
+			h.mac = mac.value.(net.HardwareAddr)
+		case FIXED_ADDRESS:
+			addr := p.l.next()
+			if addr.token != IP_ADDRESS {
+				return nil, tokenErrorf(addr, "Expected IP address, got %s", addr.string())
+			}
+			h.fixedAddress = addr.value.(net.IP).To4()
+		default:
+			if tok.token.isSetting() {
+				p.l.unread()
+				err := p.parseSetting(h.settings)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, tokenErrorf(tok, "Unexpected token %s in host", tok.string())
+		}
+	}
+
+	if h.mac == nil {
+		return nil, parseErrorf(h.pos, "Host %s requires a hardware-address", h.name)
+	}
+	if h.fixedAddress == nil {
+		return nil, parseErrorf(h.pos, "Host %s requires a fixed-address", h.name)
+	}
+	return h, nil
+}
+
+// parseMatch parses the rest of a match statement. The value may be a string
+// or a hardware address, relays commonly use their MAC as the remote ID.
+func (p *parser) parseMatch() (relayMatch, error) {
+	m := relayMatch{}
+	subOpt := p.l.next()
+	switch subOpt.token {
+	case CIRCUIT_ID:
+		m.subOption = dhcp4.RelayCircuitID
+	case REMOTE_ID:
+		m.subOption = dhcp4.RelayRemoteID
+	default:
+		return m, tokenErrorf(subOpt, "Expected circuit-id or remote-id, got %s", subOpt.string())
+	}
+
+	val := p.l.next()
+	switch val.token {
+	case STRING:
+		m.value = []byte(val.value.(string))
+	case MAC_ADDRESS:
+		m.value = []byte(val.value.(net.HardwareAddr))
+	default:
+		return m, tokenErrorf(val, "Expected STRING or hardware address, got %s", val.string())
+	}
+	if len(m.value) == 0 || len(m.value) > 255 {
+		return m, tokenErrorf(val, "Match value must be 1 to 255 bytes")
+	}
+	return m, nil
+}
+
+func (p *parser) parseClass() error {
+	nameToken := p.l.next()
+	if nameToken.token != STRING {
+		return tokenErrorf(nameToken, "Expected STRING")
+	}
+	name := strings.ToLower(nameToken.value.(string))
+	if strings.Contains(name, ",") {
+		return tokenErrorf(nameToken, "Class name %s can't contain a comma", name)
+	}
+	for _, cl := range p.c.classes {
+		if cl.name == name {
+			return tokenErrorf(nameToken, "Class %s already declared", name)
+		}
+	}
+
+	cl := &class{name: name, pos: nameToken.position()}
+mainLoop:
+	for {
+		tok := p.l.next()
+		switch tok.token {
+		case COMMENT, EOL:
+			continue
+		case EOF, END:
+			break mainLoop
+		case MATCH:
+			m, err := p.parseClassMatch()
+			if err != nil {
+				return err
+			}
+			cl.matches = append(cl.matches, m)
+		default:
+			return tokenErrorf(tok, "Unexpected token %s in class", tok.string())
+		}
+	}
+
+	if len(cl.matches) == 0 {
+		return tokenErrorf(nameToken, "Class %s has no match statements", name)
+	}
+	p.c.classes = append(p.c.classes, cl)
+	return nil
+}
+
+func (p *parser) parseClassMatch() (classMatch, error) {
+	kind := p.l.next()
+	m := classMatch{kind: kind.token}
+
+	switch kind.token {
+	case VENDOR_CLASS, USER_CLASS:
+		val := p.l.next()
+		if val.token != STRING || val.value.(string) == "" {
+			return m, tokenErrorf(val, "Expected STRING, got %s", val.string())
+		}
+		m.value = []byte(val.value.(string))
+	case HOSTNAME:
+		val := p.l.next()
+		if val.token != STRING || val.value.(string) == "" {
+			return m, tokenErrorf(val, "Expected STRING, got %s", val.string())
+		}
+		m.pattern = strings.ToLower(val.value.(string))
+		if _, err := path.Match(m.pattern, ""); err != nil {
+			return m, tokenErrorf(val, "Invalid hostname pattern %s", m.pattern)
+		}
+	case HARDWARE_PREFIX:
+		val := p.l.next()
+		switch val.token {
+		case MAC_ADDRESS:
+			m.value = []byte(val.value.(net.HardwareAddr))
+		case STRING:
+			prefix, err := parseHardwarePrefix(val.value.(string))
+			if err != nil {
+				return m, tokenErrorf(val, "Invalid hardware prefix %s", val.value)
+			}
+			m.value = prefix
+		default:
+			return m, tokenErrorf(val, "Expected hardware address prefix, got %s", val.string())
+		}
+	case PARAMETER_LIST:
+		for _, code := range p.l.untilNext(EOL) {
+			if code.token == COMMENT {
+				continue
+			}
+			if code.token != NUMBER || code.value.(uint64) > 255 {
+				return m, tokenErrorf(code, "Expected option code, got %s", code.string())
+			}
+			m.value = append(m.value, byte(code.value.(uint64)))
+		}
+		if len(m.value) == 0 {
+			return m, tokenErrorf(kind, "Parameter list requires option codes")
+		}
+	default:
+		return m, tokenErrorf(kind, "Expected vendor-class, user-class, parameter-list, hardware-prefix or hostname, got %s", kind.string())
+	}
+	return m, nil
+}
+
+// parseHardwarePrefix parses 1 to 6 colon or hyphen separated hex bytes.
+func parseHardwarePrefix(s string) ([]byte, error) {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == '-' })
+	if len(parts) == 0 || len(parts) > 6 {
+		return nil, errors.New("invalid length")
+	}
+	prefix := make([]byte, len(parts))
+	for i, part := range parts {
+		b, err := strconv.ParseUint(part, 16, 8)
+		if err != nil || len(part) > 2 {
+			return nil, errors.New("invalid byte")
+		}
+		prefix[i] = byte(b)
+	}
+	return prefix, nil
+}
+
+// checkPoolClasses ensures every class named by a pool's allow and deny statements exists.
+func (p *parser) checkPoolClasses() error {
+	defined := make(map[string]bool, len(p.c.classes))
+	for _, cl := range p.c.classes {
+		defined[cl.name] = true
+	}
+
+	for _, n := range p.c.networks {
+		for _, s := range n.subnets {
+			for _, pl := range s.pools {
+				for _, name := range append(pl.allowClasses, pl.denyClasses...) {
+					if !defined[name] {
+						return parseErrorf(pl.pos, "Pool %s - %s in network %s uses undefined class %s", pl.rangeStart, pl.rangeEnd, n.name, name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseAuthoritative parses the optional boolean of an authoritative statement.
+// A bare authoritative is true.
+func (p *parser) parseAuthoritative() (bool, error) {
+	tok := p.l.next()
+	switch tok.token {
+	case BOOLEAN:
+		return tok.value.(bool), nil
+	case EOL, COMMENT, EOF:
+		p.l.unread()
+		return true, nil
+	}
+	return false, tokenErrorf(tok, "Expected boolean, got %s", tok.string())
+}
+
 func (p *parser) parseSettingsBlock() (*settings, error) {
 	s := newSettingsBlock()
 
@@ -343,32 +939,148 @@ func (p *parser) parseSetting(setBlock *settings) error {
 		if err != nil {
 			return err
 		}
+		if code == dhcp4.OptionVendorSpecificInformation {
+			// Each statement adds a sub-option, long options are split when sent
+			data = append(setBlock.options[code], data...)
+		}
 		setBlock.options[code] = data
 		return nil
 	case DEFAULT_LEASE_TIME:
 		tokn := p.l.next()
 		if tokn.token != NUMBER {
-			return fmt.Errorf("Expected number on line %d", tokn.line)
+			return tokenErrorf(tokn, "Expected number")
 		}
 		setBlock.defaultLeaseTime = time.Duration(tokn.value.(uint64)) * time.Second
 		return nil
 	case MAX_LEASE_TIME:
 		tokn := p.l.next()
 		if tokn.token != NUMBER {
-			return fmt.Errorf("Expected number on line %d", tokn.line)
+			return tokenErrorf(tokn, "Expected number")
 		}
 		setBlock.maxLeaseTime = time.Duration(tokn.value.(uint64)) * time.Second
 		return nil
 	case FREE_LEASE_AFTER:
 		tokn := p.l.next()
 		if tokn.token != NUMBER {
-			return fmt.Errorf("Expected number on line %d", tokn.line)
+			return tokenErrorf(tokn, "Expected number")
 		}
 		setBlock.freeLeaseAfter = time.Duration(tokn.value.(uint64)) * time.Second
+		setBlock.freeLeaseAfterPos = tok.position()
+		return nil
+	case PING_CHECK:
+		tokn := p.l.next()
+		if tokn.token != BOOLEAN {
+			return tokenErrorf(tokn, "Expected boolean")
+		}
+		setBlock.pingCheck = newBoolSetting(tokn.value.(bool))
+		return nil
+	case ALLOW_BOOTP, RAPID_COMMIT:
+		tokn := p.l.next()
+		if tokn.token != BOOLEAN {
+			return tokenErrorf(tokn, "Expected boolean")
+		}
+		if tok.token == ALLOW_BOOTP {
+			setBlock.allowBOOTP = newBoolSetting(tokn.value.(bool))
+		} else {
+			setBlock.rapidCommit = newBoolSetting(tokn.value.(bool))
+		}
+		return nil
+	case BOOTP_LEASE_TIME:
+		tokn := p.l.next()
+		if tokn.token == STRING && tokn.value.(string) == "infinite" {
+			setBlock.bootpLeaseTime = infiniteLeaseTime
+			return nil
+		}
+		if tokn.token != NUMBER || tokn.value.(uint64) == 0 {
+			return tokenErrorf(tokn, "Expected number or infinite")
+		}
+		setBlock.bootpLeaseTime = time.Duration(tokn.value.(uint64)) * time.Second
+		return nil
+	case DDNS_DOMAIN, DDNS_REVERSE_ZONE:
+		tokn := p.l.next()
+		if tokn.token != STRING {
+			return tokenErrorf(tokn, "Expected domain name")
+		}
+		zone := strings.ToLower(strings.TrimSuffix(tokn.value.(string), "."))
+		if zone == "" || strings.Contains(zone, "..") || strings.ContainsAny(zone, " \t") {
+			return tokenErrorf(tokn, "Invalid domain name %q", tokn.value.(string))
+		}
+		if tok.token == DDNS_DOMAIN {
+			setBlock.ddnsDomain = zone
+		} else {
+			setBlock.ddnsReverseZone = zone
+		}
+		return nil
+	case RENEWAL_RATIO, REBINDING_RATIO:
+		tokn := p.l.next()
+		if tokn.token != FLOAT || tokn.value.(float64) <= 0 || tokn.value.(float64) >= 1 {
+			return tokenErrorf(tokn, "Expected a ratio between 0 and 1")
+		}
+		if tok.token == RENEWAL_RATIO {
+			setBlock.renewalRatio = tokn.value.(float64)
+		} else {
+			setBlock.rebindingRatio = tokn.value.(float64)
+		}
+		if setBlock.renewalRatio > 0 && setBlock.rebindingRatio > 0 && setBlock.renewalRatio >= setBlock.rebindingRatio {
+			return tokenErrorf(tokn, "Renewal ratio must be less than the rebinding ratio")
+		}
+		return nil
+	case NEXT_SERVER:
+		tokn := p.l.next()
+		if tokn.token != IP_ADDRESS || tokn.value.(net.IP).To4() == nil {
+			return tokenErrorf(tokn, "Expected IPv4 address")
+		}
+		setBlock.nextServer = tokn.value.(net.IP).To4()
+		return nil
+	case SERVER_NAME:
+		tokn := p.l.next()
+		if tokn.token != STRING || tokn.value.(string) == "" {
+			return tokenErrorf(tokn, "Expected server name")
+		}
+		if len(tokn.value.(string)) > 64 {
+			return tokenErrorf(tokn, "Server name is longer than 64 bytes")
+		}
+		setBlock.serverName = tokn.value.(string)
+		return nil
+	case FILENAME, IPXE_FILENAME:
+		tokn := p.l.next()
+		if tokn.token != STRING || tokn.value.(string) == "" {
+			return tokenErrorf(tokn, "Expected file name")
+		}
+		file := tokn.value.(string)
+		if len(file) > 128 {
+			return tokenErrorf(tokn, "File name is longer than 128 bytes")
+		}
+		if tok.token == IPXE_FILENAME {
+			setBlock.ipxeFilename = file
+			return nil
+		}
+
+		archs := p.l.untilNext(EOL)
+		if len(archs) == 0 || archs[0].token == COMMENT {
+			setBlock.filename = file
+			return nil
+		}
+		if archs[0].token != ARCH {
+			return tokenErrorf(archs[0], "Expected arch, got %s", archs[0].string())
+		}
+		archs = archs[1:]
+		if len(archs) == 0 {
+			return tokenErrorf(tokn, "Expected architecture numbers")
+		}
+		for _, a := range archs {
+			if a.token == COMMENT {
+				continue
+			}
+			if a.token != NUMBER || a.value.(uint64) > 0xFFFF {
+				return tokenErrorf(a, "Expected architecture number, got %s", a.string())
+			}
+			setBlock.archFilenames[uint16(a.value.(uint64))] = file
+		}
 		return nil
 	}
 
-	return fmt.Errorf("Unexpected token %s on line %d in settings", tok.string(), tok.line)
+	return tokenErrorf(tok, "Unexpected token %s in settings", tok.string())
 }
 
 func (p *parser) parseOption() (dhcp4.OptionCode, []byte, error) {
@@ -379,50 +1091,157 @@ func (p *parser) parseOption() (dhcp4.OptionCode, []byte, error) {
 	}
 
 	n := tokens[0] // The first token is the name of the option
+	if n.token == HOSTNAME {
+		// The hostname option shares its name with the class match keyword
+		n.token, n.value = STRING, "hostname"
+	}
 	if n.token != STRING {
-		return 0, nil, fmt.Errorf("Invalid option name on line %d", n.line)
+		return 0, nil, tokenErrorf(n, "Invalid option name")
 	}
 
 	option := n.value.(string)
 	block, exists := options[option]
+	if !exists {
+		block, exists = p.definitions[option]
+	}
 	if !exists {
 		// Manual options take the form "option-xxx" where xxx is an integer < 255
 		p := strings.Split(option, "-")
 		if len(p) != 2 {
-			return 0, nil, fmt.Errorf("Option %s is not supported on line %d", option, n.line)
+			return 0, nil, tokenErrorf(n, "Option %s is not supported", option)
 		}
 		code, err := strconv.Atoi(p[1])
 		if err != nil || code > 255 {
-			return 0, nil, fmt.Errorf("Custom option code %s is not valid on line %d", p[1], n.line)
+			return 0, nil, tokenErrorf(n, "Custom option code %s is not valid", p[1])
 		}
 		// Use a custom option block that allows any parameters and any number of them
 		block = &dhcpOptionBlock{code: dhcp4.OptionCode(code), schema: anySchema}
 	}
 
-	if block.schema.multi != oneOrMore && len(tokens)-1 > int(block.schema.multi) {
-		return 0, nil, fmt.Errorf("Option %s requires %d parameters", option, block.schema.multi)
+	optionData, err := encodeOptionData(block, option, n, tokens[1:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if block.vendor {
+		// Vendor options are sent encapsulated in option 43
+		optionData, err = dhcp4.EncodeSubOption(nil, byte(block.code), optionData)
+		if err != nil {
+			return 0, nil, tokenErrorf(n, "Vendor option %s is longer than 255 bytes", option)
+		}
+		return dhcp4.OptionVendorSpecificInformation, optionData, nil
+	}
+	return block.code, optionData, nil
+}
+
+// parseOptionDefinition parses the statement
+// "option-definition [name] [vendor] code [code] type [type] [array]".
+// A definition can be used by any option statement after it.
+func (p *parser) parseOptionDefinition() error {
+	tokens := p.l.untilNext(EOL)
+	if len(tokens) > 0 && tokens[len(tokens)-1].token == COMMENT {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 || tokens[0].token != STRING {
+		return errors.New("Option definitions require a name")
+	}
+	name := tokens[0].value.(string)
+	nameToken := tokens[0]
+	if _, exists := options[name]; exists {
+		return tokenErrorf(nameToken, "Option %s is a standard option and can't be redefined", name)
+	}
+	if _, exists := p.definitions[name]; exists {
+		return tokenErrorf(nameToken, "Option %s already defined", name)
+	}
+	if strings.HasPrefix(name, "option-") {
+		return tokenErrorf(nameToken, "Option names starting with option- are reserved")
+	}
+
+	block := &dhcpOptionBlock{}
+	tokens = tokens[1:]
+	if len(tokens) > 0 && tokens[0].token == STRING && tokens[0].value.(string) == "vendor" {
+		block.vendor = true
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) < 4 || tokens[0].token != STRING || tokens[0].value.(string) != "code" ||
+		tokens[1].token != NUMBER || tokens[2].token != STRING || tokens[2].value.(string) != "type" ||
+		tokens[3].token != STRING {
+		return tokenErrorf(nameToken, "Expected code [code] type [type]")
+	}
+	code := tokens[1].value.(uint64)
+	if code < 1 || code > 254 {
+		return tokenErrorf(nameToken, "Option code must be between 1 and 254")
+	}
+	block.code = dhcp4.OptionCode(code)
+	for _, d := range p.definitions {
+		if d.code == block.code && d.vendor == block.vendor {
+			return tokenErrorf(nameToken, "Option code %d already defined", code)
+		}
+	}
+
+	array := false
+	switch {
+	case len(tokens) == 5 && tokens[4].token == STRING && tokens[4].value.(string) == "array":
+		array = true
+	case len(tokens) > 4:
+		return tokenErrorf(nameToken, "Unexpected token %s in option definition", tokens[4].string())
+	}
+
+	typ := tokens[3].value.(string)
+	schema, ok := newDefinitionSchema(typ, array)
+	if !ok {
+		if array {
+			return tokenErrorf(nameToken, "Option type %s array is not supported", typ)
+		}
+		return tokenErrorf(nameToken, "Option type %s is not supported", typ)
+	}
+	block.schema = schema
+
+	p.definitions[name] = block
+	return nil
+}
+
+// encodeOptionData converts the parameters of option to its wire format.
+func encodeOptionData(block *dhcpOptionBlock, option string, name *lexToken, params []*lexToken) ([]byte, error) {
+	if params[0].token == HEX {
+		// Raw option data for any option
+		if len(params) != 2 || params[1].token != STRING {
+			return nil, tokenErrorf(name, "Expected hex string")
+		}
+		optionData, err := parseHexString(params[1].value.(string))
+		if err != nil {
+			return nil, tokenErrorf(name, "Invalid hex string: %s", err)
+		}
+		return optionData, nil
+	}
+
+	if block.schema.encode != nil {
+		return block.schema.encode(params)
+	}
+
+	if block.schema.multi != oneOrMore && len(params) > int(block.schema.multi) {
+		return nil, tokenErrorf(name, "Option %s requires %d parameters", option, block.schema.multi)
 	}
 
 	var optionData []byte
-	for _, tok := range tokens[1:] {
+	for _, tok := range params {
 		if block.schema.token != ANY && tok.token != block.schema.token {
-			return 0, nil, fmt.Errorf("Expected %s, got %s on line %d", block.schema.token.string(), tok.token.string(), tok.line)
+			return nil, tokenErrorf(tok, "Expected %s, got %s", block.schema.token.string(), tok.token.string())
 		}
 		switch t := tok.value.(type) {
 		case uint64:
-			buf := make([]byte, 8)
-			written := binary.PutUvarint(buf, t)
-			if written > int(block.schema.maxlen) {
-				return 0, nil, fmt.Errorf("Number is too big on line %d", tok.line)
+			// Numbers are big endian and as wide as the schema's unit
+			width := uint(block.schema.multipleOf)
+			if width < 8 && t>>(8*width) > 0 {
+				return nil, tokenErrorf(tok, "Number is too big")
 			}
-			optionData = append(optionData, buf...)
+			optionData = appendUint(optionData, t, width)
 		case int64:
-			buf := make([]byte, 8)
-			written := binary.PutVarint(buf, t)
-			if written > int(block.schema.maxlen) {
-				return 0, nil, fmt.Errorf("Number is too big on line %d", tok.line)
+			width := uint(block.schema.multipleOf)
+			if width < 8 && t < -(1<<(8*width-1)) {
+				return nil, tokenErrorf(tok, "Number is too big")
 			}
-			optionData = append(optionData, buf...)
+			optionData = appendUint(optionData, uint64(t), width)
 		case string:
 			optionData = append(optionData, []byte(t)...)
 		case bool:
@@ -439,10 +1258,18 @@ func (p *parser) parseOption() (dhcp4.OptionCode, []byte, error) {
 	}
 
 	if block.schema.maxlen != unlimited && len(optionData) > int(block.schema.maxlen) {
-		return 0, nil, fmt.Errorf("Incorrect option length on line %d", n.line)
+		return nil, tokenErrorf(name, "Incorrect option length")
 	}
 	if len(optionData)%block.schema.multipleOf != 0 {
-		return 0, nil, fmt.Errorf("Incorrect option length on line %d", n.line)
+		return nil, tokenErrorf(name, "Incorrect option length")
 	}
-	return block.code, optionData, nil
+	return optionData, nil
+}
+
+// appendUint appends the lowest width bytes of v to b in network byte order.
+func appendUint(b []byte, v uint64, width uint) []byte {
+	for i := width; i > 0; i-- {
+		b = append(b, byte(v>>(8*(i-1))))
+	}
+	return b
 }
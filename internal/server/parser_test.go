@@ -4,7 +4,26 @@
 
 package server
 
-import "testing"
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/dhcp6"
+)
+
+// assertParseErrors checks that each configuration in confs fails parsing.
+func assertParseErrors(t *testing.T, confs ...string) {
+	for _, conf := range confs {
+		if _, err := newParser(bufio.NewReader(strings.NewReader(conf)), "").parse(); err == nil {
+			t.Errorf("Expected an error for config %q, got nil", conf)
+		}
+	}
+}
 
 func TestParser(t *testing.T) {
 	// TODO: Actually check the underlying config to make sure it matches the parsed config
@@ -12,6 +31,12 @@ func TestParser(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	// The last line doesn't need a line end
+	conf := "global\n    server-identifier 192.168.0.1\n    default-lease-time 360\nend"
+	if _, err := newParser(bufio.NewReader(strings.NewReader(conf)), "").parse(); err != nil {
+		t.Errorf("Config without a final line end failed parsing: %v", err)
+	}
 }
 
 func TestIncludedConfigs(t *testing.T) {
@@ -24,4 +49,509 @@ func TestIncludedConfigs(t *testing.T) {
 	if len(c.networks) != 3 {
 		t.Fatalf("Incorrect number of networks. Expected 3, got %d", len(c.networks))
 	}
+
+	// Lines are counted per file and includes are relative to the including file
+	if pos := c.networks["two"].pos.String(); pos != "testdata/includedConfig.conf:1:9" {
+		t.Errorf("Incorrect position of included network, got %s", pos)
+	}
+	if pos := c.networks["three"].pos.String(); pos != "./testdata/includeConfig.conf:13:9" {
+		t.Errorf("Incorrect position of network after include, got %s", pos)
+	}
+}
+
+func TestIncludedGlobConfigs(t *testing.T) {
+	c, err := ParseFile("./testdata/includeGlobConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A pattern matching nothing isn't an error
+	if len(c.networks) != 2 {
+		t.Fatalf("Incorrect number of networks. Expected 2, got %d", len(c.networks))
+	}
+	if pos := c.networks["west"].pos.String(); pos != "testdata/buildings/west.conf:1:9" {
+		t.Errorf("Incorrect position of included network, got %s", pos)
+	}
+
+	// A missing file is an error
+	assertParseErrors(t, `include "./testdata/buildings/north.conf"`)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := ParseFile("./testdata/includeErrorConfig.conf")
+	if err == nil {
+		t.Fatal("Bad included config parsed without error")
+	}
+	expected := `testdata/buildings/broken.inc:3:25: Expected IP address, got STRING: bogus
+	included from testdata/buildings/lab.inc:2:1
+	included from ./testdata/includeErrorConfig.conf:5:1`
+	if err.Error() != expected {
+		t.Errorf("Incorrect error, expected:\n%s\ngot:\n%s", expected, err)
+	}
+	if pe, ok := err.(*ParseError); !ok || pe.Line != 3 || pe.Column != 25 || len(pe.IncludedFrom) != 2 {
+		t.Errorf("Expected a ParseError on line 3 column 25 with two includes, got %#v", err)
+	}
+
+	_, err = ParseFile("./testdata/includeCycleConfig.conf")
+	if err == nil || !strings.Contains(err.Error(), "Include cycle") {
+		t.Errorf("Expected an include cycle error, got %v", err)
+	}
+
+	conf := "global\n    server-identifier 192.168.0.1\n    default-lease-time forever\nend"
+	_, err = newParser(bufio.NewReader(strings.NewReader(conf)), "").parse()
+	if err == nil || err.Error() != "line 3:24: Expected number" {
+		t.Errorf("Incorrect error without a file name, got %v", err)
+	}
+}
+
+func TestHostConfig(t *testing.T) {
+	c, err := ParseFile("./testdata/hostConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := c.networks["network1"]
+	if len(n.hosts) != 3 {
+		t.Fatalf("Incorrect number of hosts. Expected 3, got %d", len(n.hosts))
+	}
+
+	mac, _ := net.ParseMAC("ab:cd:ef:00:00:01")
+	h := n.getHostByMAC(mac)
+	if h == nil {
+		t.Fatal("Host printer not found")
+	}
+	if !h.fixedAddress.Equal(net.ParseIP("10.0.1.10")) {
+		t.Errorf("Incorrect fixed address. Expected 10.0.1.10, got %s", h.fixedAddress)
+	}
+	if h.subnet != n.subnets[0] {
+		t.Error("Host printer has the wrong subnet")
+	}
+	if !bytes.Equal(h.getOptions()[dhcp4.OptionDomainName], []byte("printers.example.com")) {
+		t.Errorf("Incorrect domain name. Got %s", h.getOptions()[dhcp4.OptionDomainName])
+	}
+	if !bytes.Equal(h.getOptions()[dhcp4.OptionRouter], []byte{10, 0, 1, 1}) {
+		t.Errorf("Host didn't inherit subnet options. Got %v", h.getOptions()[dhcp4.OptionRouter])
+	}
+
+	if h := n.getHostByIP(net.ParseIP("10.0.1.11")); h == nil || h.getLeaseTime(0) != 7200*time.Second {
+		t.Error("Host switch not found or has the wrong lease time")
+	}
+	if h := n.getHostByIP(net.ParseIP("10.0.1.250")); h == nil || h.name != "globalprinter" {
+		t.Error("Global host globalprinter was not placed in network1")
+	}
+}
+
+var badHostConfigs = []string{
+	// Missing fixed-address
+	`network one
+	subnet 10.0.1.0/24
+		range 10.0.1.10 10.0.1.20
+		host a
+			hardware-address 12:34:56:00:00:01
+		end
+	end
+end
+`,
+	// Fixed address outside subnet
+	`network one
+	subnet 10.0.1.0/24
+		range 10.0.1.10 10.0.1.20
+		host a
+			hardware-address 12:34:56:00:00:01
+			fixed-address 10.0.2.10
+		end
+	end
+end
+`,
+	// Duplicate hardware address
+	`network one
+	host a
+		hardware-address 12:34:56:00:00:01
+		fixed-address 10.0.1.5
+	end
+	host b
+		hardware-address 12:34:56:00:00:01
+		fixed-address 10.0.1.6
+	end
+	subnet 10.0.1.0/24
+		range 10.0.1.10 10.0.1.20
+	end
+end
+`,
+	// Global host not in any network
+	`global
+	host a
+		hardware-address 12:34:56:00:00:01
+		fixed-address 10.0.2.10
+	end
+end
+network one
+	subnet 10.0.1.0/24
+		range 10.0.1.10 10.0.1.20
+	end
+end
+`,
+}
+
+func TestBadHostConfigs(t *testing.T) {
+	assertParseErrors(t, badHostConfigs...)
+}
+
+func TestRelayMatchConfig(t *testing.T) {
+	c, err := ParseFile("./testdata/relayConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := c.networks["building1"]
+	if len(n.relayMatches) != 2 {
+		t.Fatalf("Expected 2 network match statements, got %d", len(n.relayMatches))
+	}
+	if n.relayMatches[1].subOption != dhcp4.RelayCircuitID || string(n.relayMatches[1].value) != "eth0/2" {
+		t.Errorf("Incorrect network match statement %#v", n.relayMatches[1])
+	}
+
+	pools := n.subnets[0].pools
+	if len(pools[0].relayMatches) != 1 || pools[0].relayMatches[0].subOption != dhcp4.RelayRemoteID {
+		t.Errorf("Incorrect pool match statements %#v", pools[0].relayMatches)
+	}
+	if len(pools[1].relayMatches) != 0 {
+		t.Errorf("Expected no match statements on open pool, got %#v", pools[1].relayMatches)
+	}
+
+	if len(c.relayNetworks) != 2 || c.relayNetworks[0] != n || c.relayNetworks[1] != c.networks["building2"] {
+		t.Errorf("Relay networks not in definition order: %v", c.relayNetworks)
+	}
+}
+
+var badRelayMatchConfigs = []string{
+	// Unknown sub-option
+	`network n1
+	match agent-id "eth0/1"
+end
+`,
+	// Missing value
+	`network n1
+	match circuit-id
+end
+`,
+	// Not allowed in a registered block
+	`network n1
+	registered
+		match circuit-id "eth0/1"
+	end
+end
+`,
+}
+
+func TestBadRelayMatchConfigs(t *testing.T) {
+	assertParseErrors(t, badRelayMatchConfigs...)
+}
+
+func TestLeaseBindingConfig(t *testing.T) {
+	c, err := ParseFile("./testdata/clientIDConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.networks["network1"].leaseBinding != bindClientIDFirst {
+		t.Error("Expected network1 to default to client-id-first")
+	}
+	if c.networks["network2"].leaseBinding != bindMACOnly {
+		t.Error("Expected network2 to be mac-only")
+	}
+
+	// Unknown binding
+	assertParseErrors(t, "network n1\n\tlease-binding client-id-only\nend\n")
+}
+
+func TestSubnet6Config(t *testing.T) {
+	c, err := ParseFile("./testdata/dhcp6Config.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.hasIPv6() {
+		t.Fatal("Expected config to have IPv6 subnets")
+	}
+
+	campus := c.networks["campus"]
+	if len(campus.subnets6) != 2 {
+		t.Fatalf("Expected 2 subnet6 blocks, got %d", len(campus.subnets6))
+	}
+	reg := campus.subnets6[0]
+	if reg.allowUnknown {
+		t.Error("Expected first subnet6 to be registered")
+	}
+	if !reg.pools[0].rangeStart.Equal(net.ParseIP("2001:db8:1::100")) || !reg.pools[0].rangeEnd.Equal(net.ParseIP("2001:db8:1::1ff")) {
+		t.Errorf("Incorrect range6 %s - %s", reg.pools[0].rangeStart, reg.pools[0].rangeEnd)
+	}
+	dns := append(net.ParseIP("2001:db8::53").To16(), net.ParseIP("2001:db8::54").To16()...)
+	if !bytes.Equal(reg.options[dhcp6.OptionDNSServers], dns) {
+		t.Errorf("Incorrect dns-servers %v", reg.options[dhcp6.OptionDNSServers])
+	}
+	if !campus.subnets6[1].allowUnknown || campus.subnets6[1].getLeaseTime(0, false) != 120*time.Second {
+		t.Error("Incorrect unregistered subnet6")
+	}
+
+	if n := c.searchNetworksFor6(net.ParseIP("fd00:10::1")); n == nil || n.name != "building" {
+		t.Errorf("Expected network building for relay link fd00:10::1, got %v", n)
+	}
+	if n := c.searchNetworksFor6(nil); n == nil || n.name != "campus" {
+		t.Errorf("Expected local network campus for direct clients, got %v", n)
+	}
+}
+
+func TestBadSubnet6Configs(t *testing.T) {
+	bad := []string{
+		"network n1\n\tsubnet6 10.0.0.0/24\n\tend\nend\n",
+		"network n1\n\tsubnet 2001:db8::/64\n\tend\nend\n",
+		"network n1\n\tsubnet6 2001:db8::/64\n\t\trange6 2001:db9::1 2001:db9::10\n\tend\nend\n",
+		"network n1\n\tsubnet6 2001:db8::/64\n\t\trange6 2001:db8::10 2001:db8::1\n\tend\nend\n",
+		"network n1\n\tsubnet6 2001:db8::/64\n\t\toption6 dns-servers 10.0.0.1\n\tend\nend\n",
+		"network n1\n\tsubnet6 2001:db8::/64\n\t\toption router 10.0.0.1\n\tend\nend\n",
+	}
+	assertParseErrors(t, bad...)
+}
+
+func TestDDNSConfig(t *testing.T) {
+	c, err := ParseFile("./testdata/ddnsConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain, reverse := c.networks["network1"].ddnsZones(net.ParseIP("10.0.1.10"), false)
+	if domain != "example.com" || reverse != "1.0.10.in-addr.arpa" {
+		t.Errorf("Incorrect zones %q %q", domain, reverse)
+	}
+	if domain, reverse := c.networks["network2"].ddnsZones(net.ParseIP("10.0.2.10"), false); domain != "" || reverse != "" {
+		t.Errorf("Expected no zones, got %q %q", domain, reverse)
+	}
+
+	bad := []string{
+		"network n1\n\tddns-domain\nend\n",
+		"network n1\n\tddns-domain \"example..com\"\nend\n",
+		"network n1\n\tddns-reverse-zone 10.0.0.1\nend\n",
+	}
+	assertParseErrors(t, bad...)
+}
+
+func TestClassConfig(t *testing.T) {
+	c, err := ParseFile("./testdata/classConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.classes) != 3 || c.classes[0].name != "phones" || c.classes[2].name != "printers" {
+		t.Fatalf("Incorrect classes %#v", c.classes)
+	}
+	phones := c.classes[0]
+	if len(phones.matches) != 2 || !bytes.Equal(phones.matches[1].value, []byte{0x00, 0x04, 0xf2}) {
+		t.Errorf("Incorrect phone class matches %#v", phones.matches)
+	}
+	if prl := c.classes[2].matches[1]; prl.kind != PARAMETER_LIST || !bytes.Equal(prl.value, []byte{1, 3, 6}) {
+		t.Errorf("Incorrect parameter list match %#v", prl)
+	}
+	if string(c.global.settings.options[dhcp4.OptionHostName]) != "pg-dhcp" {
+		t.Error("Expected hostname option to still parse")
+	}
+
+	pools := c.networks["network1"].subnets[0].pools
+	if len(pools[0].allowClasses) != 1 || pools[0].allowClasses[0] != "phones" {
+		t.Errorf("Incorrect allowed classes %#v", pools[0].allowClasses)
+	}
+	if len(pools[1].denyClasses) != 2 {
+		t.Errorf("Incorrect denied classes %#v", pools[1].denyClasses)
+	}
+}
+
+func TestBadClassConfigs(t *testing.T) {
+	bad := []string{
+		"class \"empty\"\nend\n",
+		"class \"a\"\n\tmatch vendor-class \"x\"\nend\nclass \"A\"\n\tmatch vendor-class \"y\"\nend\n",
+		"class \"a\"\n\tmatch circuit-id \"x\"\nend\n",
+		"class \"a\"\n\tmatch hardware-prefix \"00:04:f2:zz\"\nend\n",
+		"class \"a\"\n\tmatch parameter-list 1 300\nend\n",
+		"class \"a\"\n\tmatch hostname \"[x\"\nend\n",
+		"network n1\n\tsubnet 10.0.1.0/24\n\t\tpool\n\t\t\tallow \"missing\"\n\t\t\trange 10.0.1.10 10.0.1.20\n\t\tend\n\tend\nend\n",
+	}
+	assertParseErrors(t, bad...)
+}
+
+func TestBadBootConfigs(t *testing.T) {
+	bad := []string{
+		"network n1\n\tnext-server \"tftp\"\nend\n",
+		"network n1\n\tnext-server 2001:db8::1\nend\n",
+		"network n1\n\tfilename\nend\n",
+		"network n1\n\tfilename \"boot.efi\" 7\nend\n",
+		"network n1\n\tfilename \"boot.efi\" arch\nend\n",
+		"network n1\n\tfilename \"boot.efi\" arch efi\nend\n",
+		"network n1\n\tserver-name \"" + strings.Repeat("a", 65) + "\"\nend\n",
+	}
+	assertParseErrors(t, bad...)
+}
+
+func TestAuthoritativeConfig(t *testing.T) {
+	c, err := ParseFile("./testdata/authoritativeConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.global.authoritative {
+		t.Error("Expected global to be authoritative")
+	}
+	if c.networks["lab"].isAuthoritative() {
+		t.Error("Expected network lab to not be authoritative")
+	}
+	if !c.networks["owned"].isAuthoritative() {
+		t.Error("Expected network owned to inherit authoritative")
+	}
+}
+
+func TestBadAuthoritativeConfigs(t *testing.T) {
+	bad := []string{
+		"global\n\tauthoritative yes\nend\n",
+		"network n1\n\tauthoritative 1\nend\n",
+		"network n1\n\tregistered\n\t\tauthoritative\n\tend\nend\n",
+		"network n1\n\tsubnet 10.0.1.0/24\n\t\tauthoritative\n\tend\nend\n",
+	}
+	assertParseErrors(t, bad...)
+}
+
+func TestTrustedRelayConfig(t *testing.T) {
+	c, err := ParseFile("./testdata/linkSelectionConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.global.trustsRelay(net.IP{10, 255, 0, 2}) {
+		t.Error("Expected relay 10.255.0.2 to be trusted")
+	}
+	if c.global.trustsRelay(net.IP{10, 0, 1, 1}) {
+		t.Error("Expected relay 10.0.1.1 to not be trusted")
+	}
+
+	bad := []string{
+		"global\n\ttrusted-relay\nend\n",
+		"global\n\ttrusted-relay 10.0.0.1 fe80::1\nend\n",
+		"network n1\n\ttrusted-relay 10.0.0.1\nend\n",
+	}
+	assertParseErrors(t, bad...)
+}
+
+func TestStructuredOptions(t *testing.T) {
+	c, err := ParseFile("./testdata/optionsConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := c.global.settings.options[dhcp4.OptionDomainSearch]
+	if expected := []byte("\x03eng\x07example\x03com\x00\x05sales\xc0\x04"); !bytes.Equal(search, expected) {
+		t.Errorf("Expected domain search %q, got %q", expected, search)
+	}
+
+	opts := c.networks["network1"].settings.options
+	routes := []byte{8, 10, 10, 0, 1, 1, 0, 10, 0, 1, 254}
+	if !bytes.Equal(opts[dhcp4.OptionClasslessRouteFormat], routes) {
+		t.Errorf("Expected routes %v, got %v", routes, opts[dhcp4.OptionClasslessRouteFormat])
+	}
+	if !bytes.Equal(opts[dhcp4.OptionMSClasslessRouteFormat], routes) {
+		t.Errorf("Expected routes %v, got %v", routes, opts[dhcp4.OptionMSClasslessRouteFormat])
+	}
+
+	vendor := []byte{6, 1, 3, 8, 7, 0x80, 0, 1, 10, 0, 0, 5, 1, 4, 10, 0, 0, 5}
+	if !bytes.Equal(opts[dhcp4.OptionVendorSpecificInformation], vendor) {
+		t.Errorf("Expected vendor options %v, got %v", vendor, opts[dhcp4.OptionVendorSpecificInformation])
+	}
+	if !bytes.Equal(opts[250], []byte{1, 2, 0xff}) {
+		t.Errorf("Expected raw option, got %v", opts[250])
+	}
+}
+
+func TestBadStructuredOptions(t *testing.T) {
+	bad := []string{
+		"global\n\toption classless-static-route 10.0.0.0/8\nend\n",
+		"global\n\toption classless-static-route 10.0.0.1/8 10.0.1.1\nend\n",
+		"global\n\toption classless-static-route 10.0.0.0 10.0.1.1 10.0.1.2\nend\n",
+		"global\n\toption domain-search \"a..com\"\nend\n",
+		"global\n\toption domain-search 10.0.0.1\nend\n",
+		"global\n\toption vendor-specific-information 255 1\nend\n",
+		"global\n\toption vendor-specific-information 1 300\nend\n",
+		"global\n\toption vendor-specific-information 1 hex\nend\n",
+		"global\n\toption option-250 hex \"0g\"\nend\n",
+		"global\n\toption option-250 hex \"012\"\nend\n",
+	}
+	assertParseErrors(t, bad...)
+}
+
+func TestLongOptionConfig(t *testing.T) {
+	// Options longer than 255 bytes are split when sent, RFC 3396
+	conf := "global\n\toption option-250 hex \"" + strings.Repeat("01", 300) + "\"\nend\n"
+	c, err := newParser(bufio.NewReader(strings.NewReader(conf)), "").parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.global.settings.options[250]) != 300 {
+		t.Errorf("Expected 300 byte option, got %d bytes", len(c.global.settings.options[250]))
+	}
+}
+
+func TestOptionDefinitions(t *testing.T) {
+	c, err := ParseFile("./testdata/definitionConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	global := c.global.settings.options
+	if string(global[252]) != "http://wpad.example.com/wpad.dat" {
+		t.Errorf("Incorrect custom string option %q", global[252])
+	}
+	if !bytes.Equal(global[dhcp4.OptionInterfaceMTU], []byte{0x05, 0xdc}) {
+		t.Errorf("Incorrect interface MTU %v", global[dhcp4.OptionInterfaceMTU])
+	}
+	if !bytes.Equal(global[dhcp4.OptionTimeOffset], []byte{0xff, 0xff, 0xb9, 0xb0}) {
+		t.Errorf("Incorrect time offset %v", global[dhcp4.OptionTimeOffset])
+	}
+
+	opts := c.networks["network1"].settings.options
+	if !bytes.Equal(opts[224], []byte{0x13, 0xc4, 0x13, 0xc5}) {
+		t.Errorf("Incorrect custom array option %v", opts[224])
+	}
+	if !bytes.Equal(opts[225], []byte{1}) {
+		t.Errorf("Incorrect custom boolean option %v", opts[225])
+	}
+	vendor := []byte{6, 1, 3, 8, 4, 10, 0, 0, 5}
+	if !bytes.Equal(opts[dhcp4.OptionVendorSpecificInformation], vendor) {
+		t.Errorf("Expected vendor options %v, got %v", vendor, opts[dhcp4.OptionVendorSpecificInformation])
+	}
+
+	// Options set in the network root are sent to clients
+	sent := c.networks["network1"].getPoolOfIP(net.IP{10, 0, 1, 10}).getOptions(false)
+	for _, code := range []dhcp4.OptionCode{224, 225, 252, dhcp4.OptionVendorSpecificInformation} {
+		if _, ok := sent[code]; !ok {
+			t.Errorf("Option %d not sent to clients", code)
+		}
+	}
+}
+
+func TestBadOptionDefinitions(t *testing.T) {
+	bad := []string{
+		"option-definition router code 200 type ip-address\n",
+		"option-definition a code 200 type uint8\noption-definition a code 201 type uint8\n",
+		"option-definition a code 200 type uint8\noption-definition b code 200 type uint8\n",
+		"option-definition a code 255 type uint8\n",
+		"option-definition a code 200 type float\n",
+		"option-definition a code 200 type string array\n",
+		"option-definition a code 200\n",
+		"option-definition option-200 code 200 type uint8\n",
+		"option-definition a code 200 type uint8\nglobal\n\toption a 256\nend\n",
+		"option-definition a code 200 type uint8\nglobal\n\toption a 1 2\nend\n",
+		"global\n\toption a 1\nend\noption-definition a code 200 type uint8\n",
+	}
+	assertParseErrors(t, bad...)
+}
+
+func TestBadRenewalRatios(t *testing.T) {
+	bad := []string{
+		"global\n\trenewal-ratio 1.5\nend\n",
+		"global\n\trenewal-ratio 1\nend\n",
+		"global\n\trebinding-ratio 0.0\nend\n",
+		"global\n\trenewal-ratio 0.9\n\trebinding-ratio 0.5\nend\n",
+	}
+	assertParseErrors(t, bad...)
 }
@@ -0,0 +1,98 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPingTimeout is used when ServerConfig.PingTimeout isn't set.
+const DefaultPingTimeout = time.Second
+
+// maxPingAttempts is the number of candidate addresses probed for a single
+// DISCOVER before giving up.
+const maxPingAttempts = 5
+
+// A Pinger checks if an address is already in use before it's offered.
+type Pinger interface {
+	// Ping returns true if ip responded within timeout.
+	Ping(ip net.IP, timeout time.Duration) (bool, error)
+}
+
+// icmpPinger sends ICMP echo requests using a raw socket. It requires
+// root or the CAP_NET_RAW capability.
+type icmpPinger struct {
+	id  uint16
+	seq uint32
+}
+
+func newICMPPinger() *icmpPinger {
+	return &icmpPinger{id: uint16(os.Getpid())}
+}
+
+func (p *icmpPinger) Ping(ip net.IP, timeout time.Duration) (bool, error) {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	seq := uint16(atomic.AddUint32(&p.seq, 1))
+	msg := []byte{
+		8, 0, // Echo request, code 0
+		0, 0, // Checksum
+		byte(p.id >> 8), byte(p.id),
+		byte(seq >> 8), byte(seq),
+		'p', 'g', '-', 'd', 'h', 'c', 'p',
+	}
+	sum := icmpChecksum(msg)
+	msg[2], msg[3] = byte(sum>>8), byte(sum)
+
+	if _, err := conn.WriteTo(msg, &net.IPAddr{IP: ip}); err != nil {
+		return false, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+
+		// Every ICMP packet received by the host is read, only accept our echo reply
+		if n < 8 || buf[0] != 0 {
+			continue
+		}
+		if from, ok := addr.(*net.IPAddr); !ok || !from.IP.Equal(ip) {
+			continue
+		}
+		if uint16(buf[4])<<8|uint16(buf[5]) != p.id || uint16(buf[6])<<8|uint16(buf[7]) != seq {
+			continue
+		}
+		return true, nil
+	}
+}
+
+// icmpChecksum is the Internet checksum from RFC 1071.
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,21 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import "testing"
+
+func TestICMPChecksum(t *testing.T) {
+	// Echo request, id 1, seq 1, no data
+	msg := []byte{8, 0, 0, 0, 0, 1, 0, 1}
+	if sum := icmpChecksum(msg); sum != 0xf7fd {
+		t.Errorf("Expected checksum 0xf7fd, got %#x", sum)
+	}
+
+	// A message with its checksum filled in sums to 0
+	msg[2], msg[3] = 0xf7, 0xfd
+	if sum := icmpChecksum(msg); sum != 0 {
+		t.Errorf("Expected checksum 0, got %#x", sum)
+	}
+}
@@ -13,6 +13,7 @@ import (
 )
 
 type pool struct {
+	pos           position
 	rangeStart    net.IP
 	rangeEnd      net.IP
 	settings      *settings
@@ -21,6 +22,9 @@ type pool struct {
 	subnet        *subnet
 	nextFreeStart int
 	ipsInPool     int
+	relayMatches  relayMatches
+	allowClasses  []string
+	denyClasses   []string
 }
 
 func newPool() *pool {
@@ -58,13 +62,7 @@ func (p *pool) getLeaseTime(req time.Duration, registered bool) time.Duration {
 		return p.settings.maxLeaseTime
 	}
 
-	// Save the result for later
-	p.settings.maxLeaseTime = p.subnet.getLeaseTime(req, registered)
-
-	if req <= p.settings.maxLeaseTime {
-		return req
-	}
-	return p.settings.maxLeaseTime
+	return p.subnet.getLeaseTime(req, registered)
 }
 
 func (p *pool) getOptions(registered bool) dhcp4.Options {
@@ -92,6 +90,9 @@ func (p *pool) getFreeLease(s *ServerConfig) *models.Lease {
 		if l.IsAbandoned { // IP in use by a device we don't know about
 			continue
 		}
+		if p.subnet.network.isReserved(l.IP) { // IP belongs to a host
+			continue
+		}
 		if l.End.After(now) { // Active lease
 			continue
 		}
@@ -119,6 +120,11 @@ func (p *pool) getFreeLease(s *ServerConfig) *models.Lease {
 			continue
 		}
 
+		// Fixed addresses are never given to other clients
+		if p.subnet.network.isReserved(next) {
+			continue
+		}
+
 		// IP has no lease with it, no lock since this is a new object
 		// and guarenteed to not be anywhere else yet.
 		l := models.NewLease()
@@ -133,6 +139,15 @@ func (p *pool) getFreeLease(s *ServerConfig) *models.Lease {
 	return nil
 }
 
+// removeLease forgets the lease of ip. The address is handed out again as if it
+// had never been leased.
+func (p *pool) removeLease(ip net.IP) {
+	delete(p.leases, ip.String())
+	if i := dhcp4.IPRange(p.rangeStart, ip) - 1; i < p.nextFreeStart {
+		p.nextFreeStart = i
+	}
+}
+
 func (p *pool) getFreeLeaseDesperate(s *ServerConfig) *models.Lease {
 	now := time.Now()
 
@@ -143,6 +158,9 @@ func (p *pool) getFreeLeaseDesperate(s *ServerConfig) *models.Lease {
 		if l.End.After(now) { // Skip active leases
 			continue
 		}
+		if p.subnet.network.isReserved(l.IP) {
+			continue
+		}
 
 		if longestExpiredLease == nil {
 			longestExpiredLease = l
@@ -161,14 +179,42 @@ func (p *pool) getFreeLeaseDesperate(s *ServerConfig) *models.Lease {
 	// Now we're getting desperate
 	// Check abandoned leases for availability
 	for _, l := range p.leases {
-		if l.IsAbandoned { // Skip non-abandoned leases
-			l.IsAbandoned = false
+		if l.IsAbandoned && !p.subnet.network.isReserved(l.IP) { // Skip non-abandoned leases
+			l.ClearAbandoned()
 			return l
 		}
 	}
 	return nil
 }
 
+// pingCheckEnabled returns if addresses must be probed before being offered
+// from this pool.
+func (p *pool) pingCheckEnabled(registered bool) bool {
+	if p.settings.pingCheck != boolUnset {
+		return p.settings.pingCheck == boolTrue
+	}
+	return p.subnet.getSettings(registered).pingCheck == boolTrue
+}
+
+// bootpAllowed returns if BOOTP clients may get an address from this pool.
+func (p *pool) bootpAllowed(registered bool) bool {
+	if p.settings.allowBOOTP != boolUnset {
+		return p.settings.allowBOOTP == boolTrue
+	}
+	return p.subnet.getSettings(registered).allowBOOTP == boolTrue
+}
+
+// scopes returns the pool's settings followed by the merged settings of its subnet.
+func (p *pool) scopes(registered bool) []*settings {
+	return []*settings{p.settings, p.subnet.getSettings(registered)}
+}
+
+// matchesRelay returns if a client with relay information r may get a lease from
+// the pool. Pools without match statements are open to all clients.
+func (p *pool) matchesRelay(r *dhcp4.RelayAgentInformation) bool {
+	return len(p.relayMatches) == 0 || p.relayMatches.matches(r)
+}
+
 func (p *pool) includes(ip net.IP) bool {
 	return dhcp4.IPInRange(p.rangeStart, p.rangeEnd, ip)
 }
@@ -0,0 +1,142 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+type pool6 struct {
+	pos        position
+	rangeStart net.IP
+	rangeEnd   net.IP
+	leases     map[string]*models.Lease // IP -> Lease
+	subnet     *subnet6
+	nextFree   net.IP // Next address that has never been leased
+}
+
+func newPool6() *pool6 {
+	return &pool6{
+		leases: make(map[string]*models.Lease),
+	}
+}
+
+func (p *pool6) getFreeLease() *models.Lease {
+	now := time.Now()
+
+	regFreeTime := p.subnet.network.global.registeredSettings.freeLeaseAfter
+	unRegFreeTime := p.subnet.network.global.unregisteredSettings.freeLeaseAfter
+	// Find a candidate from the already used leases
+	for _, l := range p.leases {
+		if l.IsAbandoned || l.End.After(now) {
+			continue
+		}
+		if l.Offered { // Lease was offered but not taken
+			l.Offered = false
+			return l
+		}
+		if !l.Registered && l.End.Add(unRegFreeTime).Before(now) {
+			return l
+		}
+		if l.Registered && l.End.Add(regFreeTime).Before(now) {
+			return l
+		}
+	}
+
+	// No candidates, find the next address that's never been used.
+	// The range may be very large so a cursor is kept instead of a count.
+	if p.nextFree == nil {
+		p.nextFree = p.rangeStart
+	}
+	for p.includes(p.nextFree) {
+		next := p.nextFree
+		p.nextFree = nextIP6(next)
+		if _, ok := p.leases[next.String()]; ok {
+			continue
+		}
+
+		l := models.NewLease()
+		l.IP = next
+		l.Network = p.subnet.network.name
+		l.Registered = !p.subnet.allowUnknown
+		p.leases[next.String()] = l
+		return l
+	}
+
+	// Reuse the oldest expired lease
+	var oldest *models.Lease
+	for _, l := range p.leases {
+		if l.IsAbandoned || l.End.After(now) {
+			continue
+		}
+		if oldest == nil || l.End.Before(oldest.End) {
+			oldest = l
+		}
+	}
+	return oldest
+}
+
+// claimLease returns the lease for ip if the address is free or was last bound
+// to duid. A lease is created if the address was never leased. ip must be in
+// the pool.
+func (p *pool6) claimLease(ip net.IP, duid []byte) *models.Lease {
+	l, ok := p.leases[ip.String()]
+	if !ok {
+		l = models.NewLease()
+		l.IP = ip
+		l.Network = p.subnet.network.name
+		l.Registered = !p.subnet.allowUnknown
+		p.leases[ip.String()] = l
+		return l
+	}
+
+	now := time.Now()
+	if l.IsAbandoned || l.End.After(now) {
+		return nil
+	}
+	if l.Offered || bytes.Equal(l.ClientID, duid) {
+		return l
+	}
+	freeAfter := p.subnet.network.global.unregisteredSettings.freeLeaseAfter
+	if l.Registered {
+		freeAfter = p.subnet.network.global.registeredSettings.freeLeaseAfter
+	}
+	if l.End.Add(freeAfter).Before(now) {
+		return l
+	}
+	return nil
+}
+
+// removeLease forgets the lease of ip. The address is handed out again as if it
+// had never been leased.
+func (p *pool6) removeLease(ip net.IP) {
+	delete(p.leases, ip.String())
+	if p.nextFree != nil && bytes.Compare(ip.To16(), p.nextFree.To16()) < 0 {
+		p.nextFree = ip
+	}
+}
+
+func (p *pool6) includes(ip net.IP) bool {
+	ip = ip.To16()
+	return ip != nil && bytes.Compare(ip, p.rangeStart.To16()) >= 0 && bytes.Compare(ip, p.rangeEnd.To16()) <= 0
+}
+
+// nextIP6 returns the address after ip. The address after the last address
+// wraps to ::.
+func nextIP6(ip net.IP) net.IP {
+	next := make(net.IP, net.IPv6len)
+	copy(next, ip.To16())
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
@@ -12,6 +12,9 @@ import (
 )
 
 func GetNetworkList() []string {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
 	n := make([]string, len(c.networks))
 	i := 0
 	for name := range c.networks {
@@ -22,6 +25,9 @@ func GetNetworkList() []string {
 }
 
 func GetLeasesInNetwork(name string) []*models.Lease {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
 	net, ok := c.networks[name]
 	if !ok {
 		return nil
@@ -30,6 +36,9 @@ func GetLeasesInNetwork(name string) []*models.Lease {
 }
 
 func GetPoolStats() []*stats.PoolStat {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
 	poolStats := make([]*stats.PoolStat, 0)
 	now := time.Now()
 	regFreeTime := time.Duration(c.global.registeredSettings.freeLeaseAfter) * time.Second
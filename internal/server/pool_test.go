@@ -6,6 +6,7 @@ package server
 
 import (
 	"bytes"
+	"net"
 	"testing"
 	"time"
 
@@ -45,6 +46,42 @@ func TestIPGiveOut(t *testing.T) {
 	}
 }
 
+func TestReservedAddressNotGivenOut(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	sc := &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	}
+
+	c, err := ParseFile("./testdata/hostConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	// 10.0.1.10 and 10.0.1.11 are fixed addresses inside the pool
+	pool := c.networks["network1"].subnets[0].pools[0]
+	for i := 0; i < pool.getCountOfIPs()-2; i++ {
+		lease := pool.getFreeLease(sc)
+		if lease == nil {
+			t.Fatal("Pool returned nil lease")
+		}
+		if lease.IP.Equal(net.IPv4(10, 0, 1, 10)) || lease.IP.Equal(net.IPv4(10, 0, 1, 11)) {
+			t.Fatalf("Reserved address %s was given out", lease.IP)
+		}
+		lease.End = time.Now().Add(time.Duration(10) * time.Second)
+	}
+
+	if lease := pool.getFreeLease(sc); lease != nil {
+		t.Fatalf("Expected no free lease, got %s", lease.IP)
+	}
+}
+
 func BenchmarkLeaseGiveOutLastLeaseNet24(b *testing.B) {
 	benchmarkPool("network1", b)
 }
@@ -0,0 +1,77 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/lfkeitel/verbose"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+func TestRapidCommit(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/rapidCommitConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+	rapidCommit := d4.Option{Code: d4.OptionRapidCommit, Value: []byte{}}
+	discover := func(mac string, relay net.IP, opts ...d4.Option) d4.Packet {
+		hw, _ := net.ParseMAC(mac)
+		p := d4.RequestPacket(d4.Discover, hw, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+		return dp
+	}
+
+	// Rapid commit pool acknowledges the DISCOVER
+	dp := discover("12:34:56:00:00:01", net.IP{10, 0, 1, 5}, rapidCommit)
+	opts := checkOptions(dp, d4.Options{
+		d4.OptionDHCPMessageType: []byte{byte(d4.ACK)},
+		d4.OptionRapidCommit:     []byte{},
+		d4.OptionRouter:          []byte{10, 0, 1, 1},
+	}, t)
+	if _, ok := opts[d4.OptionRenewalTimeValue]; !ok {
+		t.Error("Expected renewal time in rapid commit ACK")
+	}
+	lease, _ := db.GetLease(dp.YIAddr())
+	if lease == nil || lease.Offered || lease.IsExpired() {
+		t.Fatalf("Expected committed lease, got %#v", lease)
+	}
+
+	// The lease renews like any other
+	hw, _ := net.ParseMAC("12:34:56:00:00:01")
+	p := d4.RequestPacket(d4.Request, hw, dp.YIAddr(), nil, false, nil)
+	checkOptions(server.ServeDHCP(p, d4.Request, p.ParseOptions()), d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+
+	// Clients that don't ask get an offer
+	dp = discover("12:34:56:00:00:02", net.IP{10, 0, 1, 5})
+	checkOptions(dp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.Offer)}}, t)
+
+	// Pools without rapid commit make an offer
+	dp = discover("12:34:56:00:00:03", net.IP{10, 0, 2, 5}, rapidCommit)
+	opts = checkOptions(dp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.Offer)}}, t)
+	if _, ok := opts[d4.OptionRapidCommit]; ok {
+		t.Error("Expected no rapid commit option in offer")
+	}
+	if lease, _ := db.GetLease(dp.YIAddr()); lease != nil {
+		t.Errorf("Expected offered lease to not be saved, got %#v", lease)
+	}
+}
@@ -0,0 +1,145 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	"github.com/packet-guardian/pg-dhcp/models"
+	"github.com/packet-guardian/pg-dhcp/stats"
+)
+
+var leaseReapPeriod = time.Hour
+
+var (
+	reaperStatsMutex sync.Mutex
+	reaperStats      stats.ReaperStat
+)
+
+// GetReaperStats returns how many expired leases have been deleted.
+func GetReaperStats() *stats.ReaperStat {
+	reaperStatsMutex.Lock()
+	s := reaperStats
+	reaperStatsMutex.Unlock()
+	return &s
+}
+
+func (h *Handler) reapLeasesLoop() {
+	h.reapLeases()
+
+	t := time.NewTicker(leaseReapPeriod)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			h.reapLeases()
+		case <-h.reaperDone:
+			return
+		}
+	}
+}
+
+// reapLeases deletes leases that expired more than LeaseDeleteAfter ago from
+// memory and the store. Their addresses are handed out as if they had never
+// been leased. It returns the number of deleted leases.
+func (h *Handler) reapLeases() int {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	start := time.Now()
+	deleted := 0
+
+	for _, n := range h.conf.networks {
+		n.Lock()
+		for _, s := range n.subnets {
+			for _, p := range s.pools {
+				for _, l := range p.leases {
+					if h.reapLease(n, l, start) {
+						p.removeLease(l.IP)
+						deleted++
+					}
+				}
+			}
+		}
+		for _, s := range n.subnets6 {
+			for _, p := range s.pools {
+				for _, l := range p.leases {
+					if h.reapLease(n, l, start) {
+						p.removeLease(l.IP)
+						deleted++
+					}
+				}
+			}
+		}
+		for _, host := range n.hosts {
+			if host.lease != nil && h.reapLease(n, host.lease, start) {
+				host.lease = nil
+				deleted++
+			}
+		}
+		n.Unlock()
+	}
+
+	reaperStatsMutex.Lock()
+	reaperStats.DeleteAfter = h.c.LeaseDeleteAfter
+	reaperStats.LastRun = start
+	reaperStats.LastDeleted = deleted
+	reaperStats.TotalDeleted += deleted
+	reaperStatsMutex.Unlock()
+
+	if deleted > 0 {
+		h.c.Log.WithFields(verbose.Fields{
+			"deleted": deleted,
+			"took":    time.Since(start).String(),
+		}).Info("Deleted expired leases")
+	}
+	return deleted
+}
+
+// reapable returns if l is old enough to be deleted. Abandoned leases are kept
+// until the conflict is cleared and leases with a DNS record are kept until the
+// record is removed. Leases aren't deleted while their client can still claim
+// the address.
+func (h *Handler) reapable(n *network, l *models.Lease, now time.Time) bool {
+	if l.IsAbandoned || l.IsInfinite() || l.DNSName != "" {
+		return false
+	}
+
+	keep := h.c.LeaseDeleteAfter
+	freeAfter := n.global.unregisteredSettings.freeLeaseAfter
+	if l.Registered {
+		freeAfter = n.global.registeredSettings.freeLeaseAfter
+	}
+	if freeAfter > keep {
+		keep = freeAfter
+	}
+	return l.End.Add(keep).Before(now)
+}
+
+// reapLease deletes l from the store if it's reapable. n must be locked.
+func (h *Handler) reapLease(n *network, l *models.Lease, now time.Time) bool {
+	if !h.reapable(n, l, now) {
+		return false
+	}
+
+	if err := h.c.Store.DeleteLease(l); err != nil {
+		h.c.Log.WithFields(verbose.Fields{
+			"ip":    l.IP.String(),
+			"error": err,
+		}).Error("Error deleting lease")
+		return false
+	}
+
+	h.c.Log.WithFields(verbose.Fields{
+		"ip":      l.IP.String(),
+		"mac":     l.MAC.String(),
+		"network": l.Network,
+		"expired": l.End.Format(time.RFC3339),
+		"action":  "lease_delete",
+	}).Info("Deleted expired lease")
+	return true
+}
@@ -0,0 +1,115 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+func TestReapLeases(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/reaperConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:              EnvTesting,
+		Log:              verbose.New(""),
+		Store:            db,
+		LeaseDeleteAfter: time.Hour,
+	})
+
+	now := time.Now()
+	tests := []struct {
+		ip      net.IP
+		end     time.Time
+		modify  func(l *models.Lease)
+		deleted bool
+	}{
+		{ip: net.IP{10, 0, 1, 10}, end: now.Add(-3 * time.Hour), deleted: true},
+		{ip: net.IP{10, 0, 1, 11}, end: now.Add(-30 * time.Minute)}, // Not old enough
+		{ip: net.IP{10, 0, 1, 12}, end: now.Add(-90 * time.Minute)}, // Client can still claim it
+		{ip: net.IP{10, 0, 1, 13}, end: now.Add(time.Hour)},         // Active
+		{ip: net.IP{10, 0, 1, 14}, end: models.InfiniteEnd},         // BOOTP
+		{ip: net.IP{10, 0, 1, 200}, end: now.Add(-3 * time.Hour), deleted: true},
+		{ip: net.IP{10, 0, 1, 15}, end: now.Add(-3 * time.Hour), modify: func(l *models.Lease) {
+			l.Abandon("Declined")
+		}},
+		{ip: net.IP{10, 0, 1, 16}, end: now.Add(-3 * time.Hour), modify: func(l *models.Lease) {
+			l.DNSName = "client.example.com"
+		}},
+	}
+
+	for _, test := range tests {
+		l := models.NewLease()
+		l.IP = test.ip
+		l.MAC = net.HardwareAddr{0x12, 0x34, 0x56, 0, 0, test.ip[3]}
+		l.Network = "reaper"
+		l.Start = now.Add(-4 * time.Hour)
+		l.End = test.end
+		if test.modify != nil {
+			test.modify(l)
+		}
+		db.PutLease(l)
+	}
+	server.LoadLeases()
+
+	network := c.networks["reaper"]
+	pool := network.getPoolOfIP(net.IP{10, 0, 1, 10})
+	pool.nextFreeStart = 7
+
+	if deleted := server.reapLeases(); deleted != 2 {
+		t.Errorf("Expected 2 deleted leases, got %d", deleted)
+	}
+
+	for _, test := range tests {
+		stored, _ := db.GetLease(test.ip)
+		inMemory := network.findLease(test.ip)
+		if test.deleted && (stored != nil || inMemory != nil) {
+			t.Errorf("Expected lease %s to be deleted", test.ip)
+		} else if !test.deleted && (stored == nil || inMemory == nil) {
+			t.Errorf("Expected lease %s to be kept", test.ip)
+		}
+	}
+
+	// The address is given out again
+	if pool.nextFreeStart != 0 {
+		t.Errorf("Expected next free address to be reset, got %d", pool.nextFreeStart)
+	}
+
+	stats := GetReaperStats()
+	if stats.LastDeleted != 2 || stats.TotalDeleted < 2 || stats.DeleteAfter != time.Hour {
+		t.Errorf("Incorrect reaper stats: %#v", stats)
+	}
+
+	if deleted := server.reapLeases(); deleted != 0 {
+		t.Errorf("Expected nothing deleted, got %d", deleted)
+	}
+
+	// A failover partner that still has a deleted lease doesn't bring it back
+	l := models.NewLease()
+	l.IP = net.IP{10, 0, 1, 10}
+	l.MAC = net.HardwareAddr{0x12, 0x34, 0x56, 0, 0, 10}
+	l.Network = "reaper"
+	l.Start = now.Add(-4 * time.Hour)
+	l.End = now.Add(-3 * time.Hour)
+	if server.applyPartnerLease(l, false) {
+		t.Error("Expected deleted lease from partner to be skipped")
+	}
+	if stored, _ := db.GetLease(l.IP); stored != nil || network.findLease(l.IP) != nil {
+		t.Error("Deleted lease was restored by partner")
+	}
+}
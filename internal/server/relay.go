@@ -0,0 +1,64 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+// A relayMatch selects clients by the value of a relay agent information sub-option.
+type relayMatch struct {
+	subOption byte
+	value     []byte
+}
+
+type relayMatches []relayMatch
+
+// matches returns true if any of the match statements matches the relay information.
+func (m relayMatches) matches(r *dhcp4.RelayAgentInformation) bool {
+	if r == nil {
+		return false
+	}
+	for _, rm := range m {
+		if val, ok := r.SubOptions[rm.subOption]; ok && bytes.Equal(val, rm.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeRelayInfo returns the option 82 encoding of relay information stored on
+// a lease, or nil if there isn't any.
+func encodeRelayInfo(r models.RelayInfo) []byte {
+	var data []byte
+	if len(r.CircuitID) > 0 {
+		data, _ = dhcp4.EncodeSubOption(data, dhcp4.RelayCircuitID, r.CircuitID)
+	}
+	if len(r.RemoteID) > 0 {
+		data, _ = dhcp4.EncodeSubOption(data, dhcp4.RelayRemoteID, r.RemoteID)
+	}
+	if len(data) > 255 {
+		return nil
+	}
+	return data
+}
+
+// relayInfo converts parsed relay information into the form stored on a lease.
+func relayInfo(r *dhcp4.RelayAgentInformation) models.RelayInfo {
+	if r == nil {
+		return models.RelayInfo{}
+	}
+	info := models.RelayInfo{}
+	if len(r.CircuitID) > 0 {
+		info.CircuitID = append([]byte(nil), r.CircuitID...)
+	}
+	if len(r.RemoteID) > 0 {
+		info.RemoteID = append([]byte(nil), r.RemoteID...)
+	}
+	return info
+}
@@ -0,0 +1,82 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+)
+
+// configMutex guards replacing the running configuration. Anything that uses
+// the networks holds a read lock so a reload waits for in-flight requests.
+var configMutex sync.RWMutex
+
+// ErrReloadIPv6 is returned when a reload adds DHCPv6 to a server that isn't
+// listening for it.
+var ErrReloadIPv6 = errors.New("the server must be restarted to serve DHCPv6")
+
+// ReloadFile parses and validates the networks file at path and replaces the
+// running configuration with it. If the file has errors the running configuration
+// is left untouched, validation errors are returned as Problems.
+func (h *Handler) ReloadFile(path string) error {
+	conf, err := ParseFile(path)
+	if err != nil {
+		return err
+	}
+
+	problems := conf.Validate()
+	if errs := problems.Errors(); len(errs) > 0 {
+		return errs
+	}
+	for _, p := range problems {
+		h.c.Log.Warning(p.String())
+	}
+	return h.Reload(conf)
+}
+
+// Reload replaces the running configuration with conf. Leases in memory are
+// moved to the network of the same name in conf, leases whose network or
+// address is gone are kept in the store but no longer served.
+func (h *Handler) Reload(conf *Config) error {
+	start := time.Now()
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	if h.conn != nil && h.conn6 == nil && conf.hasIPv6() {
+		return ErrReloadIPv6
+	}
+
+	moved, dropped := 0, 0
+	for _, n := range h.conf.networks {
+		n.Lock()
+		for _, l := range n.getAllLeases() {
+			if newNet, ok := conf.networks[l.Network]; ok && h.placeLease(newNet, l) {
+				moved++
+			} else {
+				dropped++
+			}
+		}
+		n.Unlock()
+	}
+
+	h.conf = conf
+	c = conf
+
+	h.gatewayMutex.Lock()
+	h.gatewayCache = make(map[string]*network)
+	h.gatewayMutex.Unlock()
+
+	h.c.Log.WithFields(verbose.Fields{
+		"networks": len(conf.networks),
+		"leases":   moved,
+		"dropped":  dropped,
+		"took":     time.Since(start).String(),
+	}).Info("Reloaded configuration")
+	return nil
+}
@@ -0,0 +1,90 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/lfkeitel/verbose"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+func TestReload(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	conf, err := ParseFile("./testdata/reloadConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(conf, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+
+	mac1, _ := net.ParseMAC("12:34:56:00:00:01")
+	ap := acquireLease(server, mac1, t)
+	if ap == nil {
+		t.Fatal("Expected ACK")
+	}
+	checkOptions(ap, d4.Options{d4.OptionRouter: []byte{10, 0, 1, 1}}, t)
+	ip := ap.YIAddr()
+
+	// A broken file leaves the running configuration alone
+	if err := server.ReloadFile("./testdata/reloadBadConfig.conf"); err == nil {
+		t.Fatal("Expected reload error")
+	}
+	if server.conf != conf || c != conf {
+		t.Fatal("Configuration replaced after failed reload")
+	}
+	if err := server.ReloadFile("./testdata/validateConfig.conf"); err == nil {
+		t.Fatal("Expected reload validation error")
+	} else if _, ok := err.(Problems); !ok {
+		t.Fatalf("Expected validation problems, got %v", err)
+	}
+	if server.conf != conf || c != conf {
+		t.Fatal("Configuration replaced after invalid reload")
+	}
+
+	if err := server.ReloadFile("./testdata/reloadConfig2.conf"); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if server.conf == conf || c != server.conf {
+		t.Fatal("Configuration not replaced")
+	}
+	if len(server.gatewayCache) != 0 {
+		t.Error("Expected gateway cache to be flushed")
+	}
+	if _, ok := c.networks["lab"]; !ok {
+		t.Error("Expected new network")
+	}
+
+	lease := server.conf.networks["iot"].findLease(ip)
+	if lease == nil || lease.MAC.String() != mac1.String() {
+		t.Fatalf("Expected lease to be moved, got %#v", lease)
+	}
+
+	// The client keeps its address and gets the new options
+	ap = requestLease(server, mac1, ip)
+	if ap == nil || !ap.YIAddr().Equal(ip) {
+		t.Fatal("Expected lease renewal after reload")
+	}
+	checkOptions(ap, d4.Options{
+		d4.OptionDHCPMessageType: []byte{byte(d4.ACK)},
+		d4.OptionRouter:          []byte{10, 0, 1, 254},
+	}, t)
+
+	// The moved lease isn't given to another client
+	mac2, _ := net.ParseMAC("12:34:56:00:00:02")
+	if ap := acquireLease(server, mac2, t); ap == nil || ap.YIAddr().Equal(ip) {
+		t.Error("Expected another client to get a different address")
+	}
+}
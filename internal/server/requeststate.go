@@ -0,0 +1,149 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+// requestState is the client state a REQUEST was sent from, RFC 2131 section 4.3.2.
+type requestState int
+
+const (
+	stateSelecting  requestState = iota // Accepting an offer
+	stateInitReboot                     // Verifying a remembered address after a reboot
+	stateRenewing                       // Extending a lease with this server
+	stateRebinding                      // Extending a lease with any server
+)
+
+func (s requestState) String() string {
+	switch s {
+	case stateSelecting:
+		return "selecting"
+	case stateInitReboot:
+		return "init-reboot"
+	case stateRenewing:
+		return "renewing"
+	case stateRebinding:
+		return "rebinding"
+	}
+	return "unknown"
+}
+
+// getRequestState classifies a REQUEST by its server identifier, requested
+// address and client address. RENEWING requests are unicast to the server and
+// REBINDING requests are broadcast. The destination isn't known here so a
+// request with a client address is only treated as rebinding when it was relayed.
+func getRequestState(p dhcp4.Packet, options dhcp4.Options) requestState {
+	if _, ok := options[dhcp4.OptionServerIdentifier]; ok {
+		return stateSelecting
+	}
+	if _, ok := options[dhcp4.OptionRequestedIPAddress]; ok {
+		return stateInitReboot
+	}
+	if !p.GIAddr().Equal(net.IPv4zero) {
+		return stateRebinding
+	}
+	return stateRenewing
+}
+
+// requestedAddress returns the address a REQUEST is for, option 50 when selecting or
+// rebooting, otherwise the client address.
+func (s requestState) requestedAddress(p dhcp4.Packet, options dhcp4.Options) net.IP {
+	if s == stateSelecting || s == stateInitReboot {
+		return net.IP(options[dhcp4.OptionRequestedIPAddress]).To4()
+	}
+	return p.CIAddr().To4()
+}
+
+// gatewayNetwork returns the network of the relay address giaddr. Lookups are cached.
+func (h *Handler) gatewayNetwork(giaddr net.IP) *network {
+	gatewayIP := giaddr.String()
+	h.gatewayMutex.Lock()
+	defer h.gatewayMutex.Unlock()
+
+	network, ok := h.gatewayCache[gatewayIP]
+	if !ok {
+		// That gateway hasn't been seen before, find its network
+		network = h.conf.searchNetworksFor(giaddr)
+		if network == nil {
+			return nil
+		}
+		// Add to cache for later
+		h.gatewayCache[gatewayIP] = network
+	}
+	return network
+}
+
+// relayInformation returns the relay agent information (option 82) of a request
+// from a trusted relay. Clients could send the option themselves to pick a
+// network or pool, so it's ignored in requests without a relay address and
+// from relays not listed with trusted-relay.
+func (h *Handler) relayInformation(p dhcp4.Packet, options dhcp4.Options) *dhcp4.RelayAgentInformation {
+	giaddr := p.GIAddr()
+	if giaddr.Equal(net.IPv4zero) || !h.conf.global.trustsRelay(giaddr) {
+		return nil
+	}
+	return options.RelayAgentInformation()
+}
+
+// linkAddress returns the address identifying the link the client is on. It's the
+// relay address unless a trusted relay selected a different subnet with the subnet
+// selection option (118, RFC 3011) or the link selection sub-option of the relay
+// agent information (RFC 3527), which is how relays with a loopback address tell
+// the server the client's link.
+func (h *Handler) linkAddress(p dhcp4.Packet, options dhcp4.Options, relay *dhcp4.RelayAgentInformation) net.IP {
+	giaddr := p.GIAddr()
+	if giaddr.Equal(net.IPv4zero) || !h.conf.global.trustsRelay(giaddr) {
+		return giaddr
+	}
+	if ss := options[dhcp4.OptionSubnetSelection]; len(ss) == net.IPv4len {
+		return net.IP(ss)
+	}
+	if relay != nil && relay.LinkSelection != nil {
+		return relay.LinkSelection
+	}
+	return giaddr
+}
+
+// subnetSelectionOption returns the subnet selection option to echo in a reply,
+// RFC 3011 requires it when the option was used. It's nil if the option wasn't sent
+// or the relay isn't trusted.
+func (h *Handler) subnetSelectionOption(p dhcp4.Packet, options dhcp4.Options, relay *dhcp4.RelayAgentInformation) []dhcp4.Option {
+	ss, ok := options[dhcp4.OptionSubnetSelection]
+	if !ok || !h.linkAddress(p, options, relay).Equal(net.IP(ss)) {
+		return nil
+	}
+	return []dhcp4.Option{{Code: dhcp4.OptionSubnetSelection, Value: ss}}
+}
+
+// clientNetwork returns the network of a client getting a new address. A network
+// matching the relay agent information takes precedence over the link address.
+func (h *Handler) clientNetwork(p dhcp4.Packet, options dhcp4.Options, relay *dhcp4.RelayAgentInformation) *network {
+	if network := h.conf.searchNetworksByRelay(relay); network != nil {
+		return network
+	}
+	return h.gatewayNetwork(h.linkAddress(p, options, relay))
+}
+
+// requestNetwork returns the network a REQUEST belongs to. Clients selecting or
+// rebooting belong to the network of their link, falling back to the network of
+// the requested address when the link isn't in any network. Clients renewing or
+// rebinding have a working address so its network is used.
+func (h *Handler) requestNetwork(p dhcp4.Packet, options dhcp4.Options, state requestState, relay *dhcp4.RelayAgentInformation, reqIP net.IP) *network {
+	if network := h.conf.searchNetworksByRelay(relay); network != nil {
+		return network
+	}
+	if state == stateSelecting || state == stateInitReboot {
+		if link := h.linkAddress(p, options, relay); !link.Equal(net.IPv4zero) {
+			if network := h.gatewayNetwork(link); network != nil {
+				return network
+			}
+		}
+	}
+	return h.conf.searchNetworksFor(reqIP)
+}
@@ -0,0 +1,184 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/lfkeitel/verbose"
+	d4 "github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+func TestGetRequestState(t *testing.T) {
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	serverID := d4.Option{Code: d4.OptionServerIdentifier, Value: []byte{10, 0, 0, 1}}
+	reqIP := d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte{10, 0, 1, 10}}
+
+	tests := []struct {
+		ciaddr net.IP
+		giaddr net.IP
+		opts   []d4.Option
+		state  requestState
+	}{
+		{nil, nil, []d4.Option{serverID, reqIP}, stateSelecting},
+		{nil, net.IP{10, 0, 1, 5}, []d4.Option{serverID, reqIP}, stateSelecting},
+		{nil, nil, []d4.Option{reqIP}, stateInitReboot},
+		{nil, net.IP{10, 0, 1, 5}, []d4.Option{reqIP}, stateInitReboot},
+		{net.IP{10, 0, 1, 10}, nil, nil, stateRenewing},
+		{net.IP{10, 0, 1, 10}, net.IP{10, 0, 1, 5}, nil, stateRebinding},
+	}
+
+	for i, test := range tests {
+		p := d4.RequestPacket(d4.Request, mac, test.ciaddr, nil, false, test.opts)
+		if test.giaddr != nil {
+			p.SetGIAddr(test.giaddr)
+		}
+		if state := getRequestState(p, p.ParseOptions()); state != test.state {
+			t.Errorf("Test %d: expected %s, got %s", i, test.state, state)
+		}
+	}
+}
+
+func TestRequestStates(t *testing.T) {
+	server := setUpTest1(t)
+	defer tearDownTest1(server)
+	relay := net.ParseIP("10.0.1.5")
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+
+	p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, nil)
+	p.SetGIAddr(relay)
+	dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+	if dp == nil {
+		t.Fatal("Processed packet is nil")
+	}
+	leased := dp.YIAddr().To4()
+
+	opts := []d4.Option{
+		{Code: d4.OptionServerIdentifier, Value: []byte{10, 0, 0, 1}},
+		{Code: d4.OptionRequestedIPAddress, Value: []byte(leased)},
+	}
+	p = d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(relay)
+	rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+
+	// The server restarted and hasn't seen the relay yet
+	request := func(ciaddr, giaddr net.IP, opts []d4.Option) d4.Packet {
+		server.gatewayCache = make(map[string]*network)
+		p := d4.RequestPacket(d4.Request, mac, ciaddr, nil, false, opts)
+		if giaddr != nil {
+			p.SetGIAddr(giaddr)
+		}
+		return server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	}
+	ack := d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}
+	nak := d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.NAK)}}
+
+	initReboot := func(ip net.IP) []d4.Option {
+		return []d4.Option{{Code: d4.OptionRequestedIPAddress, Value: []byte(ip.To4())}}
+	}
+
+	// Known leases are acknowledged in every state
+	checkOptions(request(nil, relay, initReboot(leased)), ack, t)
+	checkOptions(request(leased, relay, nil), ack, t)
+	checkOptions(request(leased, nil, nil), ack, t)
+
+	// Rebooting on a different network, only an authoritative network refuses it
+	if rp := request(nil, relay, initReboot(net.IP{10, 0, 4, 10})); rp != nil {
+		t.Error("Expected no response to INIT-REBOOT from a different network")
+	}
+
+	// Unknown address on the right network, another server may know about it
+	if rp := request(nil, relay, initReboot(net.IP{10, 0, 1, 150})); rp != nil {
+		t.Error("Expected no response to INIT-REBOOT without a lease")
+	}
+	if rp := request(net.IP{10, 0, 1, 150}, nil, nil); rp != nil {
+		t.Error("Expected no response to RENEWING without a lease")
+	}
+
+	// Address outside any network
+	if rp := request(nil, nil, initReboot(net.IP{192, 168, 1, 10})); rp != nil {
+		t.Error("Expected no response to INIT-REBOOT outside any network")
+	}
+
+	// The device registered, its address is wrong for its new state
+	setDevice(server.c.Store, mac, true, false)
+	checkOptions(request(nil, relay, initReboot(leased)), nak, t)
+	checkOptions(request(leased, relay, nil), nak, t)
+}
+
+func TestLinkSelection(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/linkSelectionConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+	mac, _ := net.ParseMAC("12:34:56:00:00:01")
+	subnetSelection := func(ip net.IP) d4.Option {
+		return d4.Option{Code: d4.OptionSubnetSelection, Value: []byte(ip.To4())}
+	}
+	linkSelection := func(ip net.IP) d4.Option {
+		return d4.Option{Code: d4.OptionRelayAgentInformation, Value: append([]byte{d4.RelayLinkSelection, 4}, ip.To4()...)}
+	}
+	discover := func(relay net.IP, opts ...d4.Option) d4.Packet {
+		p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+		return dp
+	}
+
+	trusted := net.IP{10, 255, 0, 1}
+	untrusted := net.IP{10, 0, 1, 1}
+
+	tests := []struct {
+		relay   net.IP
+		opts    []d4.Option
+		network net.IP
+		echo    bool
+	}{
+		{trusted, nil, net.IP{10, 255, 0, 0}, false},
+		{trusted, []d4.Option{subnetSelection(net.IP{10, 0, 2, 0})}, net.IP{10, 0, 2, 0}, true},
+		{trusted, []d4.Option{linkSelection(net.IP{10, 0, 1, 0})}, net.IP{10, 0, 1, 0}, false},
+		{trusted, []d4.Option{subnetSelection(net.IP{10, 0, 2, 0}), linkSelection(net.IP{10, 0, 1, 0})}, net.IP{10, 0, 2, 0}, true},
+		{untrusted, []d4.Option{subnetSelection(net.IP{10, 0, 2, 0})}, net.IP{10, 0, 1, 0}, false},
+		{untrusted, []d4.Option{linkSelection(net.IP{10, 0, 2, 0})}, net.IP{10, 0, 1, 0}, false},
+	}
+
+	mask := net.CIDRMask(24, 32)
+	for i, test := range tests {
+		dp := discover(test.relay, test.opts...)
+		if !dp.YIAddr().Mask(mask).Equal(test.network) {
+			t.Errorf("Test %d: expected address in %s, got %s", i, test.network, dp.YIAddr())
+		}
+		if _, ok := dp.ParseOptions()[d4.OptionSubnetSelection]; ok != test.echo {
+			t.Errorf("Test %d: expected subnet selection echoed %t, got %t", i, test.echo, ok)
+		}
+	}
+
+	// A rebooting client is checked against the selected subnet
+	dp := discover(trusted, subnetSelection(net.IP{10, 0, 2, 0}))
+	opts := []d4.Option{
+		{Code: d4.OptionRequestedIPAddress, Value: []byte(dp.YIAddr().To4())},
+		subnetSelection(net.IP{10, 0, 2, 0}),
+	}
+	p := d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(trusted)
+	checkOptions(server.ServeDHCP(p, d4.Request, p.ParseOptions()), d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+}
@@ -8,15 +8,16 @@
 package server
 
 import (
-	"bytes"
 	"errors"
 	"net"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/lfkeitel/verbose"
 	"github.com/packet-guardian/pg-dhcp/dhcp"
+	"github.com/packet-guardian/pg-dhcp/dhcp6"
 	"github.com/packet-guardian/pg-dhcp/models"
 )
 
@@ -29,7 +30,13 @@ type Handler struct {
 	gatewayCache map[string]*network
 	gatewayMutex sync.Mutex
 	c            *ServerConfig
+	conf         *Config
 	conn         net.PacketConn
+	conn6        net.PacketConn
+	duid         dhcp6.DUID // DHCPv6 server identifier
+	dns          *dnsQueue
+	bulkListener net.Listener // Bulk leasequery connections
+	reaperDone   chan struct{}
 	closing      bool
 }
 
@@ -38,13 +45,28 @@ func NewDHCPServer(conf *Config, s *ServerConfig) *Handler {
 	if s.Log == nil {
 		s.Log = createLogger()
 	}
+	if s.Pinger == nil {
+		s.Pinger = newICMPPinger()
+	}
+	if s.PingTimeout <= 0 {
+		s.PingTimeout = DefaultPingTimeout
+	}
 	c = conf
 
-	return &Handler{
+	h := &Handler{
 		c:            s,
+		conf:         conf,
 		gatewayCache: make(map[string]*network),
 		gatewayMutex: sync.Mutex{},
+		duid:         newServerDUID(),
+	}
+	if s.Failover != nil {
+		s.Failover.h = h
+	}
+	if s.DNS != nil {
+		h.dns = newDNSQueue(s.DNS, s.Log, ddnsRetryDelay)
 	}
+	return h
 }
 
 func createLogger() *verbose.Logger {
@@ -59,18 +81,50 @@ func createLogger() *verbose.Logger {
 }
 
 // ListenAndServe starts the DHCP Handler listening on port 67 for packets.
+// If any network has a subnet6, DHCPv6 is also served on port 547.
 // This is blocking like HTTP's ListenAndServe method.
 func (h *Handler) ListenAndServe() error {
 	if h.c.Workers <= 0 {
 		return errors.New("Server.Workers needs to be greater than 0")
 	}
 
+	if h.c.Failover != nil {
+		if err := h.c.Failover.Start(); err != nil {
+			return err
+		}
+	}
+
+	if h.dns != nil {
+		go h.expireDNSLoop()
+	}
+
+	if h.c.LeaseDeleteAfter > 0 {
+		h.reaperDone = make(chan struct{})
+		go h.reapLeasesLoop()
+	}
+
+	if err := h.listenBulkLeaseQuery(); err != nil {
+		return err
+	}
+
 	h.c.Log.Info("Starting DHCP server...")
 	l, err := net.ListenPacket("udp4", ":67")
 	if err != nil {
 		return err
 	}
 	h.conn = l
+
+	if h.conf.hasIPv6() {
+		h.c.Log.Info("Starting DHCPv6 server...")
+		l6, err := dhcp6.ListenPacket(nil)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		h.conn6 = l6
+		go h.serve6()
+	}
+
 	err = dhcp4.Serve(l, h, h.c.Workers)
 	if h.closing {
 		return nil
@@ -80,57 +134,131 @@ func (h *Handler) ListenAndServe() error {
 
 func (h *Handler) Close() error {
 	h.closing = true
+	h.c.Failover.Close()
+	h.dns.close()
+	if h.reaperDone != nil {
+		close(h.reaperDone)
+	}
+	if h.bulkListener != nil {
+		h.bulkListener.Close()
+	}
 	h.conn.Close()
+	if h.conn6 != nil {
+		h.conn6.Close()
+	}
 	h.c.Store.Close()
 	return nil
 }
 
 // LoadLeases will import any current leases saved to the database.
 func (h *Handler) LoadLeases() error {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
 	h.c.Store.ForEachLease(func(l *models.Lease) {
 		// Check if the network exists
-		n, ok := c.networks[l.Network]
+		n, ok := h.conf.networks[l.Network]
 		if !ok {
 			return
 		}
+		if h.placeLease(n, l) {
+			h.c.Log.WithField("address", l.IP).Debug("Loaded lease")
+		}
+	})
+	return nil
+}
+
+// placeLease adds l to the host or pool of network n that its address belongs to.
+func (h *Handler) placeLease(n *network, l *models.Lease) bool {
+	if l.IsIPv6() {
+		if pool := n.getPool6OfIP(l.IP); pool != nil {
+			pool.leases[l.IP.String()] = l
+			return true
+		}
+		return false
+	}
 
-		// Find the correct pool
-		// TODO: Optimize this maybe with a temporary cache
-	subnetLoop:
-		for _, subnet := range n.subnets {
-			if !subnet.includes(l.IP) {
-				continue
-			}
+	// Reserved addresses belong to their host, not a pool
+	if host := n.getHostByIP(l.IP); host != nil {
+		host.lease = l
+		return true
+	}
+
+	// TODO: Optimize this maybe with a temporary cache
+	for _, subnet := range n.subnets {
+		if !subnet.includes(l.IP) {
+			continue
+		}
 
-			for _, pool := range subnet.pools {
-				if !pool.includes(l.IP) {
-					continue
-				}
+		for _, pool := range subnet.pools {
+			if pool.includes(l.IP) {
 				pool.leases[l.IP.String()] = l
-				h.c.Log.WithField("address", l.IP).Debug("Loaded lease")
-				break subnetLoop
+				return true
 			}
 		}
-	})
-	return nil
+	}
+	return false
+}
+
+// saveLease timestamps a change to l, saves it and sends it to the failover partner.
+func (h *Handler) saveLease(l *models.Lease) error {
+	l.UpdatedAt = time.Now()
+	err := h.c.Store.PutLease(l)
+	h.c.Failover.sendUpdate(l)
+	return err
+}
+
+// applyPartnerLease merges a lease from the failover partner into memory and the
+// store. Unless force is true, an existing lease is only replaced by a newer one.
+func (h *Handler) applyPartnerLease(l *models.Lease, force bool) bool {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	n, ok := h.conf.networks[l.Network]
+	if !ok {
+		return false
+	}
+	n.Lock()
+	defer n.Unlock()
+
+	if existing := n.findLease(l.IP); existing != nil {
+		if !force && !partnerLeaseIsNewer(existing, l) {
+			return false
+		}
+		*existing = *l // Keep the pointer, pools and hosts reference it
+		l = existing
+	} else if h.c.LeaseDeleteAfter > 0 && h.reapable(n, l, time.Now()) {
+		return false // Already deleted here, deletions aren't sent to the partner
+	} else if !h.placeLease(n, l) {
+		return false
+	}
+
+	if err := h.c.Store.PutLease(l); err != nil {
+		h.c.Log.WithFields(verbose.Fields{
+			"ip":    l.IP.String(),
+			"error": err,
+		}).Error("Error saving lease")
+	}
+	return true
 }
 
 // ServeDHCP processes an incoming DHCP packet and returns a response.
 func (h *Handler) ServeDHCP(p dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
-	defer func() {
-		if r := recover(); r != nil {
-			buf := make([]byte, 2048)
-			runtime.Stack(buf, false)
-			h.c.Log.WithFields(verbose.Fields{
-				"package": "dhcp",
-				"error":   r,
-				"stack":   string(buf),
-			}).Critical("Recovering from DHCP panic")
-		}
-	}()
+	defer h.recoverPanic()
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	// Leasequeries are answered by either failover partner, both know the leases
+	if msgType == dhcp4.LeaseQuery {
+		return h.handleLeaseQuery(p, options)
+	}
+
+	if !h.c.Failover.shouldServe() {
+		return nil // The failover partner is serving clients
+	}
 
 	// Log every message
-	if server, ok := options[dhcp4.OptionServerIdentifier]; !ok || net.IP(server).Equal(c.global.serverIdentifier) {
+	if server, ok := options[dhcp4.OptionServerIdentifier]; !ok || net.IP(server).Equal(h.conf.global.serverIdentifier) {
 		h.c.Log.WithFields(verbose.Fields{
 			"type":     msgType.String(),
 			"ip":       p.CIAddr().String(),
@@ -148,12 +276,14 @@ func (h *Handler) ServeDHCP(p dhcp4.Packet, msgType dhcp4.MessageType, options d
 		return nil
 	}
 
+	classes := h.conf.classify(p, options)
+
 	var response dhcp4.Packet
 	switch msgType {
 	case dhcp4.Discover:
-		response = h.handleDiscover(p, options, device)
+		response = h.handleDiscover(p, options, device, classes)
 	case dhcp4.Request:
-		response = h.handleRequest(p, options, device)
+		response = h.handleRequest(p, options, device, classes)
 	case dhcp4.Release:
 		response = h.handleRelease(p, options, device)
 	case dhcp4.Decline:
@@ -164,164 +294,376 @@ func (h *Handler) ServeDHCP(p dhcp4.Packet, msgType dhcp4.MessageType, options d
 	return response
 }
 
+// recoverPanic logs a panic while handling a packet so the server keeps running.
+// It must be deferred.
+func (h *Handler) recoverPanic() {
+	if r := recover(); r != nil {
+		buf := make([]byte, 2048)
+		runtime.Stack(buf, false)
+		h.c.Log.WithFields(verbose.Fields{
+			"package": "dhcp",
+			"error":   r,
+			"stack":   string(buf),
+		}).Critical("Recovering from DHCP panic")
+	}
+}
+
 func isDeviceRegistered(d *models.Device) bool {
 	return d.Registered && !d.Blacklisted
 }
 
 // Handle DHCP DISCOVER messages
-func (h *Handler) handleDiscover(p dhcp4.Packet, options dhcp4.Options, device *models.Device) dhcp4.Packet {
+func (h *Handler) handleDiscover(p dhcp4.Packet, options dhcp4.Options, device *models.Device, classes []string) dhcp4.Packet {
 	start := time.Now()
 
 	registered := isDeviceRegistered(device)
+	relay := h.relayInformation(p, options)
+	clientID := options[dhcp4.OptionClientIdentifier]
 
-	gatewayIP := p.GIAddr().String()
-	// Get network object that the relay IP belongs to
-	h.gatewayMutex.Lock()
-	network, ok := h.gatewayCache[gatewayIP]
-	if !ok {
-		// That gateway hasn't been seen before, find its network
-		network = c.searchNetworksFor(p.GIAddr())
-		if network == nil {
-			h.gatewayMutex.Unlock()
-			h.c.Log.WithField("relay_ip", gatewayIP).Notice("Network not found")
-			return nil
-		}
-		// Add to cache for later
-		h.gatewayCache[gatewayIP] = network
+	network := h.clientNetwork(p, options, relay)
+	if network == nil {
+		h.c.Log.WithFields(verbose.Fields{
+			"relay_ip": p.GIAddr().String(),
+			"link":     h.linkAddress(p, options, relay).String(),
+		}).Notice("Network not found")
+		return nil
 	}
 	network.Lock()
 	defer network.Unlock()
-	h.gatewayMutex.Unlock()
 
-	// Find an appropiate lease
-	lease, pool := network.getLeaseByMAC(p.CHAddr(), registered)
-	if lease == nil {
-		// Device doesn't have a recent lease, get a new one
-		lease, pool = network.getFreeLease(h.c, registered)
-		if lease == nil { // No free lease was found, be more aggressive
-			lease, pool = network.getFreeLeaseDesperate(h.c, registered)
+	var (
+		lease        *models.Lease
+		leaseOptions dhcp4.Options
+		leaseTime    time.Duration
+		hostName     string
+		scopes       []*settings
+	)
+
+	if host := network.getHostByMAC(p.CHAddr()); host != nil {
+		// Reservations always take precedence over pools
+		lease = host.getLease()
+		leaseOptions = host.getOptions()
+		leaseTime = grantLeaseTime(host.getLeaseTime, requestedLeaseTime(options))
+		hostName = host.name
+		scopes = host.scopes()
+	} else {
+		// Find an appropiate lease
+		var pool *pool
+		lease, pool = network.getLeaseByMAC(p.CHAddr(), clientID, registered)
+		if lease != nil && (!pool.matchesRelay(relay) || !pool.allowsClasses(classes)) {
+			// The client moved to a circuit or class the pool doesn't serve
+			lease = nil
+		}
+		if lease == nil {
+			// Device doesn't have a recent lease, get a new one
+			lease, pool = h.getCheckedFreeLease(network, p.CHAddr(), registered, false, relay, classes)
+			if lease == nil { // Still no lease was found, error and go to the next request
+				h.c.Log.WithFields(verbose.Fields{
+					"network":    network.name,
+					"registered": registered,
+				}).Alert("No free leases available in network")
+				return nil
+			}
 		}
-		if lease == nil { // Still no lease was found, error and go to the next request
+		leaseOptions = pool.getOptions(registered)
+		leaseTime = grantLeaseTime(func(req time.Duration) time.Duration {
+			return pool.getLeaseTime(req, registered)
+		}, requestedLeaseTime(options))
+		scopes = pool.scopes(registered)
+	}
+
+	leaseTime = h.c.Failover.capLeaseTime(leaseTime)
+
+	lease.MAC = make([]byte, len(p.CHAddr()))
+	copy(lease.MAC, p.CHAddr())
+
+	if _, ok := options[dhcp4.OptionRapidCommit]; ok && rapidCommitEnabled(scopes...) {
+		// Two message exchange, the lease is committed now and acknowledged, RFC 4039
+		fqdnReply, err := h.commitLease(p, options, network, lease, leaseTime, relay, classes)
+		if err != nil {
 			h.c.Log.WithFields(verbose.Fields{
-				"network":    network.name,
-				"registered": registered,
-			}).Alert("No free leases available in network")
+				"mac":   p.CHAddr().String(),
+				"error": err,
+			}).Error("Error saving lease")
 			return nil
 		}
+
+		h.c.Log.WithFields(verbose.Fields{
+			"ip":         lease.IP.String(),
+			"mac":        lease.MAC.String(),
+			"duration":   leaseTime.String(),
+			"network":    network.name,
+			"relay_ip":   p.GIAddr().String(),
+			"registered": registered,
+			"host":       hostName,
+			"hostname":   lease.Hostname,
+			"dns_name":   lease.DNSName,
+			"classes":    strings.Join(lease.Classes, ","),
+			"client_id":  lease.ClientIDString(),
+			"action":     "rapid_commit_ack",
+			"took":       time.Since(start).String(),
+		}).Info("Acknowledging rapid commit")
+
+		return h.ackReply(p, options, relay, lease, leaseOptions, leaseTime, scopes, fqdnReply,
+			dhcp4.Option{Code: dhcp4.OptionRapidCommit, Value: []byte{}})
 	}
 
 	// Set temporary offered flag and end time
 	lease.Offered = true
 	lease.Start = time.Now()
 	lease.End = time.Now().Add(time.Duration(30) * time.Second) // Set a short end time so it's not offered to other clients
-	lease.MAC = make([]byte, len(p.CHAddr()))
-	copy(lease.MAC, p.CHAddr())
+	lease.ClientID = append([]byte(nil), clientID...)
 	// No Save because this is a temporary "lease", if the client accepts then we commit to storage
-	// Get options
-	leaseOptions := pool.getOptions(registered)
 
 	h.c.Log.WithFields(verbose.Fields{
 		"ip":         lease.IP.String(),
 		"mac":        p.CHAddr().String(),
 		"registered": registered,
 		"network":    network.name,
+		"host":       hostName,
+		"classes":    strings.Join(classes, ","),
 		"action":     "offer",
 		"took":       time.Since(start).String(),
 	}).Info("Offering lease to client")
 
 	// Send an offer
-	return dhcp4.ReplyPacket(
+	prl := options[dhcp4.OptionParameterRequestList]
+	boot := getBootParams(options, scopes...)
+	t1, t2 := renewalTimes(leaseTime, scopes...)
+	replyOptions := append(leaseOptions.SelectOrderOrAll(prl), renewalOptions(leaseOptions, leaseTime, t1, t2)...)
+	replyOptions = append(replyOptions, boot.options(leaseOptions, prl)...)
+	reply := dhcp4.ReplyPacket(
 		p,
 		dhcp4.Offer,
-		c.global.serverIdentifier,
+		h.conf.global.serverIdentifier,
 		lease.IP,
-		pool.getLeaseTime(0, registered),
-		leaseOptions.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList]),
+		leaseTime,
+		append(replyOptions, h.subnetSelectionOption(p, options, relay)...),
 	)
+	boot.setHeader(reply)
+	return reply
 }
 
+// getCheckedFreeLease returns a free lease from network, only from pools that allow
+// BOOTP if bootp is true. If the lease's pool has ping-check
+// enabled, the address is probed first. Addresses that respond are abandoned and
+// the next candidate is tried. network must be locked, the lock is released while probing.
+// An address already reserved for mac, such as one being probed for a retransmitted
+// request, is returned instead of taking another one.
+func (h *Handler) getCheckedFreeLease(network *network, mac net.HardwareAddr, registered, bootp bool, relay *dhcp4.RelayAgentInformation, classes []string) (*models.Lease, *pool) {
+	if lease, pool := network.getOfferByMAC(mac, registered, bootp, relay, classes); lease != nil {
+		return lease, pool
+	}
+	if !h.c.Failover.canAllocate() {
+		return nil, nil
+	}
+
+	for i := 0; i < maxPingAttempts; i++ {
+		lease, pool := network.getFreeLease(h.c, registered, bootp, relay, classes)
+		if lease == nil { // No free lease was found, be more aggressive
+			lease, pool = network.getFreeLeaseDesperate(h.c, registered, bootp, relay, classes)
+		}
+		if lease != nil && lease.DNSName != "" {
+			// The address still has its previous client's records, remove them
+			// while the lease has that client's identity
+			h.removeLeaseDNS(network, lease)
+			lease.DNSName = ""
+		}
+		if lease == nil || !pool.pingCheckEnabled(registered) {
+			return lease, pool
+		}
+
+		// Reserve the address so it isn't given to another client during the probe
+		lease.Offered = true
+		lease.End = time.Now().Add(h.c.PingTimeout + (time.Duration(30) * time.Second))
+		lease.MAC = make([]byte, len(mac))
+		copy(lease.MAC, mac)
+		lease.ClientID = nil // A previous client's identifier would hide the reservation
+
+		network.Unlock()
+		inUse, err := h.c.Pinger.Ping(lease.IP, h.c.PingTimeout)
+		network.Lock()
+
+		if err != nil {
+			h.c.Log.WithFields(verbose.Fields{
+				"ip":    lease.IP.String(),
+				"error": err,
+			}).Error("Ping check failed")
+			return lease, pool
+		}
+		if !inUse {
+			return lease, pool
+		}
+
+		h.c.Log.WithFields(verbose.Fields{
+			"ip":      lease.IP.String(),
+			"network": network.name,
+			"action":  "ping_check",
+		}).Notice("Address responded to ping check, abandoning")
+
+		lease.Abandon("Responded to ping check")
+		lease.Offered = false
+		lease.MAC = nil
+		lease.Start = time.Unix(1, 0)
+		lease.End = time.Unix(1, 0)
+		if err := h.saveLease(lease); err != nil {
+			h.c.Log.WithFields(verbose.Fields{
+				"ip":    lease.IP.String(),
+				"error": err,
+			}).Error("Error saving lease")
+		}
+	}
+	return nil, nil
+}
+
+// maxAbandonReasonLen is the size of the stores' abandon_reason column. Declined
+// leases include the client's message in the reason.
+const maxAbandonReasonLen = 255
+
 // Handle DHCP REQUEST messages
-func (h *Handler) handleRequest(p dhcp4.Packet, options dhcp4.Options, device *models.Device) dhcp4.Packet {
-	if server, ok := options[dhcp4.OptionServerIdentifier]; ok && !net.IP(server).Equal(c.global.serverIdentifier) {
+func (h *Handler) handleRequest(p dhcp4.Packet, options dhcp4.Options, device *models.Device, classes []string) dhcp4.Packet {
+	if server, ok := options[dhcp4.OptionServerIdentifier]; ok && !net.IP(server).Equal(h.conf.global.serverIdentifier) {
 		return nil // Message not for this dhcp server
 	}
 
 	start := time.Now()
-	reqIP := net.IP(options[dhcp4.OptionRequestedIPAddress])
-	if reqIP == nil {
-		reqIP = net.IP(p.CIAddr())
-	}
-
-	if len(reqIP) != 4 || reqIP.Equal(net.IPv4zero) {
-		return dhcp4.ReplyPacket(p, dhcp4.NAK, c.global.serverIdentifier, nil, 0, nil)
+	state := getRequestState(p, options)
+	reqIP := state.requestedAddress(p, options)
+	if reqIP == nil || reqIP.Equal(net.IPv4zero) {
+		return dhcp4.ReplyPacket(p, dhcp4.NAK, h.conf.global.serverIdentifier, nil, 0, nil)
 	}
 
 	registered := isDeviceRegistered(device)
+	relay := h.relayInformation(p, options)
+	clientID := options[dhcp4.OptionClientIdentifier]
 
-	var network *network
-	// Get network object that the relay or client IP belongs to
-	if p.GIAddr().Equal(net.IPv4zero) {
-		// Coming directly from the client
-		network = c.searchNetworksFor(reqIP)
-	} else {
-		// Coming from a relay
-		h.gatewayMutex.Lock()
-		var ok bool
-		network, ok = h.gatewayCache[p.GIAddr().String()]
-		h.gatewayMutex.Unlock()
-		if !ok {
-			// That gateway hasn't been seen before, it needs to go through DISCOVER
-			return dhcp4.ReplyPacket(p, dhcp4.NAK, c.global.serverIdentifier, nil, 0, nil)
-		}
-	}
-
+	// Get network object that the relay information, relay, or client IP belongs to
+	network := h.requestNetwork(p, options, state, relay, reqIP)
 	if network == nil {
 		h.c.Log.WithFields(verbose.Fields{
 			"ip":         reqIP.String(),
 			"registered": registered,
+			"state":      state.String(),
 		}).Info("Got a REQUEST for IP not in a scope")
-		return dhcp4.ReplyPacket(p, dhcp4.NAK, c.global.serverIdentifier, nil, 0, nil)
+		if state == stateSelecting || h.conf.global.authoritative {
+			return dhcp4.ReplyPacket(p, dhcp4.NAK, h.conf.global.serverIdentifier, nil, 0, nil)
+		}
+		return nil // The client may belong to another server
 	}
-	network.Lock()
-	defer network.Unlock()
 
-	lease, pool := network.getLeaseByIP(reqIP, registered)
-	if lease == nil || lease.MAC == nil { // If it returns a new lease, the MAC is nil
+	if !network.includes(reqIP) {
+		// A rebooted client remembered an address from a different network
 		h.c.Log.WithFields(verbose.Fields{
 			"ip":         reqIP.String(),
 			"mac":        p.CHAddr().String(),
 			"network":    network.name,
 			"registered": registered,
-		}).Info("Client tried to request a lease that doesn't exist")
-		return dhcp4.ReplyPacket(p, dhcp4.NAK, c.global.serverIdentifier, nil, 0, nil)
+			"state":      state.String(),
+		}).Info("Client requested an address on the wrong network")
+		if state == stateSelecting || network.isAuthoritative() {
+			return dhcp4.ReplyPacket(p, dhcp4.NAK, h.conf.global.serverIdentifier, nil, 0, nil)
+		}
+		return nil // Another server may know the network better
 	}
+	network.Lock()
+	defer network.Unlock()
 
-	if !bytes.Equal(lease.MAC, p.CHAddr()) {
-		h.c.Log.WithFields(verbose.Fields{
-			"ip":         reqIP.String(),
-			"mac":        p.CHAddr().String(),
-			"lease_mac":  lease.MAC.String(),
-			"network":    network.name,
-			"registered": registered,
-		}).Info("Client tried to request lease not belonging to them")
-		return dhcp4.ReplyPacket(p, dhcp4.NAK, c.global.serverIdentifier, nil, 0, nil)
-	}
+	var (
+		lease        *models.Lease
+		leaseOptions dhcp4.Options
+		leaseDur     time.Duration
+		scopes       []*settings
+	)
 
-	leaseDur := pool.getLeaseTime(0, registered)
-	lease.Start = time.Now()
-	lease.End = time.Now().Add(leaseDur + (time.Duration(10) * time.Second)) // Add 10 seconds to account for slight clock drift
-	lease.Offered = false
-	if ci, ok := options[dhcp4.OptionHostName]; ok {
-		lease.Hostname = string(ci)
+	if host := network.getHostByMAC(p.CHAddr()); host != nil {
+		if !host.fixedAddress.Equal(reqIP) {
+			h.c.Log.WithFields(verbose.Fields{
+				"ip":         reqIP.String(),
+				"mac":        p.CHAddr().String(),
+				"fixed_ip":   host.fixedAddress.String(),
+				"host":       host.name,
+				"network":    network.name,
+				"registered": registered,
+			}).Info("Client with a reservation requested a different address")
+			return dhcp4.ReplyPacket(p, dhcp4.NAK, h.conf.global.serverIdentifier, nil, 0, nil)
+		}
+		lease = host.getLease()
+		leaseOptions = host.getOptions()
+		leaseDur = grantLeaseTime(host.getLeaseTime, requestedLeaseTime(options))
+		scopes = host.scopes()
+	} else {
+		var pool *pool
+		lease, pool = network.getLeaseByIP(reqIP, registered)
+		if lease == nil || lease.MAC == nil || lease.IsAbandoned { // If it returns a new lease, the MAC is nil
+			noRecord := lease == nil || !lease.IsAbandoned
+			if state != stateSelecting && noRecord && !network.isAuthoritative() && network.servesIP(reqIP, registered, classes) {
+				// The address is right for the client but the lease isn't known, another
+				// server may have given it out
+				h.c.Log.WithFields(verbose.Fields{
+					"ip":         reqIP.String(),
+					"mac":        p.CHAddr().String(),
+					"network":    network.name,
+					"registered": registered,
+					"state":      state.String(),
+				}).Info("No record of client's lease, ignoring request")
+				return nil
+			}
+			h.c.Log.WithFields(verbose.Fields{
+				"ip":         reqIP.String(),
+				"mac":        p.CHAddr().String(),
+				"network":    network.name,
+				"registered": registered,
+			}).Info("Client tried to request a lease that doesn't exist")
+			return dhcp4.ReplyPacket(p, dhcp4.NAK, h.conf.global.serverIdentifier, nil, 0, nil)
+		}
+
+		if !network.leaseBelongsTo(lease, p.CHAddr(), clientID) {
+			h.c.Log.WithFields(verbose.Fields{
+				"ip":         reqIP.String(),
+				"mac":        p.CHAddr().String(),
+				"lease_mac":  lease.MAC.String(),
+				"network":    network.name,
+				"registered": registered,
+			}).Info("Client tried to request lease not belonging to them")
+			return dhcp4.ReplyPacket(p, dhcp4.NAK, h.conf.global.serverIdentifier, nil, 0, nil)
+		}
+
+		// Renewals are unicast without relay information so only check relayed requests
+		if relay != nil && !pool.matchesRelay(relay) {
+			h.c.Log.WithFields(verbose.Fields{
+				"ip":         reqIP.String(),
+				"mac":        p.CHAddr().String(),
+				"network":    network.name,
+				"registered": registered,
+			}).Info("Client requested a lease from a pool its relay information doesn't match")
+			return dhcp4.ReplyPacket(p, dhcp4.NAK, h.conf.global.serverIdentifier, nil, 0, nil)
+		}
+		if !pool.allowsClasses(classes) {
+			h.c.Log.WithFields(verbose.Fields{
+				"ip":         reqIP.String(),
+				"mac":        p.CHAddr().String(),
+				"network":    network.name,
+				"registered": registered,
+				"classes":    strings.Join(classes, ","),
+			}).Info("Client requested a lease from a pool its classes aren't allowed")
+			return dhcp4.ReplyPacket(p, dhcp4.NAK, h.conf.global.serverIdentifier, nil, 0, nil)
+		}
+		leaseOptions = pool.getOptions(registered)
+		leaseDur = grantLeaseTime(func(req time.Duration) time.Duration {
+			return pool.getLeaseTime(req, registered)
+		}, requestedLeaseTime(options))
+		scopes = pool.scopes(registered)
 	}
-	if err := h.c.Store.PutLease(lease); err != nil {
+
+	leaseDur = h.c.Failover.capLeaseTime(leaseDur)
+	fqdnReply, err := h.commitLease(p, options, network, lease, leaseDur, relay, classes)
+	if err != nil {
 		h.c.Log.WithFields(verbose.Fields{
 			"mac":   p.CHAddr().String(),
 			"error": err,
 		}).Error("Error saving lease")
-		return dhcp4.ReplyPacket(p, dhcp4.NAK, c.global.serverIdentifier, nil, 0, nil)
+		return dhcp4.ReplyPacket(p, dhcp4.NAK, h.conf.global.serverIdentifier, nil, 0, nil)
 	}
-	leaseOptions := pool.getOptions(registered)
 
 	h.c.Log.WithFields(verbose.Fields{
 		"ip":          lease.IP.String(),
@@ -330,20 +672,68 @@ func (h *Handler) handleRequest(p dhcp4.Packet, options dhcp4.Options, device *m
 		"network":     network.name,
 		"relay_ip":    p.GIAddr().String(),
 		"registered":  device.Registered,
+		"state":       state.String(),
 		"hostname":    lease.Hostname,
+		"dns_name":    lease.DNSName,
+		"classes":     strings.Join(lease.Classes, ","),
+		"client_id":   lease.ClientIDString(),
+		"circuit_id":  lease.Relay.CircuitIDString(),
+		"remote_id":   lease.Relay.RemoteIDString(),
 		"action":      "request_ack",
 		"blacklisted": device.Blacklisted,
 		"took":        time.Since(start).String(),
 	}).Info("Acknowledging request")
 
-	return dhcp4.ReplyPacket(
+	return h.ackReply(p, options, relay, lease, leaseOptions, leaseDur, scopes, fqdnReply)
+}
+
+// commitLease binds lease to the client of p for leaseDur, updates DNS and saves
+// it. It returns the client FQDN option for the reply, if any. network must be locked.
+func (h *Handler) commitLease(p dhcp4.Packet, options dhcp4.Options, network *network, lease *models.Lease, leaseDur time.Duration, relay *dhcp4.RelayAgentInformation, classes []string) ([]byte, error) {
+	lease.Start = time.Now()
+	lease.End = time.Now().Add(leaseDur + (time.Duration(10) * time.Second)) // Add 10 seconds to account for slight clock drift
+	lease.Offered = false
+	lease.ClientID = append([]byte(nil), options[dhcp4.OptionClientIdentifier]...)
+	if ci, ok := options[dhcp4.OptionHostName]; ok {
+		lease.Hostname = string(ci)
+	}
+	if !p.GIAddr().Equal(net.IPv4zero) {
+		// Keep the relay information from the last relayed request when renewing directly
+		lease.Relay = relayInfo(relay)
+	}
+	lease.Classes = classes
+	lease.Type = models.LeaseDynamic // A BOOTP client may have started using DHCP
+	fqdnReply := h.updateLeaseDNS(network, lease, options, leaseDur)
+	if err := h.saveLease(lease); err != nil {
+		return nil, err
+	}
+	return fqdnReply, nil
+}
+
+// ackReply builds the ACK for a committed lease. extra options are added after
+// the lease's options.
+func (h *Handler) ackReply(p dhcp4.Packet, options dhcp4.Options, relay *dhcp4.RelayAgentInformation, lease *models.Lease, leaseOptions dhcp4.Options, leaseDur time.Duration, scopes []*settings, fqdnReply []byte, extra ...dhcp4.Option) dhcp4.Packet {
+	prl := options[dhcp4.OptionParameterRequestList]
+	boot := getBootParams(options, scopes...)
+	t1, t2 := renewalTimes(leaseDur, scopes...)
+	replyOptions := append(leaseOptions.SelectOrderOrAll(prl), renewalOptions(leaseOptions, leaseDur, t1, t2)...)
+	replyOptions = append(replyOptions, boot.options(leaseOptions, prl)...)
+	if fqdnReply != nil {
+		replyOptions = append(replyOptions, dhcp4.Option{Code: dhcp4.OptionClientFQDN, Value: fqdnReply})
+	}
+	replyOptions = append(replyOptions, h.subnetSelectionOption(p, options, relay)...)
+	replyOptions = append(replyOptions, extra...)
+
+	reply := dhcp4.ReplyPacket(
 		p,
 		dhcp4.ACK,
-		c.global.serverIdentifier,
+		h.conf.global.serverIdentifier,
 		lease.IP,
 		leaseDur,
-		leaseOptions.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList]),
+		replyOptions,
 	)
+	boot.setHeader(reply)
+	return reply
 }
 
 // Handle DHCP RELEASE messages
@@ -356,7 +746,7 @@ func (h *Handler) handleRelease(p dhcp4.Packet, options dhcp4.Options, device *m
 
 	registered := isDeviceRegistered(device)
 
-	network := c.searchNetworksFor(reqIP)
+	network := h.conf.searchNetworksFor(reqIP)
 	if network == nil {
 		h.c.Log.WithFields(verbose.Fields{
 			"ip":         reqIP.String(),
@@ -367,8 +757,8 @@ func (h *Handler) handleRelease(p dhcp4.Packet, options dhcp4.Options, device *m
 	network.Lock()
 	defer network.Unlock()
 
-	lease, _ := network.getLeaseByIP(reqIP, registered)
-	if lease == nil || !bytes.Equal(lease.MAC, p.CHAddr()) {
+	lease := network.getLeaseOrHostLease(reqIP, registered)
+	if lease == nil || !network.leaseBelongsTo(lease, p.CHAddr(), options[dhcp4.OptionClientIdentifier]) {
 		leaseMac := ""
 		if lease != nil {
 			leaseMac = lease.MAC.String()
@@ -396,7 +786,9 @@ func (h *Handler) handleRelease(p dhcp4.Packet, options dhcp4.Options, device *m
 
 	lease.Start = time.Unix(1, 0)
 	lease.End = time.Unix(1, 0)
-	if err := h.c.Store.PutLease(lease); err != nil {
+	h.removeLeaseDNS(network, lease)
+	lease.DNSName = ""
+	if err := h.saveLease(lease); err != nil {
 		h.c.Log.WithFields(verbose.Fields{
 			"mac":   p.CHAddr().String(),
 			"error": err,
@@ -406,31 +798,32 @@ func (h *Handler) handleRelease(p dhcp4.Packet, options dhcp4.Options, device *m
 }
 
 // Handle DHCP DECLINE messages
-// TODO: Decline would never work because the ciaddr field will always be 0
-// for a properly formed DECLINE message. Also, a DECLINE has nothing to do
-// with a client being registered or not.
 func (h *Handler) handleDecline(p dhcp4.Packet, options dhcp4.Options, device *models.Device) dhcp4.Packet {
+	if server, ok := options[dhcp4.OptionServerIdentifier]; ok && !net.IP(server).Equal(h.conf.global.serverIdentifier) {
+		return nil // Message not for this dhcp server
+	}
+
 	start := time.Now()
-	reqIP := p.CIAddr()
-	if reqIP == nil || reqIP.Equal(net.IPv4zero) {
+	// A client declining an address will always have a ciaddr of 0, the address is in option 50
+	reqIP := net.IP(options[dhcp4.OptionRequestedIPAddress])
+	if len(reqIP) != 4 || reqIP.Equal(net.IPv4zero) {
 		return nil
 	}
 
-	registered := isDeviceRegistered(device)
-
-	network := c.searchNetworksFor(reqIP)
+	network := h.conf.searchNetworksFor(reqIP)
 	if network == nil {
 		h.c.Log.WithFields(verbose.Fields{
-			"ip":         reqIP.String(),
-			"registered": registered,
+			"declined_ip": reqIP.String(),
+			"mac":         p.CHAddr().String(),
 		}).Notice("Got a DECLINE for IP not in a scope")
 		return nil
 	}
 	network.Lock()
 	defer network.Unlock()
 
-	lease, _ := network.getLeaseByIP(reqIP, registered)
-	if lease == nil || !bytes.Equal(lease.MAC, p.CHAddr()) {
+	// The lease may be in a pool of either registration state
+	lease := network.findLease(reqIP)
+	if lease == nil || !network.leaseBelongsTo(lease, p.CHAddr(), options[dhcp4.OptionClientIdentifier]) {
 		leaseMac := ""
 		if lease != nil {
 			leaseMac = lease.MAC.String()
@@ -441,25 +834,33 @@ func (h *Handler) handleDecline(p dhcp4.Packet, options dhcp4.Options, device *m
 			"mac":         p.CHAddr().String(),
 			"lease_mac":   leaseMac,
 			"network":     network.name,
-			"registered":  registered,
 		}).Notice("Client tried to decline lease not belonging to them")
 		return nil
 	}
 
+	reason := "Declined by client"
+	if msg, ok := options[dhcp4.OptionMessage]; ok && len(msg) > 0 {
+		reason += ": " + string(msg)
+	}
+	if len(reason) > maxAbandonReasonLen {
+		reason = reason[:maxAbandonReasonLen]
+	}
+	lease.Abandon(reason)
+	lease.Start = time.Unix(1, 0)
+	lease.End = time.Unix(1, 0)
+
 	h.c.Log.WithFields(verbose.Fields{
 		"ip":         lease.IP.String(),
 		"mac":        lease.MAC.String(),
 		"network":    network.name,
 		"relay_ip":   p.GIAddr().String(),
 		"registered": device.Registered,
+		"reason":     reason,
 		"action":     "decline",
 		"took":       time.Since(start).String(),
 	}).Notice("Abandoned lease")
 
-	lease.IsAbandoned = true
-	lease.Start = time.Unix(1, 0)
-	lease.End = time.Unix(1, 0)
-	if err := h.c.Store.PutLease(lease); err != nil {
+	if err := h.saveLease(lease); err != nil {
 		h.c.Log.WithFields(verbose.Fields{
 			"mac":   p.CHAddr().String(),
 			"error": err,
@@ -475,21 +876,34 @@ func (h *Handler) handleInform(p dhcp4.Packet, options dhcp4.Options, device *mo
 		return nil
 	}
 
-	network := c.searchNetworksFor(ip)
+	network := h.conf.searchNetworksFor(ip)
 	if network == nil {
 		return nil
 	}
 	network.Lock()
 	defer network.Unlock()
 
-	pool := network.getPoolOfIP(ip)
-	if pool == nil {
-		return nil
-	}
-
 	registered := isDeviceRegistered(device)
 
-	leaseOptions := pool.getOptions(registered)
+	var (
+		leaseOptions dhcp4.Options
+		scopes       []*settings
+	)
+	if host := network.getHostByIP(ip); host != nil {
+		leaseOptions = host.getOptions()
+		scopes = host.scopes()
+	} else if pool := network.getPoolOfIP(ip); pool != nil {
+		leaseOptions = pool.getOptions(registered)
+		scopes = pool.scopes(registered)
+	} else {
+		// Statically configured addresses only get a reply from authoritative networks
+		subnet := network.getSubnetOfIP(ip)
+		if subnet == nil || !network.isAuthoritative() {
+			return nil
+		}
+		leaseOptions = subnet.getOptions(registered)
+		scopes = []*settings{subnet.getSettings(registered)}
+	}
 
 	h.c.Log.WithFields(verbose.Fields{
 		"ip":       ip.String(),
@@ -500,12 +914,16 @@ func (h *Handler) handleInform(p dhcp4.Packet, options dhcp4.Options, device *mo
 		"took":     time.Since(start).String(),
 	}).Info("Informing client")
 
-	return dhcp4.ReplyPacket(
+	prl := options[dhcp4.OptionParameterRequestList]
+	boot := getBootParams(options, scopes...)
+	reply := dhcp4.ReplyPacket(
 		p,
 		dhcp4.ACK,
-		c.global.serverIdentifier,
+		h.conf.global.serverIdentifier,
 		net.IP([]byte{0, 0, 0, 0}),
 		0,
-		leaseOptions.SelectOrderOrAll(options[dhcp4.OptionParameterRequestList]),
+		append(leaseOptions.SelectOrderOrAll(prl), boot.options(leaseOptions, prl)...),
 	)
+	boot.setHeader(reply)
+	return reply
 }
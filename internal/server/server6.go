@@ -0,0 +1,430 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	"github.com/packet-guardian/pg-dhcp/dhcp6"
+	"github.com/packet-guardian/pg-dhcp/models"
+)
+
+// Hardware type of an Ethernet link-layer address in option 79
+const linkLayerEthernet = 1
+
+// newServerDUID creates the server's DUID-LL from the first Ethernet interface.
+// If there isn't one, a random locally administered address is used.
+func newServerDUID() dhcp6.DUID {
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 && len(iface.HardwareAddr) == 6 {
+			return dhcp6.NewDUIDLL(iface.HardwareAddr)
+		}
+	}
+
+	mac := make(net.HardwareAddr, 6)
+	rand.Read(mac)
+	mac[0] = (mac[0] | 0x02) & 0xfe // Locally administered, unicast
+	return dhcp6.NewDUIDLL(mac)
+}
+
+// serve6 serves DHCPv6 on the connection opened by ListenAndServe.
+func (h *Handler) serve6() {
+	err := dhcp6.Serve(h.conn6, h, h.c.Workers)
+	if err != nil && !h.closing {
+		h.c.Log.WithField("error", err).Error("DHCPv6 server stopped")
+	}
+}
+
+// clientHardwareAddr returns the client's hardware address from its DUID or,
+// failing that, the client link-layer address option added by the first relay.
+func clientHardwareAddr(duid dhcp6.DUID, relays []*dhcp6.RelayMessage) net.HardwareAddr {
+	if mac := duid.HardwareAddr(); mac != nil {
+		return mac
+	}
+	if len(relays) == 0 {
+		return nil
+	}
+	lla := relays[len(relays)-1].Options.Get(dhcp6.OptionClientLinkLayerAddr)
+	if len(lla) != 8 || binary.BigEndian.Uint16(lla[0:2]) != linkLayerEthernet {
+		return nil
+	}
+	return net.HardwareAddr(append([]byte(nil), lla[2:]...))
+}
+
+// ServeDHCPv6 processes an incoming DHCPv6 message and returns a reply.
+func (h *Handler) ServeDHCPv6(req *dhcp6.Message, relays []*dhcp6.RelayMessage) *dhcp6.Message {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 2048)
+			runtime.Stack(buf, false)
+			h.c.Log.WithFields(verbose.Fields{
+				"package": "dhcp6",
+				"error":   r,
+				"stack":   string(buf),
+			}).Critical("Recovering from DHCPv6 panic")
+		}
+	}()
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	if !h.c.Failover.shouldServe() {
+		return nil // The failover partner is serving clients
+	}
+
+	duid := dhcp6.DUID(req.Options.Get(dhcp6.OptionClientID))
+	if len(duid) == 0 && req.Type != dhcp6.InformationRequest {
+		return nil
+	}
+
+	serverID := req.Options.Get(dhcp6.OptionServerID)
+	switch req.Type {
+	case dhcp6.Request, dhcp6.Renew, dhcp6.Release, dhcp6.Decline:
+		if !bytes.Equal(serverID, h.duid) {
+			return nil // Message not for this dhcp server
+		}
+	case dhcp6.Confirm:
+		if serverID != nil {
+			return nil // Must be sent to all servers, RFC 8415 section 16.5
+		}
+	default:
+		if serverID != nil && !bytes.Equal(serverID, h.duid) {
+			return nil
+		}
+	}
+
+	// The relay closest to the client identifies the link it's on
+	var link net.IP
+	if len(relays) > 0 {
+		link = relays[len(relays)-1].LinkAddress
+	}
+	mac := clientHardwareAddr(duid, relays)
+
+	h.c.Log.WithFields(verbose.Fields{
+		"type":     req.Type.String(),
+		"duid":     duid.String(),
+		"mac":      mac.String(),
+		"link_ip":  link.String(),
+		"relay_ip": relayPeer(relays).String(),
+	}).Debug("Incoming DHCPv6 request")
+
+	// Clients without a known hardware address are treated as unregistered
+	device := &models.Device{}
+	if mac != nil {
+		var err error
+		device, err = h.c.Store.GetDevice(mac)
+		if err != nil {
+			h.c.Log.WithField("error", err.Error()).Error("Failed getting device")
+			return nil
+		}
+		if device.Blacklisted && h.c.BlockBlacklist {
+			return nil
+		}
+	}
+	registered := isDeviceRegistered(device)
+
+	network := h.conf.searchNetworksFor6(link)
+	if network == nil {
+		h.c.Log.WithField("link_ip", link.String()).Notice("DHCPv6 network not found")
+		return nil
+	}
+
+	switch req.Type {
+	case dhcp6.Solicit:
+		return h.handleIAs(req, dhcp6.Advertise, network, duid, mac, registered, true, false)
+	case dhcp6.Request:
+		return h.handleIAs(req, dhcp6.Reply, network, duid, mac, registered, true, true)
+	case dhcp6.Renew, dhcp6.Rebind:
+		return h.handleIAs(req, dhcp6.Reply, network, duid, mac, registered, false, true)
+	case dhcp6.Release:
+		return h.handleRelease6(req, network, duid, registered)
+	case dhcp6.Decline:
+		return h.handleDecline6(req, network, duid, registered)
+	case dhcp6.Confirm:
+		return h.handleConfirm6(req, network, duid)
+	case dhcp6.InformationRequest:
+		reply := h.newReply6(req, dhcp6.Reply, duid)
+		if s := network.getSubnet6(registered); s != nil {
+			reply.Options = append(reply.Options, s.getOptions(req.Options.Requested())...)
+		}
+		return reply
+	}
+	return nil
+}
+
+// relayPeer returns the address of the relay that sent the message to the server.
+func relayPeer(relays []*dhcp6.RelayMessage) net.IP {
+	if len(relays) == 0 {
+		return nil
+	}
+	return relays[0].PeerAddress
+}
+
+func (h *Handler) newReply6(req *dhcp6.Message, t dhcp6.MessageType, duid dhcp6.DUID) *dhcp6.Message {
+	reply := dhcp6.NewReply(req, t)
+	reply.Options.Add(dhcp6.OptionServerID, h.duid)
+	if len(duid) > 0 {
+		reply.Options.Add(dhcp6.OptionClientID, duid)
+	}
+	return reply
+}
+
+// handleIAs builds the reply to a message carrying IA_NA options. If allocate is
+// true, IAs without a binding are given a new address. If commit is true, the
+// bindings are saved, otherwise they are only held briefly as offers.
+func (h *Handler) handleIAs(req *dhcp6.Message, t dhcp6.MessageType, network *network,
+	duid dhcp6.DUID, mac net.HardwareAddr, registered, allocate, commit bool) *dhcp6.Message {
+	start := time.Now()
+	reply := h.newReply6(req, t, duid)
+
+	var replySubnet *subnet6
+	for _, data := range req.Options.GetAll(dhcp6.OptionIANA) {
+		ia, err := dhcp6.ParseIANA(data)
+		if err != nil {
+			continue
+		}
+
+		network.Lock()
+		lease, pool := network.getLeaseByDUID(duid, ia.IAID, registered)
+		if lease == nil && allocate && h.c.Failover.canAllocate() {
+			lease, pool = network.getFreeLease6(registered)
+		}
+		if lease == nil && req.Type == dhcp6.Rebind {
+			// The binding may have been lost, RFC 8415 section 18.3.5
+			addrs := iaAddresses(ia)
+			if h.c.Failover.canAllocate() {
+				lease, pool = network.claimLease6(addrs, duid, registered)
+			}
+			if lease == nil && len(addrs) > 0 {
+				network.Unlock()
+				// The addresses can't be used on this link
+				resIA := &dhcp6.IANA{IAID: ia.IAID}
+				for _, ip := range addrs {
+					resIA.Options.Add(dhcp6.OptionIAAddr, (&dhcp6.IAAddr{IP: ip}).Marshal())
+				}
+				reply.Options.Add(dhcp6.OptionIANA, resIA.Marshal())
+				continue
+			}
+		}
+		if lease == nil {
+			network.Unlock()
+			code, msg := dhcp6.NoBinding, "No binding for IA"
+			if allocate {
+				code, msg = dhcp6.NoAddrsAvail, "No addresses available"
+				h.c.Log.WithFields(verbose.Fields{
+					"network":    network.name,
+					"registered": registered,
+				}).Alert("No free DHCPv6 leases available in network")
+			}
+			resIA := &dhcp6.IANA{IAID: ia.IAID}
+			resIA.Options.Add(dhcp6.OptionStatusCode, dhcp6.StatusCodeOption(code, msg))
+			reply.Options.Add(dhcp6.OptionIANA, resIA.Marshal())
+			continue
+		}
+
+		leaseTime := h.c.Failover.capLeaseTime(pool.subnet.getLeaseTime(0, registered))
+		lease.ClientID = append([]byte(nil), duid...)
+		lease.IAID = ia.IAID
+		lease.MAC = mac
+		lease.Start = time.Now()
+		if commit {
+			lease.Offered = false
+			lease.End = lease.Start.Add(leaseTime)
+			if err := h.saveLease(lease); err != nil {
+				h.c.Log.WithFields(verbose.Fields{
+					"ip":    lease.IP.String(),
+					"error": err,
+				}).Error("Error saving lease")
+			}
+		} else {
+			// Held briefly so it's not offered to other clients
+			lease.Offered = true
+			lease.End = lease.Start.Add(time.Duration(30) * time.Second)
+		}
+		ip := lease.IP
+		network.Unlock()
+
+		h.c.Log.WithFields(verbose.Fields{
+			"ip":         ip.String(),
+			"duid":       duid.String(),
+			"iaid":       ia.IAID,
+			"registered": registered,
+			"network":    network.name,
+			"action":     req.Type.String(),
+			"took":       time.Since(start).String(),
+		}).Info("DHCPv6 lease")
+
+		addr := &dhcp6.IAAddr{
+			IP:                ip,
+			PreferredLifetime: leaseTime,
+			ValidLifetime:     leaseTime,
+		}
+		t1, t2 := renewalTimes(leaseTime, pool.subnet.scopes(registered)...)
+		resIA := &dhcp6.IANA{
+			IAID: ia.IAID,
+			T1:   t1,
+			T2:   t2,
+		}
+		resIA.Options.Add(dhcp6.OptionIAAddr, addr.Marshal())
+		reply.Options.Add(dhcp6.OptionIANA, resIA.Marshal())
+		if replySubnet == nil {
+			replySubnet = pool.subnet
+		}
+	}
+
+	if replySubnet == nil {
+		replySubnet = network.getSubnet6(registered)
+	}
+	if replySubnet != nil {
+		reply.Options = append(reply.Options, replySubnet.getOptions(req.Options.Requested())...)
+	}
+	return reply
+}
+
+// iaAddresses returns the addresses in the IA Address options of ia.
+func iaAddresses(ia *dhcp6.IANA) []net.IP {
+	var addrs []net.IP
+	for _, data := range ia.Options.GetAll(dhcp6.OptionIAAddr) {
+		if addr, err := dhcp6.ParseIAAddr(data); err == nil {
+			addrs = append(addrs, addr.IP)
+		}
+	}
+	return addrs
+}
+
+func (h *Handler) handleRelease6(req *dhcp6.Message, network *network, duid dhcp6.DUID, registered bool) *dhcp6.Message {
+	reply := h.newReply6(req, dhcp6.Reply, duid)
+
+	for _, data := range req.Options.GetAll(dhcp6.OptionIANA) {
+		ia, err := dhcp6.ParseIANA(data)
+		if err != nil {
+			continue
+		}
+
+		network.Lock()
+		lease, _ := network.getLeaseByDUID(duid, ia.IAID, registered)
+		if lease == nil {
+			network.Unlock()
+			resIA := &dhcp6.IANA{IAID: ia.IAID}
+			resIA.Options.Add(dhcp6.OptionStatusCode, dhcp6.StatusCodeOption(dhcp6.NoBinding, "No binding for IA"))
+			reply.Options.Add(dhcp6.OptionIANA, resIA.Marshal())
+			continue
+		}
+		lease.End = time.Now()
+		lease.Offered = false
+		if err := h.saveLease(lease); err != nil {
+			h.c.Log.WithFields(verbose.Fields{
+				"ip":    lease.IP.String(),
+				"error": err,
+			}).Error("Error saving lease")
+		}
+		ip := lease.IP
+		network.Unlock()
+
+		h.c.Log.WithFields(verbose.Fields{
+			"ip":      ip.String(),
+			"duid":    duid.String(),
+			"network": network.name,
+			"action":  "release",
+		}).Info("Releasing DHCPv6 lease")
+	}
+
+	reply.Options.Add(dhcp6.OptionStatusCode, dhcp6.StatusCodeOption(dhcp6.Success, "Released"))
+	return reply
+}
+
+// handleDecline6 abandons the addresses a client found in use on its link, RFC 8415
+// section 18.3.8. IAs without a binding are answered with a NoBinding status.
+func (h *Handler) handleDecline6(req *dhcp6.Message, network *network, duid dhcp6.DUID, registered bool) *dhcp6.Message {
+	reply := h.newReply6(req, dhcp6.Reply, duid)
+
+	for _, data := range req.Options.GetAll(dhcp6.OptionIANA) {
+		ia, err := dhcp6.ParseIANA(data)
+		if err != nil {
+			continue
+		}
+
+		network.Lock()
+		lease, _ := network.getLeaseByDUID(duid, ia.IAID, registered)
+		if lease == nil {
+			network.Unlock()
+			resIA := &dhcp6.IANA{IAID: ia.IAID}
+			resIA.Options.Add(dhcp6.OptionStatusCode, dhcp6.StatusCodeOption(dhcp6.NoBinding, "No binding for IA"))
+			reply.Options.Add(dhcp6.OptionIANA, resIA.Marshal())
+			continue
+		}
+
+		declined := false
+		for _, ip := range iaAddresses(ia) {
+			if ip.Equal(lease.IP) {
+				declined = true
+			}
+		}
+		if !declined {
+			network.Unlock()
+			continue
+		}
+
+		lease.Abandon("Declined by client")
+		lease.Offered = false
+		lease.Start = time.Unix(1, 0)
+		lease.End = time.Unix(1, 0)
+		if err := h.saveLease(lease); err != nil {
+			h.c.Log.WithFields(verbose.Fields{
+				"ip":    lease.IP.String(),
+				"error": err,
+			}).Error("Error saving lease")
+		}
+		ip := lease.IP
+		network.Unlock()
+
+		h.c.Log.WithFields(verbose.Fields{
+			"ip":      ip.String(),
+			"duid":    duid.String(),
+			"network": network.name,
+			"action":  "decline",
+		}).Notice("Abandoned DHCPv6 lease")
+	}
+
+	reply.Options.Add(dhcp6.OptionStatusCode, dhcp6.StatusCodeOption(dhcp6.Success, "Declined"))
+	return reply
+}
+
+// handleConfirm6 tells a client that may have moved if its addresses are still
+// on its link, RFC 8415 section 18.3.3. Messages without addresses aren't
+// answered.
+func (h *Handler) handleConfirm6(req *dhcp6.Message, network *network, duid dhcp6.DUID) *dhcp6.Message {
+	found := false
+	onLink := true
+	for _, data := range req.Options.GetAll(dhcp6.OptionIANA) {
+		ia, err := dhcp6.ParseIANA(data)
+		if err != nil {
+			continue
+		}
+		for _, ip := range iaAddresses(ia) {
+			found = true
+			if !network.includes(ip) {
+				onLink = false
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	reply := h.newReply6(req, dhcp6.Reply, duid)
+	if onLink {
+		reply.Options.Add(dhcp6.OptionStatusCode, dhcp6.StatusCodeOption(dhcp6.Success, "All addresses on link"))
+	} else {
+		reply.Options.Add(dhcp6.OptionStatusCode, dhcp6.StatusCodeOption(dhcp6.NotOnLink, "Addresses not on link"))
+	}
+	return reply
+}
@@ -0,0 +1,319 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lfkeitel/verbose"
+	"github.com/packet-guardian/pg-dhcp/dhcp6"
+)
+
+func setUpDHCP6Test(t fatalLogger) *Handler {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ParseFile("./testdata/dhcp6Config.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	return NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+}
+
+func dhcp6Request(t dhcp6.MessageType, duid dhcp6.DUID, serverID dhcp6.DUID, iaids ...uint32) *dhcp6.Message {
+	m := &dhcp6.Message{Type: t, TransactionID: [3]byte{1, 2, 3}}
+	m.Options.Add(dhcp6.OptionClientID, duid)
+	if serverID != nil {
+		m.Options.Add(dhcp6.OptionServerID, serverID)
+	}
+	for _, iaid := range iaids {
+		m.Options.Add(dhcp6.OptionIANA, (&dhcp6.IANA{IAID: iaid}).Marshal())
+	}
+	return m
+}
+
+// replyAddress returns the address and valid lifetime of the first IA_NA in m.
+func replyAddress(m *dhcp6.Message, t *testing.T) (net.IP, time.Duration) {
+	ia, err := dhcp6.ParseIANA(m.Options.Get(dhcp6.OptionIANA))
+	if err != nil {
+		t.Fatalf("Reply has no IA_NA: %v", err)
+	}
+	data := ia.Options.Get(dhcp6.OptionIAAddr)
+	if data == nil {
+		t.Fatalf("IA_NA has no address, status %v", ia.Options.Get(dhcp6.OptionStatusCode))
+	}
+	addr, err := dhcp6.ParseIAAddr(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr.IP, addr.ValidLifetime
+}
+
+func TestDHCPv6LeaseCycle(t *testing.T) {
+	server := setUpDHCP6Test(t)
+	defer tearDownStore(server.c.Store)
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	setDevice(server.c.Store, mac, true, false)
+	duid := dhcp6.NewDUIDLL(mac)
+
+	adv := server.ServeDHCPv6(dhcp6Request(dhcp6.Solicit, duid, nil, 1), nil)
+	if adv == nil || adv.Type != dhcp6.Advertise {
+		t.Fatalf("Expected Advertise, got %v", adv)
+	}
+	if !bytes.Equal(adv.Options.Get(dhcp6.OptionServerID), server.duid) {
+		t.Error("Advertise has incorrect server ID")
+	}
+	if !bytes.Equal(adv.Options.Get(dhcp6.OptionClientID), duid) {
+		t.Error("Advertise has incorrect client ID")
+	}
+	if adv.Options.Get(dhcp6.OptionDNSServers) == nil {
+		t.Error("Advertise is missing dns-servers")
+	}
+	offered, _ := replyAddress(adv, t)
+	if !offered.Equal(net.ParseIP("2001:db8:1::100")) {
+		t.Errorf("Expected 2001:db8:1::100, got %s", offered)
+	}
+	if l, _ := server.c.Store.GetLease(offered); l != nil {
+		t.Error("Advertised lease was saved")
+	}
+
+	// Requests for another server are ignored
+	if server.ServeDHCPv6(dhcp6Request(dhcp6.Request, duid, dhcp6.DUID{0, 3, 0, 1, 1, 2, 3, 4, 5, 6}, 1), nil) != nil {
+		t.Error("Expected no reply to a Request for another server")
+	}
+
+	reply := server.ServeDHCPv6(dhcp6Request(dhcp6.Request, duid, server.duid, 1), nil)
+	if reply == nil || reply.Type != dhcp6.Reply {
+		t.Fatalf("Expected Reply, got %v", reply)
+	}
+	ip, valid := replyAddress(reply, t)
+	if !ip.Equal(offered) {
+		t.Errorf("Expected %s, got %s", offered, ip)
+	}
+	if valid != 86400*time.Second {
+		t.Errorf("Expected registered lease time, got %s", valid)
+	}
+	l, _ := server.c.Store.GetLease(ip)
+	if l == nil || !bytes.Equal(l.ClientID, duid) || l.IAID != 1 || !bytes.Equal(l.MAC, mac) {
+		t.Fatalf("Lease not saved correctly %#v", l)
+	}
+
+	reply = server.ServeDHCPv6(dhcp6Request(dhcp6.Renew, duid, server.duid, 1), nil)
+	if ip, _ := replyAddress(reply, t); !ip.Equal(offered) {
+		t.Errorf("Renew gave %s, expected %s", ip, offered)
+	}
+
+	// An IA without a binding can't be renewed
+	reply = server.ServeDHCPv6(dhcp6Request(dhcp6.Renew, duid, server.duid, 2), nil)
+	ia, _ := dhcp6.ParseIANA(reply.Options.Get(dhcp6.OptionIANA))
+	if !bytes.Equal(ia.Options.Get(dhcp6.OptionStatusCode)[:2], []byte{0, byte(dhcp6.NoBinding)}) {
+		t.Error("Expected NoBinding status")
+	}
+
+	reply = server.ServeDHCPv6(dhcp6Request(dhcp6.Release, duid, server.duid, 1), nil)
+	if reply == nil || reply.Type != dhcp6.Reply {
+		t.Fatalf("Expected Reply to Release, got %v", reply)
+	}
+	if l, _ := server.c.Store.GetLease(offered); l.End.After(time.Now()) {
+		t.Error("Released lease is still active")
+	}
+}
+
+func TestDHCPv6Unregistered(t *testing.T) {
+	server := setUpDHCP6Test(t)
+	defer tearDownStore(server.c.Store)
+
+	// A DUID-EN has no hardware address, the client is unregistered
+	duid := dhcp6.DUID{0, 2, 0, 0, 0, 9, 1, 2, 3, 4}
+	reply := server.ServeDHCPv6(dhcp6Request(dhcp6.Request, duid, server.duid, 1), nil)
+	ip, valid := replyAddress(reply, t)
+	if !ip.Equal(net.ParseIP("2001:db8:2::100")) {
+		t.Errorf("Expected unregistered address, got %s", ip)
+	}
+	if valid != 120*time.Second {
+		t.Errorf("Expected subnet6 lease time, got %s", valid)
+	}
+	if ia, _ := dhcp6.ParseIANA(reply.Options.Get(dhcp6.OptionIANA)); ia.T1 != 30*time.Second || ia.T2 != 105*time.Second {
+		t.Errorf("Expected T1 30s and T2 105s, got %s and %s", ia.T1, ia.T2)
+	}
+
+	server.ServeDHCPv6(dhcp6Request(dhcp6.Request, duid, server.duid, 2), nil)
+
+	// The unregistered range only has two addresses
+	reply = server.ServeDHCPv6(dhcp6Request(dhcp6.Solicit, duid, nil, 3), nil)
+	ia, _ := dhcp6.ParseIANA(reply.Options.Get(dhcp6.OptionIANA))
+	if !bytes.Equal(ia.Options.Get(dhcp6.OptionStatusCode)[:2], []byte{0, byte(dhcp6.NoAddrsAvail)}) {
+		t.Error("Expected NoAddrsAvail status")
+	}
+}
+
+func TestDHCPv6Relayed(t *testing.T) {
+	server := setUpDHCP6Test(t)
+	defer tearDownStore(server.c.Store)
+	mac, _ := net.ParseMAC("12:34:56:ab:cd:ef")
+	setDevice(server.c.Store, mac, true, false)
+
+	// The DUID doesn't carry a hardware address, the relay provides it
+	duid := dhcp6.DUID{0, 4, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	relay := &dhcp6.RelayMessage{
+		Type:        dhcp6.RelayForw,
+		LinkAddress: net.ParseIP("fd00:10::1"),
+		PeerAddress: net.ParseIP("fe80::1"),
+	}
+	relay.Options.Add(dhcp6.OptionClientLinkLayerAddr, append([]byte{0, 1}, mac...))
+
+	reply := server.ServeDHCPv6(dhcp6Request(dhcp6.Request, duid, server.duid, 1), []*dhcp6.RelayMessage{relay})
+	ip, _ := replyAddress(reply, t)
+	if !ip.Equal(net.ParseIP("fd00:10::10")) {
+		t.Errorf("Expected address from relay link network, got %s", ip)
+	}
+	l, _ := server.c.Store.GetLease(ip)
+	if l == nil || l.Network != "building" || !bytes.Equal(l.MAC, mac) {
+		t.Errorf("Lease not saved correctly %#v", l)
+	}
+
+	// Unknown links are ignored
+	relay.LinkAddress = net.ParseIP("fd00:99::1")
+	if server.ServeDHCPv6(dhcp6Request(dhcp6.Solicit, duid, nil, 1), []*dhcp6.RelayMessage{relay}) != nil {
+		t.Error("Expected no reply for an unknown link")
+	}
+}
+
+// iaWithAddress returns an IA_NA option value holding ip.
+func iaWithAddress(iaid uint32, ip net.IP) []byte {
+	ia := &dhcp6.IANA{IAID: iaid}
+	ia.Options.Add(dhcp6.OptionIAAddr, (&dhcp6.IAAddr{IP: ip}).Marshal())
+	return ia.Marshal()
+}
+
+func TestDHCPv6ConfirmDecline(t *testing.T) {
+	server := setUpDHCP6Test(t)
+	defer tearDownStore(server.c.Store)
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	setDevice(server.c.Store, mac, true, false)
+	duid := dhcp6.NewDUIDLL(mac)
+
+	reply := server.ServeDHCPv6(dhcp6Request(dhcp6.Request, duid, server.duid, 1), nil)
+	ip, _ := replyAddress(reply, t)
+
+	confirm := func(addr net.IP) *dhcp6.Message {
+		m := dhcp6Request(dhcp6.Confirm, duid, nil)
+		if addr != nil {
+			m.Options.Add(dhcp6.OptionIANA, iaWithAddress(1, addr))
+		}
+		return server.ServeDHCPv6(m, nil)
+	}
+	status := func(m *dhcp6.Message) dhcp6.StatusCode {
+		code := m.Options.Get(dhcp6.OptionStatusCode)
+		if len(code) < 2 {
+			t.Fatal("Reply has no status code")
+		}
+		return dhcp6.StatusCode(code[1])
+	}
+
+	if reply := confirm(ip); reply == nil || status(reply) != dhcp6.Success {
+		t.Error("Expected Success for an address on link")
+	}
+	if reply := confirm(net.ParseIP("fd00:10::10")); reply == nil || status(reply) != dhcp6.NotOnLink {
+		t.Error("Expected NotOnLink for an address on another link")
+	}
+	if confirm(nil) != nil {
+		t.Error("Expected no reply to a Confirm without addresses")
+	}
+
+	// Decline abandons the bound address, IAs without a binding get NoBinding
+	m := dhcp6Request(dhcp6.Decline, duid, server.duid)
+	m.Options.Add(dhcp6.OptionIANA, iaWithAddress(1, ip))
+	m.Options.Add(dhcp6.OptionIANA, iaWithAddress(2, net.ParseIP("2001:db8:1::150")))
+	reply = server.ServeDHCPv6(m, nil)
+	if reply == nil || status(reply) != dhcp6.Success {
+		t.Fatalf("Expected Success reply to Decline, got %v", reply)
+	}
+	ias := reply.Options.GetAll(dhcp6.OptionIANA)
+	if len(ias) != 1 {
+		t.Fatalf("Expected 1 IA in Decline reply, got %d", len(ias))
+	}
+	if ia, _ := dhcp6.ParseIANA(ias[0]); ia.IAID != 2 ||
+		!bytes.Equal(ia.Options.Get(dhcp6.OptionStatusCode)[:2], []byte{0, byte(dhcp6.NoBinding)}) {
+		t.Error("Expected NoBinding status for IA 2")
+	}
+	if l, _ := server.c.Store.GetLease(ip); l == nil || !l.IsAbandoned {
+		t.Error("Declined address was not abandoned")
+	}
+
+	// The client gets a different address
+	reply = server.ServeDHCPv6(dhcp6Request(dhcp6.Request, duid, server.duid, 1), nil)
+	if next, _ := replyAddress(reply, t); next.Equal(ip) {
+		t.Errorf("Declined address %s given out again", ip)
+	}
+}
+
+func TestDHCPv6RebindWithoutBinding(t *testing.T) {
+	server := setUpDHCP6Test(t)
+	defer tearDownStore(server.c.Store)
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	setDevice(server.c.Store, mac, true, false)
+	duid := dhcp6.NewDUIDLL(mac)
+
+	reply := server.ServeDHCPv6(dhcp6Request(dhcp6.Request, duid, server.duid, 1), nil)
+	ip, _ := replyAddress(reply, t)
+
+	// The server restarted without its leases
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+	setDevice(db, mac, true, false)
+	c, err := ParseFile("./testdata/dhcp6Config.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+	server = NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+
+	rebind := func(iaid uint32, addr net.IP) *dhcp6.Message {
+		m := dhcp6Request(dhcp6.Rebind, duid, nil)
+		m.Options.Add(dhcp6.OptionIANA, iaWithAddress(iaid, addr))
+		return server.ServeDHCPv6(m, nil)
+	}
+
+	// An address valid for the link gets its binding back
+	got, valid := replyAddress(rebind(1, ip), t)
+	if !got.Equal(ip) || valid != 86400*time.Second {
+		t.Errorf("Expected %s with registered lease time, got %s %s", ip, got, valid)
+	}
+	if l, _ := db.GetLease(ip); l == nil || !bytes.Equal(l.ClientID, duid) || l.IAID != 1 {
+		t.Errorf("Rebound lease not saved correctly %#v", l)
+	}
+
+	// Addresses that aren't valid for the link are returned with zero lifetimes
+	other := net.ParseIP("fd00:10::10")
+	got, valid = replyAddress(rebind(2, other), t)
+	if !got.Equal(other) || valid != 0 {
+		t.Errorf("Expected %s with zero lifetime, got %s %s", other, got, valid)
+	}
+
+	// Renew still needs a binding
+	m := dhcp6Request(dhcp6.Renew, duid, server.duid)
+	m.Options.Add(dhcp6.OptionIANA, iaWithAddress(3, net.ParseIP("2001:db8:1::150")))
+	ia, _ := dhcp6.ParseIANA(server.ServeDHCPv6(m, nil).Options.Get(dhcp6.OptionIANA))
+	if !bytes.Equal(ia.Options.Get(dhcp6.OptionStatusCode)[:2], []byte{0, byte(dhcp6.NoBinding)}) {
+		t.Error("Expected NoBinding status for Renew")
+	}
+}
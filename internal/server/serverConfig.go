@@ -5,6 +5,8 @@
 package server
 
 import (
+	"time"
+
 	"github.com/lfkeitel/verbose"
 	"github.com/packet-guardian/pg-dhcp/store"
 )
@@ -23,6 +25,15 @@ type ServerConfig struct {
 	Store          store.Store
 	BlockBlacklist bool
 	Workers        int
+	Pinger         Pinger            // Used by ping-check, defaults to ICMP echo
+	PingTimeout    time.Duration     // Defaults to DefaultPingTimeout
+	Failover       *Failover         // Lease synchronization with a partner, nil disables
+	DNS            DNSClient         // Dynamic DNS updates, nil disables
+	DNSTTL         time.Duration     // TTL of DNS records, defaults to a third of the lease time
+	LeaseQuery     *LeaseQueryConfig // Leasequery access, nil disables leasequery
+
+	// Leases expired for this long are deleted, 0 keeps them forever
+	LeaseDeleteAfter time.Duration
 }
 
 func (s *ServerConfig) IsTesting() bool {
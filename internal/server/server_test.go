@@ -222,6 +222,482 @@ func TestBlockBlacklisted(t *testing.T) {
 	}
 }
 
+func TestHostReservation(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/hostConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+	mac, _ := net.ParseMAC("ab:cd:ef:00:00:01")
+
+	opts := []d4.Option{
+		d4.Option{
+			Code:  d4.OptionParameterRequestList,
+			Value: []byte{0x1, 0x3, 0xf},
+		},
+	}
+	p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+
+	dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+	if dp == nil {
+		t.Fatal("Processed packet is nil")
+	}
+	checkIP(dp, []byte{0xa, 0x0, 0x1, 0xa}, t)
+	checkOptions(dp, d4.Options{
+		d4.OptionRouter:     []byte{0xa, 0x0, 0x1, 0x1},
+		d4.OptionDomainName: []byte("printers.example.com"),
+	}, t)
+
+	// Requesting anything but the fixed address is refused
+	opts = append(opts, d4.Option{
+		Code:  d4.OptionRequestedIPAddress,
+		Value: []byte{0xa, 0x0, 0x1, 0xc},
+	})
+	p = d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+
+	rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	if rp == nil {
+		t.Fatal("Processed packet is nil")
+	}
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.NAK)}}, t)
+
+	opts[1].Value = []byte{0xa, 0x0, 0x1, 0xa}
+	p = d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+
+	rp = server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	if rp == nil {
+		t.Fatal("Processed packet is nil")
+	}
+	checkIP(rp, []byte{0xa, 0x0, 0x1, 0xa}, t)
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+
+	if l, _ := db.GetLease(net.ParseIP("10.0.1.10")); l == nil || l.MAC.String() != mac.String() {
+		t.Error("Host lease was not saved")
+	}
+
+	// Another client requesting the fixed address is refused
+	other, _ := net.ParseMAC("12:34:56:12:34:56")
+	p = d4.RequestPacket(d4.Request, other, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+
+	rp = server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.NAK)}}, t)
+}
+
+func TestRelayAgentInformationMatch(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/relayConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+
+	relayOption := func(circuitID, remoteID []byte) d4.Option {
+		val := append([]byte{d4.RelayCircuitID, byte(len(circuitID))}, circuitID...)
+		val = append(val, d4.RelayRemoteID, byte(len(remoteID)))
+		val = append(val, remoteID...)
+		return d4.Option{Code: d4.OptionRelayAgentInformation, Value: val}
+	}
+	remoteID := []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	var tests = []struct {
+		mac      string
+		giaddr   string
+		relay    []d4.Option
+		expected net.IP
+	}{
+		{ // Network and pool matched
+			mac:      "12:34:56:00:00:01",
+			relay:    []d4.Option{relayOption([]byte("eth0/1"), remoteID)},
+			expected: []byte{0xa, 0x0, 0x5, 0xa},
+		},
+		{ // Network matched, open pool
+			mac:      "12:34:56:00:00:02",
+			relay:    []d4.Option{relayOption([]byte("eth0/2"), []byte("other"))},
+			expected: []byte{0xa, 0x0, 0x5, 0x64},
+		},
+		{ // No match, network of the relay IP
+			mac:      "12:34:56:00:00:03",
+			relay:    []d4.Option{relayOption([]byte("eth0/3"), remoteID)},
+			expected: []byte{0xa, 0x0, 0x1, 0xa},
+		},
+		{ // No relay information
+			mac:      "12:34:56:00:00:04",
+			expected: []byte{0xa, 0x0, 0x1, 0xb},
+		},
+		{ // Untrusted relay, network of the relay IP
+			mac:      "12:34:56:00:00:05",
+			giaddr:   "10.0.1.6",
+			relay:    []d4.Option{relayOption([]byte("eth0/1"), remoteID)},
+			expected: []byte{0xa, 0x0, 0x1, 0xc},
+		},
+	}
+
+	for i, test := range tests {
+		mac, _ := net.ParseMAC(test.mac)
+		p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, test.relay)
+		if test.giaddr == "" {
+			test.giaddr = "10.0.1.5"
+		}
+		p.SetGIAddr(net.ParseIP(test.giaddr))
+
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatalf("%02d: Processed packet is nil", i)
+		}
+		checkIP(dp, test.expected, t)
+
+		replyOpts := dp.ParseOptions()
+		if len(test.relay) > 0 && !bytes.Equal(replyOpts[d4.OptionRelayAgentInformation], test.relay[0].Value) {
+			t.Errorf("%02d: Relay agent information not echoed", i)
+		}
+	}
+
+	// The relay information is saved with the lease
+	mac, _ := net.ParseMAC("12:34:56:00:00:01")
+	opts := []d4.Option{
+		relayOption([]byte("eth0/1"), remoteID),
+		d4.Option{
+			Code:  d4.OptionRequestedIPAddress,
+			Value: []byte{0xa, 0x0, 0x5, 0xa},
+		},
+	}
+	p := d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+
+	rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+
+	l, _ := db.GetLease(net.ParseIP("10.0.5.10"))
+	if l == nil {
+		t.Fatal("Lease was not saved")
+	}
+	if string(l.Relay.CircuitID) != "eth0/1" || !bytes.Equal(l.Relay.RemoteID, remoteID) {
+		t.Errorf("Relay information not saved, got %#v", l.Relay)
+	}
+
+	// Relay information sent by a client without a relay is ignored
+	p = d4.RequestPacket(d4.Discover, mac, nil, nil, false, opts)
+	if relay := server.relayInformation(p, p.ParseOptions()); relay != nil {
+		t.Errorf("Expected relay information of a direct client to be ignored, got %#v", relay)
+	}
+}
+
+func TestClientIdentifierBinding(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/clientIDConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:   EnvTesting,
+		Log:   verbose.New(""),
+		Store: db,
+	})
+	mac, _ := net.ParseMAC("12:34:56:00:00:01")
+
+	// Acquire a lease for client ID clientID through relay, returns the offered IP
+	acquire := func(relay net.IP, clientID string) net.IP {
+		opts := []d4.Option{
+			d4.Option{Code: d4.OptionClientIdentifier, Value: []byte(clientID)},
+		}
+		p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+
+		opts = append(opts, d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte(dp.YIAddr().To4())})
+		p = d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+		p.SetGIAddr(relay)
+		rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+		checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+		return dp.YIAddr()
+	}
+
+	// Client ID first, clients sharing a MAC get separate leases
+	relay1 := net.ParseIP("10.0.1.5")
+	first := acquire(relay1, "\x01client-a")
+	second := acquire(relay1, "\x01client-b")
+	if first.Equal(second) {
+		t.Errorf("Clients with different client IDs got the same lease %s", first)
+	}
+	if again := acquire(relay1, "\x01client-a"); !again.Equal(first) {
+		t.Errorf("Expected client to get its lease %s back, got %s", first, again)
+	}
+
+	// Requesting the other client's lease is refused
+	opts := []d4.Option{
+		d4.Option{Code: d4.OptionClientIdentifier, Value: []byte("\x01client-b")},
+		d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte(first.To4())},
+	}
+	p := d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(relay1)
+	rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.NAK)}}, t)
+
+	l, _ := db.GetLease(first)
+	if l == nil || string(l.ClientID) != "\x01client-a" {
+		t.Errorf("Client ID not saved with lease %s", first)
+	}
+
+	// MAC only, the client ID is ignored
+	relay2 := net.ParseIP("10.0.2.5")
+	first = acquire(relay2, "\x01client-a")
+	second = acquire(relay2, "\x01client-b")
+	if !first.Equal(second) {
+		t.Errorf("Expected the same lease with mac-only binding, got %s and %s", first, second)
+	}
+}
+
+func TestDecline(t *testing.T) {
+	server := setUpTest1(t)
+	defer tearDownTest1(server)
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	setDevice(server.c.Store, mac, true, false)
+
+	p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, nil)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+	if dp == nil {
+		t.Fatal("Processed packet is nil")
+	}
+	offered := dp.YIAddr()
+
+	opts := []d4.Option{
+		d4.Option{Code: d4.OptionRequestedIPAddress, Value: []byte(offered.To4())},
+	}
+	p = d4.RequestPacket(d4.Request, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	rp := server.ServeDHCP(p, d4.Request, p.ParseOptions())
+	checkOptions(rp, d4.Options{d4.OptionDHCPMessageType: []byte{byte(d4.ACK)}}, t)
+
+	// The lease is found even after the device's registration changes
+	setDevice(server.c.Store, mac, false, false)
+
+	opts = append(opts,
+		d4.Option{Code: d4.OptionServerIdentifier, Value: []byte{0xa, 0x0, 0x0, 0x1}},
+		d4.Option{Code: d4.OptionMessage, Value: []byte("ARP reply received")},
+	)
+	p = d4.RequestPacket(d4.Decline, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	if server.ServeDHCP(p, d4.Decline, p.ParseOptions()) != nil {
+		t.Error("Expected no response to DECLINE")
+	}
+
+	l, _ := server.c.Store.GetLease(offered)
+	if l == nil || !l.IsAbandoned {
+		t.Fatal("Declined lease was not abandoned")
+	}
+	if l.AbandonReason != "Declined by client: ARP reply received" {
+		t.Errorf("Incorrect abandon reason %q", l.AbandonReason)
+	}
+	if l.AbandonedAt.IsZero() {
+		t.Error("Abandon time not set")
+	}
+
+	conflicts := GetConflicts()
+	if len(conflicts) != 1 || !conflicts[0].IP.Equal(offered) {
+		t.Fatalf("Expected declined address in conflicts, got %v", conflicts)
+	}
+	if conflicts[0] == server.conf.networks["network1"].findLease(offered) {
+		t.Error("Expected a copy of the conflicting lease")
+	}
+
+	// The client gets a different address
+	setDevice(server.c.Store, mac, true, false)
+	p = d4.RequestPacket(d4.Discover, mac, nil, nil, false, nil)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	dp = server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+	if dp == nil {
+		t.Fatal("Processed packet is nil")
+	}
+	if dp.YIAddr().Equal(offered) {
+		t.Errorf("Declined address %s offered again", offered)
+	}
+
+	failover := &Failover{updates: make(chan []byte, 1)}
+	server.c.Failover = failover
+	if err := server.ClearConflict(offered); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ClearConflict(offered); err != ErrNoConflict {
+		t.Errorf("Expected ErrNoConflict, got %v", err)
+	}
+	if l, _ := server.c.Store.GetLease(offered); l.IsAbandoned || l.AbandonReason != "" {
+		t.Error("Conflict was not cleared in store")
+	}
+	select {
+	case data := <-failover.updates:
+		l := models.NewLease()
+		if err := l.Unserialize(data); err != nil || l.IsAbandoned || !l.IP.Equal(offered) {
+			t.Errorf("Incorrect update sent to failover partner: %v %v", l, err)
+		}
+	default:
+		t.Error("Cleared conflict not sent to failover partner")
+	}
+}
+
+func TestDeclineLongMessage(t *testing.T) {
+	server := setUpTest1(t)
+	defer tearDownTest1(server)
+	mac, _ := net.ParseMAC("12:34:56:12:34:56")
+	setDevice(server.c.Store, mac, true, false)
+	leased := acquireLease(server, mac, t).YIAddr()
+
+	// The reason must fit the store's abandon_reason column
+	opts := []d4.Option{
+		{Code: d4.OptionRequestedIPAddress, Value: []byte(leased.To4())},
+		{Code: d4.OptionServerIdentifier, Value: []byte{0xa, 0x0, 0x0, 0x1}},
+		{Code: d4.OptionMessage, Value: bytes.Repeat([]byte{'a'}, 255)},
+	}
+	p := d4.RequestPacket(d4.Decline, mac, nil, nil, false, opts)
+	p.SetGIAddr(net.ParseIP("10.0.1.5"))
+	server.ServeDHCP(p, d4.Decline, p.ParseOptions())
+
+	l, _ := server.c.Store.GetLease(leased)
+	if l == nil || !l.IsAbandoned {
+		t.Fatal("Declined lease was not abandoned")
+	}
+	if len(l.AbandonReason) != maxAbandonReasonLen {
+		t.Errorf("Expected reason of %d bytes, got %d", maxAbandonReasonLen, len(l.AbandonReason))
+	}
+}
+
+// fakePinger responds for the addresses in inUse and records every probe.
+type fakePinger struct {
+	inUse  map[string]bool
+	pinged []string
+	check  func()
+}
+
+func (p *fakePinger) Ping(ip net.IP, timeout time.Duration) (bool, error) {
+	p.pinged = append(p.pinged, ip.String())
+	if p.check != nil {
+		p.check()
+	}
+	return p.inUse[ip.String()], nil
+}
+
+func TestPingCheck(t *testing.T) {
+	db, err := setUpStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownStore(db)
+
+	c, err := ParseFile("./testdata/pingConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	pinger := &fakePinger{
+		inUse: map[string]bool{"10.0.1.10": true, "10.0.1.11": true},
+	}
+	server := NewDHCPServer(c, &ServerConfig{
+		Env:    EnvTesting,
+		Log:    verbose.New(""),
+		Store:  db,
+		Pinger: pinger,
+	})
+
+	// The network must not be locked during the probe
+	network1 := c.networks["network1"]
+	pinger.check = func() {
+		locked := make(chan bool)
+		go func() {
+			network1.Lock()
+			network1.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(time.Second):
+			t.Fatal("Network was locked during ping check")
+		}
+	}
+
+	discover := func(mac net.HardwareAddr, relay net.IP) d4.Packet {
+		p := d4.RequestPacket(d4.Discover, mac, nil, nil, false, nil)
+		p.SetGIAddr(relay)
+		dp := server.ServeDHCP(p, d4.Discover, p.ParseOptions())
+		if dp == nil {
+			t.Fatal("Processed packet is nil")
+		}
+		return dp
+	}
+
+	mac, _ := net.ParseMAC("12:34:56:00:00:01")
+	checkIP(discover(mac, net.ParseIP("10.0.1.5")), []byte{0xa, 0x0, 0x1, 0xc}, t)
+	if len(pinger.pinged) != 3 {
+		t.Errorf("Expected 3 probes, got %v", pinger.pinged)
+	}
+
+	// A retransmitted request during the probe gets the address being probed
+	mac2, _ := net.ParseMAC("12:34:56:00:00:02")
+	pinger.pinged = nil
+	pinger.check = func() {
+		pinger.check = nil
+		network1.Lock()
+		l, _ := server.getCheckedFreeLease(network1, mac2, false, false, nil, nil)
+		network1.Unlock()
+		if l == nil || !l.IP.Equal(net.IP{10, 0, 1, 13}) {
+			t.Errorf("Expected the probed address to be returned, got %v", l)
+		}
+	}
+	checkIP(discover(mac2, net.ParseIP("10.0.1.5")), []byte{0xa, 0x0, 0x1, 0xd}, t)
+	if len(pinger.pinged) != 1 {
+		t.Errorf("Expected 1 probe, got %v", pinger.pinged)
+	}
+
+	for _, ip := range []string{"10.0.1.10", "10.0.1.11"} {
+		l, _ := db.GetLease(net.ParseIP(ip))
+		if l == nil || !l.IsAbandoned || l.AbandonReason != "Responded to ping check" {
+			t.Errorf("Expected %s to be abandoned", ip)
+		}
+	}
+
+	// Ping check disabled, by default and explicitly in a pool
+	pinger.check = nil
+	pinger.pinged = nil
+	checkIP(discover(mac, net.ParseIP("10.0.2.5")), []byte{0xa, 0x0, 0x2, 0xa}, t)
+	checkIP(discover(mac, net.ParseIP("10.0.3.5")), []byte{0xa, 0x0, 0x3, 0xa}, t)
+	if len(pinger.pinged) != 0 {
+		t.Errorf("Expected no probes, got %v", pinger.pinged)
+	}
+}
+
 func checkIP(p d4.Packet, expected net.IP, t *testing.T) {
 	if !bytes.Equal(p.YIAddr().To4(), expected.To4()) {
 		t.Errorf("Incorrect IP. Expected %v, got %v", expected, p.YIAddr())
@@ -5,22 +5,115 @@
 package server
 
 import (
+	"math"
+	"net"
 	"time"
 
 	"github.com/packet-guardian/pg-dhcp/dhcp"
 )
 
+// boolSetting is a boolean setting that may be left unset to inherit its value.
+type boolSetting uint8
+
+const (
+	boolUnset boolSetting = iota
+	boolTrue
+	boolFalse
+)
+
+func newBoolSetting(b bool) boolSetting {
+	if b {
+		return boolTrue
+	}
+	return boolFalse
+}
+
 type settings struct {
 	options          map[dhcp4.OptionCode][]byte
 	defaultLeaseTime time.Duration
 	maxLeaseTime     time.Duration
 	freeLeaseAfter   time.Duration
+	pingCheck        boolSetting
+	ddnsDomain       string // Forward zone for dynamic DNS updates
+	ddnsReverseZone  string // Reverse zone for dynamic DNS updates
+	nextServer       net.IP
+	serverName       string
+	filename         string            // Boot file for clients without a matching architecture
+	archFilenames    map[uint16]string // Boot files by client architecture, option 93
+	ipxeFilename     string            // Boot file for clients already running iPXE
+	renewalRatio     float64           // T1 as a fraction of the lease time
+	rebindingRatio   float64           // T2 as a fraction of the lease time
+	allowBOOTP       boolSetting
+	bootpLeaseTime   time.Duration // infiniteLeaseTime if BOOTP leases never expire
+	rapidCommit      boolSetting
+
+	freeLeaseAfterPos position // Where free-lease-after was set, for validation
 }
 
 func newSettingsBlock() *settings {
 	return &settings{
-		options: make(map[dhcp4.OptionCode][]byte),
+		options:       make(map[dhcp4.OptionCode][]byte),
+		archFilenames: make(map[uint16]string),
+	}
+}
+
+// infiniteLeaseTime is the lease time of leases that never expire.
+const infiniteLeaseTime = time.Duration(math.MaxInt64)
+
+// bootpLeaseTime returns how long a BOOTP client keeps its address. blocks are
+// ordered from the most specific scope. BOOTP clients never renew so leases are
+// infinite unless a block sets a time.
+func bootpLeaseTime(blocks ...*settings) time.Duration {
+	for _, s := range blocks {
+		if s.bootpLeaseTime != 0 {
+			return s.bootpLeaseTime
+		}
+	}
+	return infiniteLeaseTime
+}
+
+// rapidCommitEnabled returns if clients may skip the offer and get an ACK for a
+// DISCOVER with the rapid commit option, RFC 4039. blocks are ordered from the
+// most specific scope, the first block that sets it decides.
+func rapidCommitEnabled(blocks ...*settings) bool {
+	for _, s := range blocks {
+		if s.rapidCommit != boolUnset {
+			return s.rapidCommit == boolTrue
+		}
 	}
+	return false
+}
+
+// Default T1 and T2 ratios, RFC 2131 section 4.4.5
+const (
+	defaultRenewalRatio   = 0.5
+	defaultRebindingRatio = 0.875
+)
+
+// renewalTimes returns the renewal (T1) and rebinding (T2) times of a lease of
+// duration d. blocks are ordered from the most specific scope, each ratio is
+// taken from the first block that sets it.
+func renewalTimes(d time.Duration, blocks ...*settings) (time.Duration, time.Duration) {
+	var renewal, rebinding float64
+	for _, s := range blocks {
+		if renewal == 0 {
+			renewal = s.renewalRatio
+		}
+		if rebinding == 0 {
+			rebinding = s.rebindingRatio
+		}
+	}
+	if renewal == 0 {
+		renewal = defaultRenewalRatio
+	}
+	if rebinding == 0 {
+		rebinding = defaultRebindingRatio
+	}
+	if renewal >= rebinding {
+		// Ratios set in different scopes may conflict, T1 must come before T2
+		renewal, rebinding = defaultRenewalRatio, defaultRebindingRatio
+	}
+	return time.Duration(float64(d) * renewal), time.Duration(float64(d) * rebinding)
 }
 
 // mergeSettings will merge s into d.
@@ -34,6 +127,47 @@ func mergeSettings(d, s *settings) {
 	if d.freeLeaseAfter == 0 {
 		d.freeLeaseAfter = s.freeLeaseAfter
 	}
+	if d.pingCheck == boolUnset {
+		d.pingCheck = s.pingCheck
+	}
+	if d.ddnsDomain == "" {
+		d.ddnsDomain = s.ddnsDomain
+	}
+	if d.ddnsReverseZone == "" {
+		d.ddnsReverseZone = s.ddnsReverseZone
+	}
+	if d.nextServer == nil {
+		d.nextServer = s.nextServer
+	}
+	if d.serverName == "" {
+		d.serverName = s.serverName
+	}
+	// Boot files are inherited together so a scope's filename isn't overridden
+	// by an architecture specific file from a higher scope
+	if d.filename == "" && len(d.archFilenames) == 0 {
+		d.filename = s.filename
+		for a, f := range s.archFilenames {
+			d.archFilenames[a] = f
+		}
+	}
+	if d.ipxeFilename == "" {
+		d.ipxeFilename = s.ipxeFilename
+	}
+	if d.renewalRatio == 0 {
+		d.renewalRatio = s.renewalRatio
+	}
+	if d.rebindingRatio == 0 {
+		d.rebindingRatio = s.rebindingRatio
+	}
+	if d.allowBOOTP == boolUnset {
+		d.allowBOOTP = s.allowBOOTP
+	}
+	if d.bootpLeaseTime == 0 {
+		d.bootpLeaseTime = s.bootpLeaseTime
+	}
+	if d.rapidCommit == boolUnset {
+		d.rapidCommit = s.rapidCommit
+	}
 
 	for c, v := range s.options {
 		if _, ok := d.options[c]; !ok {
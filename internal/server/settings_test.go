@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"net"
 	"testing"
 
 	"github.com/packet-guardian/pg-dhcp/dhcp"
@@ -18,6 +19,14 @@ func TestSettingsMerge(t *testing.T) {
 	s.defaultLeaseTime = 360
 	s.maxLeaseTime = 500
 	s.freeLeaseAfter = 1800
+	s.pingCheck = boolTrue
+	s.ddnsDomain = "example.com"
+	s.ddnsReverseZone = "0.10.in-addr.arpa"
+	s.nextServer = net.IP{10, 0, 0, 5}
+	s.filename = "pxelinux.0"
+	s.archFilenames[7] = "ipxe.efi"
+
+	d.archFilenames[9] = "grubx64.efi"
 
 	mergeSettings(d, s)
 
@@ -30,6 +39,20 @@ func TestSettingsMerge(t *testing.T) {
 	if d.freeLeaseAfter != s.freeLeaseAfter {
 		t.Errorf("Expected %d, got %d", d.freeLeaseAfter, s.freeLeaseAfter)
 	}
+	if d.pingCheck != s.pingCheck {
+		t.Errorf("Expected %d, got %d", s.pingCheck, d.pingCheck)
+	}
+	if d.ddnsDomain != s.ddnsDomain || d.ddnsReverseZone != s.ddnsReverseZone {
+		t.Errorf("Expected %s %s, got %s %s", s.ddnsDomain, s.ddnsReverseZone, d.ddnsDomain, d.ddnsReverseZone)
+	}
+
+	if !d.nextServer.Equal(s.nextServer) {
+		t.Errorf("Expected %s, got %s", s.nextServer, d.nextServer)
+	}
+	// Boot files are only inherited when none are set
+	if d.filename != "" || len(d.archFilenames) != 1 {
+		t.Errorf("Expected boot files to not be inherited, got %q %v", d.filename, d.archFilenames)
+	}
 
 	// Ensure the original value stays intact
 	if bytes.Equal(d.options[dhcp4.OptionBroadcastAddress], s.options[dhcp4.OptionBroadcastAddress]) {
@@ -41,3 +64,26 @@ func TestSettingsMerge(t *testing.T) {
 		t.Errorf("Expected %s, got %s", d.options[dhcp4.OptionDomainName], s.options[dhcp4.OptionDomainName])
 	}
 }
+
+func TestRenewalTimes(t *testing.T) {
+	pool := newSettingsBlock()
+	subnet := newSettingsBlock()
+
+	t1, t2 := renewalTimes(1000, pool, subnet)
+	if t1 != 500 || t2 != 875 {
+		t.Errorf("Expected default times, got %d %d", t1, t2)
+	}
+
+	pool.renewalRatio = 0.25
+	subnet.renewalRatio = 0.5
+	subnet.rebindingRatio = 0.75
+	if t1, t2 := renewalTimes(1000, pool, subnet); t1 != 250 || t2 != 750 {
+		t.Errorf("Expected 250 750, got %d %d", t1, t2)
+	}
+
+	// Conflicting ratios from different scopes use the defaults
+	pool.renewalRatio = 0.8
+	if t1, t2 := renewalTimes(1000, pool, subnet); t1 != 500 || t2 != 875 {
+		t.Errorf("Expected default times, got %d %d", t1, t2)
+	}
+}
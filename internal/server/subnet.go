@@ -12,12 +12,14 @@ import (
 )
 
 type subnet struct {
+	pos           position
 	allowUnknown  bool
 	settings      *settings
 	optionsCached bool
 	net           *net.IPNet
 	network       *network
 	pools         []*pool
+	hosts         []*host
 }
 
 func newSubnet() *subnet {
@@ -47,23 +49,22 @@ func (s *subnet) getLeaseTime(req time.Duration, registered bool) time.Duration
 		return s.settings.maxLeaseTime
 	}
 
-	// Save the result for later
-	s.settings.maxLeaseTime = s.network.getLeaseTime(req, registered)
-
-	if req <= s.settings.maxLeaseTime {
-		return req
-	}
-	return s.settings.maxLeaseTime
+	return s.network.getLeaseTime(req, registered)
 }
 
 func (s *subnet) getOptions(registered bool) dhcp4.Options {
+	return s.getSettings(registered).options
+}
+
+// getSettings returns the subnet's settings merged with those of its network.
+func (s *subnet) getSettings(registered bool) *settings {
 	if s.optionsCached {
-		return s.settings.options
+		return s.settings
 	}
 
 	mergeSettings(s.settings, s.network.getSettings(registered))
 	s.optionsCached = true
-	return s.settings.options
+	return s.settings
 }
 
 func (s *subnet) includes(ip net.IP) bool {
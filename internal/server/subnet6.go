@@ -0,0 +1,81 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp6"
+)
+
+// options6 maps the names usable in option6 statements to their DHCPv6 codes.
+var options6 = map[string]dhcp6.OptionCode{
+	"dns-servers": dhcp6.OptionDNSServers,
+	"domain-list": dhcp6.OptionDomainList,
+}
+
+type subnet6 struct {
+	pos          position
+	allowUnknown bool
+	settings     *settings
+	options      map[dhcp6.OptionCode][]byte
+	net          *net.IPNet
+	network      *network
+	pools        []*pool6
+}
+
+func newSubnet6() *subnet6 {
+	return &subnet6{
+		settings: newSettingsBlock(),
+		options:  make(map[dhcp6.OptionCode][]byte),
+	}
+}
+
+// getLeaseTime returns the lease time given the requested time req and if the client is registered.
+// If the subnet does not have an explicitly set duration, it will get the duration from its network.
+func (s *subnet6) getLeaseTime(req time.Duration, registered bool) time.Duration {
+	if req == 0 {
+		if s.settings.defaultLeaseTime > 0 {
+			return s.settings.defaultLeaseTime
+		}
+		return s.network.getLeaseTime(req, registered)
+	}
+
+	if s.settings.maxLeaseTime > 0 {
+		if req <= s.settings.maxLeaseTime {
+			return req
+		}
+		return s.settings.maxLeaseTime
+	}
+	return s.network.getLeaseTime(req, registered)
+}
+
+// scopes returns the subnet's settings followed by the merged settings of its network.
+func (s *subnet6) scopes(registered bool) []*settings {
+	return []*settings{s.settings, s.network.getSettings(registered)}
+}
+
+// getOptions returns the configured options for a reply. If requested is
+// empty all options are returned, otherwise only those requested.
+func (s *subnet6) getOptions(requested []dhcp6.OptionCode) dhcp6.Options {
+	var opts dhcp6.Options
+	if len(requested) == 0 {
+		for code, val := range s.options {
+			opts.Add(code, val)
+		}
+		return opts
+	}
+	for _, code := range requested {
+		if val, ok := s.options[code]; ok {
+			opts.Add(code, val)
+		}
+	}
+	return opts
+}
+
+func (s *subnet6) includes(ip net.IP) bool {
+	return s.net.Contains(ip)
+}
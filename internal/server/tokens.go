@@ -14,7 +14,18 @@ type token int
 type lexToken struct {
 	token     token
 	value     interface{}
-	line, pos int
+	src       *lexSource
+	line, col int
+}
+
+// position returns where the token is in the configuration files.
+func (t *lexToken) position() position {
+	p := position{line: t.line, col: t.col}
+	if t.src != nil {
+		p.file = t.src.file
+		p.include = t.src.include
+	}
+	return p
 }
 
 const (
@@ -29,7 +40,9 @@ const (
 	NUMBER
 	STRING
 	IP_ADDRESS
+	MAC_ADDRESS
 	BOOLEAN
+	FLOAT
 	literal_end
 
 	keyword_beg
@@ -44,12 +57,47 @@ const (
 	RANGE
 	INCLUDE
 	LOCAL
+	HOST
+	HARDWARE_ADDRESS
+	FIXED_ADDRESS
+	MATCH
+	CIRCUIT_ID
+	REMOTE_ID
+	LEASE_BINDING
+	SUBNET6
+	RANGE6
+	OPTION6
+	CLASS
+	ALLOW
+	DENY
+	VENDOR_CLASS
+	USER_CLASS
+	PARAMETER_LIST
+	HARDWARE_PREFIX
+	HOSTNAME
+	ARCH
+	HEX
+	OPTION_DEFINITION
+	AUTHORITATIVE
+	TRUSTED_RELAY
 
 	setting_beg
 	OPTION
 	FREE_LEASE_AFTER
 	DEFAULT_LEASE_TIME
 	MAX_LEASE_TIME
+	PING_CHECK
+	DDNS_DOMAIN
+	DDNS_REVERSE_ZONE
+	NEXT_SERVER
+	SERVER_NAME
+	FILENAME
+	IPXE_FILENAME
+	RENEWAL_RATIO
+	REBINDING_RATIO
+	ALLOW_BOOTP
+	BOOTP_LEASE_TIME
+	RAPID_COMMIT
 	setting_end
 	keyword_end
 )
@@ -59,10 +107,12 @@ var tokens = [...]string{
 	EOF:     "EOF",
 	COMMENT: "COMMENT",
 
-	NUMBER:     "NUMBER",
-	STRING:     "STRING",
-	IP_ADDRESS: "IP_ADDRESS",
-	BOOLEAN:    "BOOLEAN",
+	NUMBER:      "NUMBER",
+	STRING:      "STRING",
+	IP_ADDRESS:  "IP_ADDRESS",
+	MAC_ADDRESS: "MAC_ADDRESS",
+	BOOLEAN:     "BOOLEAN",
+	FLOAT:       "FLOAT",
 
 	END:               "end",
 	GLOBAL:            "global",
@@ -75,11 +125,46 @@ var tokens = [...]string{
 	RANGE:             "range",
 	INCLUDE:           "include",
 	LOCAL:             "local",
+	HOST:              "host",
+	HARDWARE_ADDRESS:  "hardware-address",
+	FIXED_ADDRESS:     "fixed-address",
+	MATCH:             "match",
+	CIRCUIT_ID:        "circuit-id",
+	REMOTE_ID:         "remote-id",
+	LEASE_BINDING:     "lease-binding",
+	SUBNET6:           "subnet6",
+	RANGE6:            "range6",
+	OPTION6:           "option6",
+	CLASS:             "class",
+	ALLOW:             "allow",
+	DENY:              "deny",
+	VENDOR_CLASS:      "vendor-class",
+	USER_CLASS:        "user-class",
+	PARAMETER_LIST:    "parameter-list",
+	HARDWARE_PREFIX:   "hardware-prefix",
+	HOSTNAME:          "hostname",
+	ARCH:              "arch",
+	HEX:               "hex",
+	OPTION_DEFINITION: "option-definition",
+	AUTHORITATIVE:     "authoritative",
+	TRUSTED_RELAY:     "trusted-relay",
 
 	OPTION:             "option",
 	FREE_LEASE_AFTER:   "free-lease-after",
 	DEFAULT_LEASE_TIME: "default-lease-time",
 	MAX_LEASE_TIME:     "max-lease-time",
+	PING_CHECK:         "ping-check",
+	DDNS_DOMAIN:        "ddns-domain",
+	DDNS_REVERSE_ZONE:  "ddns-reverse-zone",
+	NEXT_SERVER:        "next-server",
+	SERVER_NAME:        "server-name",
+	FILENAME:           "filename",
+	IPXE_FILENAME:      "ipxe-filename",
+	RENEWAL_RATIO:      "renewal-ratio",
+	REBINDING_RATIO:    "rebinding-ratio",
+	ALLOW_BOOTP:        "allow-bootp",
+	BOOTP_LEASE_TIME:   "bootp-lease-time",
+	RAPID_COMMIT:       "rapid-commit",
 }
 
 var keywords map[string]token
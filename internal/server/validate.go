@@ -0,0 +1,302 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/packet-guardian/pg-dhcp/dhcp"
+)
+
+// Severity is how serious a configuration problem is.
+type Severity int
+
+const (
+	// SeverityWarning problems are likely mistakes but the server can run.
+	SeverityWarning Severity = iota
+	// SeverityError problems make the server misbehave, it won't start with them.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// A Problem is an issue found in a configuration by Validate.
+type Problem struct {
+	Severity Severity
+	File     string
+	Line     int // 0 if the problem is about the whole file
+	Column   int
+	Message  string
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", position{file: p.File, line: p.Line, col: p.Column}, p.Severity, p.Message)
+}
+
+// Problems is a list of configuration problems.
+type Problems []*Problem
+
+// HasErrors returns if any of the problems is an error.
+func (ps Problems) HasErrors() bool {
+	for _, p := range ps {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns only the problems that are errors.
+func (ps Problems) Errors() Problems {
+	var errs Problems
+	for _, p := range ps {
+		if p.Severity == SeverityError {
+			errs = append(errs, p)
+		}
+	}
+	return errs
+}
+
+// Error joins the problems, one per line.
+func (ps Problems) Error() string {
+	lines := make([]string, len(ps))
+	for i, p := range ps {
+		lines[i] = p.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+type validator struct {
+	c        *Config
+	problems Problems
+}
+
+func (v *validator) errorf(pos position, format string, args ...interface{}) {
+	v.problems = append(v.problems, &Problem{SeverityError, pos.file, pos.line, pos.col, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(pos position, format string, args ...interface{}) {
+	v.problems = append(v.problems, &Problem{SeverityWarning, pos.file, pos.line, pos.col, fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the configuration for problems the parser can't find on its
+// own such as overlapping subnets and ranges. Problems are sorted by file and line.
+func (c *Config) Validate() Problems {
+	v := &validator{c: c}
+	networks := c.sortedNetworks()
+
+	v.checkGlobal()
+	v.checkLocalNetworks(networks)
+	v.checkSubnetOverlaps(networks)
+	v.checkRanges(networks)
+	v.checkFreeLeaseAfter(networks)
+	for _, n := range networks {
+		for _, s := range n.subnets {
+			v.checkSubnetOptions(s)
+		}
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return v.problems
+}
+
+// sortedNetworks returns the networks in the order they're declared.
+func (c *Config) sortedNetworks() []*network {
+	networks := make([]*network, 0, len(c.networks))
+	for _, n := range c.networks {
+		networks = append(networks, n)
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		a, b := networks[i].pos, networks[j].pos
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		return networks[i].name < networks[j].name
+	})
+	return networks
+}
+
+func (v *validator) checkGlobal() {
+	if v.c.global.serverIdentifier == nil {
+		pos := v.c.global.pos
+		if pos.line == 0 {
+			pos.file = v.c.file
+		}
+		v.errorf(pos, "No server-identifier in global block")
+	}
+}
+
+func (v *validator) checkLocalNetworks(networks []*network) {
+	var first *network
+	for _, n := range networks {
+		if !n.local {
+			continue
+		}
+		if first == nil {
+			first = n
+			continue
+		}
+		v.warnf(n.pos, "Network %s is local but network %s (%s) is already local, only one is used", n.name, first.name, first.pos)
+	}
+}
+
+func (v *validator) checkSubnetOverlaps(networks []*network) {
+	type declared struct {
+		net     *net.IPNet
+		pos     position
+		network *network
+	}
+	var subnets []declared
+	for _, n := range networks {
+		for _, s := range n.subnets {
+			subnets = append(subnets, declared{s.net, s.pos, n})
+		}
+		for _, s := range n.subnets6 {
+			subnets = append(subnets, declared{s.net, s.pos, n})
+		}
+	}
+
+	for i, a := range subnets {
+		for _, b := range subnets[:i] {
+			if a.network == b.network || !netsOverlap(a.net, b.net) {
+				continue
+			}
+			v.errorf(a.pos, "Subnet %s in network %s overlaps subnet %s in network %s (%s)", a.net, a.network.name, b.net, b.network.name, b.pos)
+		}
+	}
+}
+
+func (v *validator) checkRanges(networks []*network) {
+	type declared struct {
+		start, end net.IP
+		pos        position
+	}
+	var ranges []declared
+
+	check := func(start, end net.IP, pos position, subnet *net.IPNet) {
+		if !subnet.Contains(start) || !subnet.Contains(end) {
+			v.errorf(pos, "Range %s - %s is not in subnet %s", start, end, subnet)
+		}
+		if bytes.Compare(start.To16(), end.To16()) > 0 {
+			v.errorf(pos, "Range start %s is after range end %s", start, end)
+			return
+		}
+		for _, r := range ranges {
+			if bytes.Compare(start.To16(), r.end.To16()) <= 0 && bytes.Compare(r.start.To16(), end.To16()) <= 0 {
+				v.errorf(pos, "Range %s - %s overlaps range %s - %s (%s)", start, end, r.start, r.end, r.pos)
+			}
+		}
+		ranges = append(ranges, declared{start, end, pos})
+	}
+
+	for _, n := range networks {
+		for _, s := range n.subnets {
+			for _, p := range s.pools {
+				check(p.rangeStart, p.rangeEnd, p.pos, s.net)
+			}
+		}
+		for _, s := range n.subnets6 {
+			for _, p := range s.pools {
+				check(p.rangeStart, p.rangeEnd, p.pos, s.net)
+			}
+		}
+	}
+}
+
+// checkFreeLeaseAfter warns about free-lease-after outside the global registered
+// and unregistered blocks. Leases are only freed using those blocks' settings.
+func (v *validator) checkFreeLeaseAfter(networks []*network) {
+	var blocks []*settings
+	blocks = append(blocks, v.c.global.settings)
+	for _, h := range v.c.global.hosts {
+		blocks = append(blocks, h.settings)
+	}
+	for _, n := range networks {
+		blocks = append(blocks, n.settings, n.registeredSettings, n.unregisteredSettings)
+		for _, h := range n.hosts {
+			blocks = append(blocks, h.settings)
+		}
+		for _, s := range n.subnets {
+			blocks = append(blocks, s.settings)
+			for _, p := range s.pools {
+				blocks = append(blocks, p.settings)
+			}
+		}
+		for _, s := range n.subnets6 {
+			blocks = append(blocks, s.settings)
+		}
+	}
+
+	for _, s := range blocks {
+		if s.freeLeaseAfterPos.line != 0 {
+			v.warnf(s.freeLeaseAfterPos, "free-lease-after is ignored, it's only used in the global registered and unregistered blocks")
+		}
+	}
+}
+
+// checkSubnetOptions checks the subnet-mask, router and broadcast-address
+// options given to clients in s agree with its address and mask.
+func (v *validator) checkSubnetOptions(s *subnet) {
+	subnetOpts := s.getOptions(!s.allowUnknown)
+	v.checkOptions(s, s.pos, subnetOpts)
+	for _, p := range s.pools {
+		// Only check the options the pool changes, the rest were checked with the subnet
+		own := make(dhcp4.Options)
+		for code, val := range p.settings.options {
+			if !bytes.Equal(val, subnetOpts[code]) {
+				own[code] = val
+			}
+		}
+		v.checkOptions(s, p.pos, own)
+	}
+}
+
+func (v *validator) checkOptions(s *subnet, pos position, opts dhcp4.Options) {
+	mask := net.IP(s.net.Mask).To4()
+	if val, ok := opts[dhcp4.OptionSubnetMask]; ok && !bytes.Equal(val, mask) {
+		v.errorf(pos, "Option subnet-mask %s doesn't match subnet %s", net.IP(val), s.net)
+	}
+
+	if val, ok := opts[dhcp4.OptionRouter]; ok {
+		for i := 0; i+4 <= len(val); i += 4 {
+			if router := net.IP(val[i : i+4]); !s.includes(router) {
+				v.warnf(pos, "Router %s is not in subnet %s", router, s.net)
+			}
+		}
+	}
+
+	if val, ok := opts[dhcp4.OptionBroadcastAddress]; ok {
+		broadcast := make(net.IP, 4)
+		for i, b := range s.net.IP.To4() {
+			broadcast[i] = b | ^mask[i]
+		}
+		if !bytes.Equal(val, broadcast) {
+			v.warnf(pos, "Option broadcast-address %s isn't the broadcast address of subnet %s, %s", net.IP(val), s.net, broadcast)
+		}
+	}
+}
+
+// netsOverlap returns if a and b share any addresses.
+func netsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
@@ -0,0 +1,57 @@
+// This source file is part of the PG-DHCP project.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	c, err := ParseFile("./testdata/validateConfig.conf")
+	if err != nil {
+		t.Fatalf("Test config failed parsing: %v", err)
+	}
+
+	expected := []struct {
+		line     int
+		severity Severity
+		message  string
+	}{
+		{1, SeverityError, "No server-identifier"},
+		{9, SeverityWarning, "Router 10.0.2.1 is not in subnet 10.0.1.0/24"},
+		{12, SeverityError, "overlaps range 10.0.1.10 - 10.0.1.50 (./testdata/validateConfig.conf:11:10)"},
+		{13, SeverityError, "Range 10.0.2.10 - 10.0.2.20 is not in subnet 10.0.1.0/24"},
+		{18, SeverityWarning, "Network lab is local but network campus"},
+		{20, SeverityError, "overlaps subnet 10.0.1.0/24 in network campus"},
+		{24, SeverityError, "Option subnet-mask 255.255.0.0 doesn't match"},
+		{24, SeverityWarning, "Option broadcast-address 10.0.3.254"},
+		{33, SeverityError, "Range start 10.0.3.30 is after range end 10.0.3.25"},
+		{33, SeverityWarning, "Router 10.0.4.1 is not in subnet 10.0.3.0/24"},
+		{40, SeverityWarning, "free-lease-after is ignored"},
+	}
+
+	problems := c.Validate()
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %d:\n%s", len(expected), len(problems), problems.Error())
+	}
+	for i, e := range expected {
+		p := problems[i]
+		if p.File != "./testdata/validateConfig.conf" || p.Line != e.line || p.Severity != e.severity || !strings.Contains(p.Message, e.message) {
+			t.Errorf("Expected %s on line %d containing %q, got %s", e.severity, e.line, e.message, p)
+		}
+	}
+	if !problems.HasErrors() || len(problems.Errors()) != 6 {
+		t.Errorf("Expected 6 errors, got %d", len(problems.Errors()))
+	}
+
+	c, err = ParseFile("./testdata/includeConfig.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := c.Validate(); len(problems) != 0 {
+		t.Errorf("Expected no problems, got:\n%s", problems.Error())
+	}
+}
@@ -1,6 +1,7 @@
 package management
 
 import (
+	"errors"
 	"net"
 
 	"github.com/packet-guardian/pg-dhcp/internal/server"
@@ -26,3 +27,20 @@ func (l *Lease) Get(ip net.IP, reply *models.Lease) error {
 	*reply = *lease
 	return nil
 }
+
+func (l *Lease) GetConflicts(_ int, reply *[]*models.Lease) error {
+	*reply = server.GetConflicts()
+	return nil
+}
+
+func (l *Lease) ClearConflict(ip net.IP, ack *bool) error {
+	if clearConflictFunc == nil {
+		return errors.New("clearing conflicts isn't available")
+	}
+	if err := clearConflictFunc(ip); err != nil {
+		return err
+	}
+
+	*ack = true
+	return nil
+}
@@ -99,3 +99,49 @@ func TestLeaseGetAllFromNetworkRPC(t *testing.T) {
 		t.Fatalf("Incorrect number of leases. Expected 3, got %d", len(leases))
 	}
 }
+
+func TestLeaseConflictsRPC(t *testing.T) {
+	handler, db := setUpTest(t)
+	defer tearDownStore(db)
+
+	ip := net.ParseIP("10.0.2.10")
+	lease := &models.Lease{
+		MAC:     net.HardwareAddr([]byte{0x12, 0x34, 0x56, 0xab, 0xcd, 0xef}),
+		IP:      ip,
+		Network: "network1",
+	}
+	lease.Abandon("Declined by client")
+	db.PutLease(lease)
+	db.PutLease(&models.Lease{
+		MAC:     net.HardwareAddr([]byte{0x22, 0x34, 0x56, 0xab, 0xcd, 0xef}),
+		IP:      net.ParseIP("10.0.2.11"),
+		Network: "network1",
+	})
+
+	if err := handler.LoadLeases(); err != nil {
+		t.Fatal(err)
+	}
+
+	rpc := &Lease{store: db}
+	SetClearConflictFunc(handler.ClearConflict)
+	defer SetClearConflictFunc(nil)
+
+	var conflicts []*models.Lease
+	if err := rpc.GetConflicts(0, &conflicts); err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || !conflicts[0].IP.Equal(ip) {
+		t.Fatalf("Incorrect conflicts. Expected %s, got %v", ip, conflicts)
+	}
+
+	var ack bool
+	if err := rpc.ClearConflict(ip, &ack); err != nil || !ack {
+		t.Fatalf("Failed clearing conflict: %v", err)
+	}
+	if l, _ := db.GetLease(ip); l.IsAbandoned {
+		t.Fatal("Conflict wasn't cleared in store")
+	}
+	if err := rpc.ClearConflict(ip, &ack); err == nil {
+		t.Fatal("Expected an error clearing a non-conflict")
+	}
+}
@@ -1,13 +1,60 @@
 package management
 
 import (
+	"errors"
+	"net"
+
 	"github.com/packet-guardian/pg-dhcp/internal/server"
 	"github.com/packet-guardian/pg-dhcp/stats"
 )
 
+var (
+	reloadFunc        func() error
+	partnerDownFunc   func() error
+	clearConflictFunc func(net.IP) error
+)
+
+// SetReloadFunc sets the function Server.Reload uses to reload the networks file.
+func SetReloadFunc(f func() error) { reloadFunc = f }
+
+// SetPartnerDownFunc sets the function Server.PartnerDown uses to declare the failover partner down.
+func SetPartnerDownFunc(f func() error) { partnerDownFunc = f }
+
+// SetClearConflictFunc sets the function Lease.ClearConflict uses to clear an abandoned address.
+func SetClearConflictFunc(f func(net.IP) error) { clearConflictFunc = f }
+
 type Server int
 
 func (s *Server) GetPoolStats(_ int, reply *[]*stats.PoolStat) error {
 	*reply = server.GetPoolStats()
 	return nil
 }
+
+func (s *Server) GetReaperStats(_ int, reply *stats.ReaperStat) error {
+	*reply = *server.GetReaperStats()
+	return nil
+}
+
+func (s *Server) Reload(_ int, ack *bool) error {
+	if reloadFunc == nil {
+		return errors.New("reloading isn't available")
+	}
+	if err := reloadFunc(); err != nil {
+		return err
+	}
+
+	*ack = true
+	return nil
+}
+
+func (s *Server) PartnerDown(_ int, ack *bool) error {
+	if partnerDownFunc == nil {
+		return errors.New("failover isn't available")
+	}
+	if err := partnerDownFunc(); err != nil {
+		return err
+	}
+
+	*ack = true
+	return nil
+}
@@ -5,8 +5,11 @@
 package models
 
 import (
+	"encoding/binary"
 	"errors"
+	"math"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/packet-guardian/pg-dhcp/internal/utils"
@@ -14,11 +17,55 @@ import (
 
 var errBufTooSmall = errors.New("buffer too small")
 
+// extendedFormat is set in the flags byte of a serialized lease when the
+// hostname is length prefixed and followed by additional fields.
+const extendedFormat byte = 0x80
+
+// Additional fields of an extended serialized lease
+const (
+	leaseFieldCircuitID     byte = 1
+	leaseFieldRemoteID      byte = 2
+	leaseFieldClientID      byte = 3
+	leaseFieldAbandonReason byte = 4
+	leaseFieldAbandonedAt   byte = 5
+	leaseFieldIPv6          byte = 6
+	leaseFieldIAID          byte = 7
+	leaseFieldDNSName       byte = 8
+	leaseFieldClasses       byte = 9
+	leaseFieldType          byte = 10
+	leaseFieldUpdatedAt     byte = 11
+)
+
+// LeaseType is how a lease was assigned.
+type LeaseType uint8
+
+const (
+	// LeaseDynamic is a lease assigned with DHCP.
+	LeaseDynamic LeaseType = iota
+	// LeaseBOOTP is a lease assigned to a BOOTP client which never renews it.
+	LeaseBOOTP
+)
+
+func (t LeaseType) String() string {
+	switch t {
+	case LeaseDynamic:
+		return "dynamic"
+	case LeaseBOOTP:
+		return "bootp"
+	}
+	return "unknown"
+}
+
+// InfiniteEnd is the end time of a lease that never expires. It's the largest
+// time that fits in a 32 bit Unix timestamp so it can be stored everywhere.
+var InfiniteEnd = time.Unix(math.MaxInt32, 0)
+
 // A Lease represents a single DHCP lease in a pool. It is bound to a particular
 // pool and network.
 type Lease struct {
 	IP          net.IP
 	MAC         net.HardwareAddr
+	ClientID    []byte // Client identifier, option 61
 	Network     string
 	Start       time.Time
 	End         time.Time
@@ -26,12 +73,59 @@ type Lease struct {
 	IsAbandoned bool
 	Offered     bool
 	Registered  bool
+	Relay       RelayInfo
+
+	AbandonReason string    // Why the address is marked as a conflict
+	AbandonedAt   time.Time // When the address was marked as a conflict
+
+	IAID uint32 // DHCPv6 identity association, the DUID is stored in ClientID
+
+	DNSName string    // Fully qualified name registered in DNS for the lease
+	Classes []string  // Client classes the client was a member of when acknowledged
+	Type    LeaseType // How the lease was assigned
+
+	UpdatedAt time.Time // When the lease was last changed, the newest change wins between failover partners
+}
+
+// IsIPv6 returns if the lease is for a DHCPv6 address.
+func (l *Lease) IsIPv6() bool {
+	return l.IP != nil && l.IP.To4() == nil
+}
+
+// ClientIDString returns the client identifier as text if it's printable, otherwise as hex.
+func (l *Lease) ClientIDString() string { return printableID(l.ClientID) }
+
+// RelayInfo is the relay agent information (option 82) a lease was last
+// acknowledged through.
+type RelayInfo struct {
+	CircuitID []byte
+	RemoteID  []byte
+}
+
+// CircuitIDString returns the circuit ID as text if it's printable, otherwise as hex.
+func (r RelayInfo) CircuitIDString() string { return printableID(r.CircuitID) }
+
+// RemoteIDString returns the remote ID as text if it's printable, otherwise as hex.
+func (r RelayInfo) RemoteIDString() string { return printableID(r.RemoteID) }
+
+func printableID(id []byte) string {
+	for _, b := range id {
+		if b < 0x20 || b > 0x7e {
+			return net.HardwareAddr(id).String() // Colon separated hex
+		}
+	}
+	return string(id)
 }
 
 func NewLease() *Lease {
 	return &Lease{}
 }
 
+// IsInfinite returns if the lease never expires.
+func (l *Lease) IsInfinite() bool {
+	return !l.End.Before(InfiniteEnd)
+}
+
 // IsFree determines if the lease is expired and available for use
 func (l *Lease) IsFree() bool {
 	return l.IsExpired()
@@ -41,12 +135,32 @@ func (l *Lease) IsExpired() bool {
 	return l.End.Before(time.Now())
 }
 
+// Abandon marks the lease's address as a conflict so it won't be given out.
+func (l *Lease) Abandon(reason string) {
+	l.IsAbandoned = true
+	l.AbandonReason = reason
+	l.AbandonedAt = time.Now()
+}
+
+// ClearAbandoned removes the conflict mark from the lease.
+func (l *Lease) ClearAbandoned() {
+	l.IsAbandoned = false
+	l.AbandonReason = ""
+	l.AbandonedAt = time.Time{}
+}
+
 func (l *Lease) Serialize() []byte {
 	netBytes := []byte(l.Network)
 	hostnameBytes := []byte(l.Hostname)
-	buf := make([]byte, 29+len(netBytes)+len(hostnameBytes))
+	fields := l.serializeFields()
+
+	hostnameLen := len(hostnameBytes)
+	if fields != nil {
+		hostnameLen += 2
+	}
+	buf := make([]byte, 29+len(netBytes)+hostnameLen+len(fields))
 
-	// IPv4 Address
+	// IPv4 Address, IPv6 addresses are an extended field
 	copy(buf[:4], l.IP.To4())
 
 	// MAC Address
@@ -71,11 +185,113 @@ func (l *Lease) Serialize() []byte {
 	// Network name
 	copy(buf[29:netEnd], netBytes)
 
-	// Hostname. Hostname as no length as it's everything after the network name
-	copy(buf[netEnd:], hostnameBytes)
+	if fields == nil {
+		// Hostname. Hostname as no length as it's everything after the network name
+		copy(buf[netEnd:], hostnameBytes)
+		return buf
+	}
+
+	// Extended format, hostname is length prefixed and followed by the other fields
+	buf[10] |= extendedFormat
+	binary.BigEndian.PutUint16(buf[netEnd:], uint16(len(hostnameBytes)))
+	hostnameEnd := netEnd + 2 + len(hostnameBytes)
+	copy(buf[netEnd+2:hostnameEnd], hostnameBytes)
+	copy(buf[hostnameEnd:], fields)
 	return buf
 }
 
+// serializeFields returns the optional fields of a lease encoded as code,
+// 2 byte length, value. It returns nil if the lease has no optional fields.
+func (l *Lease) serializeFields() []byte {
+	var buf []byte
+	appendField := func(code byte, val []byte) {
+		if len(val) == 0 {
+			return
+		}
+		buf = append(buf, code, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(val)))
+		buf = append(buf, val...)
+	}
+
+	appendField(leaseFieldCircuitID, l.Relay.CircuitID)
+	appendField(leaseFieldRemoteID, l.Relay.RemoteID)
+	appendField(leaseFieldClientID, l.ClientID)
+	appendField(leaseFieldAbandonReason, []byte(l.AbandonReason))
+	if !l.AbandonedAt.IsZero() {
+		appendField(leaseFieldAbandonedAt, utils.Itob(l.AbandonedAt.Unix()))
+	}
+	if l.IsIPv6() {
+		appendField(leaseFieldIPv6, l.IP.To16())
+	}
+	if l.IAID != 0 {
+		iaid := make([]byte, 4)
+		binary.BigEndian.PutUint32(iaid, l.IAID)
+		appendField(leaseFieldIAID, iaid)
+	}
+	appendField(leaseFieldDNSName, []byte(l.DNSName))
+	appendField(leaseFieldClasses, []byte(strings.Join(l.Classes, ",")))
+	if l.Type != LeaseDynamic {
+		appendField(leaseFieldType, []byte{byte(l.Type)})
+	}
+	if !l.UpdatedAt.IsZero() {
+		updated := make([]byte, 8)
+		binary.BigEndian.PutUint64(updated, uint64(l.UpdatedAt.UnixNano()))
+		appendField(leaseFieldUpdatedAt, updated)
+	}
+	return buf
+}
+
+func (l *Lease) unserializeFields(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return errBufTooSmall
+		}
+		size := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+size {
+			return errBufTooSmall
+		}
+		val := make([]byte, size)
+		copy(val, data[3:3+size])
+
+		switch data[0] {
+		case leaseFieldCircuitID:
+			l.Relay.CircuitID = val
+		case leaseFieldRemoteID:
+			l.Relay.RemoteID = val
+		case leaseFieldClientID:
+			l.ClientID = val
+		case leaseFieldAbandonReason:
+			l.AbandonReason = string(val)
+		case leaseFieldAbandonedAt:
+			if len(val) == 8 {
+				l.AbandonedAt = time.Unix(utils.Btoi(val), 0)
+			}
+		case leaseFieldIPv6:
+			if len(val) == net.IPv6len {
+				l.IP = net.IP(val)
+			}
+		case leaseFieldIAID:
+			if len(val) == 4 {
+				l.IAID = binary.BigEndian.Uint32(val)
+			}
+		case leaseFieldDNSName:
+			l.DNSName = string(val)
+		case leaseFieldClasses:
+			l.Classes = strings.Split(string(val), ",")
+		case leaseFieldType:
+			if len(val) == 1 {
+				l.Type = LeaseType(val[0])
+			}
+		case leaseFieldUpdatedAt:
+			if len(val) == 8 {
+				l.UpdatedAt = time.Unix(0, int64(binary.BigEndian.Uint64(val)))
+			}
+		}
+		data = data[3+size:]
+	}
+	return nil
+}
+
 func (l *Lease) Unserialize(data []byte) error {
 	if len(data) < 29 {
 		return errBufTooSmall
@@ -90,8 +306,9 @@ func (l *Lease) Unserialize(data []byte) error {
 	copy(l.MAC, data[4:10])
 
 	// Boolean fields
-	l.IsAbandoned = (data[10] == 1)
+	l.IsAbandoned = (data[10]&1 == 1)
 	l.Registered = (data[11] == 1)
+	extended := (data[10]&extendedFormat != 0)
 
 	// Start time as int64
 	l.Start = time.Unix(utils.Btoi(data[12:20]), 0)
@@ -107,10 +324,22 @@ func (l *Lease) Unserialize(data []byte) error {
 		l.Network = string(data[29 : netlen+29])
 	}
 
-	if len(data) > 29+netlen {
-		// Hostname
-		hostnameStart := netlen + 29
-		l.Hostname = string(data[hostnameStart:])
+	hostnameStart := netlen + 29
+	if !extended {
+		if len(data) > hostnameStart {
+			// Hostname
+			l.Hostname = string(data[hostnameStart:])
+		}
+		return nil
 	}
-	return nil
+
+	if len(data) < hostnameStart+2 {
+		return errBufTooSmall
+	}
+	hostnameEnd := hostnameStart + 2 + int(binary.BigEndian.Uint16(data[hostnameStart:]))
+	if len(data) < hostnameEnd {
+		return errBufTooSmall
+	}
+	l.Hostname = string(data[hostnameStart+2 : hostnameEnd])
+	return l.unserializeFields(data[hostnameEnd:])
 }
@@ -51,6 +51,24 @@ var leaseTests = []struct {
 			Registered:  true,
 		},
 	},
+	{
+		data: []byte{0xa, 0x0, 0x2, 0x8, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56, 0x81, 0x1, 0xd0, 0xf9, 0x87, 0x90, 0xb, 0x0, 0x0, 0x0, 0xa0, 0x89, 0x88, 0x90, 0xb, 0x0, 0x0, 0x0, 0x3, 0x4e, 0x65, 0x74, 0x0, 0x4, 0x68, 0x6f, 0x73, 0x74, 0x1, 0x0, 0x5, 0x65, 0x74, 0x68, 0x2f, 0x31, 0x2, 0x0, 0x2, 0x0, 0xff, 0x3, 0x0, 0x7, 0x1, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56},
+		actual: &Lease{
+			IP:          net.ParseIP("10.0.2.8").To4(),
+			MAC:         net.HardwareAddr([]byte{0xab, 0xcd, 0xef, 0x12, 0x34, 0x56}),
+			ClientID:    []byte{0x1, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56},
+			Network:     "Net",
+			Start:       time.Unix(1493237352, 0),
+			End:         time.Unix(1493238352, 0),
+			Hostname:    "host",
+			IsAbandoned: true,
+			Registered:  true,
+			Relay: RelayInfo{
+				CircuitID: []byte("eth/1"),
+				RemoteID:  []byte{0x0, 0xff},
+			},
+		},
+	},
 }
 
 func TestLeaseSerialize(t *testing.T) {
@@ -75,3 +93,94 @@ func TestLeaseUnserialize(t *testing.T) {
 		}
 	}
 }
+
+func TestRelayInfoStrings(t *testing.T) {
+	r := RelayInfo{
+		CircuitID: []byte("eth/1"),
+		RemoteID:  []byte{0x0, 0xff},
+	}
+	if r.CircuitIDString() != "eth/1" {
+		t.Errorf("Expected circuit ID eth/1, got %s", r.CircuitIDString())
+	}
+	if r.RemoteIDString() != "00:ff" {
+		t.Errorf("Expected remote ID 00:ff, got %s", r.RemoteIDString())
+	}
+}
+
+func TestLeaseAbandonSerialize(t *testing.T) {
+	lease := &Lease{
+		IP:      net.ParseIP("10.0.2.9").To4(),
+		MAC:     net.HardwareAddr([]byte{0xab, 0xcd, 0xef, 0x12, 0x34, 0x56}),
+		Network: "Net",
+	}
+	lease.Abandon("declined by client")
+
+	lease2 := NewLease()
+	if err := lease2.Unserialize(lease.Serialize()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !lease2.IsAbandoned || lease2.AbandonReason != "declined by client" {
+		t.Errorf("Abandon reason not kept, got %t %q", lease2.IsAbandoned, lease2.AbandonReason)
+	}
+	if lease2.AbandonedAt.Unix() != lease.AbandonedAt.Unix() {
+		t.Errorf("Abandon time not kept. Expected %s, got %s", lease.AbandonedAt, lease2.AbandonedAt)
+	}
+
+	lease2.ClearAbandoned()
+	lease3 := NewLease()
+	if err := lease3.Unserialize(lease2.Serialize()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if lease3.IsAbandoned || lease3.AbandonReason != "" || !lease3.AbandonedAt.IsZero() {
+		t.Errorf("Abandon mark not cleared, got %#v", lease3)
+	}
+}
+
+func TestLeaseIPv6Serialize(t *testing.T) {
+	lease := &Lease{
+		IP:       net.ParseIP("2001:db8::10"),
+		MAC:      net.HardwareAddr([]byte{0xab, 0xcd, 0xef, 0x12, 0x34, 0x56}),
+		ClientID: []byte{0x0, 0x3, 0x0, 0x1, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56},
+		IAID:     0x01020304,
+		Network:  "Net",
+		DNSName:  "host.example.com",
+		Classes:  []string{"phones", "pxe"},
+		Start:    time.Unix(1493237352, 0),
+		End:      time.Unix(1493238352, 0),
+
+		UpdatedAt: time.Unix(1493237352, 123456789),
+	}
+	if !lease.IsIPv6() {
+		t.Fatal("Expected lease to be IPv6")
+	}
+
+	lease2 := NewLease()
+	if err := lease2.Unserialize(lease.Serialize()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(lease, lease2) {
+		t.Errorf("Unserialized lease failed. Expected %#v, got %#v.", lease, lease2)
+	}
+}
+
+func TestLeaseBOOTPSerialize(t *testing.T) {
+	lease := &Lease{
+		IP:      net.ParseIP("10.0.1.5").To4(),
+		MAC:     net.HardwareAddr([]byte{0xab, 0xcd, 0xef, 0x12, 0x34, 0x56}),
+		Network: "Net",
+		Start:   time.Unix(1493237352, 0),
+		End:     InfiniteEnd,
+		Type:    LeaseBOOTP,
+	}
+
+	lease2 := NewLease()
+	if err := lease2.Unserialize(lease.Serialize()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(lease, lease2) {
+		t.Errorf("Unserialized lease failed. Expected %#v, got %#v.", lease, lease2)
+	}
+	if !lease2.IsInfinite() || lease2.IsExpired() {
+		t.Error("Expected BOOTP lease to never expire")
+	}
+}
@@ -27,6 +27,8 @@ type DeviceRequest interface {
 type LeaseRequest interface {
 	GetAllFromNetwork(name string) ([]*models.Lease, error)
 	Get(ip net.IP) (*models.Lease, error)
+	GetConflicts() ([]*models.Lease, error)
+	ClearConflict(ip net.IP) error
 }
 
 type NetworkRequest interface {
@@ -35,4 +37,7 @@ type NetworkRequest interface {
 
 type ServerRequest interface {
 	GetPoolStats() ([]*stats.PoolStat, error)
+	GetReaperStats() (*stats.ReaperStat, error)
+	Reload() error
+	PartnerDown() error
 }
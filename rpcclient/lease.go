@@ -25,3 +25,15 @@ func (l *LeaseRPCRequest) Get(ip net.IP) (*models.Lease, error) {
 	}
 	return reply, nil
 }
+
+func (l *LeaseRPCRequest) GetConflicts() ([]*models.Lease, error) {
+	var reply []*models.Lease
+	if err := l.client.c.Call("Lease.GetConflicts", 0, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (l *LeaseRPCRequest) ClearConflict(ip net.IP) error {
+	return l.client.c.Call("Lease.ClearConflict", ip, nil)
+}
@@ -13,3 +13,21 @@ func (s *ServerRPCRequest) GetPoolStats() ([]*stats.PoolStat, error) {
 	}
 	return reply, nil
 }
+
+func (s *ServerRPCRequest) GetReaperStats() (*stats.ReaperStat, error) {
+	reply := new(stats.ReaperStat)
+	if err := s.client.c.Call("Server.GetReaperStats", 0, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (s *ServerRPCRequest) Reload() error {
+	var ack bool
+	return s.client.c.Call("Server.Reload", 0, &ack)
+}
+
+func (s *ServerRPCRequest) PartnerDown() error {
+	var ack bool
+	return s.client.c.Call("Server.PartnerDown", 0, &ack)
+}
@@ -0,0 +1,10 @@
+package stats
+
+import "time"
+
+type ReaperStat struct {
+	DeleteAfter  time.Duration // 0 when the reaper is disabled
+	LastRun      time.Time
+	LastDeleted  int
+	TotalDeleted int
+}
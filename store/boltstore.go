@@ -26,7 +26,7 @@ type BoltStore struct {
 }
 
 type queueItem struct {
-	key, val []byte
+	key, val []byte // A nil val deletes the key
 }
 
 func NewBoltStore(path string) (*BoltStore, error) {
@@ -84,6 +84,12 @@ func (s *BoltStore) Flush() {
 		s.db.Batch(func(tx *bolt.Tx) error {
 			bucket := tx.Bucket(leaseBucket)
 			for _, item := range leaseBatch {
+				if item.val == nil {
+					if err := bucket.Delete(item.key); err != nil {
+						return err
+					}
+					continue
+				}
 				if err := bucket.Put(item.key, item.val); err != nil {
 					return err
 				}
@@ -104,7 +110,7 @@ func (s *BoltStore) GetLease(ip net.IP) (*models.Lease, error) {
 	var data []byte
 
 	s.db.View(func(tx *bolt.Tx) error {
-		data = tx.Bucket(leaseBucket).Get([]byte(ip.To4()))
+		data = tx.Bucket(leaseBucket).Get(leaseKey(ip))
 		return nil
 	})
 
@@ -122,11 +128,29 @@ func (s *BoltStore) GetLease(ip net.IP) (*models.Lease, error) {
 func (s *BoltStore) PutLease(l *models.Lease) error {
 	data := l.Serialize()
 	s.m.Lock()
-	s.leaseQueue.PushBack(queueItem{[]byte(l.IP.To4()), data})
+	s.leaseQueue.PushBack(queueItem{leaseKey(l.IP), data})
 	s.m.Unlock()
 	return nil
 }
 
+// DeleteLease queues the lease for deletion. It's removed in order with pending
+// writes so an earlier PutLease can't recreate it.
+func (s *BoltStore) DeleteLease(l *models.Lease) error {
+	s.m.Lock()
+	s.leaseQueue.PushBack(queueItem{leaseKey(l.IP), nil})
+	s.m.Unlock()
+	return nil
+}
+
+// leaseKey returns the bucket key of a lease, the 4 byte form of IPv4
+// addresses and the 16 byte form of IPv6 addresses.
+func leaseKey(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return []byte(ip4)
+	}
+	return []byte(ip.To16())
+}
+
 func (s *BoltStore) ForEachLease(foreach func(*models.Lease)) error {
 	return s.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(leaseBucket)
@@ -32,6 +32,15 @@ func TestForEachLeaseBoltDBStore(t *testing.T) {
 	testForEachLease(t, store)
 }
 
+func TestDeleteLeaseBoltDBStore(t *testing.T) {
+	store, err := setUpBoltDBStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownBoltDBStore(store)
+	testDeleteLease(t, store)
+}
+
 func TestDeviceBoltDBStore(t *testing.T) {
 	store, err := setUpBoltDBStore()
 	if err != nil {
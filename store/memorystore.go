@@ -40,6 +40,13 @@ func (s *MemoryStore) PutLease(l *models.Lease) error {
 	return nil
 }
 
+func (s *MemoryStore) DeleteLease(l *models.Lease) error {
+	s.m.Lock()
+	delete(s.leases, l.IP.String())
+	s.m.Unlock()
+	return nil
+}
+
 func (s *MemoryStore) ForEachLease(foreach func(*models.Lease)) error {
 	s.m.RLock()
 	for _, v := range s.leases {
@@ -24,6 +24,14 @@ func TestForEachLeaseMemoryStore(t *testing.T) {
 	testForEachLease(t, store)
 }
 
+func TestDeleteLeaseMemoryStore(t *testing.T) {
+	store, err := setUpMemoryStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDeleteLease(t, store)
+}
+
 func TestDeviceMemoryStore(t *testing.T) {
 	store, err := setUpMemoryStore()
 	if err != nil {
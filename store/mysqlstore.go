@@ -7,15 +7,29 @@ CREATE TABLE "device" (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8
 
 CREATE TABLE "lease" (
-	"ip" VARCHAR(15) NOT NULL UNIQUE KEY,
+	"ip" VARCHAR(45) NOT NULL UNIQUE KEY,
 	"mac" VARCHAR(17) NOT NULL,
 	"network" TEXT NOT NULL,
 	"start" INTEGER NOT NULL,
 	"end" INTEGER NOT NULL,
 	"hostname" TEXT NOT NULL,
 	"abandoned" TINYINT DEFAULT 0,
-	"registered" TINYINT DEFAULT 0
+	"registered" TINYINT DEFAULT 0,
+	"circuit_id" VARBINARY(255) NOT NULL DEFAULT '',
+	"remote_id" VARBINARY(255) NOT NULL DEFAULT '',
+	"client_id" VARBINARY(255) NOT NULL DEFAULT '',
+	"abandon_reason" VARCHAR(255) NOT NULL DEFAULT '',
+	"abandoned_at" INTEGER NOT NULL DEFAULT 0,
+	"iaid" INTEGER UNSIGNED NOT NULL DEFAULT 0,
+	"dns_name" VARCHAR(255) NOT NULL DEFAULT '',
+	"classes" VARCHAR(255) NOT NULL DEFAULT '',
+	"lease_type" TINYINT NOT NULL DEFAULT 0,
+	"updated_at" BIGINT NOT NULL DEFAULT 0
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8
+
+Lease tables created for older versions have a VARCHAR(15) "ip" column and are
+missing the columns after "registered". They're migrated the first time the store
+is used.
 */
 
 package store
@@ -24,6 +38,7 @@ import (
 	"database/sql"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -42,6 +57,7 @@ type MySQLStore struct {
 	getLeaseStmt      *sql.Stmt
 	getAllLeasesStmt  *sql.Stmt
 	putLeaseStmt      *sql.Stmt
+	deleteLeaseStmt   *sql.Stmt
 	getDeviceStmt     *sql.Stmt
 	getAllDevicesStmt *sql.Stmt
 	putDeviceStmt     *sql.Stmt
@@ -86,23 +102,85 @@ func (s *MySQLStore) prepare() error {
 	return nil
 }
 
+// leaseColumns are the lease table columns added after the original schema.
+var leaseColumns = []struct{ name, definition string }{
+	{"circuit_id", "VARBINARY(255) NOT NULL DEFAULT ''"},
+	{"remote_id", "VARBINARY(255) NOT NULL DEFAULT ''"},
+	{"client_id", "VARBINARY(255) NOT NULL DEFAULT ''"},
+	{"abandon_reason", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"abandoned_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"iaid", "INTEGER UNSIGNED NOT NULL DEFAULT 0"},
+	{"dns_name", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"classes", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"lease_type", "TINYINT NOT NULL DEFAULT 0"},
+	{"updated_at", "BIGINT NOT NULL DEFAULT 0"},
+}
+
+// migrateLeaseTable widens the ip column for IPv6 addresses and adds the columns
+// in leaseColumns missing from the lease table.
+func (s *MySQLStore) migrateLeaseTable() error {
+	rows, err := s.db.Query(`SELECT column_name, COALESCE(character_maximum_length, 0) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?`, s.leaseTable)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]int64) // Column name to maximum length
+	for rows.Next() {
+		var name string
+		var length int64
+		if err := rows.Scan(&name, &length); err != nil {
+			return err
+		}
+		existing[strings.ToLower(name)] = length
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if length, ok := existing["ip"]; ok && length < 45 {
+		if _, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE "%s" MODIFY COLUMN "ip" VARCHAR(45) NOT NULL`, s.leaseTable)); err != nil {
+			return fmt.Errorf("widening lease column ip: %v", err)
+		}
+	}
+
+	for _, col := range leaseColumns {
+		if _, ok := existing[col.name]; ok {
+			continue
+		}
+		if _, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, s.leaseTable, col.name, col.definition)); err != nil {
+			return fmt.Errorf("adding lease column %s: %v", col.name, err)
+		}
+	}
+	return nil
+}
+
 func (s *MySQLStore) prepareLeaseStmts() error {
+	if err := s.migrateLeaseTable(); err != nil {
+		return err
+	}
+
 	var err error
-	s.getLeaseStmt, err = s.db.Prepare(fmt.Sprintf(`SELECT "mac", "network", "start", "end", "hostname", "abandoned", "registered" FROM "%s" WHERE "ip" = ?`, s.leaseTable))
+	s.getLeaseStmt, err = s.db.Prepare(fmt.Sprintf(`SELECT "mac", "network", "start", "end", "hostname", "abandoned", "registered", "circuit_id", "remote_id", "client_id", "abandon_reason", "abandoned_at", "iaid", "dns_name", "classes", "lease_type", "updated_at" FROM "%s" WHERE "ip" = ?`, s.leaseTable))
 	if err != nil {
 		return err
 	}
 
-	s.getAllLeasesStmt, err = s.db.Prepare(fmt.Sprintf(`SELECT "ip", "mac", "network", "start", "end", "hostname", "abandoned", "registered" FROM "%s"`, s.leaseTable))
+	s.getAllLeasesStmt, err = s.db.Prepare(fmt.Sprintf(`SELECT "ip", "mac", "network", "start", "end", "hostname", "abandoned", "registered", "circuit_id", "remote_id", "client_id", "abandon_reason", "abandoned_at", "iaid", "dns_name", "classes", "lease_type", "updated_at" FROM "%s"`, s.leaseTable))
 	if err != nil {
 		return err
 	}
 
 	s.putLeaseStmt, err = s.db.Prepare(fmt.Sprintf(
-		`INSERT INTO "%s" (ip, mac, network, start, end, hostname, abandoned, registered)
-			VALUES (?,?,?,?,?,?,?,?)
+		`INSERT INTO "%s" (ip, mac, network, start, end, hostname, abandoned, registered, circuit_id, remote_id, client_id, abandon_reason, abandoned_at, iaid, dns_name, classes, lease_type, updated_at)
+			VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
 		ON DUPLICATE KEY
-			UPDATE mac=VALUES(mac), network=VALUES(network), start=VALUES(start), end=VALUES(end), hostname=VALUES(hostname), abandoned=VALUES(abandoned), registered=VALUES(registered)`, s.leaseTable))
+			UPDATE mac=VALUES(mac), network=VALUES(network), start=VALUES(start), end=VALUES(end), hostname=VALUES(hostname), abandoned=VALUES(abandoned), registered=VALUES(registered), circuit_id=VALUES(circuit_id), remote_id=VALUES(remote_id), client_id=VALUES(client_id), abandon_reason=VALUES(abandon_reason), abandoned_at=VALUES(abandoned_at), iaid=VALUES(iaid), dns_name=VALUES(dns_name), classes=VALUES(classes), lease_type=VALUES(lease_type), updated_at=VALUES(updated_at)`, s.leaseTable))
+	if err != nil {
+		return err
+	}
+
+	s.deleteLeaseStmt, err = s.db.Prepare(fmt.Sprintf(`DELETE FROM "%s" WHERE "ip" = ?`, s.leaseTable))
 	if err != nil {
 		return err
 	}
@@ -151,6 +229,16 @@ func (s *MySQLStore) GetLease(ip net.IP) (*models.Lease, error) {
 		hostname    string
 		isAbandoned bool
 		registered  bool
+		circuitID   []byte
+		remoteID    []byte
+		clientID    []byte
+		reason      string
+		abandonedAt int64
+		iaid        uint32
+		dnsName     string
+		classes     string
+		leaseType   uint8
+		updatedAt   int64
 	)
 
 	err := row.Scan(
@@ -161,6 +249,16 @@ func (s *MySQLStore) GetLease(ip net.IP) (*models.Lease, error) {
 		&hostname,
 		&isAbandoned,
 		&registered,
+		&circuitID,
+		&remoteID,
+		&clientID,
+		&reason,
+		&abandonedAt,
+		&iaid,
+		&dnsName,
+		&classes,
+		&leaseType,
+		&updatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -180,6 +278,20 @@ func (s *MySQLStore) GetLease(ip net.IP) (*models.Lease, error) {
 	lease.Hostname = hostname
 	lease.IsAbandoned = isAbandoned
 	lease.Registered = registered
+	lease.Relay.CircuitID = nilIfEmpty(circuitID)
+	lease.Relay.RemoteID = nilIfEmpty(remoteID)
+	lease.ClientID = nilIfEmpty(clientID)
+	lease.AbandonReason = reason
+	lease.IAID = iaid
+	lease.DNSName = dnsName
+	lease.Classes = splitClasses(classes)
+	lease.Type = models.LeaseType(leaseType)
+	if abandonedAt > 0 {
+		lease.AbandonedAt = time.Unix(abandonedAt, 0)
+	}
+	if updatedAt > 0 {
+		lease.UpdatedAt = time.Unix(0, updatedAt)
+	}
 	return lease, nil
 }
 
@@ -188,6 +300,14 @@ func (s *MySQLStore) PutLease(l *models.Lease) error {
 		return err
 	}
 
+	var abandonedAt, updatedAt int64
+	if !l.AbandonedAt.IsZero() {
+		abandonedAt = l.AbandonedAt.Unix()
+	}
+	if !l.UpdatedAt.IsZero() {
+		updatedAt = l.UpdatedAt.UnixNano()
+	}
+
 	_, err := s.putLeaseStmt.Exec(
 		l.IP.String(),
 		l.MAC.String(),
@@ -197,10 +317,29 @@ func (s *MySQLStore) PutLease(l *models.Lease) error {
 		l.Hostname,
 		l.IsAbandoned,
 		l.Registered,
+		nonNilBytes(l.Relay.CircuitID),
+		nonNilBytes(l.Relay.RemoteID),
+		nonNilBytes(l.ClientID),
+		l.AbandonReason,
+		abandonedAt,
+		l.IAID,
+		l.DNSName,
+		strings.Join(l.Classes, ","),
+		uint8(l.Type),
+		updatedAt,
 	)
 	return err
 }
 
+func (s *MySQLStore) DeleteLease(l *models.Lease) error {
+	if err := s.prepare(); err != nil {
+		return err
+	}
+
+	_, err := s.deleteLeaseStmt.Exec(l.IP.String())
+	return err
+}
+
 func (s *MySQLStore) ForEachLease(foreach func(*models.Lease)) error {
 	if err := s.prepare(); err != nil {
 		return err
@@ -222,6 +361,16 @@ func (s *MySQLStore) ForEachLease(foreach func(*models.Lease)) error {
 			hostname    string
 			isAbandoned bool
 			registered  bool
+			circuitID   []byte
+			remoteID    []byte
+			clientID    []byte
+			reason      string
+			abandonedAt int64
+			iaid        uint32
+			dnsName     string
+			classes     string
+			leaseType   uint8
+			updatedAt   int64
 		)
 
 		err := rows.Scan(
@@ -233,6 +382,16 @@ func (s *MySQLStore) ForEachLease(foreach func(*models.Lease)) error {
 			&hostname,
 			&isAbandoned,
 			&registered,
+			&circuitID,
+			&remoteID,
+			&clientID,
+			&reason,
+			&abandonedAt,
+			&iaid,
+			&dnsName,
+			&classes,
+			&leaseType,
+			&updatedAt,
 		)
 		if err != nil {
 			return err
@@ -249,6 +408,20 @@ func (s *MySQLStore) ForEachLease(foreach func(*models.Lease)) error {
 		lease.Hostname = hostname
 		lease.IsAbandoned = isAbandoned
 		lease.Registered = registered
+		lease.Relay.CircuitID = nilIfEmpty(circuitID)
+		lease.Relay.RemoteID = nilIfEmpty(remoteID)
+		lease.ClientID = nilIfEmpty(clientID)
+		lease.AbandonReason = reason
+		lease.IAID = iaid
+		lease.DNSName = dnsName
+		lease.Classes = splitClasses(classes)
+		lease.Type = models.LeaseType(leaseType)
+		if abandonedAt > 0 {
+			lease.AbandonedAt = time.Unix(abandonedAt, 0)
+		}
+		if updatedAt > 0 {
+			lease.UpdatedAt = time.Unix(0, updatedAt)
+		}
 		foreach(lease)
 	}
 
@@ -340,3 +513,27 @@ func (s *MySQLStore) ForEachDevice(foreach func(*models.Device)) error {
 	}
 	return nil
 }
+
+// nilIfEmpty normalizes empty binary columns to nil to match leases from the other stores.
+func nilIfEmpty(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// nonNilBytes returns an empty slice for nil so the NOT NULL binary columns are satisfied.
+func nonNilBytes(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+// splitClasses parses the comma separated classes column.
+func splitClasses(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
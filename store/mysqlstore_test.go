@@ -61,14 +61,24 @@ func setUpMySQLStore(t *testing.T) (*MySQLStore, error) {
 	}
 
 	_, err = s.db.Exec(`CREATE TABLE "lease" (
-		"ip" VARCHAR(15) NOT NULL UNIQUE KEY,
+		"ip" VARCHAR(45) NOT NULL UNIQUE KEY,
 		"mac" VARCHAR(17) NOT NULL,
 		"network" TEXT NOT NULL,
 		"start" INTEGER NOT NULL,
 		"end" INTEGER NOT NULL,
 		"hostname" TEXT NOT NULL,
 		"abandoned" TINYINT DEFAULT 0,
-		"registered" TINYINT DEFAULT 0
+		"registered" TINYINT DEFAULT 0,
+		"circuit_id" VARBINARY(255) NOT NULL DEFAULT '',
+		"remote_id" VARBINARY(255) NOT NULL DEFAULT '',
+		"client_id" VARBINARY(255) NOT NULL DEFAULT '',
+		"abandon_reason" VARCHAR(255) NOT NULL DEFAULT '',
+		"abandoned_at" INTEGER NOT NULL DEFAULT 0,
+		"iaid" INTEGER UNSIGNED NOT NULL DEFAULT 0,
+		"dns_name" VARCHAR(255) NOT NULL DEFAULT '',
+		"classes" VARCHAR(255) NOT NULL DEFAULT '',
+		"lease_type" TINYINT NOT NULL DEFAULT 0,
+		"updated_at" BIGINT NOT NULL DEFAULT 0
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
 	if err != nil {
 		t.Fatal(err)
@@ -107,6 +117,19 @@ func TestForEachLeaseMySQLStore(t *testing.T) {
 	testForEachLease(t, store)
 }
 
+func TestDeleteLeaseMySQLStore(t *testing.T) {
+	if !mysqlAvailable {
+		t.Skipf("MySQL server not running on %s", mysqlCfg.Addr)
+	}
+
+	store, err := setUpMySQLStore(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownMySQLStore(store)
+	testDeleteLease(t, store)
+}
+
 func TestDeviceMySQLStore(t *testing.T) {
 	if !mysqlAvailable {
 		t.Skipf("MySQL server not running on %s", mysqlCfg.Addr)
@@ -145,3 +168,35 @@ func TestForEachDeviceMySQLStore(t *testing.T) {
 	defer tearDownMySQLStore(store)
 	testForEachDevice(t, store)
 }
+
+func TestMigrateLeaseMySQLStore(t *testing.T) {
+	if !mysqlAvailable {
+		t.Skipf("MySQL server not running on %s", mysqlCfg.Addr)
+	}
+
+	store, err := setUpMySQLStore(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tearDownMySQLStore(store)
+
+	// A lease table from before the added columns
+	_, err = store.db.Exec("DROP TABLE IF EXISTS lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.db.Exec(`CREATE TABLE "lease" (
+		"ip" VARCHAR(15) NOT NULL UNIQUE KEY,
+		"mac" VARCHAR(17) NOT NULL,
+		"network" TEXT NOT NULL,
+		"start" INTEGER NOT NULL,
+		"end" INTEGER NOT NULL,
+		"hostname" TEXT NOT NULL,
+		"abandoned" TINYINT DEFAULT 0,
+		"registered" TINYINT DEFAULT 0
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLeaseStore(t, store)
+}
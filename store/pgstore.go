@@ -11,14 +11,24 @@ CREATE TABLE "blacklist" (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 AUTO_INCREMENT=1
 
 CREATE TABLE "lease" (
-	"ip" VARCHAR(15) NOT NULL UNIQUE KEY,
+	"ip" VARCHAR(45) NOT NULL UNIQUE KEY,
 	"mac" VARCHAR(17) NOT NULL,
 	"network" TEXT NOT NULL,
 	"start" INTEGER NOT NULL,
 	"end" INTEGER NOT NULL,
 	"hostname" TEXT NOT NULL,
 	"abandoned" TINYINT DEFAULT 0,
-	"registered" TINYINT DEFAULT 0
+	"registered" TINYINT DEFAULT 0,
+	"circuit_id" VARBINARY(255) NOT NULL DEFAULT '',
+	"remote_id" VARBINARY(255) NOT NULL DEFAULT '',
+	"client_id" VARBINARY(255) NOT NULL DEFAULT '',
+	"abandon_reason" VARCHAR(255) NOT NULL DEFAULT '',
+	"abandoned_at" INTEGER NOT NULL DEFAULT 0,
+	"iaid" INTEGER UNSIGNED NOT NULL DEFAULT 0,
+	"dns_name" VARCHAR(255) NOT NULL DEFAULT '',
+	"classes" VARCHAR(255) NOT NULL DEFAULT '',
+	"lease_type" TINYINT NOT NULL DEFAULT 0,
+	"updated_at" BIGINT NOT NULL DEFAULT 0
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8
 */
 
@@ -98,6 +108,12 @@ func (s *PGStore) PutLease(l *models.Lease) error {
 	}
 	return s.MySQLStore.PutLease(l)
 }
+func (s *PGStore) DeleteLease(l *models.Lease) error {
+	if err := s.prepare(); err != nil {
+		return err
+	}
+	return s.MySQLStore.DeleteLease(l)
+}
 func (s *PGStore) ForEachLease(foreach func(*models.Lease)) error {
 	if err := s.prepare(); err != nil {
 		return err
@@ -53,6 +53,24 @@ var leaseTests = []struct {
 			Registered:  true,
 		},
 	},
+	{
+		data: []byte{0xa, 0x0, 0x2, 0x8, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56, 0x81, 0x1, 0xd0, 0xf9, 0x87, 0x90, 0xb, 0x0, 0x0, 0x0, 0xa0, 0x89, 0x88, 0x90, 0xb, 0x0, 0x0, 0x0, 0x3, 0x4e, 0x65, 0x74, 0x0, 0x4, 0x68, 0x6f, 0x73, 0x74, 0x1, 0x0, 0x5, 0x65, 0x74, 0x68, 0x2f, 0x31, 0x2, 0x0, 0x2, 0x0, 0xff, 0x3, 0x0, 0x7, 0x1, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56},
+		actual: &models.Lease{
+			IP:          net.ParseIP("10.0.2.8").To4(),
+			MAC:         net.HardwareAddr([]byte{0xab, 0xcd, 0xef, 0x12, 0x34, 0x56}),
+			ClientID:    []byte{0x1, 0xab, 0xcd, 0xef, 0x12, 0x34, 0x56},
+			Network:     "Net",
+			Start:       time.Unix(1493237352, 0),
+			End:         time.Unix(1493238352, 0),
+			Hostname:    "host",
+			IsAbandoned: true,
+			Registered:  true,
+			Relay: models.RelayInfo{
+				CircuitID: []byte("eth/1"),
+				RemoteID:  []byte{0x0, 0xff},
+			},
+		},
+	},
 }
 
 func closeStore(s Store) error {
@@ -113,6 +131,36 @@ func testForEachLease(t *testing.T, s Store) {
 	}
 }
 
+func testDeleteLease(t *testing.T, s Store) {
+	lease1 := leaseTests[0].actual
+	lease2 := leaseTests[1].actual
+
+	s.PutLease(lease1)
+	s.PutLease(lease2)
+	if err := s.DeleteLease(lease1); err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := s.(flusher); ok {
+		f.Flush()
+	}
+
+	deleted, err := s.GetLease(lease1.IP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != nil {
+		t.Error("Deleted lease still exists")
+	}
+
+	kept, err := s.GetLease(lease2.IP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept == nil {
+		t.Error("Lease was deleted but shouldn't have been")
+	}
+}
+
 func testDeviceStore(t *testing.T, s Store) {
 	// Test not blacklisted
 	device := &models.Device{
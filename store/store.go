@@ -11,6 +11,7 @@ type Store interface {
 
 	GetLease(ip net.IP) (*models.Lease, error)
 	PutLease(l *models.Lease) error
+	DeleteLease(l *models.Lease) error
 	ForEachLease(foreach func(*models.Lease)) error
 
 	GetDevice(mac net.HardwareAddr) (*models.Device, error)
@@ -5,12 +5,20 @@ CREATE TABLE "device" (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8
 
 CREATE TABLE "lease" (
-	"ip" VARCHAR(15) NOT NULL UNIQUE KEY,
+	"ip" VARCHAR(45) NOT NULL UNIQUE KEY,
 	"mac" VARCHAR(17) NOT NULL,
 	"network" TEXT NOT NULL,
 	"start" INTEGER NOT NULL,
 	"end" INTEGER NOT NULL,
 	"hostname" TEXT NOT NULL,
 	"abandoned" TINYINT DEFAULT 0,
-	"registered" TINYINT DEFAULT 0
+	"registered" TINYINT DEFAULT 0,
+	"circuit_id" VARBINARY(255) NOT NULL DEFAULT '',
+	"remote_id" VARBINARY(255) NOT NULL DEFAULT '',
+	"client_id" VARBINARY(255) NOT NULL DEFAULT '',
+	"abandon_reason" VARCHAR(255) NOT NULL DEFAULT '',
+	"abandoned_at" INTEGER NOT NULL DEFAULT 0,
+	"iaid" INTEGER UNSIGNED NOT NULL DEFAULT 0,
+	"dns_name" VARCHAR(255) NOT NULL DEFAULT '',
+	"classes" VARCHAR(255) NOT NULL DEFAULT ''
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8